@@ -1,39 +1,58 @@
 module github.com/hexya-erp/hexya
 
-go 1.13
+go 1.18
 
 require (
 	github.com/beevik/etree v1.1.0
-	github.com/cockroachdb/apd v1.1.0 // indirect
 	github.com/cockroachdb/apd/v2 v2.0.1
 	github.com/disintegration/imaging v1.6.0
 	github.com/flosch/pongo2 v0.0.0-20190707114632-bbf5a6c351f4
 	github.com/gin-contrib/pprof v1.2.1
 	github.com/gin-contrib/sessions v0.0.1
-	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/gin-gonic/gin v1.4.0
-	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/google/uuid v1.1.1
-	github.com/gorilla/sessions v1.2.0 // indirect
 	github.com/hexya-erp/pool v1.0.2
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.2.0
+	github.com/smartystreets/goconvey v0.0.0-20190306220146-200a235640ff
+	github.com/spf13/cobra v0.0.5
+	github.com/spf13/viper v1.5.0
+	go.uber.org/zap v1.12.0
+	golang.org/x/crypto v0.0.0-20191107222254-f4817d981bb6
+	golang.org/x/tools v0.0.0-20191107235519-f7ea15e60b12
+	gopkg.in/yaml.v2 v2.2.5
+)
+
+require (
+	github.com/cockroachdb/apd v1.1.0 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/gin-contrib/sse v0.1.0 // indirect
+	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/gorilla/context v1.1.1 // indirect
+	github.com/gorilla/securecookie v1.1.1 // indirect
+	github.com/gorilla/sessions v1.2.0 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/json-iterator/go v1.1.8 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
 	github.com/juju/errors v0.0.0-20190930114154-d42613fe1ab9 // indirect
-	github.com/lib/pq v1.2.0
+	github.com/magiconair/properties v1.8.1 // indirect
 	github.com/mattn/go-isatty v0.0.10 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
 	github.com/pelletier/go-toml v1.6.0 // indirect
-	github.com/smartystreets/goconvey v0.0.0-20190306220146-200a235640ff
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
 	github.com/spf13/afero v1.2.2 // indirect
-	github.com/spf13/cobra v0.0.5
+	github.com/spf13/cast v1.3.0 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/spf13/viper v1.5.0
+	github.com/subosito/gotenv v1.2.0 // indirect
 	github.com/ugorji/go v1.1.7 // indirect
+	github.com/ugorji/go/codec v1.1.7 // indirect
+	go.uber.org/atomic v1.5.0 // indirect
 	go.uber.org/multierr v1.4.0 // indirect
-	go.uber.org/zap v1.12.0
-	golang.org/x/crypto v0.0.0-20191107222254-f4817d981bb6
+	golang.org/x/image v0.0.0-20180708004352-c73c2afc3b81 // indirect
 	golang.org/x/net v0.0.0-20191108063844-7e6e90b9ea88 // indirect
 	golang.org/x/sys v0.0.0-20191105231009-c1f44814a5cd // indirect
-	golang.org/x/tools v0.0.0-20191107235519-f7ea15e60b12
-	gopkg.in/yaml.v2 v2.2.5 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
 )
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/po"
 )
 
@@ -205,6 +206,15 @@ func TranslateCustom(lang, id, moduleName string) string {
 	return Registry.TranslateCustom(lang, id, moduleName)
 }
 
+// TranslateError returns the given error with its message translated in the
+// given lang as code and interpolated with its arguments, if it is a
+// UserError or one of its typed variants. Other errors are returned as is.
+func TranslateError(lang string, err error) error {
+	return exceptions.Translate(err, func(src string) string {
+		return Registry.TranslateCode(lang, "", src)
+	})
+}
+
 // A fieldRef references a field in the translation maps
 type fieldRef struct {
 	lang  string
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -85,6 +86,24 @@ func TestI18N(t *testing.T) {
 			trans = TranslateCode("fr", "stock", "You are not allowed to perform this operation")
 			So(trans, ShouldEqual, "You are not allowed to perform this operation")
 		})
+		Convey("Translating errors should work", func() {
+			err := TranslateError("fr", exceptions.ValidationError{
+				UserError: exceptions.UserError{
+					Message: "Record %s cannot be deleted",
+					Args:    []interface{}{"Jane"},
+					Debug:   "debug data",
+				},
+			})
+			So(err, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+			So(err.(exceptions.ValidationError).Message, ShouldEqual, "L'enregistrement Jane ne peut pas être supprimé")
+			So(err.(exceptions.ValidationError).Debug, ShouldEqual, "debug data")
+			err = TranslateError("de", exceptions.UserError{
+				Message: "Record %s cannot be deleted",
+				Args:    []interface{}{"Jane"},
+			})
+			So(err.(exceptions.UserError).Message, ShouldEqual, "Record Jane cannot be deleted")
+			So(TranslateError("fr", os.ErrNotExist), ShouldEqual, os.ErrNotExist)
+		})
 		Convey("Translating custom should work", func() {
 			trans := TranslateCustom("fr", "Create", "testModule")
 			So(trans, ShouldEqual, "Créer")
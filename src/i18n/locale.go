@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 )
 
@@ -97,7 +98,19 @@ func (l *Locale) Check() error {
 func (l *Locale) FormatFloat(number float64, digits nbutils.Digits) string {
 	number = nbutils.Round(number, digits.ToPrecision())
 	format := fmt.Sprintf("%%.%df", digits.Scale)
-	numStr := fmt.Sprintf(format, number)
+	return l.formatNumber(fmt.Sprintf(format, number))
+}
+
+// FormatDecimal formats the given decimal number according to this Locale,
+// with the given digits. The number is rounded half up to the scale of digits
+// without going through a float, so that all its digits are kept.
+func (l *Locale) FormatDecimal(number decimals.Decimal, digits nbutils.Digits) string {
+	return l.formatNumber(number.StringFixed(int32(digits.Scale)))
+}
+
+// formatNumber formats the given number string, as formatted by Go,
+// with the separators of this Locale.
+func (l *Locale) formatNumber(numStr string) string {
 	parts := strings.Split(numStr, ".")
 	intPart := parts[0]
 	var decPart string
@@ -138,10 +151,22 @@ func (l *Locale) FormatFloat(number float64, digits nbutils.Digits) string {
 	return res
 }
 
-// FormatMonetary formats the given value according to this Locale and given currency
-func (l *Locale) FormatMonetary(value float64, curr Currency) string {
+// FormatMonetary formats the given value according to this Locale and given currency.
+//
+// value can be a decimals.Decimal, which is then formatted exactly, or any number.
+func (l *Locale) FormatMonetary(value interface{}, curr Currency) string {
 	digs := nbutils.Digits{Precision: 16, Scale: int8(curr.DecimalPlaces())}
-	amount := l.FormatFloat(value, digs)
+	var amount string
+	switch v := value.(type) {
+	case decimals.Decimal:
+		amount = l.FormatDecimal(v, digs)
+	default:
+		fValue, err := nbutils.CastToFloat(value)
+		if err != nil {
+			panic(fmt.Errorf("unable to format %v as monetary value: %s", value, err))
+		}
+		amount = l.FormatFloat(fValue, digs)
+	}
 	if curr.Position() == "before" {
 		return fmt.Sprintf("%s %s", curr.Symbol(), amount)
 	}
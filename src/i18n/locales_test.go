@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -79,6 +80,9 @@ func TestLocale(t *testing.T) {
 			yen := currency{decimals: 0, symbol: "¥", position: "before"}
 			So(fr.FormatMonetary(1234567.789, eur), ShouldEqual, "1 234 567,79 €")
 			So(ja.FormatMonetary(1234567.789, yen), ShouldEqual, "¥ 1,234,568")
+			So(fr.FormatMonetary(decimals.MustParse("12345678901234567.895"), eur), ShouldEqual, "12 345 678 901 234 567,90 €")
+			So(ja.FormatMonetary(int64(1234), yen), ShouldEqual, "¥ 1,234")
+			So(func() { fr.FormatMonetary("abc", eur) }, ShouldPanic)
 		})
 	})
 	Convey("Testing number grouping JSON Marshalling", t, func() {
@@ -197,9 +197,20 @@ func executeCustomPoFuncs(lang, moduleDir string, messages MessageMap) MessageMa
 	return messages
 }
 
+// userErrorFunctions are the names of the functions whose first argument is
+// the message of the UserError they create.
+var userErrorFunctions = map[string]bool{
+	"newUserError":         true,
+	"panicValidationError": true,
+	"panicAccessError":     true,
+	"panicMissingError":    true,
+}
+
 // addCodeToMessages adds to the given messages map the translatable fields of the code
 // defined in go files inside the given resourcesDir and sub directories.
-// This extracts strings given as argument to T().
+// This extracts strings given as argument to T(), the messages of
+// exceptions.UserError literals and the messages given to the functions
+// of userErrorFunctions.
 func addCodeToMessages(lang string, moduleDir string, messages MessageMap) MessageMap {
 	fSet := token.NewFileSet()
 	goFiles, err := filepath.Glob(fmt.Sprintf("%s/**.go", moduleDir))
@@ -218,18 +229,35 @@ func addCodeToMessages(lang string, moduleDir string, messages MessageMap) Messa
 				if err != nil {
 					return true
 				}
+				if userErrorFunctions[fnctName] && len(node.Args) > 0 {
+					if lit, ok := node.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						messages = updateMessagesWithCodeTranslation(lang, strings.Trim(lit.Value, "\"`"), messages)
+					}
+					return true
+				}
 				if fnctName != "T" {
 					return true
 				}
 				strArg := strings.Trim(node.Args[0].(*ast.BasicLit).Value, "\"`")
-				codeTrans := i18n.TranslateCode(lang, "", strArg)
-				if codeTrans == strArg {
-					codeTrans = ""
+				messages = updateMessagesWithCodeTranslation(lang, strArg, messages)
+			case *ast.CompositeLit:
+				sel, ok := node.Type.(*ast.SelectorExpr)
+				if !ok || sel.Sel.Name != "UserError" {
+					return true
+				}
+				for _, elt := range node.Elts {
+					kv, ok := elt.(*ast.KeyValueExpr)
+					if !ok {
+						continue
+					}
+					key, ok := kv.Key.(*ast.Ident)
+					if !ok || key.Name != "Message" {
+						continue
+					}
+					if lit, ok := kv.Value.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						messages = updateMessagesWithCodeTranslation(lang, strings.Trim(lit.Value, "\"`"), messages)
+					}
 				}
-				msgRef := MessageRef{MsgId: strArg}
-				msg := GetOrCreateMessage(messages, msgRef, codeTrans)
-				msg.ExtractedComment += "code:\n"
-				messages[msgRef] = msg
 			}
 			return true
 		})
@@ -237,6 +265,20 @@ func addCodeToMessages(lang string, moduleDir string, messages MessageMap) Messa
 	return messages
 }
 
+// updateMessagesWithCodeTranslation adds the given code string to the
+// given messages map with its translation in the given lang.
+func updateMessagesWithCodeTranslation(lang, src string, messages MessageMap) MessageMap {
+	codeTrans := i18n.TranslateCode(lang, "", src)
+	if codeTrans == src {
+		codeTrans = ""
+	}
+	msgRef := MessageRef{MsgId: src}
+	msg := GetOrCreateMessage(messages, msgRef, codeTrans)
+	msg.ExtractedComment += "code:\n"
+	messages[msgRef] = msg
+	return messages
+}
+
 // addResourceItemsToMessages adds to the given messages map the translatable fields of the views
 // defined in XML files inside the given resourcesDir
 func addResourceItemsToMessages(lang string, resourcesDir string, messages MessageMap) MessageMap {
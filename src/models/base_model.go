@@ -46,6 +46,9 @@ const (
 	ManualModel
 	// SystemModel is a model that is used internally by the Hexya Framework
 	SystemModel
+	// AttachmentModel is a model for holding the values of binary fields
+	// that are stored outside of their model's table.
+	AttachmentModel
 )
 
 //  declareCommonMixin creates the common mixin that is needed for all models
@@ -104,19 +107,31 @@ func commonMixinNew(rc *RecordCollection, data RecordData) *RecordCollection {
 
 // Create inserts a record in the database from the given data.
 // Returns the created RecordCollection.
+//
+// If called on a memory record created by New, the record is inserted
+// with its values, overridden by the given data.
 func commonMixinCreate(rc *RecordCollection, data RecordData) *RecordCollection {
 	return rc.create(data)
 }
 
 // Read reads the database and returns a slice of FieldMap of the given model.
+//
+// Fields obtained with an alias, such as "Profile.Age as age", are
+// given in the result with their alias as key.
 func commonMixinRead(rc *RecordCollection, fields FieldNames) []RecordData {
 	var res []RecordData
 	// Check if we have id in fields, and add it otherwise
 	fields = addIDIfNotPresent(fields)
+	// Remove fields the user is not allowed to read
+	fields = rc.readableFields(fields)
 	// Do the actual reading
 	for _, rec := range rc.Records() {
 		fData := NewModelData(rc.model)
 		for _, fName := range fields {
+			if af, ok := fName.(aliasedFieldName); ok {
+				fData.Underlying().FieldMap[af.alias] = rec.Get(af.fieldName)
+				continue
+			}
 			fData.Underlying().Set(fName, rec.Get(fName))
 		}
 		res = append(res, fData)
@@ -196,19 +211,28 @@ func commonMixinCopy(rc *RecordCollection, overrides RecordData) *RecordCollecti
 	return newRs
 }
 
-// NameGet retrieves the human readable name of this record.`,
+// NameGet retrieves the human readable name of this record.
+//
+// The name is made of the values of the model's name fields separated by spaces.`,
 func commonMixinNameGet(rc *RecordCollection) string {
-	if _, nameExists := rc.model.fields.Get("Name"); nameExists {
-		switch name := rc.Get(rc.model.FieldName("Name")).(type) {
+	nameFields := rc.model.NameFields()
+	if len(nameFields) == 0 {
+		return rc.String()
+	}
+	names := make([]string, 0, len(nameFields))
+	for _, nf := range nameFields {
+		switch name := rc.Get(nf).(type) {
 		case string:
-			return name
+			if name != "" || len(nameFields) == 1 {
+				names = append(names, name)
+			}
 		case fmt.Stringer:
-			return name.String()
+			names = append(names, name.String())
 		default:
-			log.Panic("Name field is neither a string nor a fmt.Stringer", "model", rc.model)
+			log.Panic("Name field is neither a string nor a fmt.Stringer", "model", rc.model, "field", nf)
 		}
 	}
-	return rc.String()
+	return strings.Join(names, " ")
 }
 
 // SearchByName searches for records that have a display name matching the given
@@ -222,7 +246,7 @@ func commonMixinSearchByName(rc *RecordCollection, name string, op operator.Oper
 	if op == "" {
 		op = operator.IContains
 	}
-	cond := rc.Model().Field(rc.model.FieldName("Name")).AddOperator(op, name)
+	cond := rc.model.nameCondition(op, name)
 	if !additionalCond.Underlying().IsEmpty() {
 		cond = cond.AndCond(additionalCond.Underlying())
 	}
@@ -278,7 +302,7 @@ func commonMixinCheckRecursion(rc *RecordCollection) bool {
 		return true
 	}
 	// We use direct SQL query to bypass access control
-	query := fmt.Sprintf(`SELECT parent_id FROM %s WHERE id = ?`, adapters[db.DriverName()].quoteTableName(rc.model.tableName))
+	query := fmt.Sprintf(`SELECT parent_id FROM %s WHERE id = ?`, adapters[db.DriverName()].QuoteTableName(rc.model.tableName))
 	rc.Load(rc.model.FieldName("Parent"))
 	for _, record := range rc.Records() {
 		currentID := record.ids[0]
@@ -392,7 +416,7 @@ func commonMixinOnChange(rc *RecordCollection, params OnchangeParams) OnchangeRe
 					retValues.Set(fName, newVal)
 				}
 			default:
-				if val != newVal {
+				if !valuesEqual(val, newVal) {
 					retValues.Set(fName, newVal)
 				}
 			}
@@ -576,6 +600,7 @@ func declareBaseMixin() {
 	baseMixin.InheritModel(Registry.MustGet("CommonMixin"))
 	baseMixin.addMethod("ComputeLastUpdate", baseMixinComputeLastUpdate)
 	baseMixin.addMethod("ComputeDisplayName", baseMixinComputeDisplayName)
+	baseMixin.addMethod("SearchDisplayName", baseMixinSearchDisplayName)
 	baseMixin.fields.add(&Field{
 		model:       baseMixin,
 		name:        "CreateDate",
@@ -636,6 +661,7 @@ func declareBaseMixin() {
 		fieldType:   fieldtype.Char,
 		structField: reflect.StructField{Type: reflect.TypeOf("")},
 		compute:     "ComputeDisplayName",
+		search:      "SearchDisplayName",
 		depends:     []string{""},
 	})
 }
@@ -661,6 +687,12 @@ func baseMixinComputeDisplayName(rc *RecordCollection) *ModelData {
 	return res
 }
 
+// SearchDisplayName returns the condition to apply on the name fields of
+// this model when searching on the DisplayName field.
+func baseMixinSearchDisplayName(rc *RecordCollection, op operator.Operator, arg interface{}) *Condition {
+	return rc.model.nameCondition(op, fmt.Sprintf("%v", arg))
+}
+
 func declareModelMixin() {
 	modelMixin := NewMixinModel("ModelMixin")
 	modelMixin.InheritModel(Registry.MustGet("BaseMixin"))
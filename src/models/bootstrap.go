@@ -56,10 +56,14 @@ func BootStrap() {
 	updateFieldDefs()
 	updateRelatedPaths()
 	syncRelatedFieldInfo()
+	inflateAttachments()
+	inflateTombstones()
+	inflateExternalIDs()
 	inflateContexts()
 	updateRelatedPaths()
 	updateDefaultOrder()
 	bootStrapMethods()
+	updateDisplayNameDepends()
 	processDepends()
 	checkFieldMethodsExist()
 	checkComputeMethodsSignature()
@@ -193,6 +197,7 @@ func addMixinMethods(mixinModel, model *Model) {
 					funcValue: wrapFunctionForMethodLayer(lf.funcValue),
 					mixedIn:   true,
 					method:    emi,
+					source:    lf.source,
 				}
 				emi.nextLayer[&ml] = firstMixedLayer
 				firstMixedLayer = &ml
@@ -209,6 +214,7 @@ func addMixinMethods(mixinModel, model *Model) {
 			newMethInfo := copyMethod(model, methInfo)
 			for i := 0; i < len(layersInv); i++ {
 				newMethInfo.addMethodLayer(layersInv[i].funcValue)
+				newMethInfo.topLayer.source = layersInv[i].source
 			}
 			model.methods.set(methName, newMethInfo)
 		}
@@ -303,6 +309,7 @@ func syncRelatedFieldInfo() {
 			newFI.compute = ""
 			newFI.constraint = ""
 			newFI.inverse = ""
+			newFI.search = ""
 			newFI.depends = nil
 			newFI.contexts = nil
 			*fi = newFI
@@ -344,6 +351,59 @@ func inflateContexts() {
 	}
 }
 
+// inflateAttachments creates the attachment models of binary fields that
+// are stored as attachments and makes these fields related to the value
+// field of their attachment model.
+func inflateAttachments() {
+	for _, mi := range Registry.registryByName {
+		if mi.IsMixin() {
+			continue
+		}
+		for _, fi := range mi.fields.registryByName {
+			if !fi.attachment {
+				continue
+			}
+			attachmentModel := createAttachmentModel(fi)
+			fName := fmt.Sprintf("%sHexyaAttachment", fi.name)
+			r2oField := &Field{
+				name:             fName,
+				json:             strutils.SnakeCase(fName),
+				model:            mi,
+				fieldType:        fieldtype.Rev2One,
+				relatedModelName: attachmentModel.name,
+				relatedModel:     attachmentModel,
+				reverseFK:        "Record",
+				jsonReverseFK:    "record_id",
+				noCopy:           true,
+				structField: reflect.StructField{
+					Name: fName,
+					Type: reflect.TypeOf(int64(0)),
+				},
+			}
+			mi.fields.add(r2oField)
+			fi.relatedPathStr = fmt.Sprintf("%s%s%s", fName, ExprSep, fi.name)
+			fi.index = false
+			fi.unique = false
+		}
+	}
+}
+
+// inflateTombstones creates the tombstone models of the models
+// for which tombstones have been enabled.
+func inflateTombstones() {
+	for _, mi := range Registry.registryByName {
+		if !mi.tombstones || mi.IsMixin() || mi.IsManual() {
+			continue
+		}
+		mi.tombstoneModel = createTombstoneModel(mi)
+	}
+}
+
+// inflateExternalIDs creates the model that maps external IDs to their records
+func inflateExternalIDs() {
+	createExternalIDModel()
+}
+
 // createContextsTreeView creates an editable tree view for the given context model.
 // The created view is added to the Views map which will be processed by the views package at bootstrap.
 func createContextsTreeView(fi *Field, contexts FieldContexts) {
@@ -399,10 +459,11 @@ func setupSecurity() {
 	updateContextModelsSecurity()
 }
 
-// updateContextModelsSecurity synchronizes the methods permissions of context models with their base model.
+// updateContextModelsSecurity synchronizes the methods permissions of context
+// and attachment models with their base model.
 func updateContextModelsSecurity() {
 	for _, model := range Registry.registryByName {
-		if !model.isContext() {
+		if !model.isContext() && !model.isAttachment() {
 			continue
 		}
 		baseModel := model.fields.MustGet("Record").relatedModel
@@ -431,13 +492,42 @@ func updateRelatedPaths() {
 	}
 }
 
-// updateDefaultOrder sets defaultOrder from defaultOrderStr
+// updateDefaultOrder sets defaultOrder from defaultOrderStr.
+//
+// It panics if a field of the default order of a model cannot be
+// used for sorting in the database.
 func updateDefaultOrder() {
 	for _, model := range Registry.registryByName {
 		if model.IsM2MLink() {
 			continue
 		}
 		model.defaultOrder = model.ordersFromStrings(model.defaultOrderStr)
+		for _, order := range model.defaultOrder {
+			fi := model.getRelatedFieldInfo(order.field)
+			if !fi.isStored() && !fi.isRelatedField() {
+				log.Panic("Default order field must be stored in database", "model", model.name, "field", order.field)
+			}
+		}
+	}
+}
+
+// updateDisplayNameDepends sets the dependencies of the DisplayName
+// field of each model to the name fields of the model.
+func updateDisplayNameDepends() {
+	for _, model := range Registry.registryByName {
+		fi, ok := model.fields.Get("DisplayName")
+		if !ok || fi.compute != "ComputeDisplayName" {
+			continue
+		}
+		nameFields := model.NameFields()
+		if len(nameFields) == 0 {
+			continue
+		}
+		depends := make([]string, len(nameFields))
+		for i, nf := range nameFields {
+			depends[i] = nf.Name()
+		}
+		fi.depends = depends
 	}
 }
 
@@ -470,6 +560,12 @@ func checkFieldMethodsExist() {
 				}
 				model.methods.MustGet(field.inverse)
 			}
+			if field.search != "" {
+				if field.compute == "" || field.stored {
+					log.Panic("Search method must only be set on non stored computed fields", "model", model.name, "field", field.name, "method", field.search)
+				}
+				model.methods.MustGet(field.search)
+			}
 		}
 	}
 }
@@ -481,7 +577,7 @@ func loadManualSequencesFromDB() {
 		return
 	}
 	adapter := adapters[db.DriverName()]
-	for _, dbSeq := range adapter.sequences("%_manseq") {
+	for _, dbSeq := range adapter.Sequences("%_manseq") {
 		seq := &Sequence{
 			JSON:      dbSeq.Name,
 			Start:     dbSeq.StartValue,
@@ -15,9 +15,11 @@
 package models
 
 import (
+	"container/list"
 	"errors"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 )
@@ -26,9 +28,20 @@ import (
 // improve performance. cache is not safe for concurrent access.
 type cache struct {
 	sync.RWMutex
-	data       map[string]map[int64]FieldMap                    // cache data values by model and id
-	x2mRelated map[string]map[int64]map[string]map[string]int64 // o2m and r2m relations by model, id, field, context
-	m2mLinks   map[string]map[[2]int64]bool                     // many2many relations by relation model and ids
+	data       map[string]map[int64]FieldMap                        // cache data values by model and id
+	x2mRelated map[string]map[int64]map[string]map[string]int64     // o2m and r2m relations by model, id, field, context
+	m2mLinks   map[string]map[[2]int64]bool                         // many2many relations by relation model and ids
+	limit      int                                                  // maximum number of records, 0 for no limit
+	lru        *list.List                                           // records by last usage, most recent first
+	lruElems   map[cacheRecordRef]*list.Element                     // lru elements by record
+	loading    int32                                                // number of loads in progress, accessed atomically
+	computed   map[cacheRecordRef]map[string]map[string]interface{} // non stored computed values by record, field and context
+}
+
+// A cacheRecordRef identifies a record in the cache
+type cacheRecordRef struct {
+	model string
+	id    int64
 }
 
 // notInCacheError is returned when a request in cache returns no entry
@@ -113,6 +126,7 @@ func (c *cache) setDataValue(model string, id int64, jsonName string, value inte
 		c.data[model][id]["id"] = id
 	}
 	c.data[model][id][jsonName] = value
+	c.touchLocked(model, id)
 }
 
 // setX2MValue sets the id for the jsonName field of record ref in the x2mRelation map
@@ -174,6 +188,12 @@ func (c *cache) deleteData(model string, id int64) {
 	defer c.Unlock()
 	delete(c.data[model], id)
 	delete(c.x2mRelated[model], id)
+	ref := cacheRecordRef{model: model, id: id}
+	delete(c.computed, ref)
+	if elem, ok := c.lruElems[ref]; ok {
+		c.lru.Remove(elem)
+		delete(c.lruElems, ref)
+	}
 }
 
 // removeM2MLinks removes all M2M links associated with the record with
@@ -253,6 +273,34 @@ func (c *cache) addRecord(mi *Model, id int64, fMap FieldMap, ctxSlug string) {
 // this method, since this will bring discrepancies in the other
 // records references (One2Many and Many2Many fields).
 func (c *cache) invalidateRecord(mi *Model, id int64) {
+	c.deleteRecord(mi, id)
+	// Memoized values of other records may depend on this record
+	c.clearComputed()
+}
+
+// invalidateRecords removes the records with the given ids of the given
+// model from the cache.
+func (c *cache) invalidateRecords(mi *Model, ids []int64) {
+	for _, id := range ids {
+		c.deleteRecord(mi, id)
+	}
+	c.clearComputed()
+}
+
+// invalidateModel removes all the records of the given model from the cache.
+func (c *cache) invalidateModel(mi *Model) {
+	c.RLock()
+	ids := make([]int64, 0, len(c.data[mi.name]))
+	for id := range c.data[mi.name] {
+		ids = append(ids, id)
+	}
+	c.RUnlock()
+	c.invalidateRecords(mi, ids)
+}
+
+// deleteRecord removes the data and the many2many links of the record
+// with the given id of the given model from the cache.
+func (c *cache) deleteRecord(mi *Model, id int64) {
 	c.deleteData(mi.name, id)
 	for _, fi := range mi.fields.registryByJSON {
 		if fi.fieldType == fieldtype.Many2Many {
@@ -261,6 +309,58 @@ func (c *cache) invalidateRecord(mi *Model, id int64) {
 	}
 }
 
+// clear removes all the entries of the cache.
+func (c *cache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.data = make(map[string]map[int64]FieldMap)
+	c.x2mRelated = make(map[string]map[int64]map[string]map[string]int64)
+	c.m2mLinks = make(map[string]map[[2]int64]bool)
+	c.lru = list.New()
+	c.lruElems = make(map[cacheRecordRef]*list.Element)
+	c.computed = make(map[cacheRecordRef]map[string]map[string]interface{})
+}
+
+// getComputed returns the memoized value of the non stored computed field
+// with the given JSON name of the given record in the given context, and
+// true if there is one.
+func (c *cache) getComputed(model string, id int64, jsonName, ctxSlug string) (interface{}, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	val, ok := c.computed[cacheRecordRef{model: model, id: id}][jsonName][ctxSlug]
+	return val, ok
+}
+
+// setComputed memoizes the value of the non stored computed field with the
+// given JSON name of the given record in the given context.
+func (c *cache) setComputed(model string, id int64, jsonName, ctxSlug string, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	ref := cacheRecordRef{model: model, id: id}
+	if _, ok := c.computed[ref]; !ok {
+		c.computed[ref] = make(map[string]map[string]interface{})
+	}
+	if _, ok := c.computed[ref][jsonName]; !ok {
+		c.computed[ref][jsonName] = make(map[string]interface{})
+	}
+	c.computed[ref][jsonName][ctxSlug] = value
+}
+
+// removeComputed removes the memoized values of the non stored computed
+// field with the given JSON name of the given record in all contexts.
+func (c *cache) removeComputed(model string, id int64, jsonName string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.computed[cacheRecordRef{model: model, id: id}], jsonName)
+}
+
+// clearComputed removes all the memoized values of non stored computed fields.
+func (c *cache) clearComputed() {
+	c.Lock()
+	defer c.Unlock()
+	c.computed = make(map[cacheRecordRef]map[string]map[string]interface{})
+}
+
 // removeEntry removes the given entry from cache
 func (c *cache) removeEntry(mi *Model, id int64, fieldName, ctxSlug string) {
 	if !c.checkIfInCache(mi, []int64{id}, []string{fieldName}, ctxSlug, true) {
@@ -311,6 +411,7 @@ func (c *cache) get(mi *Model, id int64, fieldName string, ctxSlug string) inter
 	case fieldtype.Many2Many:
 		return c.getM2MLinks(fi, id)
 	default:
+		c.touch(mi.name, id)
 		return c.data[mi.name][id][fName]
 	}
 }
@@ -388,12 +489,178 @@ func (c *cache) getRelatedRefCommon(mi *Model, id int64, path string, ctxSlug st
 	return mi, id, exprs[0], nil
 }
 
+// setLimit sets the maximum number of records of this cache.
+// A limit of 0 or less removes the limit.
+//
+// Records are not evicted by this method, but on the next call to evict.
+func (c *cache) setLimit(limit int) {
+	c.Lock()
+	defer c.Unlock()
+	if limit <= 0 {
+		c.limit = 0
+		c.lru = list.New()
+		c.lruElems = make(map[cacheRecordRef]*list.Element)
+		return
+	}
+	if c.limit == 0 {
+		// We start tracking records that are already in cache
+		for model, records := range c.data {
+			for id := range records {
+				c.touchLocked(model, id)
+			}
+		}
+	}
+	c.limit = limit
+}
+
+// touch marks the given record as the most recently used one.
+func (c *cache) touch(model string, id int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.touchLocked(model, id)
+}
+
+// touchLocked is the same as touch, but the caller must hold the lock.
+func (c *cache) touchLocked(model string, id int64) {
+	if c.limit == 0 {
+		return
+	}
+	ref := cacheRecordRef{model: model, id: id}
+	if elem, ok := c.lruElems[ref]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.lruElems[ref] = c.lru.PushFront(ref)
+}
+
+// startLoading marks the beginning of a load in this cache, during which no
+// record is evicted. Each call must be followed by a call to endLoading.
+func (c *cache) startLoading() {
+	atomic.AddInt32(&c.loading, 1)
+}
+
+// endLoading marks the end of a load started with startLoading.
+func (c *cache) endLoading() {
+	atomic.AddInt32(&c.loading, -1)
+}
+
+// evict removes the least recently used records from the cache until there
+// are no more records than the limit of this cache.
+//
+// Memory records created by New are never evicted since they cannot be read
+// again from the database. Nothing is evicted while records are being loaded,
+// so that evict can be safely called at the beginning of each load.
+func (c *cache) evict() {
+	if atomic.LoadInt32(&c.loading) > 0 {
+		return
+	}
+	var victims []cacheRecordRef
+	c.RLock()
+	if c.limit == 0 {
+		c.RUnlock()
+		return
+	}
+	excess := c.lru.Len() - c.limit
+	for elem := c.lru.Back(); elem != nil && len(victims) < excess; elem = elem.Prev() {
+		ref := elem.Value.(cacheRecordRef)
+		if ref.id < 0 {
+			continue
+		}
+		victims = append(victims, ref)
+	}
+	c.RUnlock()
+	if len(victims) == 0 {
+		return
+	}
+	for _, ref := range victims {
+		mi, ok := Registry.Get(ref.model)
+		if !ok {
+			c.deleteData(ref.model, ref.id)
+			continue
+		}
+		c.removeX2MEntries(mi, ref.id)
+		c.deleteData(ref.model, ref.id)
+		for _, fi := range mi.fields.registryByJSON {
+			if fi.fieldType == fieldtype.Many2Many {
+				c.removeStoredM2MLinks(fi, ref.id)
+			}
+		}
+	}
+}
+
+// removeStoredM2MLinks removes the M2M links of the record with the given id
+// on the given field, except links to memory records.
+func (c *cache) removeStoredM2MLinks(fi *Field, id int64) {
+	c.Lock()
+	defer c.Unlock()
+	index := (strings.Compare(fi.m2mOurField.name, fi.m2mTheirField.name) + 1) / 2
+	for link := range c.m2mLinks[fi.m2mRelModel.name] {
+		if link[index] == id && link[(index+1)%2] > 0 {
+			delete(c.m2mLinks[fi.m2mRelModel.name], link)
+		}
+	}
+}
+
+// removeX2MEntries removes from the cache the values of the one2many, rev2one
+// and many2many fields of the records referencing the record of the given
+// model with the given id, so that they are read again from the database
+// instead of from incomplete cache data once this record is removed.
+// Values of memory records are kept.
+func (c *cache) removeX2MEntries(mi *Model, id int64) {
+	type fieldRef struct {
+		model string
+		id    int64
+		json  string
+	}
+	var refs []fieldRef
+	c.RLock()
+	fMap := c.data[mi.name][id]
+	for _, fi := range mi.fields.registryByJSON {
+		switch {
+		case fi.fieldType.IsFKRelationType():
+			// The one2many or rev2one field of the record we point at holds this record
+			relID, ok := fMap[fi.json].(int64)
+			if !ok || relID <= 0 {
+				continue
+			}
+			for _, rfi := range fi.relatedModel.fields.registryByJSON {
+				if (rfi.fieldType == fieldtype.One2Many || rfi.fieldType == fieldtype.Rev2One) &&
+					rfi.relatedModelName == mi.name && rfi.jsonReverseFK == fi.json {
+					refs = append(refs, fieldRef{model: rfi.model.name, id: relID, json: rfi.json})
+				}
+			}
+		case fi.fieldType == fieldtype.Many2Many:
+			// The reverse many2many field of the records we are linked to holds this record
+			ourIndex := (strings.Compare(fi.m2mOurField.name, fi.m2mTheirField.name) + 1) / 2
+			theirIndex := (ourIndex + 1) % 2
+			for _, rfi := range fi.relatedModel.fields.registryByJSON {
+				if rfi.fieldType != fieldtype.Many2Many || rfi.m2mRelModel != fi.m2mRelModel ||
+					rfi.m2mOurField.name != fi.m2mTheirField.name {
+					continue
+				}
+				for link := range c.m2mLinks[fi.m2mRelModel.name] {
+					if link[ourIndex] == id && link[theirIndex] > 0 {
+						refs = append(refs, fieldRef{model: rfi.model.name, id: link[theirIndex], json: rfi.json})
+					}
+				}
+			}
+		}
+	}
+	c.RUnlock()
+	for _, ref := range refs {
+		c.deleteFieldData(ref.model, ref.id, ref.json)
+	}
+}
+
 // newCache creates a pointer to a new cache instance.
 func newCache() *cache {
 	res := cache{
 		data:       make(map[string]map[int64]FieldMap),
 		x2mRelated: make(map[string]map[int64]map[string]map[string]int64),
 		m2mLinks:   make(map[string]map[[2]int64]bool),
+		lru:        list.New(),
+		lruElems:   make(map[cacheRecordRef]*list.Element),
+		computed:   make(map[cacheRecordRef]map[string]map[string]interface{}),
 	}
 	return &res
 }
@@ -34,6 +34,8 @@ type predicate struct {
 	operator operator.Operator
 	arg      interface{}
 	cond     *Condition
+	rawSQL   string
+	jsonPath []string
 	isOr     bool
 	isNot    bool
 	isCond   bool
@@ -103,6 +105,16 @@ func (c Condition) AndCond(cond *Condition) *Condition {
 	return &c
 }
 
+// AndRaw completes the current condition with the given raw SQL fragment as an
+// AND clause between brackets : c.AndRaw(sql, args...) => c AND (sql)
+//
+// args are bound to the '?' placeholders of sql. It is the caller's responsibility
+// to write a valid and injection-safe SQL fragment.
+func (c Condition) AndRaw(sql string, args ...interface{}) *Condition {
+	c.predicates = append(c.predicates, predicate{rawSQL: sql, arg: args})
+	return &c
+}
+
 // AndNot completes the current condition with a simple AND NOT clause :
 // c.AndNot().nextCond => c AND NOT nextCond
 //
@@ -160,6 +172,9 @@ func (c Condition) OrNotCond(cond *Condition) *Condition {
 }
 
 // Serialize returns the condition as a list which mimics Odoo domains.
+//
+// Serialize panics if the condition has raw SQL fragments added with AndRaw,
+// since they cannot be evaluated by the clients of serialized conditions.
 func (c Condition) Serialize() []interface{} {
 	return serializePredicates(c.predicates)
 }
@@ -204,7 +219,15 @@ func (c Condition) String() string {
 			res += fmt.Sprintf("(\n%s\n)\n", p.cond.String())
 			continue
 		}
-		res += fmt.Sprintf("%s %s %v\n", joinFieldNames(p.exprs, ExprSep).Name(), p.operator, p.arg)
+		if p.rawSQL != "" {
+			res += fmt.Sprintf("(%s) %v\n", p.rawSQL, p.arg)
+			continue
+		}
+		field := joinFieldNames(p.exprs, ExprSep).Name()
+		if len(p.jsonPath) > 0 {
+			field = fmt.Sprintf("%s%v", field, p.jsonPath)
+		}
+		res += fmt.Sprintf("%s %s %v\n", field, p.operator, p.arg)
 	}
 	return res
 }
@@ -235,7 +258,11 @@ func (cs ConditionStart) Field(name FieldName) *ConditionField {
 
 // FilteredOn adds a condition with a table join on the given field and
 // filters the result with the given condition
+//
+// It panics if condition has raw SQL fragments, since they cannot be applied
+// to the related model.
 func (cs ConditionStart) FilteredOn(field FieldName, condition *Condition) *Condition {
+	condition.checkNoRawSQL()
 	res := cs.cond
 	for i, p := range condition.predicates {
 		condition.predicates[i].exprs = append([]FieldName{field}, p.exprs...)
@@ -249,8 +276,9 @@ func (cs ConditionStart) FilteredOn(field FieldName, condition *Condition) *Cond
 // A ConditionField is a partial Condition when we have set
 // a field name in a predicate and are about to add an operator.
 type ConditionField struct {
-	cs    ConditionStart
-	exprs []FieldName
+	cs       ConditionStart
+	exprs    []FieldName
+	jsonPath []string
 }
 
 // JSON returns the json field name of this ConditionField
@@ -265,6 +293,16 @@ func (c ConditionField) Name() string {
 
 var _ FieldName = ConditionField{}
 
+// JSONPath makes the following operator apply to the value at the given
+// path inside the document of this JSON field, instead of the whole field.
+// Each key of the path is a JSON object key or an array index.
+//
+// Values inside the document are compared as text.
+func (c ConditionField) JSONPath(path ...string) *ConditionField {
+	c.jsonPath = append(append([]string{}, c.jsonPath...), path...)
+	return &c
+}
+
 // AddOperator adds a condition value to the condition with the given operator and data
 // If multi is true, a recordset will be converted into a slice of int64
 // otherwise, it will return an int64 and panic if the recordset is not
@@ -288,6 +326,7 @@ func (c ConditionField) AddOperator(op operator.Operator, data interface{}) *Con
 		exprs:    c.exprs,
 		operator: op,
 		arg:      data,
+		jsonPath: c.jsonPath,
 		isNot:    c.cs.nextIsNot,
 		isOr:     c.cs.nextIsOr,
 	})
@@ -320,6 +359,11 @@ func (c ConditionField) Equals(data interface{}) *Condition {
 	return c.AddOperator(operator.Equals, data)
 }
 
+// IEquals appends a case insensitive '=' operator to the current Condition
+func (c ConditionField) IEquals(data interface{}) *Condition {
+	return c.AddOperator(operator.IEquals, data)
+}
+
 // NotEquals appends the '!=' operator to the current Condition
 func (c ConditionField) NotEquals(data interface{}) *Condition {
 	return c.AddOperator(operator.NotEquals, data)
@@ -385,11 +429,22 @@ func (c ConditionField) NotIn(data interface{}) *Condition {
 	return c.AddOperator(operator.NotIn, data)
 }
 
-// ChildOf appends the 'child of' operator to the current Condition
+// ChildOf appends the 'child of' operator to the current Condition.
+//
+// It matches the records whose field is one of the given records
+// or one of their descendants through the Parent field.
 func (c ConditionField) ChildOf(data interface{}) *Condition {
 	return c.AddOperator(operator.ChildOf, data)
 }
 
+// ParentOf appends the 'parent of' operator to the current Condition.
+//
+// It matches the records whose field is one of the given records
+// or one of their ancestors through the Parent field.
+func (c ConditionField) ParentOf(data interface{}) *Condition {
+	return c.AddOperator(operator.ParentOf, data)
+}
+
 // IsNull checks if the current condition field is null
 func (c ConditionField) IsNull() *Condition {
 	return c.AddOperator(operator.Equals, nil)
@@ -413,12 +468,35 @@ func (c *Condition) IsEmpty() bool {
 	return false
 }
 
+// hasRawSQL returns true if this condition or one of its nested conditions
+// has a raw SQL fragment added with AndRaw.
+func (c Condition) hasRawSQL() bool {
+	for _, p := range c.predicates {
+		if p.rawSQL != "" || (p.cond != nil && p.cond.hasRawSQL()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNoRawSQL panics if this condition has a raw SQL fragment.
+func (c Condition) checkNoRawSQL() {
+	if c.hasRawSQL() {
+		log.Panic("Raw SQL conditions cannot be filtered on a relation field")
+	}
+}
+
 // getAllExpressions returns a list of all exprs used in this condition,
 // and recursively in all subconditions.
 // Expressions are given in field json format
+//
+// Raw SQL fragments are not parsed: the fields they use are not returned.
 func (c Condition) getAllExpressions(mi *Model) [][]FieldName {
 	var res [][]FieldName
 	for _, p := range c.predicates {
+		if p.rawSQL != "" {
+			continue
+		}
 		res = append(res, p.exprs)
 		if p.cond != nil {
 			res = append(res, p.cond.getAllExpressions(mi)...)
@@ -428,9 +506,12 @@ func (c Condition) getAllExpressions(mi *Model) [][]FieldName {
 }
 
 // substituteExprs recursively replaces condition exprs that match substs keys
-// with the corresponding substs values.
+// with the corresponding substs values. Raw SQL fragments are left untouched.
 func (c *Condition) substituteExprs(mi *Model, substs map[FieldName][]FieldName) {
 	for i, p := range c.predicates {
+		if p.rawSQL != "" {
+			continue
+		}
 		for k, v := range substs {
 			if len(p.exprs) > 0 && joinFieldNames(p.exprs, ExprSep) == k {
 				c.predicates[i].exprs = v
@@ -442,26 +523,79 @@ func (c *Condition) substituteExprs(mi *Model, substs map[FieldName][]FieldName)
 	}
 }
 
+// substituteSearchMethods recursively replaces in the condition the predicates
+// on non stored computed fields by the condition returned by the field's search method.
+func (c *Condition) substituteSearchMethods(rc *RecordCollection) {
+	for i, p := range c.predicates {
+		if p.cond != nil {
+			p.cond.substituteSearchMethods(rc)
+			continue
+		}
+		if p.rawSQL != "" || len(p.exprs) == 0 {
+			continue
+		}
+		fi := rc.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
+		if fi.search == "" {
+			continue
+		}
+		searchRS := rc.env.Pool(fi.model.name)
+		cond := fi.model.methods.MustGet(fi.search).Call(searchRS, p.operator, p.arg).(Conditioner).Underlying()
+		if len(p.exprs) == 1 {
+			c.predicates[i] = predicate{cond: cond, isCond: true, isOr: p.isOr, isNot: p.isNot}
+			continue
+		}
+		// The field is on a related model: we search the matching records
+		// and filter on the relation field instead.
+		c.predicates[i] = predicate{
+			exprs:    p.exprs[:len(p.exprs)-1],
+			operator: operator.In,
+			arg:      searchRS.Search(cond).Ids(),
+			isOr:     p.isOr,
+			isNot:    p.isNot,
+		}
+	}
+}
+
 // substituteChildOfOperator recursively replaces in the condition the
-// predicates with ChildOf operator by the predicates to actually execute.
+// predicates with ChildOf or ParentOf operator by the predicates to actually execute.
 func (c *Condition) substituteChildOfOperator(rc *RecordCollection) {
 	for i, p := range c.predicates {
 		if p.cond != nil {
 			p.cond.substituteChildOfOperator(rc)
 		}
-		if p.operator != operator.ChildOf {
+		if p.rawSQL != "" || (p.operator != operator.ChildOf && p.operator != operator.ParentOf) {
 			continue
 		}
-		recModel := rc.model.getRelatedModelInfo(joinFieldNames(p.exprs, ExprSep))
-		if !recModel.hasParentField() {
-			// If we have no parent field, then we fetch only the "parent" record
+		arg := sanitizeArgs(rc.query.evaluateConditionArgFunctions(p), true)
+		if arg != nil && reflect.ValueOf(arg).Kind() != reflect.Slice {
+			arg = []interface{}{arg}
+		}
+		if arg == nil || reflect.ValueOf(arg).Len() == 0 {
+			// No record is given, so that no record matches
+			c.predicates[i].exprs = []FieldName{ID}
 			c.predicates[i].operator = operator.Equals
+			c.predicates[i].arg = -1
 			continue
 		}
-		var parentIds []int64
-		rc.Env().Cr().Select(&parentIds, adapters[db.DriverName()].childrenIdsQuery(recModel.tableName), p.arg)
 		c.predicates[i].operator = operator.In
-		c.predicates[i].arg = parentIds
+		recModel := rc.model.getRelatedModelInfo(joinFieldNames(p.exprs, ExprSep))
+		if !recModel.hasParentField() {
+			// If we have no parent field, then we fetch only the given records
+			c.predicates[i].arg = arg
+			continue
+		}
+		query := adapters[db.DriverName()].ChildrenIdsQuery(recModel.tableName)
+		if p.operator == operator.ParentOf {
+			query = adapters[db.DriverName()].ParentIdsQuery(recModel.tableName)
+		}
+		var relIds []int64
+		rc.Env().Cr().Select(&relIds, query, arg)
+		c.predicates[i].arg = relIds
+		if len(relIds) == 0 {
+			c.predicates[i].exprs = []FieldName{ID}
+			c.predicates[i].operator = operator.Equals
+			c.predicates[i].arg = -1
+		}
 	}
 }
 
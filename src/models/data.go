@@ -6,15 +6,20 @@ package models
 import (
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"gopkg.in/yaml.v2"
 )
 
 // LoadCSVDataFile loads the data of the given file into the database.
@@ -150,3 +155,253 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 	}
 	return values
 }
+
+// A dataRecord is a record declared in a data file loaded with LoadDataFile
+type dataRecord struct {
+	Model  string                 `json:"model" yaml:"model"`
+	ID     string                 `json:"id" yaml:"id"`
+	Values map[string]interface{} `json:"values" yaml:"values"`
+}
+
+// A dataLoader loads the records of a data file
+type dataLoader struct {
+	env      Environment
+	fileName string
+	// pending holds the external IDs of the records of the file
+	// that have not been loaded yet.
+	pending map[string]bool
+}
+
+// LoadDataFile creates or updates in the given Environment the records
+// declared in the given YAML or JSON data file. The file format is given by
+// its extension (.yml, .yaml or .json).
+//
+// The file holds a list of records, each with the name of its model, its
+// external ID and the values of its fields by field name or JSON name
+// under the "model", "id" and "values" keys respectively.
+//
+// Relation fields are given as the external ID of the related record, or as
+// a list of external IDs for to-many relations. They may reference records
+// that are declared later in the same file. Records are matched by external
+// ID, so that loading a file again updates its records instead of creating
+// new ones.
+//
+// An error that points at the offending record and field is returned if the
+// file cannot be loaded.
+func LoadDataFile(env Environment, fileName string) error {
+	records, err := readDataFile(fileName)
+	if err != nil {
+		return err
+	}
+	dl := dataLoader{
+		env:      env,
+		fileName: fileName,
+		pending:  make(map[string]bool),
+	}
+	for i, rec := range records {
+		if err := dl.checkRecord(rec); err != nil {
+			return fmt.Errorf("%s: record #%d: %s", fileName, i+1, err)
+		}
+	}
+	deferred := make(map[*dataRecord][]string)
+	for len(records) > 0 {
+		// We load first the records whose references are all loaded.
+		// If there is none, there is a reference cycle and we load the
+		// first record without the fields that reference pending records.
+		index := 0
+		for i, rec := range records {
+			if len(dl.pendingFields(rec)) == 0 {
+				index = i
+				break
+			}
+		}
+		rec := records[index]
+		fields := dl.pendingFields(rec)
+		if err := dl.loadRecord(rec, fields); err != nil {
+			return err
+		}
+		delete(dl.pending, rec.ID)
+		if len(fields) > 0 {
+			deferred[rec] = fields
+		}
+		records = append(records[:index], records[index+1:]...)
+	}
+	for rec, fields := range deferred {
+		values := make(map[string]interface{})
+		for _, f := range fields {
+			values[f] = rec.Values[f]
+		}
+		if err := dl.loadRecord(&dataRecord{Model: rec.Model, ID: rec.ID, Values: values}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDataFile returns the records declared in the given data file
+func readDataFile(fileName string) ([]*dataRecord, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data file: %s", err)
+	}
+	var records []*dataRecord
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		err = json.Unmarshal(content, &records)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &records)
+	default:
+		return nil, fmt.Errorf("%s: unknown data file format, expected .json, .yml or .yaml", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to parse data file: %s", fileName, err)
+	}
+	return records, nil
+}
+
+// checkRecord returns an error if the given record has no external ID, an
+// unknown model or field, or if its external ID is already used in the file.
+func (dl *dataLoader) checkRecord(rec *dataRecord) error {
+	if rec.ID == "" {
+		return errors.New("missing external ID")
+	}
+	if dl.pending[rec.ID] {
+		return fmt.Errorf("duplicate external ID %q", rec.ID)
+	}
+	dl.pending[rec.ID] = true
+	mi, ok := Registry.Get(rec.Model)
+	if !ok {
+		return fmt.Errorf("%q: unknown model %q", rec.ID, rec.Model)
+	}
+	for _, key := range sortedValuesKeys(rec.Values) {
+		if _, ok := mi.fields.Get(key); !ok {
+			return fmt.Errorf("%q: unknown field %q in model %s", rec.ID, key, mi.name)
+		}
+	}
+	return nil
+}
+
+// pendingFields returns the keys of the given record's values that reference
+// records of the file that have not been loaded yet.
+func (dl *dataLoader) pendingFields(rec *dataRecord) []string {
+	mi := Registry.MustGet(rec.Model)
+	var res []string
+	for _, key := range sortedValuesKeys(rec.Values) {
+		fi := mi.fields.MustGet(key)
+		if !fi.fieldType.IsRelationType() {
+			continue
+		}
+		for _, ref := range dataRefs(rec.Values[key]) {
+			if dl.pending[ref] {
+				res = append(res, key)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// loadRecord creates or updates the given record, without the given
+// skipped fields. Panics are returned as errors.
+func (dl *dataLoader) loadRecord(rec *dataRecord, skipped []string) (err error) {
+	mi := Registry.MustGet(rec.Model)
+	var field string
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: record %q: %v", dl.fileName, rec.ID, r)
+			if field != "" {
+				err = fmt.Errorf("%s: record %q: field %s: %v", dl.fileName, rec.ID, field, r)
+			}
+		}
+	}()
+	skip := make(map[string]bool)
+	for _, f := range skipped {
+		skip[f] = true
+	}
+	values := make(FieldMap)
+	for _, key := range sortedValuesKeys(rec.Values) {
+		if skip[key] {
+			continue
+		}
+		field = key
+		fi := mi.fields.MustGet(key)
+		val, err := dl.resolveValue(fi, rec.Values[key])
+		if err != nil {
+			return fmt.Errorf("%s: record %q: field %s: %s", dl.fileName, rec.ID, key, err)
+		}
+		values[fi.json] = val
+	}
+	field = ""
+	rc := dl.env.Pool(mi.name)
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	existing := rc.Search(mi.Field(mi.FieldName(externalIDFieldName)).Equals(rec.ID)).Limit(1)
+	if existing.IsEmpty() {
+		values[mi.fields.MustGet(externalIDFieldName).json] = rec.ID
+		rc.Call("Create", NewModelData(mi, values))
+		return nil
+	}
+	if len(values) > 0 {
+		existing.Call("Write", NewModelData(mi, values))
+	}
+	return nil
+}
+
+// resolveValue returns the value to set on the given field for the given
+// value of a data file, resolving the external IDs of relation fields.
+func (dl *dataLoader) resolveValue(fi *Field, value interface{}) (interface{}, error) {
+	if !fi.fieldType.IsRelationType() {
+		return value, nil
+	}
+	refs := dataRefs(value)
+	relRC := dl.env.Pool(fi.relatedModelName)
+	if len(refs) == 0 {
+		return relRC, nil
+	}
+	if fi.fieldType.Is2OneRelationType() && len(refs) > 1 {
+		return nil, fmt.Errorf("expected a single external ID, got %v", refs)
+	}
+	relMI := fi.relatedModel
+	relRC = relRC.Search(relMI.Field(relMI.FieldName(externalIDFieldName)).In(refs))
+	if relRC.Len() == len(refs) {
+		return relRC, nil
+	}
+	found := make(map[string]bool)
+	for _, rec := range relRC.Records() {
+		found[rec.ExternalID()] = true
+	}
+	for _, ref := range refs {
+		if !found[ref] {
+			return nil, fmt.Errorf("unknown external ID %q in model %s", ref, relMI.name)
+		}
+	}
+	return relRC, nil
+}
+
+// dataRefs returns the external IDs given by the value of a relation field
+// in a data file, which may be a single external ID or a list of them.
+func dataRefs(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			res = append(res, fmt.Sprintf("%v", item))
+		}
+		return res
+	}
+	return nil
+}
+
+// sortedValuesKeys returns the keys of the given values, sorted
+func sortedValuesKeys(values map[string]interface{}) []string {
+	res := make([]string, 0, len(values))
+	for k := range values {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
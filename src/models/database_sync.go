@@ -7,14 +7,16 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
 )
 
 // SyncDatabase creates or updates database tables with the data in the model registry
 func SyncDatabase() {
 	log.Info("Updating database schema")
 	adapter := adapters[db.DriverName()]
-	dbTables := adapter.tables()
+	dbTables := adapter.Tables()
 	// Create or update sequences
 	updateDBSequences()
 	// Create or update existing tables
@@ -33,10 +35,18 @@ func SyncDatabase() {
 		if model.IsMixin() || model.IsManual() {
 			continue
 		}
+		model.addCompositeKeyConstraint()
 		buildSQLErrorSubstitutionMap(model)
 		updateDBForeignKeyConstraints(model)
 		updateDBConstraints(model)
 	}
+	// Create or update SQL views
+	for _, model := range Registry.registryByTableName {
+		if !model.isView() {
+			continue
+		}
+		updateDBView(model)
+	}
 	// Run init method on each model
 	for _, model := range Registry.registryByTableName {
 		if model.IsMixin() {
@@ -46,7 +56,7 @@ func SyncDatabase() {
 	}
 
 	// Drop DB tables that are not in the models
-	for dbTable := range adapter.tables() {
+	for dbTable := range adapter.Tables() {
 		var modelExists bool
 		for tableName, model := range Registry.registryByTableName {
 			if dbTable != tableName || model.IsMixin() {
@@ -67,12 +77,17 @@ func buildSQLErrorSubstitutionMap(model *Model) {
 	for sqlConstrName, sqlConstr := range model.sqlConstraints {
 		model.sqlErrors[sqlConstrName] = sqlConstr.errorString
 	}
+	for indexName, index := range model.sqlIndexes {
+		if index.unique {
+			model.sqlErrors[indexName] = fmt.Sprintf("Another record already exists with the same %s", strings.Join(index.fields.Names(), ", "))
+		}
+	}
 	for _, field := range model.fields.registryByJSON {
 		if field.unique {
 			cName := fmt.Sprintf("%s_%s_key", model.tableName, field.json)
 			model.sqlErrors[cName] = fmt.Sprintf("%s must be unique", field.name)
 		}
-		if field.fieldType.IsFKRelationType() {
+		if field.fieldType.IsFKRelationType() && !field.noForeignKey {
 			cName := fmt.Sprintf("%s_%s_fkey", model.tableName, field.json)
 			model.sqlErrors[cName] = fmt.Sprintf("%s must reference an existing %s record", field.name, field.relatedModelName)
 		}
@@ -88,19 +103,19 @@ func updateDBSequences() {
 			continue
 		}
 		exists := false
-		for _, dbSeq := range adapter.sequences("%_bootseq") {
+		for _, dbSeq := range adapter.Sequences("%_bootseq") {
 			if sequence.JSON == dbSeq.Name {
 				exists = true
 			}
 		}
 		if !exists {
-			adapter.createSequence(sequence.JSON, sequence.Increment, sequence.Start)
+			adapter.CreateSequence(sequence.JSON, sequence.Increment, sequence.Start)
 			continue
 		}
-		adapter.alterSequence(sequence.JSON, sequence.Increment, sequence.Start)
+		adapter.AlterSequence(sequence.JSON, sequence.Increment, sequence.Start)
 	}
 	// Drop unused boot sequences
-	for _, dbSeq := range adapter.sequences("%_bootseq") {
+	for _, dbSeq := range adapter.Sequences("%_bootseq") {
 		var sequenceExists bool
 		for _, sequence := range Registry.sequences {
 			if sequence.JSON == dbSeq.Name {
@@ -109,7 +124,7 @@ func updateDBSequences() {
 			}
 		}
 		if !sequenceExists {
-			adapter.dropSequence(dbSeq.Name)
+			adapter.DropSequence(dbSeq.Name)
 		}
 	}
 }
@@ -123,13 +138,13 @@ func createDBTable(m *Model) {
 		if colName == "id" || !fi.isStored() {
 			continue
 		}
-		col := fmt.Sprintf("%s %s", colName, adapter.columnSQLDefinition(fi, false))
+		col := fmt.Sprintf("%s %s", adapter.QuoteIdentifier(colName), adapter.ColumnSQLDefinition(fi, false))
 		columns = append(columns, col)
 	}
 	query := fmt.Sprintf(`
 CREATE TABLE %s (
 	id serial NOT NULL PRIMARY KEY`,
-		adapter.quoteTableName(m.tableName))
+		adapter.QuoteTableName(m.tableName))
 	if len(columns) > 0 {
 		query += ",\n\t" + strings.Join(columns, ",\n\t")
 	}
@@ -137,10 +152,19 @@ CREATE TABLE %s (
 	dbExecuteNoTx(query)
 }
 
+// updateDBView drops and recreates the SQL view of the given view model
+// in the database.
+func updateDBView(m *Model) {
+	adapter := adapters[db.DriverName()]
+	viewName := adapter.QuoteTableName(m.tableName)
+	dbExecuteNoTx(fmt.Sprintf(`DROP VIEW IF EXISTS %s`, viewName))
+	dbExecuteNoTx(fmt.Sprintf(`CREATE VIEW %s AS (%s)`, viewName, m.viewQuery))
+}
+
 // dropDBTable drops the given table in the database
 func dropDBTable(tableName string) {
 	adapter := adapters[db.DriverName()]
-	query := fmt.Sprintf(`DROP TABLE %s`, adapter.quoteTableName(tableName))
+	query := fmt.Sprintf(`DROP TABLE %s`, adapter.QuoteTableName(tableName))
 	dbExecuteNoTx(query)
 }
 
@@ -148,7 +172,7 @@ func dropDBTable(tableName string) {
 // given Model.
 func updateDBColumns(mi *Model) {
 	adapter := adapters[db.DriverName()]
-	dbColumns := adapter.columns(mi.tableName)
+	dbColumns := adapter.Columns(mi.tableName)
 	// create or update columns from registry data
 	for colName, fi := range mi.fields.registryByJSON {
 		if colName == "id" || !fi.isStored() {
@@ -159,11 +183,11 @@ func updateDBColumns(mi *Model) {
 			createDBColumn(fi)
 			continue
 		}
-		if dbColData.DataType != adapter.typeSQL(fi) {
+		if dbColData.DataType != adapter.TypeSQL(fi) || numericDigitsChanged(dbColData, fi) {
 			updateDBColumnDataType(fi)
 		}
-		if (dbColData.IsNullable == "NO" && !adapter.fieldIsNotNull(fi)) ||
-			(dbColData.IsNullable == "YES" && adapter.fieldIsNotNull(fi)) {
+		if (dbColData.IsNullable == "NO" && !adapter.FieldIsNotNull(fi)) ||
+			(dbColData.IsNullable == "YES" && adapter.FieldIsNotNull(fi)) {
 			updateDBColumnNullable(fi)
 		}
 	}
@@ -185,13 +209,13 @@ func createDBColumn(fi *Field) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ADD COLUMN %s %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.columnSQLDefinition(fi, true))
+	`, adapter.QuoteTableName(fi.model.tableName), adapter.QuoteIdentifier(fi.json), adapter.ColumnSQLDefinition(fi, true))
 	dbExecuteNoTx(query)
 	// Set default value if defined
 	if fi.defaultFunc != nil {
 		updateQuery := fmt.Sprintf(`
 			UPDATE %s SET %s = ? WHERE %s IS NULL
-		`, adapter.quoteTableName(fi.model.tableName), fi.json, fi.json)
+		`, adapter.QuoteTableName(fi.model.tableName), adapter.QuoteIdentifier(fi.json), adapter.QuoteIdentifier(fi.json))
 		var defaultValue interface{}
 		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
 			defaultValue = fi.defaultFunc(env)
@@ -208,15 +232,28 @@ func updateDBColumnDataType(fi *Field) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ALTER COLUMN %s SET DATA TYPE %s
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, adapter.typeSQL(fi))
+	`, adapter.QuoteTableName(fi.model.tableName), adapter.QuoteIdentifier(fi.json), adapter.ColumnTypeSQL(fi))
 	dbExecuteNoTx(query)
 }
 
+// numericDigitsChanged returns true if the given numeric column in the database
+// does not have the precision and scale of the digits of the given Field.
+func numericDigitsChanged(dbColData ColumnData, fi *Field) bool {
+	if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Decimal {
+		return false
+	}
+	if fi.digits == (nbutils.Digits{}) {
+		return dbColData.NumericPrecision.Valid
+	}
+	return dbColData.NumericPrecision.Int64 != int64(fi.digits.Precision) ||
+		dbColData.NumericScale.Int64 != int64(fi.digits.Scale)
+}
+
 // updateDBColumnNullable updates the NULL/NOT NULL data in database for the given Field
 func updateDBColumnNullable(fi *Field) {
 	adapter := adapters[db.DriverName()]
 	var verb string
-	if adapter.fieldIsNotNull(fi) {
+	if adapter.FieldIsNotNull(fi) {
 		verb = "SET"
 	} else {
 		verb = "DROP"
@@ -224,7 +261,7 @@ func updateDBColumnNullable(fi *Field) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		ALTER COLUMN %s %s NOT NULL
-	`, adapter.quoteTableName(fi.model.tableName), fi.json, verb)
+	`, adapter.QuoteTableName(fi.model.tableName), adapter.QuoteIdentifier(fi.json), verb)
 	query, _ = sanitizeQuery(query)
 	_, err := db.Exec(query)
 	if err != nil {
@@ -238,7 +275,7 @@ func dropDBColumn(tableName, colName string) {
 	query := fmt.Sprintf(`
 		ALTER TABLE %s
 		DROP COLUMN %s
-	`, adapter.quoteTableName(tableName), colName)
+	`, adapter.QuoteTableName(tableName), adapter.QuoteIdentifier(colName))
 	dbExecuteNoTx(query)
 }
 
@@ -247,8 +284,8 @@ func dropDBColumn(tableName, colName string) {
 func updateDBForeignKeyConstraints(m *Model) {
 	adapter := adapters[db.DriverName()]
 	for colName, fi := range m.fields.registryByJSON {
-		fkContraintInDB := adapter.constraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
-		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored()
+		fkContraintInDB := adapter.ConstraintExists(fmt.Sprintf("%s_%s_fkey", m.tableName, colName))
+		fieldIsFK := fi.fieldType.IsFKRelationType() && fi.isStored() && !fi.noForeignKey
 		switch {
 		case fieldIsFK && !fkContraintInDB:
 			createFKConstraint(m.tableName, colName, fi.relatedModel.tableName, string(fi.onDelete))
@@ -263,12 +300,12 @@ func updateDBForeignKeyConstraints(m *Model) {
 func updateDBConstraints(m *Model) {
 	adapter := adapters[db.DriverName()]
 	for constraintName, constraint := range m.sqlConstraints {
-		if !adapter.constraintExists(constraintName) {
+		if !adapter.ConstraintExists(constraintName) {
 			createConstraint(m.tableName, constraintName, constraint.sql)
 		}
 	}
 dbConLoop:
-	for _, dbConstraintName := range adapter.constraints(fmt.Sprintf("%%_%s_mancon", m.tableName)) {
+	for _, dbConstraintName := range adapter.Constraints(fmt.Sprintf("%%_%s_mancon", m.tableName)) {
 		for constraintName := range m.sqlConstraints {
 			if constraintName == dbConstraintName {
 				continue dbConLoop
@@ -276,12 +313,16 @@ dbConLoop:
 		}
 		dropConstraint(m.tableName, dbConstraintName)
 	}
+	if keyCon := m.compositeKeyConstraintName(); len(m.keyFields) == 0 && adapter.ConstraintExists(keyCon) {
+		dropConstraint(m.tableName, keyCon)
+	}
 }
 
 // createFKConstraint creates an FK constraint for the given column that references the given targetTable
 func createFKConstraint(tableName, colName, targetTable, ondelete string) {
 	adapter := adapters[db.DriverName()]
-	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", colName, adapter.quoteTableName(targetTable), ondelete)
+	constraint := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s ON DELETE %s", adapter.QuoteIdentifier(colName),
+		adapter.QuoteTableName(targetTable), ondelete)
 	createConstraint(tableName, fmt.Sprintf("%s_%s_fkey", tableName, colName), constraint)
 }
 
@@ -295,7 +336,7 @@ func createConstraint(tableName, constraintName, sql string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s ADD CONSTRAINT %s %s
-	`, adapter.quoteTableName(tableName), constraintName, sql)
+	`, adapter.QuoteTableName(tableName), constraintName, sql)
 	dbExecuteNoTx(query)
 }
 
@@ -304,7 +345,7 @@ func dropConstraint(tableName, constraintName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s
-	`, adapter.quoteTableName(tableName), constraintName)
+	`, adapter.QuoteTableName(tableName), constraintName)
 	dbExecuteNoTx(query)
 }
 
@@ -313,14 +354,56 @@ func dropConstraint(tableName, constraintName string) {
 func updateDBIndexes(m *Model) {
 	adapter := adapters[db.DriverName()]
 	for colName, fi := range m.fields.registryByJSON {
-		indexInDB := adapter.indexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
+		indexInDB := adapter.IndexExists(m.tableName, fmt.Sprintf("%s_%s_index", m.tableName, colName))
 		switch {
-		case fi.index && !indexInDB:
+		case fi.hasIndex() && !indexInDB:
 			createColumnIndex(m.tableName, colName)
-		case indexInDB && !fi.index:
+		case indexInDB && !fi.hasIndex():
 			dropColumnIndex(m.tableName, colName)
 		}
+		ftIndexInDB := adapter.IndexExists(m.tableName, fmt.Sprintf("%s_%s_fts_index", m.tableName, colName))
+		switch {
+		case fi.hasFullTextIndex() && !ftIndexInDB:
+			createFullTextIndex(m.tableName, colName, fi.fullText)
+		case ftIndexInDB && !fi.hasFullTextIndex():
+			dropFullTextIndex(m.tableName, colName)
+		}
+	}
+	for name, index := range m.sqlIndexes {
+		if !adapter.IndexExists(m.tableName, name) {
+			createModelIndex(m, index)
+		}
+	}
+	for _, dbIndexName := range adapter.Indexes(m.tableName, "%_mindex") {
+		if _, ok := m.sqlIndexes[dbIndexName]; !ok {
+			dropModelIndex(dbIndexName)
+		}
+	}
+}
+
+// createModelIndex creates the given index on several columns of the table of m
+func createModelIndex(m *Model, index sqlIndex) {
+	adapter := adapters[db.DriverName()]
+	cols := make([]string, len(index.fields))
+	for i, field := range index.fields {
+		cols[i] = adapter.QuoteIdentifier(m.fields.MustGet(field.Name()).json)
 	}
+	var unique string
+	if index.unique {
+		unique = "UNIQUE "
+	}
+	query := fmt.Sprintf(`
+		CREATE %sINDEX %s ON %s (%s)
+	`, unique, index.name, adapter.QuoteTableName(m.tableName), strings.Join(cols, ", "))
+	dbExecuteNoTx(query)
+}
+
+// dropModelIndex drops the index with the given name
+func dropModelIndex(name string) {
+	query := fmt.Sprintf(`
+		DROP INDEX IF EXISTS %s
+	`, name)
+	dbExecuteNoTx(query)
 }
 
 // createColumnIndex creates an column index for colName in the given table
@@ -328,7 +411,7 @@ func createColumnIndex(tableName, colName string) {
 	adapter := adapters[db.DriverName()]
 	query := fmt.Sprintf(`
 		CREATE INDEX %s ON %s (%s)
-	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.quoteTableName(tableName), colName)
+	`, fmt.Sprintf("%s_%s_index", tableName, colName), adapter.QuoteTableName(tableName), adapter.QuoteIdentifier(colName))
 	dbExecuteNoTx(query)
 }
 
@@ -340,6 +423,25 @@ func dropColumnIndex(tableName, colName string) {
 	dbExecuteNoTx(query)
 }
 
+// createFullTextIndex creates a full text search index for colName in the
+// given table with the given text search configuration
+func createFullTextIndex(tableName, colName, config string) {
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`
+		CREATE INDEX %s ON %s USING GIN (%s)
+	`, fmt.Sprintf("%s_%s_fts_index", tableName, colName), adapter.QuoteTableName(tableName),
+		adapter.TextSearchVectorSQL(adapter.QuoteIdentifier(colName), config))
+	dbExecuteNoTx(query)
+}
+
+// dropFullTextIndex drops the full text search index for colName in the given table
+func dropFullTextIndex(tableName, colName string) {
+	query := fmt.Sprintf(`
+		DROP INDEX IF EXISTS %s
+	`, fmt.Sprintf("%s_%s_fts_index", tableName, colName))
+	dbExecuteNoTx(query)
+}
+
 // runInit runs the Init function of the given model if it exists
 func runInit(model *Model) {
 	if _, exists := model.methods.Get("Init"); exists {
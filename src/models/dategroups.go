@@ -0,0 +1,150 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// A DateGrouping is the granularity with which the values of a date
+// or datetime field are grouped in a grouped query.
+type DateGrouping string
+
+// Available date groupings
+const (
+	DateGroupDay     DateGrouping = "day"
+	DateGroupWeek    DateGrouping = "week"
+	DateGroupMonth   DateGrouping = "month"
+	DateGroupQuarter DateGrouping = "quarter"
+	DateGroupYear    DateGrouping = "year"
+)
+
+// valid returns true if dg is a known DateGrouping
+func (dg DateGrouping) valid() bool {
+	switch dg {
+	case DateGroupDay, DateGroupWeek, DateGroupMonth, DateGroupQuarter, DateGroupYear:
+		return true
+	}
+	return false
+}
+
+// next returns the start of the bucket following the bucket starting at t
+func (dg DateGrouping) next(t time.Time) time.Time {
+	switch dg {
+	case DateGroupDay:
+		return t.AddDate(0, 0, 1)
+	case DateGroupWeek:
+		return t.AddDate(0, 0, 7)
+	case DateGroupMonth:
+		return t.AddDate(0, 1, 0)
+	case DateGroupQuarter:
+		return t.AddDate(0, 3, 0)
+	case DateGroupYear:
+		return t.AddDate(1, 0, 0)
+	}
+	log.Panic("Unknown date grouping", "grouping", dg)
+	return t
+}
+
+// splitDateGroupExpr splits a "Field:grouping" group expression into its
+// field and its date grouping. The last returned value is false if expr
+// has no date grouping.
+func splitDateGroupExpr(model *Model, expr FieldName) (FieldName, DateGrouping, bool) {
+	toks := strings.Split(expr.Name(), ":")
+	if len(toks) != 2 {
+		return expr, "", false
+	}
+	return model.FieldName(toks[0]), DateGrouping(toks[1]), true
+}
+
+// GroupByDate returns a new RecordSet grouped by the given date or datetime
+// field truncated to the given grouping, e.g. to get one group per month.
+//
+// Datetime values are truncated in the timezone given by the "tz" key of the
+// context, or in UTC if it is not set. The value of the field in the rows
+// returned by Aggregates is the start of each group.
+func (rc *RecordCollection) GroupByDate(field FieldName, grouping DateGrouping) *RecordCollection {
+	fi := rc.model.getRelatedFieldInfo(field)
+	if fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime {
+		log.Panic("Only date and datetime fields can be grouped by date", "model", rc.model.name, "field", field)
+	}
+	if !grouping.valid() {
+		log.Panic("Unknown date grouping", "grouping", grouping)
+	}
+	rSet := rc.GroupBy(field)
+	dateGroups := make(map[string]DateGrouping)
+	for k, v := range rc.query.dateGroups {
+		dateGroups[k] = v
+	}
+	dateGroups[field.JSON()] = grouping
+	rSet.query.dateGroups = dateGroups
+	return rSet
+}
+
+// groupLocation returns the location in which datetime values
+// are truncated when grouped by date.
+func (q *Query) groupLocation() *time.Location {
+	tz := q.recordSet.env.context.GetString("tz")
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Panic("Invalid timezone in context", "tz", tz, "error", err)
+	}
+	return loc
+}
+
+// dateGroupSQL returns the SQL expression of the given field alias
+// truncated with the date grouping of the given group, if any.
+// The second returned value is false if the group is not grouped by date.
+func (q *Query) dateGroupSQL(group FieldName, alias string) (string, bool) {
+	grouping, ok := q.dateGroups[group.JSON()]
+	if !ok {
+		return alias, false
+	}
+	adapter := adapters[db.DriverName()]
+	fi := q.recordSet.model.getRelatedFieldInfo(group)
+	if fi.fieldType == fieldtype.Date {
+		return adapter.DateTruncSQL(alias, grouping, true, ""), true
+	}
+	return adapter.DateTruncSQL(alias, grouping, false, q.groupLocation().String()), true
+}
+
+// dateGroupCondition returns the condition matching the records of the
+// group of the given date grouped field that starts at value.
+func (q *Query) dateGroupCondition(cond *Condition, group FieldName, value interface{}) *Condition {
+	grouping := q.dateGroups[group.JSON()]
+	start, ok := value.(time.Time)
+	if !ok {
+		return cond.And().Field(group).IsNull()
+	}
+	fi := q.recordSet.model.getRelatedFieldInfo(group)
+	if fi.fieldType == fieldtype.Date {
+		end := grouping.next(start)
+		return cond.And().Field(group).GreaterOrEqual(dates.Date{Time: start}).
+			And().Field(group).Lower(dates.Date{Time: end})
+	}
+	end := grouping.next(start.In(q.groupLocation())).UTC()
+	return cond.And().Field(group).GreaterOrEqual(dates.DateTime{Time: start}).
+		And().Field(group).Lower(dates.DateTime{Time: end})
+}
+
+// groupCondition returns the condition to retrieve the individual aggregated
+// rows in vals, starting from the given initial condition.
+func (q *Query) groupCondition(groups []FieldName, vals map[string]interface{}, initialCondition *Condition) *Condition {
+	res := initialCondition
+	for _, group := range groups {
+		if _, ok := q.dateGroups[group.JSON()]; ok {
+			res = q.dateGroupCondition(res, group, vals[group.JSON()])
+			continue
+		}
+		res = res.And().Field(group).Equals(vals[group.JSON()])
+	}
+	return res
+}
@@ -19,13 +19,14 @@ import (
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 	"github.com/jmoiron/sqlx"
 )
 
 var (
 	db       *sqlx.DB
-	adapters map[string]dbAdapter
+	adapters map[string]DBAdapter
 )
 
 // ConnectionParams are the database agnostic parameters to connect to the database
@@ -43,78 +44,137 @@ type ConnectionParams struct {
 
 // A ColumnData holds information from the db schema about one column
 type ColumnData struct {
-	ColumnName    string
-	DataType      string
-	IsNullable    string
-	ColumnDefault sql.NullString
+	ColumnName       string
+	DataType         string
+	IsNullable       string
+	ColumnDefault    sql.NullString
+	NumericPrecision sql.NullInt64
+	NumericScale     sql.NullInt64
 }
 
-// A seqData holds the data of a sequence in the database
-type seqData struct {
+// A SeqData holds the data of a sequence in the database
+type SeqData struct {
 	Name       string `db:"sequence_name"`
 	StartValue int64  `db:"start_value"`
 	Increment  int64  `db:"increment"`
 }
 
-type dbAdapter interface {
-	// connectionString returns the connection string for the given parameters
-	connectionString(ConnectionParams) string
-	// operatorSQL returns the sql string and placeholders for the given DomainOperator
-	operatorSQL(operator.Operator, interface{}) (string, interface{})
-	// typeSQL returns the SQL type string, including columns constraints if any
-	typeSQL(fi *Field) string
-	// columnSQLDefinition returns the SQL type string, including columns constraints if any
+// A DBAdapter is the SQL dialect of a database engine. All database specific
+// SQL is rendered through the adapter registered for the current driver.
+type DBAdapter interface {
+	// ConnectionString returns the connection string for the given parameters
+	ConnectionString(ConnectionParams) string
+	// BindType returns the sqlx bind type of the placeholders of the driver.
+	// Queries are written with '?' placeholders and rebound with this type.
+	BindType() int
+	// QuoteIdentifier returns the given table or column name quoted so
+	// that it is not interpreted as an SQL keyword.
+	QuoteIdentifier(string) string
+	// LimitOffsetSQL returns the SQL clause to limit the number of rows of a
+	// query and to skip the first rows. 0 means no limit or no offset.
+	LimitOffsetSQL(limit, offset int) string
+	// OnConflictDoNothingSQL returns the clause to append to an INSERT query
+	// so that no row is inserted, nor returned, when a row with the same
+	// conflict columns already exists.
+	OnConflictDoNothingSQL(conflictCols []string) string
+	// OperatorSQL returns the sql string and placeholders for the given DomainOperator
+	OperatorSQL(operator.Operator, interface{}) (string, interface{})
+	// TypeSQL returns the SQL type string, including columns constraints if any
+	TypeSQL(fi *Field) string
+	// ColumnTypeSQL returns the SQL type string of the column of the given Field,
+	// including its size, precision and scale if any.
+	ColumnTypeSQL(fi *Field) string
+	// ColumnSQLDefinition returns the SQL type string, including columns constraints if any
 	//
 	// If null is true, then the column will be nullable, whatever the field defines
-	columnSQLDefinition(fi *Field, null bool) string
-	// tables returns a map of table names of the database
-	tables() map[string]bool
-	// columns returns a list of ColumnData for the given tableName
-	columns(tableName string) map[string]ColumnData
-	// fieldIsNull returns true if the given Field results in a
+	ColumnSQLDefinition(fi *Field, null bool) string
+	// Tables returns a map of table names of the database
+	Tables() map[string]bool
+	// Columns returns a list of ColumnData for the given tableName
+	Columns(tableName string) map[string]ColumnData
+	// FieldIsNotNull returns true if the given Field results in a
 	// NOT NULL column in database.
-	fieldIsNotNull(fi *Field) bool
-	// quoteTableName returns the given table name with sql quotes
-	quoteTableName(string) string
-	// indexExists returns true if an index with the given name exists in the given table
-	indexExists(table string, name string) bool
-	// constraintExists returns true if a constraint with the given name exists
-	constraintExists(name string) bool
-	// constraints returns a list of all constraints matching the given SQL pattern
-	constraints(pattern string) []string
-	// setTransactionIsolation returns the SQL string to set the transaction isolation
-	// level to serializable
-	setTransactionIsolation() string
-	// createSequence creates a DB sequence with the given name
-	createSequence(name string, increment, start int64)
-	// dropSequence drop the DB sequence with the given name
-	dropSequence(name string)
-	// alterSequence modifies the DB sequence given by name
-	alterSequence(name string, increment, restart int64)
-	// nextSequenceValue returns the next value of the given given sequence
-	nextSequenceValue(name string) int64
-	// sequences returns a list of all sequences matching the given SQL pattern
-	sequences(pattern string) []seqData
-	// childrenIdsQuery returns a query that finds all descendant of the given
+	FieldIsNotNull(fi *Field) bool
+	// QuoteTableName returns the given table name with sql quotes
+	QuoteTableName(string) string
+	// IndexExists returns true if an index with the given name exists in the given table
+	IndexExists(table string, name string) bool
+	// Indexes returns a list of the indexes of the given table matching the given SQL pattern
+	Indexes(table string, pattern string) []string
+	// ConstraintExists returns true if a constraint with the given name exists
+	ConstraintExists(name string) bool
+	// Constraints returns a list of all constraints matching the given SQL pattern
+	Constraints(pattern string) []string
+	// SetTransactionIsolation returns the SQL string to set the transaction isolation
+	// level to the given level. sql.LevelDefault must be treated as serializable.
+	SetTransactionIsolation(level sql.IsolationLevel) string
+	// SetTransactionReadOnly returns the SQL string to set the current transaction
+	// in read-only mode
+	SetTransactionReadOnly() string
+	// DateTruncSQL returns the SQL expression that truncates the given date or
+	// datetime expr with the given grouping. If dateOnly is false, the datetime
+	// is truncated in the given timezone and the result is given back in UTC.
+	DateTruncSQL(expr string, grouping DateGrouping, dateOnly bool, tz string) string
+	// TextSearchVectorSQL returns the SQL expression of the text search
+	// vector of the given column expr with the given text search configuration
+	TextSearchVectorSQL(expr string, config string) string
+	// JSONPathSQL returns the SQL expression of the value as text at the
+	// given path inside the JSON document of the column expr.
+	JSONPathSQL(expr string, path []string) string
+	// TextSearchQuerySQL returns the SQL expression of a text search query
+	// with the given configuration. It has a placeholder for the searched text.
+	TextSearchQuerySQL(config string) string
+	// TransactionTimestampQuery returns the SQL query that gets the UTC
+	// timestamp at which the current transaction started
+	TransactionTimestampQuery() string
+	// CreateSequence creates a DB sequence with the given name
+	CreateSequence(name string, increment, start int64)
+	// DropSequence drop the DB sequence with the given name
+	DropSequence(name string)
+	// AlterSequence modifies the DB sequence given by name
+	AlterSequence(name string, increment, restart int64)
+	// NextSequenceValue returns the next value of the given given sequence
+	NextSequenceValue(name string) int64
+	// Sequences returns a list of all sequences matching the given SQL pattern
+	Sequences(pattern string) []SeqData
+	// ChildrenIdsQuery returns a query that finds all descendant of the given
 	// a record from table including itself. The query has a placeholder for the
 	// record's ID
-	childrenIdsQuery(table string) string
-	// substituteErrorMessage substitutes the given error's message by newMsg
-	substituteErrorMessage(err error, newMsg string) error
-	// isSerializationError returns true if the given error is a serialization error
+	ChildrenIdsQuery(table string) string
+	// ParentIdsQuery returns a query that finds all ancestors of the given
+	// records from table including themselves. The query has a placeholder for
+	// the records' IDs
+	ParentIdsQuery(table string) string
+	// SubstituteErrorMessage substitutes the given error's message by newMsg
+	SubstituteErrorMessage(err error, newMsg string) error
+	// IsSerializationError returns true if the given error is a serialization error
 	// and that the failed transaction should be retried.
-	isSerializationError(err error) bool
+	IsSerializationError(err error) bool
 }
 
-// registerDBAdapter adds a adapter to the adapters registry
-// name of the adapter should match the database/sql driver name
-func registerDBAdapter(name string, adapter dbAdapter) {
+// RegisterDBAdapter adds a adapter to the adapters registry
+// name of the adapter should match the database/sql driver name.
+//
+// It is meant to be called in the init function of the package
+// that implements the adapter of another database engine.
+func RegisterDBAdapter(name string, adapter DBAdapter) {
 	adapters[name] = adapter
 }
 
 // Cursor is a wrapper around a database transaction
 type Cursor struct {
-	tx *sqlx.Tx
+	tx  *sqlx.Tx
+	now dates.DateTime
+}
+
+// Now returns the date and time at which the current transaction started,
+// as given by the database. This value is constant for the whole transaction.
+func (c *Cursor) Now() dates.DateTime {
+	if c.now.IsZero() {
+		adapter := adapters[db.DriverName()]
+		dbGet(c.tx, &c.now, adapter.TransactionTimestampQuery())
+	}
+	return c.now
 }
 
 // Execute a query without returning any rows. It panics in case of error.
@@ -136,10 +196,17 @@ func (c *Cursor) Select(dest interface{}, query string, args ...interface{}) {
 }
 
 // newCursor returns a new db cursor on the given database
+// with a serializable isolation level
 func newCursor(db *sqlx.DB) *Cursor {
+	return newCursorWithIsolation(db, sql.LevelDefault)
+}
+
+// newCursorWithIsolation returns a new db cursor on the given database
+// with the given transaction isolation level.
+func newCursorWithIsolation(db *sqlx.DB, level sql.IsolationLevel) *Cursor {
 	adapter := adapters[db.DriverName()]
 	tx := db.MustBegin()
-	dbExecute(tx, adapter.setTransactionIsolation())
+	dbExecute(tx, adapter.SetTransactionIsolation(level))
 	return &Cursor{
 		tx: tx,
 	}
@@ -148,7 +215,7 @@ func newCursor(db *sqlx.DB) *Cursor {
 // DBConnect connects to a database using the given driver and arguments.
 func DBConnect(driver string, params ConnectionParams) {
 	adapter := adapters[driver]
-	connData := adapter.connectionString(params)
+	connData := adapter.ConnectionString(params)
 	db = sqlx.MustConnect(driver, connData)
 	log.Info("Connected to database", "driver", driver, "connData", connData)
 }
@@ -238,7 +305,7 @@ func sanitizeQuery(query string, args ...interface{}) (string, []interface{}) {
 	if err != nil {
 		log.Panic("Unable to expand 'IN' statement", "error", err, "query", query, "args", originalArgs)
 	}
-	q = sqlx.Rebind(sqlx.BindType(db.DriverName()), q)
+	q = sqlx.Rebind(adapters[db.DriverName()].BindType(), q)
 	return q, args
 }
 
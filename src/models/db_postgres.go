@@ -15,18 +15,29 @@
 package models
 
 import (
+	"database/sql"
 	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 type postgresAdapter struct{}
 
+// pgMaxInListSize is the maximum number of values of an IN or NOT IN
+// condition that are sent as separate query parameters. Longer lists are
+// sent as a single array parameter, so as not to exceed the parameters
+// limit of Postgres.
+const pgMaxInListSize = 1000
+
 var pgOperators = map[operator.Operator]string{
 	operator.Equals:         "= ?",
+	operator.IEquals:        "= LOWER(?)",
 	operator.NotEquals:      "!= ?",
 	operator.Contains:       "LIKE ?",
 	operator.NotContains:    "NOT LIKE ?",
@@ -49,7 +60,9 @@ var pgTypes = map[fieldtype.Type]string{
 	fieldtype.Date:      "date",
 	fieldtype.DateTime:  "timestamp without time zone",
 	fieldtype.Integer:   "integer",
+	fieldtype.JSON:      "jsonb",
 	fieldtype.Float:     "numeric",
+	fieldtype.Decimal:   "numeric",
 	fieldtype.HTML:      "text",
 	fieldtype.Binary:    "bytea",
 	fieldtype.Selection: "character varying",
@@ -57,8 +70,8 @@ var pgTypes = map[fieldtype.Type]string{
 	fieldtype.One2One:   "integer",
 }
 
-// connectionString returns the connection string for the given parameters
-func (d *postgresAdapter) connectionString(params ConnectionParams) string {
+// ConnectionString returns the connection string for the given parameters
+func (d *postgresAdapter) ConnectionString(params ConnectionParams) string {
 	connectString := fmt.Sprintf("dbname=%s", params.DBName)
 	if params.SSLMode != "" {
 		connectString += fmt.Sprintf(" sslmode=%s", params.SSLMode)
@@ -87,30 +100,37 @@ func (d *postgresAdapter) connectionString(params ConnectionParams) string {
 	return connectString
 }
 
-// operatorSQL returns the sql string and placeholders for the given DomainOperator
+// OperatorSQL returns the sql string and placeholders for the given DomainOperator
 // Also modifies the given args to match the syntax of the operator.
-func (d *postgresAdapter) operatorSQL(do operator.Operator, arg interface{}) (string, interface{}) {
+func (d *postgresAdapter) OperatorSQL(do operator.Operator, arg interface{}) (string, interface{}) {
 	op := pgOperators[do]
 	switch do {
 	case operator.Contains, operator.IContains, operator.NotContains, operator.NotIContains:
 		arg = fmt.Sprintf("%%%s%%", arg)
+	case operator.In, operator.NotIn:
+		argVal := reflect.ValueOf(arg)
+		if argVal.Kind() != reflect.Slice || argVal.Len() <= pgMaxInListSize {
+			break
+		}
+		op = "= ANY(?)"
+		if do == operator.NotIn {
+			op = "!= ALL(?)"
+		}
+		arg = pq.Array(arg)
 	}
 	return op, arg
 }
 
-// typeSQL returns the sql type string for the given Field
-func (d *postgresAdapter) typeSQL(fi *Field) string {
+// TypeSQL returns the sql type string for the given Field
+func (d *postgresAdapter) TypeSQL(fi *Field) string {
 	typ, _ := pgTypes[fi.fieldType]
 	return typ
 }
 
-// columnSQLDefinition returns the SQL type string, including columns constraints if any
-//
-// If null is true, then the column will be nullable, whatever the field defines
-func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
-	var res string
-	typ, ok := pgTypes[fi.fieldType]
-	res = typ
+// ColumnTypeSQL returns the SQL type string of the column of the given Field,
+// including its size, precision and scale if any.
+func (d *postgresAdapter) ColumnTypeSQL(fi *Field) string {
+	res, ok := pgTypes[fi.fieldType]
 	if !ok {
 		log.Panic("Unknown column type", "type", fi.fieldType, "model", fi.model.name, "field", fi.name)
 	}
@@ -119,13 +139,21 @@ func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
 		if fi.size > 0 {
 			res = fmt.Sprintf("%s(%d)", res, fi.size)
 		}
-	case fieldtype.Float:
+	case fieldtype.Float, fieldtype.Decimal:
 		emptyD := nbutils.Digits{}
 		if fi.digits != emptyD {
 			res = fmt.Sprintf("numeric(%d, %d)", fi.digits.Precision, fi.digits.Scale)
 		}
 	}
-	if d.fieldIsNotNull(fi) && !null {
+	return res
+}
+
+// ColumnSQLDefinition returns the SQL type string, including columns constraints if any
+//
+// If null is true, then the column will be nullable, whatever the field defines
+func (d *postgresAdapter) ColumnSQLDefinition(fi *Field, null bool) string {
+	res := d.ColumnTypeSQL(fi)
+	if d.FieldIsNotNull(fi) && !null {
 		res += " NOT NULL"
 	}
 
@@ -135,17 +163,17 @@ func (d *postgresAdapter) columnSQLDefinition(fi *Field, null bool) string {
 	return res
 }
 
-// fieldIsNull returns true if the given Field results in a
+// FieldIsNotNull returns true if the given Field results in a
 // NOT NULL column in database.
-func (d *postgresAdapter) fieldIsNotNull(fi *Field) bool {
+func (d *postgresAdapter) FieldIsNotNull(fi *Field) bool {
 	if fi.required {
 		return true
 	}
 	return false
 }
 
-// tables returns a map of table names of the database
-func (d *postgresAdapter) tables() map[string]bool {
+// Tables returns a map of table names of the database
+func (d *postgresAdapter) Tables() map[string]bool {
 	var resList []string
 	query := "SELECT table_name FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')"
 	if err := db.Select(&resList, query); err != nil {
@@ -158,15 +186,39 @@ func (d *postgresAdapter) tables() map[string]bool {
 	return res
 }
 
-// quoteTableName returns the given table name with sql quotes
-func (d *postgresAdapter) quoteTableName(tableName string) string {
-	return fmt.Sprintf(`"%s"`, tableName)
+// QuoteTableName returns the given table name with sql quotes
+func (d *postgresAdapter) QuoteTableName(tableName string) string {
+	return d.QuoteIdentifier(tableName)
+}
+
+// BindType returns the sqlx bind type of the placeholders of the driver.
+func (d *postgresAdapter) BindType() int {
+	return sqlx.DOLLAR
 }
 
-// columns returns a list of ColumnData for the given tableName
-func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
+// QuoteIdentifier returns the given table or column name quoted so
+// that it is not interpreted as an SQL keyword.
+func (d *postgresAdapter) QuoteIdentifier(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.Replace(name, `"`, `""`, -1))
+}
+
+// LimitOffsetSQL returns the SQL clause to limit the number of rows of a
+// query and to skip the first rows. 0 means no limit or no offset.
+func (d *postgresAdapter) LimitOffsetSQL(limit, offset int) string {
+	var res string
+	if limit > 0 {
+		res = fmt.Sprintf(`LIMIT %d `, limit)
+	}
+	if offset > 0 {
+		res += fmt.Sprintf(`OFFSET %d`, offset)
+	}
+	return res
+}
+
+// Columns returns a list of ColumnData for the given tableName
+func (d *postgresAdapter) Columns(tableName string) map[string]ColumnData {
 	query := fmt.Sprintf(`
-		SELECT column_name, data_type, is_nullable, column_default
+		SELECT column_name, data_type, is_nullable, column_default, numeric_precision, numeric_scale
 		FROM information_schema.columns
 		WHERE table_schema NOT IN ('pg_catalog', 'information_schema') AND table_name = '%s'
 	`, tableName)
@@ -181,44 +233,52 @@ func (d *postgresAdapter) columns(tableName string) map[string]ColumnData {
 	return res
 }
 
-// indexExists returns true if an index with the given name exists in the given table
-func (d *postgresAdapter) indexExists(table string, name string) bool {
+// IndexExists returns true if an index with the given name exists in the given table
+func (d *postgresAdapter) IndexExists(table string, name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_indexes WHERE tablename = '%s' AND indexname = '%s'", table, name)
 	var cnt int
 	dbGetNoTx(&cnt, query)
 	return cnt > 0
 }
 
-// constraintExists returns true if a constraint with the given name exists in the given table
-func (d *postgresAdapter) constraintExists(name string) bool {
+// Indexes returns a list of the indexes of the given table matching the given SQL pattern
+func (d *postgresAdapter) Indexes(table string, pattern string) []string {
+	query := "SELECT indexname FROM pg_indexes WHERE tablename = ? AND indexname ILIKE ?"
+	var res []string
+	dbSelectNoTx(&res, query, table, pattern)
+	return res
+}
+
+// ConstraintExists returns true if a constraint with the given name exists in the given table
+func (d *postgresAdapter) ConstraintExists(name string) bool {
 	query := fmt.Sprintf("SELECT COUNT(*) FROM pg_constraint WHERE conname = '%s'", name)
 	var cnt int
 	dbGetNoTx(&cnt, query)
 	return cnt > 0
 }
 
-// constraints returns a list of all constraints matching the given SQL pattern
-func (d *postgresAdapter) constraints(pattern string) []string {
+// Constraints returns a list of all constraints matching the given SQL pattern
+func (d *postgresAdapter) Constraints(pattern string) []string {
 	query := "SELECT conname FROM pg_constraint WHERE conname ILIKE ?"
 	var res []string
 	dbSelectNoTx(&res, query, pattern)
 	return res
 }
 
-// createSequence creates a DB sequence with the given name
-func (d *postgresAdapter) createSequence(name string, increment, start int64) {
+// CreateSequence creates a DB sequence with the given name
+func (d *postgresAdapter) CreateSequence(name string, increment, start int64) {
 	query := fmt.Sprintf("CREATE SEQUENCE %s INCREMENT BY %d START WITH %d", name, increment, start)
 	dbExecuteNoTx(query)
 }
 
-// dropSequence drops the DB sequence with the given name
-func (d *postgresAdapter) dropSequence(name string) {
+// DropSequence drops the DB sequence with the given name
+func (d *postgresAdapter) DropSequence(name string) {
 	query := fmt.Sprintf("DROP SEQUENCE IF EXISTS %s", name)
 	dbExecuteNoTx(query)
 }
 
-// alterSequence modifies the DB sequence given by name
-func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
+// AlterSequence modifies the DB sequence given by name
+func (d *postgresAdapter) AlterSequence(name string, increment, restart int64) {
 	query := fmt.Sprintf(`ALTER SEQUENCE %s`, name)
 	if increment != 0 {
 		query += fmt.Sprintf(` INCREMENT BY %d`, increment)
@@ -229,51 +289,142 @@ func (d *postgresAdapter) alterSequence(name string, increment, restart int64) {
 	dbExecuteNoTx(query)
 }
 
-// nextSequenceValue returns the next value of the given given sequence
-func (d *postgresAdapter) nextSequenceValue(name string) int64 {
+// NextSequenceValue returns the next value of the given given sequence
+func (d *postgresAdapter) NextSequenceValue(name string) int64 {
 	query := fmt.Sprintf("SELECT nextval('%s')", name)
 	var val int64
 	dbGetNoTx(&val, query)
 	return val
 }
 
-// sequences returns a list of all sequences matching the given SQL pattern
-func (d *postgresAdapter) sequences(pattern string) []seqData {
+// Sequences returns a list of all sequences matching the given SQL pattern
+func (d *postgresAdapter) Sequences(pattern string) []SeqData {
 	query := "SELECT sequence_name, start_value, increment FROM information_schema.sequences WHERE sequence_name ILIKE ?"
-	var res []seqData
+	var res []SeqData
 	dbSelectNoTx(&res, query, pattern)
 	return res
 }
 
-// setTransactionIsolation returns the SQL string to set the
-// transaction isolation level to serializable
-func (d *postgresAdapter) setTransactionIsolation() string {
-	return "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
+// SetTransactionIsolation returns the SQL string to set the
+// transaction isolation level to the given level. sql.LevelDefault
+// is treated as serializable.
+func (d *postgresAdapter) SetTransactionIsolation(level sql.IsolationLevel) string {
+	var pgLevel string
+	switch level {
+	case sql.LevelDefault, sql.LevelSerializable:
+		pgLevel = "SERIALIZABLE"
+	case sql.LevelRepeatableRead:
+		pgLevel = "REPEATABLE READ"
+	case sql.LevelReadCommitted:
+		pgLevel = "READ COMMITTED"
+	case sql.LevelReadUncommitted:
+		pgLevel = "READ UNCOMMITTED"
+	default:
+		log.Panic("Unsupported transaction isolation level", "level", level)
+	}
+	return fmt.Sprintf("SET TRANSACTION ISOLATION LEVEL %s", pgLevel)
+}
+
+// SetTransactionReadOnly returns the SQL string to set the
+// current transaction in read-only mode
+func (d *postgresAdapter) SetTransactionReadOnly() string {
+	return "SET TRANSACTION READ ONLY"
+}
+
+// DateTruncSQL returns the SQL expression that truncates the given date or
+// datetime expr with the given grouping. If dateOnly is false, the datetime
+// is truncated in the given timezone and the result is given back in UTC.
+func (d *postgresAdapter) DateTruncSQL(expr string, grouping DateGrouping, dateOnly bool, tz string) string {
+	if dateOnly {
+		return fmt.Sprintf("date_trunc('%s', %s)::date", grouping, expr)
+	}
+	return fmt.Sprintf("date_trunc('%s', %s AT TIME ZONE 'UTC' AT TIME ZONE '%s') AT TIME ZONE '%s' AT TIME ZONE 'UTC'",
+		grouping, expr, tz, tz)
+}
+
+// TextSearchVectorSQL returns the SQL expression of the text search
+// vector of the given column expr with the given text search configuration
+func (d *postgresAdapter) TextSearchVectorSQL(expr string, config string) string {
+	return fmt.Sprintf("to_tsvector('%s', coalesce(%s, ''))", strings.Replace(config, "'", "''", -1), expr)
+}
+
+// OnConflictDoNothingSQL returns the clause to append to an INSERT query
+// so that no row is inserted, nor returned, when a row with the same
+// conflict columns already exists.
+func (d *postgresAdapter) OnConflictDoNothingSQL(conflictCols []string) string {
+	conflicts := make([]string, len(conflictCols))
+	for i, col := range conflictCols {
+		conflicts[i] = d.QuoteIdentifier(col)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(conflicts, ", "))
+}
+
+// JSONPathSQL returns the SQL expression of the value as text at the
+// given path inside the JSON document of the column expr.
+func (d *postgresAdapter) JSONPathSQL(expr string, path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = fmt.Sprintf("'%s'", strings.Replace(key, "'", "''", -1))
+	}
+	return fmt.Sprintf("jsonb_extract_path_text(%s, %s)", expr, strings.Join(keys, ", "))
+}
+
+// TextSearchQuerySQL returns the SQL expression of a text search query
+// with the given configuration. It has a placeholder for the searched text.
+func (d *postgresAdapter) TextSearchQuerySQL(config string) string {
+	return fmt.Sprintf("plainto_tsquery('%s', ?)", strings.Replace(config, "'", "''", -1))
+}
+
+// TransactionTimestampQuery returns the SQL query that gets the UTC
+// timestamp at which the current transaction started
+func (d *postgresAdapter) TransactionTimestampQuery() string {
+	return "SELECT now() AT TIME ZONE 'UTC'"
 }
 
-// childrenIdsQuery returns a query that finds all descendant of the given
+// ChildrenIdsQuery returns a query that finds all descendant of the given
 // a record from table including itself. The query has a placeholder for the
 // record's ID
-func (d *postgresAdapter) childrenIdsQuery(table string) string {
+func (d *postgresAdapter) ChildrenIdsQuery(table string) string {
 	res := fmt.Sprintf(`
 WITH RECURSIVE "recursive_query_children_ids" AS
 (
 	SELECT  id
 	FROM    %s "m1"
-	WHERE   id = ?
-UNION ALL
+	WHERE   id IN (?)
+UNION
 	SELECT  "m2".id
 	FROM    %s "m2"
 	JOIN    "recursive_query_children_ids"
 	ON      "m2".parent_id = "recursive_query_children_ids".id
 )
 SELECT  id
-FROM    recursive_query_children_ids`, d.quoteTableName(table), d.quoteTableName(table))
+FROM    recursive_query_children_ids`, d.QuoteTableName(table), d.QuoteTableName(table))
+	return res
+}
+
+// ParentIdsQuery returns a query that finds all ancestors of the given
+// records from table including themselves. The query has a placeholder for
+// the records' IDs
+func (d *postgresAdapter) ParentIdsQuery(table string) string {
+	res := fmt.Sprintf(`
+WITH RECURSIVE "recursive_query_parent_ids" AS
+(
+	SELECT  id, parent_id
+	FROM    %s "m1"
+	WHERE   id IN (?)
+UNION
+	SELECT  "m2".id, "m2".parent_id
+	FROM    %s "m2"
+	JOIN    "recursive_query_parent_ids"
+	ON      "m2".id = "recursive_query_parent_ids".parent_id
+)
+SELECT  id
+FROM    recursive_query_parent_ids`, d.QuoteTableName(table), d.QuoteTableName(table))
 	return res
 }
 
-// substituteErrorMessage substitutes the given error's message by newMsg
-func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error {
+// SubstituteErrorMessage substitutes the given error's message by newMsg
+func (d *postgresAdapter) SubstituteErrorMessage(err error, newMsg string) error {
 	pgError, ok := err.(*pq.Error)
 	if !ok {
 		return err
@@ -282,13 +433,13 @@ func (d *postgresAdapter) substituteErrorMessage(err error, newMsg string) error
 	return pgError
 }
 
-// isSerializationError returns true if the given error is a serialization error
+// IsSerializationError returns true if the given error is a serialization error
 // and that the failed transaction should be retried.
-func (d *postgresAdapter) isSerializationError(err error) bool {
+func (d *postgresAdapter) IsSerializationError(err error) bool {
 	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Class() == "40" {
 		return true
 	}
 	return false
 }
 
-var _ dbAdapter = new(postgresAdapter)
+var _ DBAdapter = new(postgresAdapter)
@@ -15,8 +15,11 @@
 package models
 
 import (
+	"database/sql"
 	"fmt"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/logging"
 )
@@ -45,6 +48,9 @@ type Environment struct {
 	previousMethod *Method
 	recursions     uint8
 	nextNegativeID int64
+	readOnly       bool
+	clone          bool
+	recompute      *recomputeQueue
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -62,13 +68,113 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
-// commit the transaction of this environment.
+// NextSequence returns the next value of the Sequence with the given
+// name or JSON name, formatted with the Sequence's prefix, suffix and padding.
+//
+// Sequences values are not rolled back with the transaction, so that
+// there may be gaps between the returned values.
+func (env Environment) NextSequence(code string) string {
+	return Registry.MustGetSequence(code).NextFormattedValue(env)
+}
+
+// ReadOnly returns true if this Environment's transaction is read-only.
+// Creating, updating or deleting records in a read-only Environment panics.
+func (env Environment) ReadOnly() bool {
+	return env.readOnly
+}
+
+// Flush materializes in the database all the pending operations of this
+// Environment, such as postponed recomputations of stored computed fields.
+//
+// Create and Write calls are always executed immediately in the database,
+// so that Flush is only needed before executing raw SQL queries that rely
+// on stored computed fields. Committing the transaction of an Environment
+// implies a Flush.
+func (env Environment) Flush() {
+	for !env.recompute.isEmpty() {
+		for _, rp := range env.recompute.pop(env) {
+			if rp.recs.IsEmpty() {
+				// records have been unlinked since the recomputation was postponed
+				continue
+			}
+			rp.recs.applyMethod(rp.method)
+		}
+	}
+}
+
+// TranslateError returns the given error with its message translated in the
+// language of this Environment if it is a UserError or one of its typed
+// variants. Other errors are returned as is.
+func (env Environment) TranslateError(err error) error {
+	return i18n.TranslateError(env.context.GetString("lang"), err)
+}
+
+// DeferRecompute executes fn and postpones the recomputation of all the stored
+// computed fields modified inside fn until fn returns. Records that need to
+// be recomputed are then recomputed in batch, each of them only once whatever
+// the number of writes on the records it depends on.
+//
+// This is meant to speed up bulk loads where many records are written
+// one by one. Stored computed fields of the records modified inside fn are
+// not up to date until fn returns. If fn panics, the panic is propagated
+// and postponed recomputations are dropped. Nested calls only recompute
+// when the outermost call returns.
+func (env Environment) DeferRecompute(fn func()) {
+	env.recompute.deferred++
+	var done bool
+	func() {
+		defer func() {
+			env.recompute.deferred--
+			if !done && env.recompute.deferred == 0 {
+				env.recompute.reset()
+			}
+		}()
+		fn()
+		done = true
+	}()
+	if env.recompute.deferred == 0 {
+		env.Flush()
+	}
+}
+
+// CloneReadOnly returns a new Environment with the same user and context as
+// this one, but with its own database connection, read-only transaction and
+// cache. It is meant to run read-only work in parallel goroutines, each of
+// them using its own clone.
+//
+// Creating, updating or deleting records in a clone panics. A clone does not
+// see the changes of this Environment that have not been committed yet.
+//
+// Call Close on the clone when it is not needed anymore to release its
+// database connection.
+func (env Environment) CloneReadOnly() Environment {
+	clone := newEnvironment(env.uid)
+	clone.context = env.context.Copy()
+	clone.super = env.super
+	clone.setReadOnly()
+	clone.clone = true
+	return clone
+}
+
+// Close releases the database connection of an Environment returned by
+// CloneReadOnly by rolling back its transaction. The Environment must not
+// be used afterwards.
+func (env Environment) Close() {
+	if !env.clone {
+		log.Panic("Only Environments returned by CloneReadOnly can be closed")
+	}
+	env.rollback()
+}
+
+// commit flushes the pending operations of this environment and commits
+// its transaction.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
-func (env Environment) commit() {
-	env.Cr().tx.Commit()
+func (env Environment) commit() error {
+	env.Flush()
+	return env.Cr().tx.Commit()
 }
 
 // rollback the transaction of this environment.
@@ -80,6 +186,13 @@ func (env Environment) rollback() {
 	env.Cr().tx.Rollback()
 }
 
+// setReadOnly sets this Environment's transaction in read-only mode.
+// It must be called before any other query in the transaction.
+func (env *Environment) setReadOnly() {
+	env.cr.Execute(adapters[db.DriverName()].SetTransactionReadOnly())
+	env.readOnly = true
+}
+
 // checkRecursion panics if the recursion depth limit is reached
 func (env Environment) checkRecursion() {
 	if env.recursions > maxRecursionDepth {
@@ -121,11 +234,23 @@ func (env Environment) DumpCache() string {
 // or rollback() on the returned Environment after operation to release
 // the database connection.
 func newEnvironment(uid int64) Environment {
+	return newEnvironmentWithIsolation(uid, sql.LevelDefault)
+}
+
+// newEnvironmentWithIsolation returns a new Environment for the given user ID
+// whose transaction has the given isolation level. sql.LevelDefault sets the
+// default serializable isolation level.
+//
+// WARNING: Callers to newEnvironmentWithIsolation should ensure to either call
+// commit() or rollback() on the returned Environment after operation to release
+// the database connection.
+func newEnvironmentWithIsolation(uid int64, level sql.IsolationLevel) Environment {
 	env := Environment{
-		cr:      newCursor(db),
-		uid:     uid,
-		context: types.NewContext(),
-		cache:   newCache(),
+		cr:        newCursorWithIsolation(db, level),
+		uid:       uid,
+		context:   types.NewContext(),
+		cache:     newCache(),
+		recompute: newRecomputeQueue(),
 	}
 	return env
 }
@@ -137,20 +262,30 @@ func newEnvironment(uid int64) Environment {
 // rolls it back otherwise, returning an arror. Database serialization
 // errors are automatically retried several times before returning an
 // error if they still occur.
-func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) error {
-	return doExecuteInNewEnvironment(uid, 0, fnct)
+//
+// Use WithIsolationLevel to change the isolation level of the transaction,
+// WithReadOnly to forbid any modification of the data and WithMaxRetries to
+// change the number of times serialization errors are retried.
+func ExecuteInNewEnvironment(uid int64, fnct func(Environment), opts ...TxOption) error {
+	options := txOptions{
+		maxRetries: DBSerializationMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return doExecuteInNewEnvironment(uid, 0, options, fnct)
 }
 
-func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment)) (rError error) {
-	env := newEnvironment(uid)
+func doExecuteInNewEnvironment(uid int64, retries uint8, options txOptions, fnct func(Environment)) (rError error) {
+	env := newEnvironmentWithIsolation(uid, options.isolation)
 	defer func() {
 		if r := recover(); r != nil {
 			env.rollback()
-			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			if err, ok := r.(error); ok && adapters[db.DriverName()].IsSerializationError(err) {
 				// Transaction error
 				retries++
-				if retries < DBSerializationMaxRetries {
-					if doExecuteInNewEnvironment(uid, retries, fnct) == nil {
+				if retries < options.maxRetries {
+					if doExecuteInNewEnvironment(uid, retries, options, fnct) == nil {
 						rError = nil
 						return
 					}
@@ -159,12 +294,133 @@ func doExecuteInNewEnvironment(uid int64, retries uint8, fnct func(Environment))
 			rError = logging.LogPanicData(r)
 			return
 		}
-		env.commit()
+		rError = env.commit()
 	}()
+	if options.readOnly {
+		env.setReadOnly()
+	}
 	fnct(env)
+	// Flush here so that recomputation panics are recovered above
+	env.Flush()
 	return nil
 }
 
+// DefaultRetryBackoff is the default duration to wait before retrying a
+// transaction in RunInTransaction. This duration is doubled at each retry.
+const DefaultRetryBackoff = 10 * time.Millisecond
+
+// txOptions holds the transaction and retry parameters of RunInTransaction
+// and ExecuteInNewEnvironment
+type txOptions struct {
+	maxRetries uint8
+	backoff    time.Duration
+	isolation  sql.IsolationLevel
+	readOnly   bool
+}
+
+// A TxOption modifies the transaction opened by RunInTransaction or
+// ExecuteInNewEnvironment, such as its isolation level or retry behaviour.
+type TxOption func(*txOptions)
+
+// RetryOption is the former name of TxOption.
+//
+// Deprecated: use TxOption instead.
+type RetryOption = TxOption
+
+// WithMaxRetries sets the maximum number of times a transaction is
+// executed before RunInTransaction gives up.
+func WithMaxRetries(maxRetries uint8) TxOption {
+	return func(o *txOptions) {
+		o.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the duration to wait before the first retry of a
+// transaction. This duration is doubled at each subsequent retry.
+func WithBackoff(backoff time.Duration) TxOption {
+	return func(o *txOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WithIsolationLevel sets the isolation level of the transactions opened
+// by RunInTransaction or ExecuteInNewEnvironment. The default is serializable.
+//
+// Serialization errors only occur with the sql.LevelRepeatableRead and
+// sql.LevelSerializable levels. With lower levels, transactions are
+// never retried, but concurrent transactions may see each other's changes.
+func WithIsolationLevel(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// WithReadOnly makes the transactions opened by RunInTransaction or
+// ExecuteInNewEnvironment read-only.
+// Trying to create, update or delete records in such a transaction panics.
+func WithReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// RunInTransaction executes the given fnct in a new Environment
+// within a new transaction.
+//
+// The transaction is committed if fnct returns nil and rolled back if
+// fnct returns an error or panics. If the transaction fails because of
+// a serialization error or a deadlock, it is rolled back and retried
+// with an exponential backoff. Use WithMaxRetries and WithBackoff to
+// change the default retry behaviour, WithIsolationLevel to change the
+// isolation level of the transaction and WithReadOnly to forbid any
+// modification of the data.
+func RunInTransaction(uid int64, fnct func(env Environment) error, opts ...TxOption) error {
+	options := txOptions{
+		maxRetries: DBSerializationMaxRetries,
+		backoff:    DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	var err error
+	for i := uint8(0); i < options.maxRetries || i == 0; i++ {
+		if i > 0 {
+			time.Sleep(options.backoff << (i - 1))
+		}
+		err = runInTransactionOnce(uid, options, fnct)
+		if err == nil || !adapters[db.DriverName()].IsSerializationError(err) {
+			return err
+		}
+		log.Debug("Retrying transaction after serialization error", "retry", i+1, "error", err)
+	}
+	return err
+}
+
+// runInTransactionOnce executes fnct in a new Environment and commits
+// the transaction if fnct succeeded. Serialization errors are returned
+// as is so that the caller can decide to retry.
+func runInTransactionOnce(uid int64, options txOptions, fnct func(env Environment) error) (rError error) {
+	env := newEnvironmentWithIsolation(uid, options.isolation)
+	defer func() {
+		if r := recover(); r != nil {
+			env.rollback()
+			if err, ok := r.(error); ok && adapters[db.DriverName()].IsSerializationError(err) {
+				rError = err
+				return
+			}
+			rError = logging.LogPanicData(r)
+		}
+	}()
+	if options.readOnly {
+		env.setReadOnly()
+	}
+	if err := fnct(env); err != nil {
+		env.rollback()
+		return err
+	}
+	return env.commit()
+}
+
 // SimulateInNewEnvironment executes the given fnct in a new Environment
 // within a new transaction and rolls back the transaction at the end.
 //
@@ -179,7 +435,7 @@ func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)
 	defer func() {
 		env.rollback()
 		if r := recover(); r != nil {
-			if err, ok := r.(error); ok && adapters[db.DriverName()].isSerializationError(err) {
+			if err, ok := r.(error); ok && adapters[db.DriverName()].IsSerializationError(err) {
 				// Transaction error. We try again even if we rollback anyway
 				// to be as close as ExecuteInNewEnvironment as possible
 				retries++
@@ -202,3 +458,36 @@ func doSimulateInNewEnvironment(uid int64, retries uint8, fnct func(Environment)
 func (env Environment) Pool(modelName string) *RecordCollection {
 	return newRecordCollection(env, modelName)
 }
+
+// PoolFiltered returns a RecordCollection of the given modelName filtered
+// on the given condition. This is a shortcut for env.Pool(modelName).Search(cond)
+// which also checks that all the fields used in cond exist in the model.
+//
+// As with Search, no query is executed until the records are read.
+func (env Environment) PoolFiltered(modelName string, cond *Condition) *RecordCollection {
+	rc := newRecordCollection(env, modelName)
+	rc.model.checkConditionFields(cond)
+	return rc.Search(cond)
+}
+
+// SetCacheLimit sets the maximum number of records kept in the cache of this
+// Environment. When the limit is exceeded, the least recently used records
+// are removed from the cache before records are loaded from the database.
+// They will be read again from the database if they are needed.
+//
+// The limit is not strict, since records are never removed while a load is in
+// progress, and memory records created by New are never removed. A limit of
+// 0 or less, which is the default, means no limit.
+func (env Environment) SetCacheLimit(n int) {
+	env.cache.setLimit(n)
+}
+
+// New returns a memory only record of the given model with the given values.
+//
+// The returned record has a negative ID and is never read from the database:
+// Get and Set only work on its in-memory values and computed fields are
+// computed from them. Calling Create on it inserts it into the database.
+func (env Environment) New(modelName string, values FieldMap) *RecordCollection {
+	rc := env.Pool(modelName)
+	return rc.Call("New", NewModelData(rc.model, values)).(RecordSet).Collection()
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// newUserError logs the given message with its context key/value pairs
+// and returns a UserError with this message. The context is kept as
+// debug data of the error.
+func newUserError(msg string, ctx ...interface{}) exceptions.UserError {
+	log.Error(msg, ctx...)
+	var debug strings.Builder
+	for i := 0; i+1 < len(ctx); i += 2 {
+		fmt.Fprintf(&debug, "%v : %v\n", ctx[i], ctx[i+1])
+	}
+	return exceptions.UserError{
+		Message: msg,
+		Debug:   debug.String(),
+	}
+}
+
+// panicValidationError logs the given message with its context and panics
+// with a ValidationError.
+func panicValidationError(msg string, ctx ...interface{}) {
+	panic(exceptions.ValidationError{UserError: newUserError(msg, ctx...)})
+}
+
+// panicAccessError logs the given message with its context and panics
+// with an AccessError.
+func panicAccessError(msg string, ctx ...interface{}) {
+	panic(exceptions.AccessError{UserError: newUserError(msg, ctx...)})
+}
+
+// panicMissingError logs the given message with its context and panics
+// with a MissingError.
+func panicMissingError(msg string, ctx ...interface{}) {
+	panic(exceptions.MissingError{UserError: newUserError(msg, ctx...)})
+}
+
+// asValidationError returns the given panic data of a failed constraint
+// as a ValidationError if it is a plain UserError. Other panic data,
+// including the typed variants of UserError, are returned as is.
+func asValidationError(r interface{}) interface{} {
+	if userErr, ok := r.(exceptions.UserError); ok {
+		return exceptions.ValidationError{UserError: userErr}
+	}
+	return r
+}
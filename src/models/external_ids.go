@@ -0,0 +1,184 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+)
+
+const (
+	// externalIDFieldName is the name of the field that holds the external ID
+	// of the records of all models that inherit ModelMixin.
+	externalIDFieldName = "HexyaExternalID"
+	// externalIDModelName is the name of the model that maps declared
+	// external IDs to the records they identify.
+	externalIDModelName = "HexyaModelData"
+)
+
+// createExternalIDModel creates the model that maps the declared external IDs
+// to their records, with a unique index on their module and name.
+func createExternalIDModel() *Model {
+	newModel := Model{
+		name:            externalIDModelName,
+		rulesRegistry:   newRecordRuleRegistry(),
+		tableName:       strutils.SnakeCase(externalIDModelName),
+		fields:          newFieldsCollection(),
+		methods:         newMethodsCollection(),
+		options:         SystemModel,
+		sqlErrors:       make(map[string]string),
+		defaultOrderStr: []string{"ID"},
+	}
+	pkField := &Field{
+		name:      "ID",
+		json:      "id",
+		model:     &newModel,
+		required:  true,
+		noCopy:    true,
+		fieldType: fieldtype.Integer,
+		structField: reflect.TypeOf(
+			struct {
+				ID int64
+			}{},
+		).Field(0),
+	}
+	newModel.fields.add(pkField)
+	for _, name := range []string{"Module", "Name", "Model"} {
+		newModel.fields.add(&Field{
+			name:        name,
+			json:        strutils.SnakeCase(name),
+			model:       &newModel,
+			required:    name != "Module",
+			noCopy:      true,
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Name: name, Type: reflect.TypeOf("")},
+		})
+	}
+	resIDField := &Field{
+		name:        "ResID",
+		json:        "res_id",
+		model:       &newModel,
+		required:    true,
+		noCopy:      true,
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Name: "ResID", Type: reflect.TypeOf(int64(0))},
+	}
+	newModel.fields.add(resIDField)
+	newModel.addIndex(FieldNames{newModel.FieldName("Module"), newModel.FieldName("Name")}, true)
+	newModel.addIndex(FieldNames{newModel.FieldName("Model"), newModel.FieldName("ResID")}, false)
+
+	Registry.add(&newModel)
+	injectMixInModel(Registry.MustGet("BaseMixin"), &newModel)
+	return &newModel
+}
+
+// splitExternalID returns the module and the name of the given external ID.
+// The module is the part before the first dot, e.g. "base" in
+// "base.main_company". External IDs without dot have an empty module.
+func splitExternalID(externalID string) (string, string) {
+	if i := strings.Index(externalID, "."); i >= 0 {
+		return externalID[:i], externalID[i+1:]
+	}
+	return "", externalID
+}
+
+// Ref returns the record with the given external ID, whatever its model.
+//
+// External IDs, such as "base.main_company", are made of the name of a module
+// and of a name that is unique in this module. They are declared when
+// creating or writing a record with a value for its HexyaExternalID field,
+// such as from the id column of data files, and are then mapped to this record.
+//
+// Ref panics if no record has this external ID.
+func (env Environment) Ref(externalID string) *RecordCollection {
+	type extRef struct {
+		Model string `db:"model"`
+		ResID int64  `db:"res_id"`
+	}
+	adapter := adapters[db.DriverName()]
+	module, name := splitExternalID(externalID)
+	query := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s = ? AND %s = ?`,
+		adapter.QuoteIdentifier("model"), adapter.QuoteIdentifier("res_id"), adapter.QuoteTableName(strutils.SnakeCase(externalIDModelName)),
+		adapter.QuoteIdentifier("module"), adapter.QuoteIdentifier("name"))
+	var refs []extRef
+	env.cr.Select(&refs, query, module, name)
+	if len(refs) == 0 {
+		panicMissingError("Unknown external ID", "externalID", externalID)
+	}
+	mi, ok := Registry.Get(refs[0].Model)
+	if !ok {
+		panicMissingError("Unknown model of external ID", "externalID", externalID, "model", refs[0].Model)
+	}
+	// We check that the record still exists, since it may have been deleted
+	// by the database or without unlinking it.
+	res := env.Pool(mi.name).Search(mi.Field(ID).Equals(refs[0].ResID))
+	if res.IsEmpty() {
+		panicMissingError("Record of external ID does not exist anymore", "externalID", externalID,
+			"model", refs[0].Model, "id", refs[0].ResID)
+	}
+	return res
+}
+
+// ExternalID returns the external ID of this record. This is the reverse of
+// Environment.Ref.
+//
+// It panics if this RecordCollection is not a singleton.
+func (rc *RecordCollection) ExternalID() string {
+	rc.EnsureOne()
+	return rc.Get(rc.model.FieldName(externalIDFieldName)).(string)
+}
+
+// updateExternalIDs maps the records of this RecordCollection to the external
+// ID declared in the given create or write data, if any.
+func (rc *RecordCollection) updateExternalIDs(data RecordData) {
+	if _, ok := rc.model.fields.Get(externalIDFieldName); !ok || rc.hasNegIds || !data.Underlying().Has(rc.model.FieldName(externalIDFieldName)) {
+		return
+	}
+	externalID, _ := data.Underlying().Get(rc.model.FieldName(externalIDFieldName)).(string)
+	rc.removeExternalIDs()
+	if externalID == "" {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	module, name := splitExternalID(externalID)
+	// The external ID is moved to these records if it was mapped to other
+	// ones, such as records deleted with DeleteAll.
+	rc.env.cr.Execute(fmt.Sprintf(`DELETE FROM %s WHERE %s = ? AND %s = ?`,
+		adapter.QuoteTableName(strutils.SnakeCase(externalIDModelName)), adapter.QuoteIdentifier("module"),
+		adapter.QuoteIdentifier("name")), module, name)
+	query := fmt.Sprintf(`INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, ?, ?)`,
+		adapter.QuoteTableName(strutils.SnakeCase(externalIDModelName)), adapter.QuoteIdentifier("module"),
+		adapter.QuoteIdentifier("name"), adapter.QuoteIdentifier("model"), adapter.QuoteIdentifier("res_id"))
+	rc.env.cr.Execute(query, module, name, rc.model.name, rc.ids[0])
+}
+
+// checkSingleExternalID panics if fMap declares an external ID for several
+// records of this RecordCollection, since an external ID identifies a single
+// record.
+func (rc *RecordCollection) checkSingleExternalID(fMap FieldMap) {
+	if _, ok := rc.model.fields.Get(externalIDFieldName); !ok || len(rc.Ids()) <= 1 {
+		return
+	}
+	if externalID, _ := fMap.Get(rc.model.FieldName(externalIDFieldName)); externalID != nil && externalID != "" {
+		panicValidationError("An external ID cannot be given to several records", "model", rc.model.name,
+			"externalID", externalID, "ids", rc.ids)
+	}
+}
+
+// removeExternalIDs removes the external IDs mapped to the records of this
+// RecordCollection.
+func (rc *RecordCollection) removeExternalIDs() {
+	if _, ok := rc.model.fields.Get(externalIDFieldName); !ok || len(rc.ids) == 0 || rc.hasNegIds {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = ? AND %s IN (?)`,
+		adapter.QuoteTableName(strutils.SnakeCase(externalIDModelName)), adapter.QuoteIdentifier("model"),
+		adapter.QuoteIdentifier("res_id"))
+	rc.env.cr.Execute(query, rc.model.name, rc.ids)
+}
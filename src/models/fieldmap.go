@@ -4,6 +4,7 @@
 package models
 
 import (
+	"reflect"
 	"sort"
 )
 
@@ -140,3 +141,18 @@ func (fm FieldMap) Copy() FieldMap {
 	}
 	return res
 }
+
+// Diff returns a new FieldMap with the entries of other that do not exist
+// in this FieldMap or that have a different value.
+//
+// Keys are compared as is, so that both FieldMaps must use the same keys format.
+func (fm FieldMap) Diff(other FieldMap) FieldMap {
+	res := make(FieldMap)
+	for k, v := range other {
+		if ov, ok := fm[k]; ok && reflect.DeepEqual(ov, v) {
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
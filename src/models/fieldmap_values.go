@@ -0,0 +1,151 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
+)
+
+// FieldMapFromValues returns a FieldMap of the given model with the given
+// url.Values, such as those of an HTML form post or a query string.
+//
+// Keys of values can be either field names or field JSON names. String
+// values are converted to the type of their field. Values of to-many
+// relation fields are the ids of the related records, one per value of the
+// key. For other fields, only the last value of the key is used, so that an
+// unchecked checkbox can be sent as a hidden field with the same name.
+// Boolean fields accept "on" as true.
+//
+// It returns an error if a key is not a field of the model or if a value
+// cannot be converted to the type of its field.
+func FieldMapFromValues(mi *Model, values url.Values) (FieldMap, error) {
+	res := make(FieldMap)
+	var unknown []string
+	for key, vals := range values {
+		fi, ok := mi.fields.Get(key)
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		val, err := parseFieldValue(fi, vals)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for field %s of model %s: %s", fi.name, mi.name, err)
+		}
+		res[fi.json] = val
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown fields in model %s: %s", mi.name, strings.Join(unknown, ", "))
+	}
+	mi.convertValuesToFieldType(&res, false)
+	return res, nil
+}
+
+// parseFieldValue converts the given string values to a value suitable
+// for the given field.
+func parseFieldValue(fi *Field, vals []string) (interface{}, error) {
+	if fi.fieldType.Is2ManyRelationType() {
+		ids := make([]int64, 0, len(vals))
+		for _, v := range vals {
+			if v == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+	var val string
+	if len(vals) > 0 {
+		val = vals[len(vals)-1]
+	}
+	if val == "" && fi.fieldType != fieldtype.Boolean {
+		return nil, nil
+	}
+	switch {
+	case fi.fieldType == fieldtype.Boolean:
+		if val == "" || val == "on" {
+			return val == "on", nil
+		}
+		return strconv.ParseBool(val)
+	case fi.fieldType == fieldtype.Integer, fi.fieldType.Is2OneRelationType():
+		return strconv.ParseInt(val, 10, 64)
+	case fi.fieldType == fieldtype.Float:
+		return strconv.ParseFloat(val, 64)
+	case fi.fieldType == fieldtype.Decimal:
+		return decimals.Parse(val)
+	case fi.fieldType == fieldtype.Date:
+		var d dates.Date
+		err := d.Scan(val)
+		return d, err
+	case fi.fieldType == fieldtype.DateTime:
+		var dt dates.DateTime
+		err := dt.Scan(val)
+		return dt, err
+	}
+	return val, nil
+}
+
+// FieldMapToValues returns the given FieldMap of the given model as
+// url.Values keyed by the fields' JSON names. This is the reverse of
+// FieldMapFromValues.
+//
+// Relation fields are given as the ids of the related records, with one
+// value per record for to-many relation fields. Empty values, such as nil
+// values or zero dates, are given as empty strings.
+func FieldMapToValues(mi *Model, fMap FieldMap) url.Values {
+	res := make(url.Values)
+	for key, val := range fMap {
+		fi := mi.fields.MustGet(key)
+		res[fi.json] = formatFieldValue(fi, val)
+	}
+	return res
+}
+
+// formatFieldValue returns the given value of the given field
+// as a slice of strings.
+func formatFieldValue(fi *Field, val interface{}) []string {
+	switch v := val.(type) {
+	case nil:
+		return []string{""}
+	case RecordSet:
+		val = v.Ids()
+	case dates.Date:
+		if v.IsZero() {
+			return []string{""}
+		}
+	case dates.DateTime:
+		if v.IsZero() {
+			return []string{""}
+		}
+	}
+	if ids, ok := val.([]int64); ok {
+		if fi.fieldType.Is2OneRelationType() {
+			if len(ids) == 0 {
+				return []string{""}
+			}
+			return []string{strconv.FormatInt(ids[0], 10)}
+		}
+		res := make([]string, len(ids))
+		for i, id := range ids {
+			res[i] = strconv.FormatInt(id, 10)
+		}
+		return res
+	}
+	if fi.fieldType.Is2OneRelationType() && val == int64(0) {
+		return []string{""}
+	}
+	return []string{fmt.Sprintf("%v", val)}
+}
@@ -22,7 +22,10 @@ import (
 	"sync"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
@@ -62,6 +65,7 @@ type computeData struct {
 	fieldName string
 	compute   string
 	path      string
+	mode      RecomputeMode
 }
 
 // FieldsCollection is a collection of Field instances in a model.
@@ -76,6 +80,22 @@ type FieldsCollection struct {
 	bootstrapped         bool
 }
 
+// setFieldJSON changes the JSON name, and therefore the column name, of the
+// given field of this collection. It does nothing if json is empty.
+func (fc *FieldsCollection) setFieldJSON(fi *Field, json string) {
+	if json == "" || json == fi.json {
+		return
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	if _, exists := fc.registryByJSON[json]; exists {
+		log.Panic("A field with this JSON name already exists", "model", fc.model.name, "field", fi.name, "json", json)
+	}
+	delete(fc.registryByJSON, fi.json)
+	fi.json = json
+	fc.registryByJSON[json] = fi
+}
+
 // Get returns the Field of the field with the given name.
 // name can be either the name of the field or its JSON name.
 func (fc *FieldsCollection) Get(name string) (fi *Field, ok bool) {
@@ -196,51 +216,59 @@ func (fc *FieldsCollection) register(fInfo *Field) {
 
 // Field holds the meta information about a field
 type Field struct {
-	model            *Model
-	name             string
-	json             string
-	description      string
-	help             string
-	stored           bool
-	required         bool
-	readOnly         bool
-	requiredFunc     func(Environment) (bool, Conditioner)
-	readOnlyFunc     func(Environment) (bool, Conditioner)
-	invisibleFunc    func(Environment) (bool, Conditioner)
-	unique           bool
-	index            bool
-	compute          string
-	depends          []string
-	relatedModelName string
-	relatedModel     *Model
-	reverseFK        string
-	jsonReverseFK    string
-	m2mRelModel      *Model
-	m2mOurField      *Field
-	m2mTheirField    *Field
-	selection        types.Selection
-	selectionFunc    func() types.Selection
-	fieldType        fieldtype.Type
-	groupOperator    string
-	size             int
-	digits           nbutils.Digits
-	structField      reflect.StructField
-	relatedPathStr   string
-	relatedPath      FieldName
-	dependencies     []computeData
-	embed            bool
-	noCopy           bool
-	defaultFunc      func(Environment) interface{}
-	onDelete         OnDeleteAction
-	onChange         string
-	onChangeWarning  string
-	onChangeFilters  string
-	constraint       string
-	inverse          string
-	filter           *Condition
-	contexts         FieldContexts
-	ctxType          ctxType
-	updates          []map[string]interface{}
+	model               *Model
+	name                string
+	json                string
+	description         string
+	help                string
+	stored              bool
+	required            bool
+	readOnly            bool
+	requiredFunc        func(Environment) (bool, Conditioner)
+	readOnlyFunc        func(Environment) (bool, Conditioner)
+	invisibleFunc       func(Environment) (bool, Conditioner)
+	unique              bool
+	index               bool
+	compute             string
+	depends             []string
+	relatedModelName    string
+	relatedModel        *Model
+	reverseFK           string
+	jsonReverseFK       string
+	m2mRelModel         *Model
+	m2mOurField         *Field
+	m2mTheirField       *Field
+	selection           types.Selection
+	selectionFunc       func() types.Selection
+	fieldType           fieldtype.Type
+	groupOperator       string
+	size                int
+	digits              nbutils.Digits
+	structField         reflect.StructField
+	relatedPathStr      string
+	relatedPath         FieldName
+	dependencies        []computeData
+	embed               bool
+	noCopy              bool
+	defaultFunc         func(Environment) interface{}
+	onDelete            OnDeleteAction
+	noForeignKey        bool
+	onChange            string
+	onChangeWarning     string
+	onChangeFilters     string
+	constraint          string
+	inverse             string
+	search              string
+	filter              *Condition
+	contexts            FieldContexts
+	ctxType             ctxType
+	attachment          bool
+	fullText            string
+	groups              map[*security.Group]bool
+	writeGroups         map[*security.Group]bool
+	recomputeMode       RecomputeMode
+	readOnlyAfterCreate bool
+	updates             []map[string]interface{}
 }
 
 // isComputedField returns true if this field is computed
@@ -297,6 +325,43 @@ func (f *Field) isReadOnly() bool {
 	return false
 }
 
+// isReadableBy returns true if the user with the given uid is allowed
+// to read this field.
+func (f *Field) isReadableBy(uid int64) bool {
+	return userInGroups(uid, f.groups)
+}
+
+// isWritableBy returns true if the user with the given uid is allowed
+// to modify this field.
+func (f *Field) isWritableBy(uid int64) bool {
+	return userInGroups(uid, f.groups) && userInGroups(uid, f.writeGroups)
+}
+
+// userInGroups returns true if groups is empty or if the user with the
+// given uid belongs to one of the groups. The superuser always belongs.
+func userInGroups(uid int64, groups map[*security.Group]bool) bool {
+	if len(groups) == 0 || uid == security.SuperUserID {
+		return true
+	}
+	for group := range security.Registry.UserGroups(uid) {
+		if groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIndex returns true if this field's column is indexed in the database.
+// Foreign keys are always indexed.
+func (f *Field) hasIndex() bool {
+	return (f.index || f.fieldType.IsFKRelationType()) && f.isStored() && !f.isContextedField()
+}
+
+// hasFullTextIndex returns true if this field is indexed for full text search
+func (f *Field) hasFullTextIndex() bool {
+	return f.fullText != "" && f.isStored() && !f.isContextedField()
+}
+
 // isContextedField returns true if the value of this field depends on contexts
 func (f *Field) isContextedField() bool {
 	if f.contexts != nil && len(f.contexts) > 0 {
@@ -380,6 +445,7 @@ func CreateM2MRelModelInfo(relModelName, model1, model2, field1, field2 string,
 		fields:          newFieldsCollection(),
 		methods:         newMethodsCollection(),
 		options:         Many2ManyLinkModel | SystemModel,
+		sqlConstraints:  make(map[string]sqlConstraint),
 		sqlErrors:       make(map[string]string),
 		defaultOrderStr: []string{"ID"},
 	}
@@ -419,10 +485,30 @@ func CreateM2MRelModelInfo(relModelName, model1, model2, field1, field2 string,
 		},
 	}
 	newMI.fields.add(theirField)
+	// Link records are identified by the couple of records they link
+	newMI.keyFields = FieldNames{ourField, theirField}
 	Registry.add(newMI)
 	return newMI, ourField, theirField
 }
 
+// SetM2MRelModelNames sets the name of the table of the given m2m link model
+// and the names of the columns of its fields pointing to our and their
+// models. This is useful to map a many2many relation onto an existing table.
+//
+// Empty names are left unchanged, that is the snake case name of the link
+// model for the table and the snake case name of the field with "_id" appended
+// for the columns.
+func SetM2MRelModelNames(relModel *Model, ourField, theirField *Field, table, ourColumn, theirColumn string) {
+	if !relModel.IsM2MLink() {
+		log.Panic("Model is not a many2many link model", "model", relModel.name)
+	}
+	if table != "" {
+		Registry.setTableName(relModel, table)
+	}
+	relModel.fields.setFieldJSON(ourField, ourColumn)
+	relModel.fields.setFieldJSON(theirField, theirColumn)
+}
+
 // createContextsModel creates a new contexts model for holding field values that depends on contexts
 func createContextsModel(fi *Field, contexts FieldContexts) *Model {
 	if !fi.isStored() {
@@ -506,6 +592,132 @@ func createContextsModel(fi *Field, contexts FieldContexts) *Model {
 	return &newModel
 }
 
+// createAttachmentModel creates a new attachment model for holding the values
+// of the given binary field outside of its model's table.
+func createAttachmentModel(fi *Field) *Model {
+	if fi.fieldType != fieldtype.Binary {
+		log.Panic("Only binary fields can be stored as attachments", "model", fi.model.name, "field", fi.name)
+	}
+	if !fi.isStored() {
+		log.Panic("You cannot store non stored fields as attachments", "model", fi.model.name, "field", fi.name)
+	}
+	if fi.isContextedField() {
+		log.Panic("You cannot store contexted fields as attachments", "model", fi.model.name, "field", fi.name)
+	}
+	name := fmt.Sprintf("%sHexya%sAttachment", fi.model.name, fi.name)
+	newModel := Model{
+		name:            name,
+		rulesRegistry:   newRecordRuleRegistry(),
+		tableName:       strutils.SnakeCase(name),
+		fields:          newFieldsCollection(),
+		methods:         newMethodsCollection(),
+		options:         AttachmentModel | SystemModel,
+		sqlErrors:       make(map[string]string),
+		defaultOrderStr: []string{"ID"},
+	}
+	pkField := &Field{
+		name:      "ID",
+		json:      "id",
+		model:     &newModel,
+		required:  true,
+		noCopy:    true,
+		fieldType: fieldtype.Integer,
+		structField: reflect.TypeOf(
+			struct {
+				ID int64
+			}{},
+		).Field(0),
+	}
+	newModel.fields.add(pkField)
+	fkField := &Field{
+		name:             "Record",
+		json:             "record_id",
+		model:            &newModel,
+		required:         true,
+		noCopy:           true,
+		fieldType:        fieldtype.Many2One,
+		relatedModelName: fi.model.name,
+		relatedModel:     fi.model,
+		index:            true,
+		unique:           true,
+		onDelete:         Cascade,
+		structField: reflect.StructField{
+			Name: "Record",
+			Type: reflect.TypeOf(int64(0)),
+		},
+	}
+	newModel.fields.add(fkField)
+	valueField := *fi
+	valueField.model = &newModel
+	valueField.compute = ""
+	valueField.embed = false
+	valueField.stored = false
+	valueField.onChange = ""
+	valueField.constraint = ""
+	valueField.attachment = false
+	newModel.fields.add(&valueField)
+
+	Registry.add(&newModel)
+	injectMixInModel(Registry.MustGet("BaseMixin"), &newModel)
+	return &newModel
+}
+
+// createTombstoneModel creates a new model for logging the ids
+// of the deleted records of the given model.
+func createTombstoneModel(mi *Model) *Model {
+	name := fmt.Sprintf("%sHexyaTombstone", mi.name)
+	newModel := Model{
+		name:            name,
+		rulesRegistry:   newRecordRuleRegistry(),
+		tableName:       strutils.SnakeCase(name),
+		fields:          newFieldsCollection(),
+		methods:         newMethodsCollection(),
+		options:         SystemModel,
+		sqlErrors:       make(map[string]string),
+		defaultOrderStr: []string{"ID"},
+	}
+	pkField := &Field{
+		name:      "ID",
+		json:      "id",
+		model:     &newModel,
+		required:  true,
+		noCopy:    true,
+		fieldType: fieldtype.Integer,
+		structField: reflect.TypeOf(
+			struct {
+				ID int64
+			}{},
+		).Field(0),
+	}
+	newModel.fields.add(pkField)
+	resIDField := &Field{
+		name:        "ResID",
+		json:        "res_id",
+		model:       &newModel,
+		required:    true,
+		noCopy:      true,
+		index:       true,
+		fieldType:   fieldtype.Integer,
+		structField: reflect.StructField{Name: "ResID", Type: reflect.TypeOf(int64(0))},
+	}
+	newModel.fields.add(resIDField)
+	deleteDateField := &Field{
+		name:        "DeleteDate",
+		json:        "delete_date",
+		model:       &newModel,
+		required:    true,
+		noCopy:      true,
+		index:       true,
+		fieldType:   fieldtype.DateTime,
+		structField: reflect.StructField{Name: "DeleteDate", Type: reflect.TypeOf(dates.DateTime{})},
+	}
+	newModel.fields.add(deleteDateField)
+
+	Registry.add(&newModel)
+	injectMixInModel(Registry.MustGet("BaseMixin"), &newModel)
+	return &newModel
+}
+
 // processDepends populates the dependencies of each Field from the depends strings of
 // each Field instances.
 func processDepends() {
@@ -525,15 +737,38 @@ func processDepends() {
 					fieldName: fInfo.name,
 					compute:   fInfo.compute,
 					path:      path,
+					mode:      fInfo.recomputeMode,
 				}
 				refModelInfo := mi.getRelatedModelInfo(mi.FieldName(path))
 				refField := refModelInfo.fields.MustGet(refName)
 				refField.dependencies = append(refField.dependencies, targetComputeData)
+				addReverseFKDepends(targetComputeData, tokens)
 			}
 		}
 	}
 }
 
+// addReverseFKDepends adds the given computeData to the dependencies of the
+// reverse foreign keys of the one2many and rev2one fields of the given path,
+// so that the field is recomputed when records are added to or removed from
+// these relations.
+func addReverseFKDepends(cData computeData, tokens []string) {
+	mi := cData.model
+	for i, token := range tokens {
+		fi := mi.fields.MustGet(token)
+		if !fi.fieldType.IsRelationType() {
+			return
+		}
+		if fi.fieldType.IsReverseRelationType() {
+			revData := cData
+			revData.path = strings.Join(tokens[:i+1], ExprSep)
+			fkField := fi.relatedModel.fields.MustGet(fi.reverseFK)
+			fkField.dependencies = append(fkField.dependencies, revData)
+		}
+		mi = fi.relatedModel
+	}
+}
+
 // checkComputeMethodsSignature check the signature of all methods used
 // in computed fields and for OnChange methods.
 // It panics if it is not the case.
@@ -591,6 +826,15 @@ func checkComputeMethodsSignature() {
 				log.Panic("Inverse methods should not return any value", "model", model.name, "field", fi.name, "method", method.name)
 			}
 		}
+		for _, fi := range model.fields.registryByName {
+			if fi.search == "" {
+				continue
+			}
+			method := model.methods.MustGet(fi.search)
+			if err := checkSearchMethType(method); err != nil {
+				log.Panic(err.Error(), "model", model.name, "method", method.name, "field", fi.name)
+			}
+		}
 	}
 }
 
@@ -636,6 +880,27 @@ func checkOnChangeWarningType(method *Method) error {
 	return nil
 }
 
+// checkSearchMethType returns an error if the given method does not have
+// the correct number and type of arguments and returns for a search method
+func checkSearchMethType(method *Method) error {
+	methType := method.methodType
+	var msg string
+	switch {
+	case methType.NumIn() != 3:
+		msg = "Search methods should have 2 arguments"
+	case methType.In(1) != reflect.TypeOf(operator.Operator("")):
+		msg = "Search methods first argument must be of type operator.Operator"
+	case methType.NumOut() != 1:
+		msg = "Search methods should return a single value"
+	case !methType.Out(0).Implements(reflect.TypeOf((*Conditioner)(nil)).Elem()):
+		msg = "Search methods returned value must implement models.Conditioner"
+	}
+	if msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
 // checkOnChangeFiltersType panics if the given method does not have
 // the correct number and type of arguments and returns for a onChangeFilters method
 func checkOnChangeFiltersType(method *Method) error {
@@ -12,6 +12,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 )
@@ -50,7 +51,9 @@ type Binary struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
+	Attachment      bool
 	Default         func(models.Environment) interface{}
 }
 
@@ -84,6 +87,7 @@ type Boolean struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -119,11 +123,13 @@ type Char struct {
 	Size            int
 	GoType          interface{}
 	Translate       bool
+	FullText        string
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -161,6 +167,7 @@ type Date struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -198,6 +205,7 @@ type DateTime struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -209,6 +217,51 @@ func (df DateTime) DeclareField(fc *models.FieldsCollection, name string) *model
 	return fInfo
 }
 
+// A Decimal is a field for storing exact decimal numbers, such as monetary
+// amounts.
+//
+// Values are stored as NUMERIC in the database, with the precision and scale
+// given by Digits if any, and their Go type is decimals.Decimal so that
+// arithmetic does not lose precision.
+type Decimal struct {
+	JSON            string
+	String          string
+	Help            string
+	Stored          bool
+	Required        bool
+	ReadOnly        bool
+	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
+	Unique          bool
+	Index           bool
+	Compute         models.Methoder
+	Depends         []string
+	Related         string
+	GroupOperator   string
+	NoCopy          bool
+	Digits          nbutils.Digits
+	OnChange        models.Methoder
+	OnChangeWarning models.Methoder
+	OnChangeFilters models.Methoder
+	Constraint      models.Methoder
+	Inverse         models.Methoder
+	Search          models.Methoder
+	Contexts        models.FieldContexts
+	Default         func(models.Environment) interface{}
+}
+
+// DeclareField adds this decimal field for the given models.FieldsCollection with the given name.
+func (df Decimal) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	if df.Default == nil {
+		df.Default = models.DefaultValue(decimals.Decimal{})
+	}
+	fInfo := models.CreateFieldFromStruct(fc, &df, name, fieldtype.Decimal, new(decimals.Decimal))
+	fInfo.SetProperty("groupOperator", strutils.GetDefaultString(df.GroupOperator, "sum"))
+	fInfo.SetProperty("digits", df.Digits)
+	return fInfo
+}
+
 // A Float is a field for storing decimal numbers.
 type Float struct {
 	JSON            string
@@ -234,6 +287,7 @@ type Float struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -271,11 +325,13 @@ type HTML struct {
 	Size            int
 	GoType          interface{}
 	Translate       bool
+	FullText        string
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -311,6 +367,7 @@ type Integer struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -325,6 +382,43 @@ func (i Integer) DeclareField(fc *models.FieldsCollection, name string) *models.
 	return fInfo
 }
 
+// A JSON is a field for storing semi-structured data as a JSON document.
+//
+// Values are map[string]interface{} by default. Set GoType to a pointer to
+// a struct to have values decoded into this struct instead.
+//
+// Use the JSONPath method of a condition field to query into the document.
+type JSON struct {
+	JSON            string
+	String          string
+	Help            string
+	Stored          bool
+	Required        bool
+	ReadOnly        bool
+	RequiredFunc    func(models.Environment) (bool, models.Conditioner)
+	ReadOnlyFunc    func(models.Environment) (bool, models.Conditioner)
+	InvisibleFunc   func(models.Environment) (bool, models.Conditioner)
+	Index           bool
+	Compute         models.Methoder
+	Depends         []string
+	Related         string
+	NoCopy          bool
+	GoType          interface{}
+	OnChange        models.Methoder
+	OnChangeWarning models.Methoder
+	OnChangeFilters models.Methoder
+	Constraint      models.Methoder
+	Inverse         models.Methoder
+	Search          models.Methoder
+	Contexts        models.FieldContexts
+	Default         func(models.Environment) interface{}
+}
+
+// DeclareField creates a JSON field for the given models.FieldsCollection with the given name.
+func (jf JSON) DeclareField(fc *models.FieldsCollection, name string) *models.Field {
+	return models.CreateFieldFromStruct(fc, &jf, name, fieldtype.JSON, new(map[string]interface{}))
+}
+
 // A Many2Many is a field for storing many-to-many relations.
 //
 // Clients are expected to handle many2many fields with a table or with tags.
@@ -347,12 +441,16 @@ type Many2Many struct {
 	M2MLinkModelName string
 	M2MOurField      string
 	M2MTheirField    string
+	M2MTable         string
+	M2MOurColumn     string
+	M2MTheirColumn   string
 	OnChange         models.Methoder
 	OnChangeWarning  models.Methoder
 	OnChangeFilters  models.Methoder
 	Constraint       models.Methoder
 	Filter           models.Conditioner
 	Inverse          models.Methoder
+	Search           models.Methoder
 	Default          func(models.Environment) interface{}
 }
 
@@ -367,6 +465,17 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 	if their == "" {
 		their = mf.RelationModel.Underlying().Name()
 	}
+	var derived bool
+	if our == their && fc.Model().Name() == mf.RelationModel.Underlying().Name() {
+		// This is the case of many2many relations from a model to itself:
+		// we name the side that has not been given after this field.
+		switch {
+		case mf.M2MTheirField == "":
+			their, derived = name, true
+		case mf.M2MOurField == "":
+			our, derived = name, true
+		}
+	}
 	if our == their {
 		log.Panic("Many2many relation must have different 'M2MOurField' and 'M2MTheirField'",
 			"model", fc.Model().Name(), "field", name, "ours", our, "theirs", their)
@@ -377,8 +486,13 @@ func (mf Many2Many) DeclareField(fc *models.FieldsCollection, name string) *mode
 	m2mRelModName := mf.M2MLinkModelName
 	if m2mRelModName == "" {
 		m2mRelModName = fmt.Sprintf("%s%sRel", modelNames[0], modelNames[1])
+		if derived {
+			// Self referencing relations with default names have their own link model
+			m2mRelModName = fmt.Sprintf("%s%sRel", fc.Model().Name(), name)
+		}
 	}
 	m2mRelModel, m2mOurField, m2mTheirField := models.CreateM2MRelModelInfo(m2mRelModName, fc.Model().Name(), mf.RelationModel.Underlying().Name(), our, their, fc.Model().IsMixin())
+	models.SetM2MRelModelNames(m2mRelModel, m2mOurField, m2mTheirField, mf.M2MTable, mf.M2MOurColumn, mf.M2MTheirColumn)
 
 	if mf.Filter != nil {
 		fInfo.SetProperty("filter", mf.Filter.Underlying())
@@ -412,12 +526,14 @@ type Many2One struct {
 	RelationModel   models.Modeler
 	Embed           bool
 	OnDelete        models.OnDeleteAction
+	NoForeignKey    bool
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Filter          models.Conditioner
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -441,6 +557,7 @@ func (mf Many2One) DeclareField(fc *models.FieldsCollection, name string) *model
 	}
 	fInfo.SetProperty("relationModel", mf.RelationModel.Underlying())
 	fInfo.SetProperty("onDelete", onDelete)
+	fInfo.SetProperty("noForeignKey", mf.NoForeignKey)
 	fInfo.SetProperty("noCopy", noCopy)
 	fInfo.SetProperty("required", required)
 	fInfo.SetProperty("embed", mf.Embed)
@@ -473,6 +590,7 @@ type One2Many struct {
 	Constraint      models.Methoder
 	Filter          models.Conditioner
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Default         func(models.Environment) interface{}
 }
 
@@ -512,12 +630,14 @@ type One2One struct {
 	RelationModel   models.Modeler
 	Embed           bool
 	OnDelete        models.OnDeleteAction
+	NoForeignKey    bool
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Filter          models.Conditioner
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -541,6 +661,7 @@ func (of One2One) DeclareField(fc *models.FieldsCollection, name string) *models
 	}
 	fInfo.SetProperty("relationModel", of.RelationModel.Underlying())
 	fInfo.SetProperty("onDelete", onDelete)
+	fInfo.SetProperty("noForeignKey", of.NoForeignKey)
 	fInfo.SetProperty("noCopy", noCopy)
 	fInfo.SetProperty("required", required)
 	fInfo.SetProperty("embed", of.Embed)
@@ -574,6 +695,7 @@ type Rev2One struct {
 	Constraint      models.Methoder
 	Filter          models.Conditioner
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Default         func(models.Environment) interface{}
 }
 
@@ -617,6 +739,7 @@ type Selection struct {
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
@@ -652,11 +775,13 @@ type Text struct {
 	Size            int
 	GoType          interface{}
 	Translate       bool
+	FullText        string
 	OnChange        models.Methoder
 	OnChangeWarning models.Methoder
 	OnChangeFilters models.Methoder
 	Constraint      models.Methoder
 	Inverse         models.Methoder
+	Search          models.Methoder
 	Contexts        models.FieldContexts
 	Default         func(models.Environment) interface{}
 }
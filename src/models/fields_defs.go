@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
@@ -60,6 +61,13 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 	cons, _ := val.FieldByName("Constraint").Interface().(Methoder)
 	compute, inverse, onchange, onchangeWarning, onchangeFilters, constraint := getFuncNames(comp, inv, onc, onw, onf, cons)
 
+	var search string
+	if srch := val.FieldByName("Search"); srch.IsValid() {
+		if srchMeth, ok := srch.Interface().(Methoder); ok {
+			search = srchMeth.Underlying().name
+		}
+	}
+
 	var unique bool
 	if uni := val.FieldByName("Unique"); uni.IsValid() {
 		unique = uni.Bool()
@@ -78,6 +86,14 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 			return res
 		}
 	}
+	var attachment bool
+	if att := val.FieldByName("Attachment"); att.IsValid() {
+		attachment = att.Bool()
+	}
+	var fullText string
+	if ft := val.FieldByName("FullText"); ft.IsValid() {
+		fullText = ft.String()
+	}
 	var noCopy bool
 	if noc := val.FieldByName("NoCopy"); noc.IsValid() {
 		noCopy = noc.Bool()
@@ -98,6 +114,7 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 		index:           val.FieldByName("Index").Bool(),
 		compute:         compute,
 		inverse:         inverse,
+		search:          search,
 		depends:         val.FieldByName("Depends").Interface().([]string),
 		relatedPathStr:  val.FieldByName("Related").String(),
 		noCopy:          noCopy,
@@ -109,6 +126,8 @@ func CreateFieldFromStruct(fc *FieldsCollection, fStruct interface{}, name strin
 		onChangeFilters: onchangeFilters,
 		constraint:      constraint,
 		contexts:        contexts,
+		attachment:      attachment,
+		fullText:        fullText,
 	}
 	return fInfo
 }
@@ -210,6 +229,8 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.defaultFunc = value.(func(Environment) interface{})
 	case "onDelete":
 		f.onDelete = value.(OnDeleteAction)
+	case "noForeignKey":
+		f.noForeignKey = value.(bool)
 	case "onChange":
 		f.onChange = value.(string)
 	case "onChangeWarning":
@@ -220,6 +241,8 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		f.constraint = value.(string)
 	case "inverse":
 		f.inverse = value.(string)
+	case "search":
+		f.search = value.(string)
 	case "filter":
 		f.filter = value.(*Condition)
 	case "relationModel":
@@ -250,11 +273,36 @@ func (f *Field) SetProperty(property string, value interface{}) {
 		}
 	case "contexts":
 		f.contexts = value.(FieldContexts)
+	case "attachment":
+		f.attachment = value.(bool)
+	case "fullText":
+		f.fullText = value.(string)
+	case "groups":
+		f.groups = groupsMap(value.([]*security.Group))
+	case "writeGroups":
+		f.writeGroups = groupsMap(value.([]*security.Group))
+	case "recomputeMode":
+		mode := value.(RecomputeMode)
+		if !mode.valid() {
+			log.Panic("Unknown recompute mode", "model", f.model.name, "field", f.name, "mode", mode)
+		}
+		f.recomputeMode = mode
+	case "readOnlyAfterCreate":
+		f.readOnlyAfterCreate = value.(bool)
 	default:
 		log.Panic("Unknown property", "property", property, "value", value)
 	}
 }
 
+// groupsMap returns the given groups as a set
+func groupsMap(groups []*security.Group) map[*security.Group]bool {
+	res := make(map[*security.Group]bool)
+	for _, group := range groups {
+		res[group] = true
+	}
+	return res
+}
+
 // SetFieldType overrides the type of Field.
 // This may fail at database sync if the table already has values and
 // the old type cannot be casted into the new type by the database.
@@ -293,6 +341,46 @@ func (f *Field) SetOnDelete(value OnDeleteAction) *Field {
 	return f
 }
 
+// SetNoForeignKey overrides the value of the NoForeignKey parameter of this Field
+func (f *Field) SetNoForeignKey(value bool) *Field {
+	f.addUpdate("noForeignKey", value)
+	return f
+}
+
+// SetGroups restricts the access to this Field to the members of the
+// given groups. Other users cannot read nor modify it.
+// Call SetGroups without argument to remove the restriction.
+func (f *Field) SetGroups(groups ...*security.Group) *Field {
+	f.addUpdate("groups", groups)
+	return f
+}
+
+// SetWriteGroups restricts the modification of this Field to the members
+// of the given groups. Other users can still read it.
+// Call SetWriteGroups without argument to remove the restriction.
+func (f *Field) SetWriteGroups(groups ...*security.Group) *Field {
+	f.addUpdate("writeGroups", groups)
+	return f
+}
+
+// SetReadOnlyAfterCreate makes this Field settable when creating a record
+// but immutable afterwards, such as the sequence number of a document.
+//
+// Writing a new value to this Field on existing records panics, unless it
+// is done by the superuser, e.g. with Sudo in migrations. Writing the current
+// value is allowed.
+func (f *Field) SetReadOnlyAfterCreate(value bool) *Field {
+	f.addUpdate("readOnlyAfterCreate", value)
+	return f
+}
+
+// SetRecomputeMode sets when this stored computed Field is recomputed after
+// a change of one of the fields it depends on. See RecomputeMode.
+func (f *Field) SetRecomputeMode(mode RecomputeMode) *Field {
+	f.addUpdate("recomputeMode", mode)
+	return f
+}
+
 // SetCompute overrides the value of the Compute parameter of this Field
 func (f *Field) SetCompute(value Methoder) *Field {
 	var methName string
@@ -387,6 +475,18 @@ func (f *Field) SetTranslate(value bool) *Field {
 	return f
 }
 
+// SetAttachment overrides the value of the Attachment parameter of this Field
+func (f *Field) SetAttachment(value bool) *Field {
+	f.addUpdate("attachment", value)
+	return f
+}
+
+// SetFullText overrides the value of the FullText parameter of this Field
+func (f *Field) SetFullText(value string) *Field {
+	f.addUpdate("fullText", value)
+	return f
+}
+
 // SetContexts overrides the value of the Contexts parameter of this Field
 func (f *Field) SetContexts(value FieldContexts) *Field {
 	f.addUpdate("contexts", value)
@@ -468,6 +568,16 @@ func (f *Field) SetInverse(value Methoder) *Field {
 	return f
 }
 
+// SetSearch overrides the value of the Search parameter of this Field
+func (f *Field) SetSearch(value Methoder) *Field {
+	var methName string
+	if value != nil {
+		methName = value.Underlying().name
+	}
+	f.addUpdate("search", methName)
+	return f
+}
+
 // SetFilter overrides the value of the Filter parameter of this Field
 func (f *Field) SetFilter(value Conditioner) *Field {
 	f.addUpdate("filter", value.Underlying())
@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 )
 
 // A Type defines a type of a model's field
@@ -20,9 +21,11 @@ const (
 	Char      Type = "char"
 	Date      Type = "date"
 	DateTime  Type = "datetime"
+	Decimal   Type = "decimal"
 	Float     Type = "float"
 	HTML      Type = "html"
 	Integer   Type = "integer"
+	JSON      Type = "json"
 	Many2Many Type = "many2many"
 	Many2One  Type = "many2one"
 	One2Many  Type = "one2many"
@@ -71,7 +74,7 @@ func (t Type) Is2ManyRelationType() bool {
 // IsNullInDB returns true if this type's zero value is
 // saved as null in database.
 func (t Type) IsNullInDB() bool {
-	return t.IsFKRelationType() || t == Binary || t == Char || t == Text || t == HTML || t == Selection || t == Date || t == DateTime
+	return t.IsFKRelationType() || t == Binary || t == Char || t == Text || t == HTML || t == Selection || t == Date || t == DateTime || t == JSON
 }
 
 // DefaultGoType returns this Type's default Go type
@@ -89,10 +92,14 @@ func (t Type) DefaultGoType() reflect.Type {
 		return reflect.TypeOf(*new(dates.DateTime))
 	case Float:
 		return reflect.TypeOf(*new(float64))
+	case Decimal:
+		return reflect.TypeOf(*new(decimals.Decimal))
 	case Integer, Many2One, One2One, Rev2One:
 		return reflect.TypeOf(*new(int64))
 	case One2Many, Many2Many:
 		return reflect.TypeOf(*new([]int64))
+	case JSON:
+		return reflect.TypeOf(*new(map[string]interface{}))
 	}
 	return reflect.TypeOf(nil)
 }
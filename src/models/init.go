@@ -33,8 +33,8 @@ func init() {
 	log = logging.GetLogger("models")
 	sqlx.NameMapper = strutils.SnakeCase
 	// DB drivers
-	adapters = make(map[string]dbAdapter)
-	registerDBAdapter("postgres", new(postgresAdapter))
+	adapters = make(map[string]DBAdapter)
+	RegisterDBAdapter("postgres", new(postgresAdapter))
 	// model registry
 	Registry = newModelCollection()
 	Views = make(map[*Model][]string)
@@ -15,7 +15,9 @@
 package models
 
 import (
+	"fmt"
 	"reflect"
+	"runtime"
 	"sync"
 
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -153,6 +155,7 @@ func (m *Method) addMethodLayer(val reflect.Value) {
 	ml := methodLayer{
 		funcValue: wrapFunctionForMethodLayer(val),
 		method:    m,
+		source:    funcSource(val),
 	}
 	if m.topLayer != nil {
 		m.nextLayer[&ml] = m.topLayer
@@ -229,6 +232,46 @@ type methodLayer struct {
 	method    *Method
 	mixedIn   bool
 	funcValue reflect.Value
+	source    string
+}
+
+// funcSource returns the name of the given function together with
+// the file and line where it is defined.
+func funcSource(fnctVal reflect.Value) string {
+	fn := runtime.FuncForPC(fnctVal.Pointer())
+	if fn == nil {
+		return "<unknown>"
+	}
+	file, line := fn.FileLine(fn.Entry())
+	return fmt.Sprintf("%s (%s:%d)", fn.Name(), file, line)
+}
+
+// MethodLayers returns a description of each layer of the method with the
+// given methodName of the model with the given modelName, starting from the
+// top layer (i.e. the one that is executed first) down to the base layer.
+//
+// Each description gives the function of the layer and the file and line
+// where it is defined. Layers inherited from a mixin are marked as such.
+// This function is meant for debugging method overrides and returns an
+// empty slice if the model or the method does not exist.
+func MethodLayers(modelName, methodName string) []string {
+	mi, ok := Registry.Get(modelName)
+	if !ok {
+		return []string{}
+	}
+	meth, ok := mi.methods.Get(methodName)
+	if !ok {
+		return []string{}
+	}
+	res := []string{}
+	for cl := meth.topLayer; cl != nil; cl = meth.getNextLayer(cl) {
+		desc := cl.source
+		if cl.mixedIn {
+			desc += " [mixed in]"
+		}
+		res = append(res, desc)
+	}
+	return res
 }
 
 // copyMethod creates a new method without any method layer for
@@ -370,6 +413,17 @@ func (m *Model) NewMethod(methodName string, fnct interface{}) *Method {
 	return meth
 }
 
+// AddMethod registers a brand new method with the given methodName on the
+// model with the given modelName. The method can then be called through
+// Call or CallMulti.
+//
+// fnct must be a function whose first argument implements RecordSet.
+// This function panics if the method already exists in the model. Use
+// Method.Extend to add a layer to an existing method.
+func AddMethod(modelName, methodName string, fnct interface{}) *Method {
+	return Registry.MustGet(modelName).NewMethod(methodName, fnct)
+}
+
 // AddEmptyMethod creates a new method without function layer
 // The resulting method cannot be called until finalize is called
 func (m *Model) AddEmptyMethod(methodName string) *Method {
@@ -9,6 +9,7 @@ type Operator string
 // Operators
 const (
 	Equals         Operator = "="
+	IEquals        Operator = "=i"
 	NotEquals      Operator = "!="
 	Greater        Operator = ">"
 	GreaterOrEqual Operator = ">="
@@ -23,10 +24,12 @@ const (
 	In             Operator = "in"
 	NotIn          Operator = "not in"
 	ChildOf        Operator = "child_of"
+	ParentOf       Operator = "parent_of"
 )
 
 var allowedOperators = map[Operator]bool{
 	Equals:         true,
+	IEquals:        true,
 	NotEquals:      true,
 	Greater:        true,
 	GreaterOrEqual: true,
@@ -41,6 +44,7 @@ var allowedOperators = map[Operator]bool{
 	In:             true,
 	NotIn:          true,
 	ChildOf:        true,
+	ParentOf:       true,
 }
 
 var negativeOperators = map[Operator]bool{
@@ -52,6 +56,7 @@ var negativeOperators = map[Operator]bool{
 
 var positiveOperators = map[Operator]bool{
 	Equals:    true,
+	IEquals:   true,
 	IContains: true,
 	ILike:     true,
 	Contains:  true,
@@ -60,8 +65,10 @@ var positiveOperators = map[Operator]bool{
 }
 
 var multiOperator = map[Operator]bool{
-	In:    true,
-	NotIn: true,
+	In:       true,
+	NotIn:    true,
+	ChildOf:  true,
+	ParentOf: true,
 }
 
 // IsMulti returns true if the operator expects a array as arguments
@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
+	"github.com/hexya-erp/hexya/src/tools/typesutils"
 )
 
 const maxSQLidentifierLength = 63
@@ -51,16 +52,22 @@ type orderPredicate struct {
 // A Query defines the common part an SQL Query, i.e. all that come
 // after the FROM keyword.
 type Query struct {
-	recordSet *RecordCollection
-	cond      *Condition
-	ctxCond   *Condition
-	fetchAll  bool
-	limit     int
-	offset    int
-	groups    []FieldName
-	ctxGroups []FieldName
-	orders    []orderPredicate
-	ctxOrders []orderPredicate
+	recordSet  *RecordCollection
+	cond       *Condition
+	ctxCond    *Condition
+	fetchAll   bool
+	limit      int
+	offset     int
+	groups     []FieldName
+	ctxGroups  []FieldName
+	dateGroups map[string]DateGrouping
+	orders     []orderPredicate
+	ctxOrders  []orderPredicate
+	original   *Query
+	allowAll   bool
+	windows    []windowExpr
+	ctes       []cteData
+	unions     []*Query
 }
 
 // clone returns a pointer to a deep copy of this Query
@@ -145,6 +152,9 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 	if p.isCond {
 		return q.conditionSQLClause(p.cond)
 	}
+	if p.rawSQL != "" {
+		return fmt.Sprintf("(%s)", p.rawSQL), SQLParams(p.arg.([]interface{}))
+	}
 
 	fi := q.recordSet.model.getRelatedFieldInfo(joinFieldNames(p.exprs, ExprSep))
 	if fi.fieldType.IsFKRelationType() {
@@ -162,7 +172,16 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 
 	adapter := adapters[db.DriverName()]
 	arg := q.evaluateConditionArgFunctions(p)
-	opSql, arg := adapter.operatorSQL(p.operator, arg)
+	switch {
+	case len(p.jsonPath) > 0:
+		if fi.fieldType != fieldtype.JSON {
+			log.Panic("JSON path given on a non JSON field", "model", q.recordSet.model.name, "field", fi.name, "path", p.jsonPath)
+		}
+		field = adapter.JSONPathSQL(field, p.jsonPath)
+	case fi.fieldType == fieldtype.JSON:
+		arg = sqlValue(fi, arg)
+	}
+	opSql, arg := adapter.OperatorSQL(p.operator, arg)
 
 	var isNull bool
 	switch v := arg.(type) {
@@ -181,6 +200,9 @@ func (q *Query) predicateSQLClause(p predicate) (string, SQLParams) {
 		return nullSQLClause(field, p.operator, fi)
 	}
 
+	if p.operator == operator.IEquals {
+		field = fmt.Sprintf("LOWER(%s)", field)
+	}
 	sql = fmt.Sprintf(`%s %s`, field, opSql)
 	if p.operator.IsNegative() {
 		sql = fmt.Sprintf(`(%s IS NULL OR %s)`, field, sql)
@@ -197,7 +219,7 @@ func nullSQLClause(field string, op operator.Operator, fi *Field) (string, SQLPa
 		args SQLParams
 	)
 	switch op {
-	case operator.Equals, operator.Like, operator.ILike, operator.Contains, operator.IContains:
+	case operator.Equals, operator.IEquals, operator.Like, operator.ILike, operator.Contains, operator.IContains:
 		sql = fmt.Sprintf(`%s IS NULL`, field)
 		if !fi.isRelationField() {
 			sql = fmt.Sprintf(`(%s OR %s = ?)`, sql, field)
@@ -218,22 +240,18 @@ func nullSQLClause(field string, op operator.Operator, fi *Field) (string, SQLPa
 // sqlLimitClause returns the sql string for the LIMIT and OFFSET clauses
 // of this Query
 func (q *Query) sqlLimitOffsetClause() string {
-	var res string
-	if q.limit > 0 {
-		res = fmt.Sprintf(`LIMIT %d `, q.limit)
-	}
-	if q.offset > 0 {
-		res += fmt.Sprintf(`OFFSET %d`, q.offset)
-	}
-	return res
+	adapter := adapters[db.DriverName()]
+	return adapter.LimitOffsetSQL(q.limit, q.offset)
 }
 
 // sqlOrderByClause returns the sql string for the ORDER BY clause
 // of this Query
 func (q *Query) sqlOrderByClause() string {
+	adapter := adapters[db.DriverName()]
 	resSlice := make([]string, len(q.orders))
 	for i, order := range q.orders {
-		_, _, resSlice[i] = q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
+		_, _, alias := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
+		resSlice[i] = adapter.QuoteIdentifier(alias)
 		if order.desc {
 			resSlice[i] += " DESC"
 		}
@@ -263,18 +281,19 @@ func (q *Query) sqlCtxOrderBy() string {
 // sqlOrderByClauseForGroupBy returns the sql string for the ORDER BY clause
 // of this Query, which should be a group by clause.
 func (q *Query) sqlOrderByClauseForGroupBy(aggFncts map[string]string) string {
+	adapter := adapters[db.DriverName()]
 	resSlice := make([]string, len(q.orders))
 	for i, order := range q.orders {
 		aggFnct := aggFncts[order.field.JSON()]
+		_, _, alias := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
+		jfe := adapter.QuoteIdentifier(alias)
 		if aggFnct == "" {
-			_, _, jfe := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
 			if order.desc {
 				jfe += " DESC"
 			}
 			resSlice[i] = jfe
 			continue
 		}
-		_, _, jfe := q.joinedFieldExpression(splitFieldNames(order.field, ExprSep), true, i)
 		resSlice[i] = fmt.Sprintf("%s(%s)", aggFnct, jfe)
 		if order.desc {
 			resSlice[i] += " DESC"
@@ -294,9 +313,11 @@ func (q *Query) sqlGroupByClause() string {
 		oExprs := splitFieldNames(group, ExprSep)
 		fExprs = append(fExprs, oExprs)
 	}
+	adapter := adapters[db.DriverName()]
 	resSlice := make([]string, len(q.groups))
 	for i, field := range fExprs {
-		_, _, resSlice[i] = q.joinedFieldExpression(field, true, i)
+		_, _, alias := q.joinedFieldExpression(field, true, i)
+		resSlice[i], _ = q.dateGroupSQL(q.groups[i], adapter.QuoteIdentifier(alias))
 	}
 	res := strings.Join(resSlice, ", ")
 	ctxStr := strings.TrimSpace(q.sqlCtxGroupByClause())
@@ -314,9 +335,11 @@ func (q *Query) sqlCtxGroupByClause() string {
 		oExprs := splitFieldNames(group, ExprSep)
 		fExprs = append(fExprs, oExprs)
 	}
+	adapter := adapters[db.DriverName()]
 	resSlice := make([]string, len(q.ctxGroups))
 	for i, field := range fExprs {
-		_, _, resSlice[i] = q.joinedFieldExpression(field, true, i)
+		_, _, alias := q.joinedFieldExpression(field, true, i)
+		resSlice[i] = adapter.QuoteIdentifier(alias)
 	}
 	return strings.Join(resSlice, ", ")
 }
@@ -326,23 +349,96 @@ func (q *Query) sqlCtxGroupByClause() string {
 func (q *Query) deleteQuery() (string, SQLParams) {
 	adapter := adapters[db.DriverName()]
 	sql, args := q.sqlWhereClause(false)
-	delQuery := fmt.Sprintf(`DELETE FROM %s %s`, adapter.quoteTableName(q.recordSet.model.tableName), sql)
+	delQuery := fmt.Sprintf(`DELETE FROM %s %s`, adapter.QuoteTableName(q.recordSet.model.tableName), sql)
+	return delQuery, args
+}
+
+// deleteAllQuery returns the SQL query string and parameters to delete
+// all the rows matching this Query in a single query, without fetching
+// their ids first.
+//
+// The select query may return order by columns besides the id, so that
+// only its id column is used in the IN clause.
+func (q *Query) deleteAllQuery() (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	selQuery, args, _ := q.selectQuery([]FieldName{ID})
+	delQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s IN (SELECT %s FROM (%s) ids)`, adapter.QuoteTableName(q.recordSet.model.tableName),
+		adapter.QuoteIdentifier("id"), adapter.QuoteIdentifier("id"), selQuery)
 	return delQuery, args
 }
 
 // insertQuery returns the SQL query string and parameters to insert
 // a row with the given data.
 func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
+	sql, vals, cols := q.insertSQL(data)
+	return fmt.Sprintf("%s %s", sql, q.returningSQL(cols)), vals
+}
+
+// upsertQuery returns the SQL query string and parameters to insert
+// a row with the given data, unless a row with the same values for the
+// given conflictFields already exists. In this case, no row is returned.
+func (q *Query) upsertQuery(data FieldMap, conflictFields []FieldName) (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	if len(conflictFields) == 0 {
+		log.Panic("No conflict fields given for upsert", "model", q.recordSet.model.name)
+	}
+	sql, vals, cols := q.insertSQL(data)
+	conflictCols := make([]string, len(conflictFields))
+	for i, field := range conflictFields {
+		conflictCols[i] = q.recordSet.model.fields.MustGet(field.Name()).json
+	}
+	sql = fmt.Sprintf("%s %s %s", sql, adapter.OnConflictDoNothingSQL(conflictCols), q.returningSQL(cols))
+	return sql, vals
+}
+
+// batchInsertQuery returns the SQL query string and parameters to insert
+// one row for each of the given rows. rows must have been given by insertColumns
+// and must all have the same columns.
+func (q *Query) batchInsertQuery(cols []string, rows []SQLParams) (string, SQLParams) {
 	adapter := adapters[db.DriverName()]
+	if len(rows) == 0 {
+		log.Panic("No data given for insert")
+	}
+	quotedCols := make([]string, len(cols))
+	for i, col := range cols {
+		quotedCols[i] = adapter.QuoteIdentifier(col)
+	}
+	rowSQL := "(?" + strings.Repeat(", ?", len(cols)-1) + ")"
+	values := make([]string, len(rows))
+	var vals SQLParams
+	for i, row := range rows {
+		values[i] = rowSQL
+		vals = vals.Extend(row)
+	}
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s", adapter.QuoteTableName(q.recordSet.model.tableName),
+		strings.Join(quotedCols, ", "), strings.Join(values, ", "), q.returningSQL(cols))
+	return sql, vals
+}
+
+// insertSQL returns the INSERT query string without RETURNING clause, its
+// parameters and the JSON names of the inserted columns for the given data.
+func (q *Query) insertSQL(data FieldMap) (string, SQLParams, []string) {
+	adapter := adapters[db.DriverName()]
+	cols, vals := q.insertColumns(data)
+	quotedCols := make([]string, len(cols))
+	for i, col := range cols {
+		quotedCols[i] = adapter.QuoteIdentifier(col)
+	}
+	tableName := adapter.QuoteTableName(q.recordSet.model.tableName)
+	fields := strings.Join(quotedCols, ", ")
+	values := "?" + strings.Repeat(", ?", len(cols)-1)
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, fields, values)
+	return sql, vals, cols
+}
+
+// insertColumns returns the JSON names of the columns to insert for the
+// given data, sorted by name, and the corresponding parameters.
+func (q *Query) insertColumns(data FieldMap) ([]string, SQLParams) {
 	if len(data) == 0 {
 		log.Panic("No data given for insert")
 	}
-	var (
-		cols []string
-		vals SQLParams
-		i    int
-		sql  string
-	)
+	var cols []string
+	colVals := make(map[string]interface{})
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
 		if fi.fieldType.IsFKRelationType() && !fi.required {
@@ -352,14 +448,53 @@ func (q *Query) insertQuery(data FieldMap) (string, SQLParams) {
 			}
 		}
 		cols = append(cols, fi.json)
-		vals = append(vals, v)
-		i++
+		colVals[fi.json] = sqlValue(fi, v)
 	}
-	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
-	fields := strings.Join(cols, ", ")
-	values := "?" + strings.Repeat(", ?", i-1)
-	sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", tableName, fields, values)
-	return sql, vals
+	sort.Strings(cols)
+	vals := make(SQLParams, len(cols))
+	for i, col := range cols {
+		vals[i] = colVals[col]
+	}
+	return cols, vals
+}
+
+// sqlValue returns the given value of the field fi in a form that can be
+// passed as an argument of an SQL query. JSON fields values are marshalled
+// and their zero value is stored as NULL.
+func sqlValue(fi *Field, value interface{}) interface{} {
+	if fi.fieldType != fieldtype.JSON {
+		return value
+	}
+	if typesutils.IsZero(value) {
+		return nil
+	}
+	return strutils.MarshalToJSONString(value)
+}
+
+// returningSQL returns the RETURNING clause that gives back the id and the
+// values of the given written columns of the inserted or updated rows, so
+// that values set by the database can be put in cache. The version column of
+// the model, which is set by the database, is returned too.
+func (q *Query) returningSQL(writtenCols []string) string {
+	adapter := adapters[db.DriverName()]
+	colsMap := make(map[string]bool)
+	for _, col := range writtenCols {
+		colsMap[col] = true
+	}
+	if vf := q.recordSet.model.versionField; vf != nil {
+		colsMap[q.recordSet.model.fields.MustGet(vf.Name()).json] = true
+	}
+	delete(colsMap, "id")
+	cols := make([]string, 0, len(colsMap))
+	for col := range colsMap {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	cols = append([]string{"id"}, cols...)
+	for i, col := range cols {
+		cols[i] = adapter.QuoteIdentifier(col)
+	}
+	return fmt.Sprintf("RETURNING %s", strings.Join(cols, ", "))
 }
 
 // countQuery returns the SQL query string and parameters to count
@@ -391,10 +526,11 @@ func (q *Query) selectCommonQuery(fields []FieldName) (string, SQLParams, map[st
 	if ctxOrderSQL != "" {
 		ctxOrderSQL = fmt.Sprintf(", %s", ctxOrderSQL)
 	}
-	selQuery := fmt.Sprintf(`SELECT DISTINCT ON (%s.id) %s FROM %s %s ORDER BY %s.id %s`,
+	selQuery := fmt.Sprintf(`SELECT DISTINCT ON (%s."id") %s FROM %s %s ORDER BY %s."id" %s`,
 		q.thisTable(), fieldsSQL, tablesSQL, whereSQL, q.thisTable(), ctxOrderSQL)
 	selQuery = strutils.Substitute(selQuery, joinsMap)
-	return selQuery, args, fieldSubsts
+	withSQL, withArgs := q.withClause()
+	return withSQL + selQuery, withArgs.Extend(args), fieldSubsts
 }
 
 // selectQuery returns the SQL query string and parameters to retrieve
@@ -410,6 +546,9 @@ func (q *Query) selectQuery(fields []FieldName) (string, SQLParams, map[string]s
 	if len(q.groups) > 0 {
 		log.Panic("Calling selectQuery on a Group By query")
 	}
+	if len(q.unions) > 0 {
+		return q.selectUnionQuery(fields)
+	}
 	subQuery, args, substs := q.selectCommonQuery(fields)
 	orderSQL := q.sqlOrderByClause()
 	limitSQL := q.sqlLimitOffsetClause()
@@ -418,6 +557,46 @@ func (q *Query) selectQuery(fields []FieldName) (string, SQLParams, map[string]s
 	return selQuery, args, substs
 }
 
+// selectUnionQuery returns the SQL query string and parameters to retrieve
+// the rows of the queries combined with UNION ALL in this Query.
+// fields is the list of fields to retrieve.
+//
+// The condition of this Query is added to each combined query, whereas its
+// order, limit and offset apply to the combined rows.
+func (q *Query) selectUnionQuery(fields []FieldName) (string, SQLParams, map[string]string) {
+	adapter := adapters[db.DriverName()]
+	fieldExprs, _ := q.selectData(fields, false)
+	unionFields := make([]FieldName, len(fieldExprs))
+	cols := make([]string, len(fieldExprs))
+	substs := make(map[string]string)
+	for i, fe := range fieldExprs {
+		unionFields[i] = joinFieldNames(fe, ExprSep)
+		_, natAlias, realAlias := q.joinedFieldExpression(fe, true, i)
+		cols[i] = adapter.QuoteIdentifier(realAlias)
+		substs[realAlias] = natAlias
+	}
+	colsSQL := strings.Join(cols, ", ")
+	parts := make([]string, len(q.unions))
+	var args SQLParams
+	for i, union := range q.unions {
+		uq := union.clone(union.recordSet)
+		uq.cond = uq.cond.AndCond(q.cond)
+		uSQL, uArgs, uSubsts := uq.selectQuery(unionFields)
+		for realAlias, natAlias := range substs {
+			if uSubsts[realAlias] != natAlias {
+				log.Panic("Unable to combine queries selecting different columns", "model", q.recordSet.model, "column", natAlias)
+			}
+		}
+		parts[i] = fmt.Sprintf(`(SELECT %s FROM (%s) u%d)`, colsSQL, uSQL, i)
+		args = args.Extend(uArgs)
+	}
+	orderSQL := q.sqlOrderByClause()
+	limitSQL := q.sqlLimitOffsetClause()
+	selQuery := fmt.Sprintf(`SELECT * FROM (%s) foo %s %s`,
+		strings.Join(parts, " UNION ALL "), orderSQL, limitSQL)
+	return selQuery, args, substs
+}
+
 // selectGroupQuery returns the SQL query string and parameters to retrieve
 // the result of this Query object, which must include a Group By.
 // fields is the list of fields to retrieve.
@@ -451,6 +630,7 @@ func (q *Query) selectGroupQuery(fieldsList []FieldName, aggFncts map[string]str
 // - Expressions defined by the given fields and that must appear in the field list of the select clause.
 // - All expressions that also include expressions used in the where clause.
 func (q *Query) selectData(fields []FieldName, withCtx bool) ([][]FieldName, [][]FieldName) {
+	q.substituteSearchPredicates()
 	q.substituteChildOfPredicates()
 	// Get all expressions, first given by fields removing duplicates
 	var fieldExprs [][]FieldName
@@ -482,6 +662,12 @@ func (q *Query) selectData(fields []FieldName, withCtx bool) ([][]FieldName, [][
 	return fieldExprs, allExprs
 }
 
+// substituteSearchPredicates replaces in the query the predicates on fields
+// with a search method by the condition returned by this method.
+func (q *Query) substituteSearchPredicates() {
+	q.cond.substituteSearchMethods(q.recordSet)
+}
+
 // substituteChildOfPredicates replaces in the query the predicates with ChildOf
 // operator by the predicates to actually execute.
 func (q *Query) substituteChildOfPredicates() {
@@ -496,6 +682,7 @@ func (q *Query) updateQuery(data FieldMap) (string, SQLParams) {
 		log.Panic("No data given for update")
 	}
 	cols := make([]string, len(data))
+	jsonCols := make([]string, len(data))
 	vals := make(SQLParams, len(data))
 	var (
 		i   int
@@ -503,14 +690,19 @@ func (q *Query) updateQuery(data FieldMap) (string, SQLParams) {
 	)
 	for k, v := range data {
 		fi := q.recordSet.model.fields.MustGet(k)
-		cols[i] = fmt.Sprintf("%s = ?", fi.json)
-		vals[i] = v
+		cols[i] = fmt.Sprintf("%s = ?", adapter.QuoteIdentifier(fi.json))
+		jsonCols[i] = fi.json
+		vals[i] = sqlValue(fi, v)
 		i++
 	}
-	tableName := adapter.quoteTableName(q.recordSet.model.tableName)
+	if vf := q.recordSet.model.versionField; vf != nil {
+		vCol := adapter.QuoteIdentifier(q.recordSet.model.fields.MustGet(vf.Name()).json)
+		cols = append(cols, fmt.Sprintf("%s = COALESCE(%s, 0) + 1", vCol, vCol))
+	}
+	tableName := adapter.QuoteTableName(q.recordSet.model.tableName)
 	updates := strings.Join(cols, ", ")
 	whereSQL, args := q.sqlWhereClause(false)
-	sql = fmt.Sprintf("UPDATE %s SET %s %s", tableName, updates, whereSQL)
+	sql = fmt.Sprintf("UPDATE %s SET %s %s %s", tableName, updates, whereSQL, q.returningSQL(jsonCols))
 	vals = append(vals, args...)
 	return sql, vals
 }
@@ -537,26 +729,32 @@ func (q *Query) fieldsSQL(fieldExprs [][]FieldName) (string, map[string]string)
 // Parameter must be with the following format (column names):
 // [['user_id', 'name'] ['id'] ['profile_id', 'age']]
 func (q *Query) fieldsGroupSQL(fieldExprs [][]FieldName, aggFncts map[string]string) string {
+	adapter := adapters[db.DriverName()]
 	fStr := make([]string, len(fieldExprs))
 	for i, exprs := range fieldExprs {
 		aggFnct := aggFncts[joinFieldNames(exprs, ExprSep).JSON()]
+		alias := adapter.QuoteIdentifier(joinFieldNames(exprs, sqlSep).JSON())
 		if aggFnct == "" {
-			fStr[i] = joinFieldNames(exprs, sqlSep).JSON()
+			fStr[i] = alias
+			if dgSQL, ok := q.dateGroupSQL(joinFieldNames(exprs, ExprSep), alias); ok {
+				fStr[i] = fmt.Sprintf("%s AS %s", dgSQL, alias)
+			}
 			continue
 		}
-		fStr[i] = fmt.Sprintf("%s(%s) AS %s", aggFnct, joinFieldNames(exprs, sqlSep).JSON(), joinFieldNames(exprs, sqlSep).JSON())
+		fStr[i] = fmt.Sprintf("%s(%s) AS %s", aggFnct, alias, alias)
 	}
 	return strings.Join(fStr, ", ")
 }
 
 // joinedFieldExpression joins the given expressions into a fields sql string
-//     ['profile_id' 'user_id' 'name'] => "profiles__users".name
-//     ['age'] => "mytable".age
+//     ['profile_id' 'user_id' 'name'] => "profiles__users"."name"
+//     ['age'] => "mytable"."age"
 //
 // If withAlias is true, then returns fields with its alias. In this case, aliasIndex is used
 // to define aliases when the nominal "profile_id__user_id__name" is longer than 64 chars.
 // Returned second argument is the nominal alias and third argument is the alias actually used.
 func (q *Query) joinedFieldExpression(exprs []FieldName, withAlias bool, aliasIndex int) (string, string, string) {
+	adapter := adapters[db.DriverName()]
 	joins := q.generateTableJoins(exprs)
 	lastJoin := joins[len(joins)-1]
 	if withAlias {
@@ -565,9 +763,10 @@ func (q *Query) joinedFieldExpression(exprs []FieldName, withAlias bool, aliasIn
 		if len(fAlias) > maxSQLidentifierLength {
 			fAlias = fmt.Sprintf("f%d", aliasIndex)
 		}
-		return fmt.Sprintf("%s.%s AS %s", lastJoin.alias, lastJoin.expr.JSON(), fAlias), oldAlias, fAlias
+		return fmt.Sprintf("%s.%s AS %s", lastJoin.alias, adapter.QuoteIdentifier(lastJoin.expr.JSON()),
+			adapter.QuoteIdentifier(fAlias)), oldAlias, fAlias
 	}
-	return fmt.Sprintf("%s.%s", lastJoin.alias, lastJoin.expr.JSON()), "", ""
+	return fmt.Sprintf("%s.%s", lastJoin.alias, adapter.QuoteIdentifier(lastJoin.expr.JSON())), "", ""
 }
 
 // generateTableJoins transforms a list of fields expression into a list of tableJoins
@@ -577,7 +776,7 @@ func (q *Query) generateTableJoins(fieldExprs []FieldName) []tableJoin {
 	var joins []tableJoin
 	curMI := q.recordSet.model
 	// Create the tableJoin for the current table
-	currentTableName := adapter.quoteTableName(curMI.tableName)
+	currentTableName := adapter.QuoteTableName(curMI.tableName)
 	var curExpr FieldName
 	if len(fieldExprs) > 0 {
 		curExpr = fieldExprs[0]
@@ -617,15 +816,17 @@ func (q *Query) generateTableJoins(fieldExprs []FieldName) []tableJoin {
 			}
 		case fieldtype.Many2Many:
 			// Add relation table join
-			relationTableName := adapter.quoteTableName(fi.m2mRelModel.tableName)
-			alias = fmt.Sprintf("%s%s%s", alias, sqlSep, fi.m2mRelModel.tableName)
+			relationTableName := adapter.QuoteTableName(fi.m2mRelModel.tableName)
+			// The alias includes the column pointing to our model so that both
+			// sides of a self-referencing many2many relation get their own joins.
+			alias = fmt.Sprintf("%s%s%s%s%s", alias, sqlSep, fi.m2mRelModel.tableName, sqlSep, fi.m2mOurField.json)
 			tj := tableJoin{
 				tableName:  relationTableName,
 				joined:     true,
 				field:      fi.m2mRelModel.FieldName(fi.m2mOurField.name),
 				otherTable: curTJ,
 				otherField: ID,
-				alias:      adapter.quoteTableName(alias),
+				alias:      adapter.QuoteTableName(alias),
 				expr:       fi.m2mRelModel.FieldName(fi.m2mTheirField.name),
 			}
 			joins = append(joins, tj)
@@ -637,7 +838,7 @@ func (q *Query) generateTableJoins(fieldExprs []FieldName) []tableJoin {
 			}
 		}
 
-		linkedTableName := adapter.quoteTableName(fi.relatedModel.tableName)
+		linkedTableName := adapter.QuoteTableName(fi.relatedModel.tableName)
 		alias = fmt.Sprintf("%s%s%s", alias, sqlSep, fi.relatedModel.tableName)
 		nextTJ := tableJoin{
 			tableName:  linkedTableName,
@@ -645,7 +846,7 @@ func (q *Query) generateTableJoins(fieldExprs []FieldName) []tableJoin {
 			field:      field,
 			otherTable: curTJ,
 			otherField: otherField,
-			alias:      adapter.quoteTableName(alias),
+			alias:      adapter.QuoteTableName(alias),
 			expr:       tjExpr,
 		}
 		joins = append(joins, nextTJ)
@@ -673,7 +874,7 @@ func (q *Query) tablesSQL(fExprs [][]FieldName) (string, map[string]string) {
 		tJoins := q.generateTableJoins(f)
 		for _, j := range tJoins {
 			if _, exists := joinsMap[j.alias]; !exists {
-				joinsMap[j.alias] = adapter.quoteTableName(fmt.Sprintf("T%d", aliasIndex))
+				joinsMap[j.alias] = adapter.QuoteTableName(fmt.Sprintf("T%d", aliasIndex))
 				if aliasIndex == 0 {
 					joinsMap[j.alias] = j.alias
 				}
@@ -688,7 +889,7 @@ func (q *Query) tablesSQL(fExprs [][]FieldName) (string, map[string]string) {
 // thisTable returns the quoted table name of this query's recordset table
 func (q *Query) thisTable() string {
 	adapter := adapters[db.DriverName()]
-	return adapter.quoteTableName(q.recordSet.model.tableName)
+	return adapter.QuoteTableName(q.recordSet.model.tableName)
 }
 
 // isEmpty returns true if this query is empty
@@ -700,6 +901,19 @@ func (q *Query) isEmpty() bool {
 	return q.sideDataIsEmpty()
 }
 
+// targetsAllRecords returns true if this query, or the query it has been
+// built from before its ids were fetched, selects all the records of the table.
+//
+// Conditions added to a fetched RecordSet with Search are also added to its
+// original query, so that the effective condition is checked.
+func (q *Query) targetsAllRecords() bool {
+	orig := q
+	if q.original != nil {
+		orig = q.original
+	}
+	return orig.fetchAll && orig.cond.IsEmpty() && orig.limit == 0 && orig.offset == 0
+}
+
 // sideDataIsEmpty returns true if all side data of the query is empty.
 // By side data, we mean everything but the condition itself.
 func (q *Query) sideDataIsEmpty() bool {
@@ -718,6 +932,9 @@ func (q *Query) sideDataIsEmpty() bool {
 	if len(q.orders) > 0 {
 		return false
 	}
+	if len(q.unions) > 0 {
+		return false
+	}
 	return true
 }
 
@@ -733,14 +950,24 @@ func (q *Query) substituteConditionExprs(substMap map[FieldName][]FieldName) {
 			}
 		}
 	}
+	// dateGroups map may be shared with other queries
+	dateGroups := make(map[string]DateGrouping)
+	for k, v := range q.dateGroups {
+		dateGroups[k] = v
+	}
 	for i, group := range q.groups {
 		for k, v := range substMap {
 			if group.JSON() == k.JSON() {
 				q.groups[i] = joinFieldNames(v, ExprSep)
+				if dg, ok := q.dateGroups[k.JSON()]; ok {
+					delete(dateGroups, k.JSON())
+					dateGroups[q.groups[i].JSON()] = dg
+				}
 				break
 			}
 		}
 	}
+	q.dateGroups = dateGroups
 }
 
 // evaluateConditionArgFunctions evaluates all args in the queries that are functions and
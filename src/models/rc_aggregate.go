@@ -0,0 +1,206 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// Aggregate evaluates the given SQL aggregate expression (e.g. "SUM(Qty * Price)")
+// over the records of this RecordCollection and returns its result as a float64.
+//
+// Field names used in the expression are translated into their columns. A NULL
+// result, for instance when no record matches, is returned as 0. It is the
+// caller's responsibility to give a valid SQL aggregate expression, since it
+// is injected as is in the query. See AggregateExprs for details.
+func (rc *RecordCollection) Aggregate(expr string) float64 {
+	val := rc.AggregateExprs(expr)[expr]
+	switch v := val.(type) {
+	case nil:
+		return 0
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	log.Panic("Aggregate expression did not return a number", "model", rc.model, "expr", expr, "value", val)
+	return 0
+}
+
+// AggregateExprs evaluates each of the given SQL aggregate expressions over the
+// records of this RecordCollection and returns their results in a FieldMap keyed
+// by expression.
+//
+// In the given expressions, the Go name of the stored fields of this model
+// (e.g. "Qty" in "SUM(Qty * Price)") is translated into the corresponding column.
+// Names followed by an opening parenthesis, quoted strings and quoted identifiers
+// are left untouched. Relation paths such as "User.Name" are not supported.
+//
+// The expressions are injected as is in the query: it is the caller's
+// responsibility to give valid SQL aggregate expressions and never to build
+// them from user input. Numeric results are returned as float64.
+func (rc *RecordCollection) AggregateExprs(exprs ...string) FieldMap {
+	if len(exprs) == 0 {
+		log.Panic("No aggregate expression given", "model", rc.model)
+	}
+	if len(rc.query.groups) > 0 {
+		log.Panic("Trying to evaluate aggregate expressions on a grouped query", "model", rc.model)
+	}
+	if rc.hasNegIds {
+		log.Panic("Trying to aggregate a memory RecordSet created by New", "model", rc.model, "ids", rc.ids)
+	}
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	adapter := adapters[db.DriverName()]
+	cols := make([]string, len(exprs))
+	for i, expr := range exprs {
+		cols[i] = fmt.Sprintf("%s AS %s", rc.translateSQLExpr(expr), adapter.QuoteIdentifier(fmt.Sprintf("agg%d", i)))
+	}
+	idsQuery, args := rc.idsSubQuery()
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s.%s IN (%s)`,
+		strings.Join(cols, ", "), rc.query.thisTable(), rc.query.thisTable(), adapter.QuoteIdentifier("id"), idsQuery)
+
+	rows := dbQuery(rc.env.cr.tx, query, args...)
+	defer rows.Close()
+	vals := make(FieldMap)
+	if rows.Next() {
+		if err := sqlx.MapScan(rows, vals); err != nil {
+			log.Panic(err.Error(), "model", rc.ModelName(), "exprs", exprs)
+		}
+	}
+	res := make(FieldMap)
+	for i, expr := range exprs {
+		val := vals[fmt.Sprintf("agg%d", i)]
+		if b, ok := val.([]byte); ok {
+			// Numeric values are returned as text by the driver
+			if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+				val = f
+			} else {
+				val = string(b)
+			}
+		}
+		res[expr] = val
+	}
+	return res
+}
+
+// translateSQLExpr returns the given SQL expression with the names of
+// the stored fields of this model replaced by their qualified column.
+func (rc *RecordCollection) translateSQLExpr(expr string) string {
+	adapter := adapters[db.DriverName()]
+	isIdentRune := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	runes := []rune(expr)
+	var res strings.Builder
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\'' || r == '"':
+			// Copy quoted strings and identifiers verbatim
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j < len(runes) {
+				j++
+			}
+			res.WriteString(string(runes[i:j]))
+			i = j
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(runes) && isIdentRune(runes[j]) {
+				j++
+			}
+			ident := string(runes[i:j])
+			k := j
+			for k < len(runes) && unicode.IsSpace(runes[k]) {
+				k++
+			}
+			fi, ok := rc.model.fields.registryByName[ident]
+			isFunc := k < len(runes) && runes[k] == '('
+			isQualified := i > 0 && runes[i-1] == '.'
+			if ok && fi.isStored() && !isFunc && !isQualified {
+				res.WriteString(fmt.Sprintf("%s.%s", rc.query.thisTable(), adapter.QuoteIdentifier(fi.json)))
+			} else {
+				res.WriteString(ident)
+			}
+			i = j
+		case unicode.IsDigit(r):
+			// Copy numbers entirely so that exponents are not taken for identifiers
+			j := i + 1
+			for j < len(runes) && (isIdentRune(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			res.WriteString(string(runes[i:j]))
+			i = j
+		default:
+			res.WriteRune(r)
+			i++
+		}
+	}
+	return res.String()
+}
+
+// RelatedCount returns, for each record of this RecordCollection, the number of
+// records of the given one2many or many2many field, without loading them.
+//
+// Counts are computed with a single grouped query. As when reading the field,
+// access rules of the related model apply to one2many fields only.
+func (rc *RecordCollection) RelatedCount(fieldName string) map[int64]int {
+	fi := rc.model.fields.MustGet(fieldName)
+	if fi.fieldType != fieldtype.One2Many && fi.fieldType != fieldtype.Many2Many {
+		log.Panic("RelatedCount requires a one2many or many2many field", "model", rc.model, "field", fieldName)
+	}
+	if rc.hasNegIds {
+		log.Panic("Trying to count related records of a memory RecordSet created by New", "model", rc.model, "ids", rc.ids)
+	}
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	res := make(map[int64]int)
+	ids := rc.Ids()
+	if len(ids) == 0 {
+		return res
+	}
+	for _, id := range ids {
+		res[id] = 0
+	}
+	adapter := adapters[db.DriverName()]
+	var (
+		query string
+		args  SQLParams
+	)
+	switch fi.fieldType {
+	case fieldtype.One2Many:
+		relRC := rc.env.Pool(fi.relatedModelName)
+		fkField := relRC.model.fields.MustGet(fi.reverseFK)
+		idsQuery, idsArgs := relRC.Search(relRC.model.Field(relRC.model.FieldName(fi.reverseFK)).In(ids)).idsSubQuery()
+		fkCol := adapter.QuoteIdentifier(fkField.json)
+		query = fmt.Sprintf(`SELECT %s, COUNT(*) FROM %s WHERE %s IN (%s) GROUP BY %s`,
+			fkCol, adapter.QuoteTableName(relRC.model.tableName), adapter.QuoteIdentifier("id"), idsQuery, fkCol)
+		args = idsArgs
+	case fieldtype.Many2Many:
+		ourCol := adapter.QuoteIdentifier(fi.m2mOurField.json)
+		query = fmt.Sprintf(`SELECT %s, COUNT(*) FROM %s WHERE %s IN (?) GROUP BY %s`,
+			ourCol, adapter.QuoteTableName(fi.m2mRelModel.tableName), ourCol, ourCol)
+		args = SQLParams{ids}
+	}
+	rows := dbQuery(rc.env.cr.tx, query, args...)
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id  int64
+			cnt int
+		)
+		if err := rows.Scan(&id, &cnt); err != nil {
+			log.Panic(err.Error(), "model", rc.ModelName(), "field", fieldName)
+		}
+		res[id] = cnt
+	}
+	return res
+}
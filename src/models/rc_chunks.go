@@ -0,0 +1,69 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "fmt"
+
+// ReadChunked loads the given fields of the records of this RecordCollection
+// by chunks of chunkSize records ordered by ID and calls fn with each chunk.
+//
+// Chunks are fetched with keyset pagination on the ID, so that each query
+// only reads chunkSize rows whatever the size of the table. Each chunk is
+// loaded in its own cache, so that only one chunk is held in memory at a
+// time and that neither this RecordCollection nor the cache of its
+// Environment are modified. If fn returns an error, iteration stops and the
+// error is returned.
+func (rc *RecordCollection) ReadChunked(chunkSize int, fields []FieldName, fn func(*RecordCollection) error) error {
+	if chunkSize <= 0 {
+		log.Panic("Chunk size must be strictly positive", "model", rc.model.name, "chunkSize", chunkSize)
+	}
+	if rc.query.isEmpty() {
+		return nil
+	}
+	base := rc.clone()
+	if rc.query.limit > 0 || rc.query.offset > 0 {
+		// Chunks must only span the records of this limited set
+		adapter := adapters[db.DriverName()]
+		idsQuery, args := rc.idsSubQuery()
+		base = rc.env.Pool(rc.ModelName())
+		base = base.SearchRaw(fmt.Sprintf("%s.%s IN (%s)", base.query.thisTable(), adapter.QuoteIdentifier("id"), idsQuery), args...)
+	}
+	var lastID int64
+	for {
+		chunkEnv := rc.Env()
+		chunkEnv.cache = newCache()
+		chunk := newRecordCollection(chunkEnv, rc.ModelName())
+		chunk.query = base.query.clone(chunk)
+		chunk.query.original = nil
+		chunk = chunk.Search(rc.model.Field(ID).Greater(lastID)).OrderBy("ID").Limit(chunkSize).Load(fields...)
+		if len(chunk.ids) == 0 {
+			return nil
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+		if len(chunk.ids) < chunkSize {
+			return nil
+		}
+		lastID = chunk.ids[len(chunk.ids)-1]
+	}
+}
+
+// MappedChunked reads the values of the given field for all the records of
+// this RecordCollection by chunks of chunkSize records and calls fn with the
+// values of each chunk, ordered by ID.
+//
+// Unlike reading all the values at once, only one chunk is kept in memory at a
+// time, which makes it suitable to process a single column of a very large
+// number of records. If fn returns an error, no more chunks are read and the
+// error is returned. See ReadChunked.
+func (rc *RecordCollection) MappedChunked(fieldName FieldName, chunkSize int, fn func([]interface{}) error) error {
+	return rc.ReadChunked(chunkSize, []FieldName{fieldName}, func(chunk *RecordCollection) error {
+		values := make([]interface{}, len(chunk.ids))
+		for i, rec := range chunk.Records() {
+			values[i] = rec.Get(fieldName)
+		}
+		return fn(values)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/security"
+)
+
+// companyFieldName is the name of the many2one field that holds the
+// company of the records of multi-company models.
+const companyFieldName = "Company"
+
+// An AllowedCompaniesFunc returns the ids of the companies that the user of
+// the given Environment is allowed to access. A nil slice means that the
+// user is not restricted to any company.
+type AllowedCompaniesFunc func(env Environment) []int64
+
+// allowedCompaniesFunc is the function used to get the allowed companies
+var allowedCompaniesFunc AllowedCompaniesFunc = contextAllowedCompanies
+
+// SetAllowedCompaniesFunc sets the function that returns the companies
+// the user of an Environment is allowed to access.
+//
+// By default, allowed companies are read from the "allowed_company_ids"
+// key of the context, and users are not restricted if this key is not set.
+func SetAllowedCompaniesFunc(fn AllowedCompaniesFunc) {
+	if fn == nil {
+		fn = contextAllowedCompanies
+	}
+	allowedCompaniesFunc = fn
+}
+
+// contextAllowedCompanies returns the ids set in the "allowed_company_ids"
+// key of the context of the given Environment, or nil if it is not set.
+func contextAllowedCompanies(env Environment) []int64 {
+	if !env.Context().HasKey("allowed_company_ids") {
+		return nil
+	}
+	return env.Context().GetIntegerSlice("allowed_company_ids")
+}
+
+// companyField returns the company field of this model, or nil if this
+// model is not a multi-company model.
+func (m *Model) companyField() *Field {
+	fi, ok := m.fields.Get(companyFieldName)
+	if !ok || fi.fieldType != fieldtype.Many2One {
+		return nil
+	}
+	return fi
+}
+
+// companyCondition returns the condition that restricts this RecordCollection
+// to the records of the companies allowed to the user, or nil if there is
+// no restriction. Records without company are always allowed.
+func (rc *RecordCollection) companyCondition() *Condition {
+	fi := rc.model.companyField()
+	if fi == nil {
+		return nil
+	}
+	allowed := allowedCompaniesFunc(rc.Env())
+	if allowed == nil {
+		return nil
+	}
+	companyField := rc.model.FieldName(fi.name)
+	return rc.model.Field(companyField).IsNull().Or().Field(companyField).In(allowed)
+}
+
+// checkCompanyAccess panics if the given FieldMap sets the company field of
+// this RecordCollection's model to a company that the user is not allowed
+// to access. fMap values must have been converted to the field types.
+func (rc *RecordCollection) checkCompanyAccess(fMap FieldMap) {
+	fi := rc.model.companyField()
+	if fi == nil || rc.env.uid == security.SuperUserID {
+		return
+	}
+	val, ok := fMap.Get(rc.model.FieldName(fi.name))
+	if !ok {
+		return
+	}
+	companyID, isID := val.(int64)
+	if !isID || companyID == 0 {
+		return
+	}
+	allowed := allowedCompaniesFunc(rc.Env())
+	if allowed == nil {
+		return
+	}
+	for _, id := range allowed {
+		if id == companyID {
+			return
+		}
+	}
+	panicAccessError("You are not allowed to access this company", "model", rc.ModelName(), "company", companyID, "uid", rc.env.uid)
+}
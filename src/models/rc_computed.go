@@ -21,10 +21,133 @@ import (
 	"github.com/hexya-erp/hexya/src/tools/typesutils"
 )
 
+// A RecomputeMode defines when a stored computed field is recomputed
+// after a change of one of the fields it depends on.
+type RecomputeMode string
+
+const (
+	// RecomputeImmediate fields are recomputed as soon as one of the
+	// fields they depend on is modified. This is the default.
+	RecomputeImmediate RecomputeMode = "immediate"
+	// RecomputeLazy fields are marked as dirty when one of the fields they
+	// depend on is modified. They are recomputed the next time they are read
+	// or when the Environment is flushed, whichever comes first.
+	RecomputeLazy RecomputeMode = "lazy"
+	// RecomputeManual fields are only recomputed by calling Recompute.
+	RecomputeManual RecomputeMode = "manual"
+)
+
+// valid returns true if this RecomputeMode is a known mode
+func (rm RecomputeMode) valid() bool {
+	switch rm {
+	case "", RecomputeImmediate, RecomputeLazy, RecomputeManual:
+		return true
+	}
+	return false
+}
+
 // A recomputePair gives a method to apply on a record collection.
 type recomputePair struct {
 	recs   *RecordCollection
 	method string
+	mode   RecomputeMode
+}
+
+// A recomputeKey identifies a compute method of a model
+type recomputeKey struct {
+	model  string
+	method string
+}
+
+// A recomputeQueue holds the stored computed fields recomputations
+// that have been postponed in an Environment.
+//
+// deferred is the number of nested DeferRecompute calls being executed.
+// All recomputations are postponed while it is strictly positive.
+type recomputeQueue struct {
+	keys     []recomputeKey
+	ids      map[recomputeKey][]int64
+	deferred int
+}
+
+// newRecomputeQueue returns a new empty recomputeQueue
+func newRecomputeQueue() *recomputeQueue {
+	return &recomputeQueue{
+		ids: make(map[recomputeKey][]int64),
+	}
+}
+
+// add postpones the recomputation given by the recomputePair
+func (q *recomputeQueue) add(rp recomputePair) {
+	key := recomputeKey{model: rp.recs.model.name, method: rp.method}
+	if _, exists := q.ids[key]; !exists {
+		q.keys = append(q.keys, key)
+	}
+	q.ids[key] = append(q.ids[key], rp.recs.Ids()...)
+}
+
+// take removes the postponed recomputation of the given key from this queue
+// and returns the ids of the records to recompute.
+func (q *recomputeQueue) take(key recomputeKey) []int64 {
+	ids, exists := q.ids[key]
+	if !exists {
+		return nil
+	}
+	delete(q.ids, key)
+	for i, k := range q.keys {
+		if k == key {
+			q.keys = append(q.keys[:i], q.keys[i+1:]...)
+			break
+		}
+	}
+	return ids
+}
+
+// isEmpty returns true if there is no postponed recomputation in this queue
+func (q *recomputeQueue) isEmpty() bool {
+	return len(q.keys) == 0
+}
+
+// pop empties this queue and returns its content as a slice of recomputePair
+// on the given Environment. Each compute method is returned only once for all
+// the records that need to be recomputed with it.
+func (q *recomputeQueue) pop(env Environment) []recomputePair {
+	res := make([]recomputePair, len(q.keys))
+	for i, key := range q.keys {
+		res[i] = recomputePair{
+			recs:   env.Pool(key.model).withIds(q.ids[key]).ForceLoad(ID),
+			method: key.method,
+		}
+	}
+	q.reset()
+	return res
+}
+
+// reset removes all the postponed recomputations of this queue
+func (q *recomputeQueue) reset() {
+	q.keys = nil
+	q.ids = make(map[recomputeKey][]int64)
+}
+
+// snapshot returns a copy of the postponed recomputations of this queue,
+// so that they can be restored later with restore.
+func (q *recomputeQueue) snapshot() recomputeQueue {
+	res := recomputeQueue{
+		keys: append([]recomputeKey{}, q.keys...),
+		ids:  make(map[recomputeKey][]int64, len(q.ids)),
+	}
+	for key, ids := range q.ids {
+		res.ids[key] = append([]int64{}, ids...)
+	}
+	return res
+}
+
+// restore replaces the postponed recomputations of this queue by
+// those of the given snapshot. The nesting level of DeferRecompute
+// calls is left untouched.
+func (q *recomputeQueue) restore(snap recomputeQueue) {
+	q.keys = snap.keys
+	q.ids = snap.ids
 }
 
 // computeFieldValues updates the given params with the given computed (non stored) fields
@@ -38,9 +161,63 @@ func (rc *RecordCollection) computeFieldValues(params *FieldMap, fields ...strin
 			// probably because it was computed with another field
 			continue
 		}
-		newParams := rc.Call(fInfo.compute).(RecordData).Underlying().FieldMap
-		(*params).MergeWith(newParams, rc.model)
+		(*params).MergeWith(rc.callComputeMethod(fInfo), rc.model)
+	}
+}
+
+// callComputeMethod calls the compute method of the given field on this
+// singleton and returns the computed values keyed by fields' JSON names.
+func (rc *RecordCollection) callComputeMethod(fi *Field) FieldMap {
+	res := make(FieldMap)
+	res.MergeWith(rc.Call(fi.compute).(RecordData).Underlying().FieldMap, rc.model)
+	return res
+}
+
+// EvalCompute calls the compute method of the given computed field on this
+// record and returns the computed value. The value is neither stored in the
+// database nor in the cache, and memoized values are ignored.
+//
+// This is mainly meant for testing compute methods on memory records created
+// with New, without inserting records in the database. It works with both
+// stored and non stored computed fields. It panics if this RecordCollection
+// is not a singleton or if the field is not computed.
+func (rc *RecordCollection) EvalCompute(fieldName string) interface{} {
+	rc.EnsureOne()
+	fi := rc.model.fields.MustGet(fieldName)
+	if !fi.isComputedField() {
+		log.Panic("Field is not a computed field", "model", rc.model.name, "field", fieldName)
+	}
+	return rc.callComputeMethod(fi)[fi.json]
+}
+
+// computedValue returns the value of the given non stored computed field
+// for the record of this singleton.
+//
+// Values of fields with dependencies are memoized in the cache of the
+// Environment for the current user and context, so that the field is
+// computed only once until one of its dependencies is modified.
+func (rc *RecordCollection) computedValue(fi *Field) interface{} {
+	memoize := len(fi.depends) > 0 && len(rc.ids) == 1 && !rc.hasNegIds
+	ctxSlug := rc.computedMemoKey()
+	if memoize {
+		if val, ok := rc.env.cache.getComputed(rc.model.name, rc.ids[0], fi.json, ctxSlug); ok {
+			return val
+		}
+	}
+	fMap := make(FieldMap)
+	rc.computeFieldValues(&fMap, fi.json)
+	if memoize {
+		rc.env.cache.setComputed(rc.model.name, rc.ids[0], fi.json, ctxSlug, fMap[fi.json])
 	}
+	return fMap[fi.json]
+}
+
+// computedMemoKey returns the key under which the values of non stored
+// computed fields of this RecordCollection are memoized. Compute methods
+// may depend on the user through access rules, so the key is made of the
+// user and of the context.
+func (rc *RecordCollection) computedMemoKey() string {
+	return fmt.Sprintf("%d|%s", rc.env.uid, rc.env.context.String())
 }
 
 // processTriggers execute computed fields recomputation (for stored fields) or
@@ -48,6 +225,8 @@ func (rc *RecordCollection) computeFieldValues(params *FieldMap, fields ...strin
 // attribute.
 func (rc *RecordCollection) processTriggers(keys []FieldName) {
 	if rc.Env().Context().GetBool("hexya_no_recompute_stored_fields") {
+		// We do not know which memoized values are stale
+		rc.env.cache.clearComputed()
 		return
 	}
 	rc.updateStoredFields(rc.retrieveComputeData(keys))
@@ -57,6 +236,36 @@ func (rc *RecordCollection) processTriggers(keys []FieldName) {
 //
 // Returned value is an ordered slice of methods to apply on records
 func (rc *RecordCollection) retrieveComputeData(fields []FieldName) []recomputePair {
+	return rc.retrievePathComputeData(fields, false)
+}
+
+// retrieveRelatedComputeData returns the recomputations of the fields of
+// other records that depend on this RecordCollection through the relation
+// fields of the given FieldMap.
+//
+// It must be called before the FieldMap is written, so that the records that
+// will not be related to this RecordCollection anymore are recomputed too.
+func (rc *RecordCollection) retrieveRelatedComputeData(fMap FieldMap) []recomputePair {
+	if rc.Env().Context().GetBool("hexya_no_recompute_stored_fields") {
+		return nil
+	}
+	var relFields []FieldName
+	for _, fName := range fMap.FieldNames(rc.model) {
+		fi, ok := rc.model.fields.Get(fName.Name())
+		if ok && fi.fieldType.IsFKRelationType() {
+			relFields = append(relFields, fName)
+		}
+	}
+	if len(relFields) == 0 {
+		return nil
+	}
+	return rc.retrievePathComputeData(relFields, true)
+}
+
+// retrievePathComputeData looks up fields that need to be recomputed when the given fields
+// are modified. If relatedOnly is true, only fields of records related to this RecordCollection
+// through a path are returned.
+func (rc *RecordCollection) retrievePathComputeData(fields []FieldName, relatedOnly bool) []recomputePair {
 	// Find record fields to update from the modified fields
 	var (
 		toUpdateKeys []string
@@ -69,6 +278,9 @@ func (rc *RecordCollection) retrieveComputeData(fields []FieldName) []recomputeP
 			continue
 		}
 		for _, dep := range refFieldInfo.dependencies {
+			if relatedOnly && dep.path == "" {
+				continue
+			}
 			key := fmt.Sprintf("%s-%s-%s-%t", dep.model.name, dep.path, dep.compute, dep.stored)
 			if _, exists := toUpdateData[key]; !exists {
 				toUpdateKeys = append(toUpdateKeys, key)
@@ -82,19 +294,24 @@ func (rc *RecordCollection) retrieveComputeData(fields []FieldName) []recomputeP
 	for _, key := range toUpdateKeys {
 		cData := toUpdateData[key]
 		recs := rc
+		if cData.mode == RecomputeManual {
+			continue
+		}
 		if cData.path != "" {
 			cPath := cData.model.FieldName(cData.path)
 			recs = rc.Env().Pool(cData.model.name).Search(rc.Model().Field(cPath).In(rc.Ids()))
 		}
 		if !cData.stored {
 			// Field is not stored, just invalidating cache
+			fJSON := cData.model.fields.MustGet(cData.fieldName).json
 			for _, id := range recs.Ids() {
 				rc.env.cache.removeEntry(recs.model, id, cData.fieldName, rc.query.ctxArgsSlug())
+				rc.env.cache.removeComputed(recs.model.name, id, fJSON)
 			}
 			continue
 		}
 		recs.Fetch()
-		res = append(res, recomputePair{recs: recs, method: cData.compute})
+		res = append(res, recomputePair{recs: recs, method: cData.compute, mode: cData.mode})
 	}
 	return res
 }
@@ -107,10 +324,64 @@ func (rc *RecordCollection) updateStoredFields(compPairs []recomputePair) {
 			// if it is empty now, it must be because the records have been unlinked in between
 			continue
 		}
+		if rp.mode == RecomputeLazy || rc.env.recompute.deferred > 0 {
+			rc.env.recompute.add(rp)
+			continue
+		}
 		rp.recs.applyMethod(rp.method)
 	}
 }
 
+// recomputeIfDirty recomputes the given lazy field if its recomputation
+// has been postponed for the record of this singleton. All the postponed
+// records of the field's compute method are recomputed at once.
+func (rc *RecordCollection) recomputeIfDirty(fi *Field) {
+	if fi.recomputeMode != RecomputeLazy || rc.hasNegIds || rc.IsEmpty() {
+		return
+	}
+	key := recomputeKey{model: rc.model.name, method: fi.compute}
+	var dirty bool
+	for _, id := range rc.env.recompute.ids[key] {
+		if id == rc.ids[0] {
+			dirty = true
+			break
+		}
+	}
+	if !dirty {
+		return
+	}
+	ids := rc.env.recompute.take(key)
+	rc.env.Pool(rc.model.name).withIds(ids).ForceLoad(ID).applyMethod(fi.compute)
+}
+
+// Recompute recomputes the given stored computed fields of the records of this
+// RecordCollection, or all its stored computed fields if none is given.
+//
+// This is the only way to update fields with the RecomputeManual mode, but it
+// can be used on any stored computed field.
+func (rc *RecordCollection) Recompute(fieldNames ...FieldName) {
+	fields := rc.model.fields.computedStoredFields
+	if len(fieldNames) > 0 {
+		fields = make([]*Field, len(fieldNames))
+		for i, fName := range fieldNames {
+			fi := rc.model.fields.MustGet(fName.Name())
+			if !fi.isComputedField() || !fi.stored {
+				log.Panic("Only stored computed fields can be recomputed", "model", rc.model.name, "field", fName)
+			}
+			fields[i] = fi
+		}
+	}
+	recs := rc.Fetch()
+	done := make(map[string]bool)
+	for _, fi := range fields {
+		if done[fi.compute] {
+			continue
+		}
+		recs.applyMethod(fi.compute)
+		done[fi.compute] = true
+	}
+}
+
 // applyMethod calls the method on this recordset.
 func (rc *RecordCollection) applyMethod(methodName string) {
 	for _, rec := range rc.Records() {
@@ -122,14 +393,7 @@ func (rc *RecordCollection) applyMethod(methodName string) {
 			if f == "write_date" {
 				continue
 			}
-			if rs, isRS := rec.Get(rec.model.FieldName(f)).(RecordSet); isRS {
-				if !rs.Collection().Equals(v.(RecordSet).Collection()) {
-					doUpdate = true
-					break
-				}
-				continue
-			}
-			if rec.Get(rec.model.FieldName(f)) != v {
+			if !valuesEqual(rec.Get(rec.model.FieldName(f)), v) {
 				doUpdate = true
 				break
 			}
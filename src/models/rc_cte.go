@@ -0,0 +1,74 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A cteData is a common table expression added to a query with With
+type cteData struct {
+	name string
+	sub  *RecordCollection
+}
+
+// With returns a new RecordSet whose select queries are prefixed with a
+// common table expression named name, i.e. "WITH name AS (...)", holding
+// all the stored columns of the records of sub.
+//
+// The CTE can then be referenced as a table in raw SQL conditions of this
+// RecordSet, for instance:
+//
+//	recent := env.Pool("Post").Search(q.Post().CreateDate().Greater(since))
+//	users := env.Pool("User").With("recent", recent).
+//		SearchRaw(`"user"."id" IN (SELECT "user_id" FROM recent)`)
+//
+// The query of sub is evaluated when this RecordSet is queried, with its own
+// access rules, and its arguments are merged with those of the main query.
+// The CTE is only added to select queries (searches, counts, loads and group
+// queries) and not to queries that update or delete records by condition.
+//
+// name must be a lower case SQL identifier.
+func (rc *RecordCollection) With(name string, sub RecordSet) *RecordCollection {
+	if !sqlAliasRegexp.MatchString(name) {
+		log.Panic("Invalid CTE name", "model", rc.model, "name", name)
+	}
+	for _, cte := range rc.query.ctes {
+		if cte.name == name {
+			log.Panic("CTE name already used", "model", rc.model, "name", name)
+		}
+	}
+	subRS := sub.Collection()
+	if subRS.hasNegIds {
+		log.Panic("Trying to use a memory RecordSet created by New as CTE", "model", subRS.model, "ids", subRS.ids)
+	}
+	rSet := rc.clone()
+	rSet.query.ctes = append(append([]cteData{}, rc.query.ctes...), cteData{
+		name: name,
+		sub:  subRS.clone(),
+	})
+	return rSet
+}
+
+// withClause returns the WITH clause of this query, including the trailing
+// space, and its arguments. It returns an empty string if no CTE is defined.
+func (q *Query) withClause() (string, SQLParams) {
+	if len(q.ctes) == 0 {
+		return "", SQLParams{}
+	}
+	adapter := adapters[db.DriverName()]
+	var (
+		ctes []string
+		args SQLParams
+	)
+	for _, cte := range q.ctes {
+		table := cte.sub.query.thisTable()
+		idsQuery, idsArgs := cte.sub.idsSubQuery()
+		ctes = append(ctes, fmt.Sprintf(`%s AS (SELECT %s.* FROM %s WHERE %s.%s IN (%s))`,
+			cte.name, table, table, table, adapter.QuoteIdentifier("id"), idsQuery))
+		args = args.Extend(idsArgs)
+	}
+	return fmt.Sprintf("WITH %s ", strings.Join(ctes, ", ")), args
+}
@@ -19,6 +19,21 @@ import (
 	"github.com/hexya-erp/hexya/src/models/types"
 )
 
+// checkNotReadOnly panics if the environment of this RecordCollection
+// is read-only, if its model is a SQL view model or if it has been built
+// with UnionQuery. operation is the name of the attempted operation.
+func (rc *RecordCollection) checkNotReadOnly(operation string) {
+	if rc.env.readOnly {
+		log.Panic("Trying to modify data in a read-only environment", "model", rc.ModelName(), "operation", operation)
+	}
+	if rc.model.isView() {
+		log.Panic("Trying to modify data of a SQL view model", "model", rc.ModelName(), "operation", operation)
+	}
+	if rc.readOnly {
+		log.Panic("Trying to modify data of a read-only RecordSet", "model", rc.ModelName(), "operation", operation)
+	}
+}
+
 // WithEnv returns a copy of the current RecordCollection with the given Environment.
 func (rc *RecordCollection) WithEnv(env Environment) *RecordCollection {
 	rSet := rc.clone()
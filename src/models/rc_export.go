@@ -0,0 +1,142 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// exportBatchSize is the number of records loaded at once from the
+// database when exporting a RecordCollection.
+const exportBatchSize = 1000
+
+// csvExportOptions holds the parameters of a CSV export
+type csvExportOptions struct {
+	delimiter rune
+	labels    bool
+}
+
+// A CSVExportOption modifies the output of ExportCSV
+type CSVExportOption func(*csvExportOptions)
+
+// CSVDelimiter sets the field delimiter of the CSV output. Default is ','.
+func CSVDelimiter(delimiter rune) CSVExportOption {
+	return func(o *csvExportOptions) {
+		o.delimiter = delimiter
+	}
+}
+
+// CSVWithLabels makes ExportCSV write the fields' descriptions in the header
+// row instead of their JSON names.
+func CSVWithLabels() CSVExportOption {
+	return func(o *csvExportOptions) {
+		o.labels = true
+	}
+}
+
+// ExportCSV writes the given fields of all the records of this RecordCollection
+// to w in CSV format.
+//
+// The first row is a header with the JSON names of the fields. Then each record
+// is written on its own row. Relation fields are written as the display names of
+// the related records, separated by '|' for multiple records.
+//
+// Records are written ordered by ID. They are loaded from the database by
+// batches with ReadChunked, each in its own cache, so that large
+// RecordCollections can be exported without filling the cache of this
+// RecordCollection's Environment.
+func (rc *RecordCollection) ExportCSV(w io.Writer, fields []FieldName, opts ...CSVExportOption) error {
+	options := csvExportOptions{
+		delimiter: ',',
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = options.delimiter
+	header := make([]string, len(fields))
+	for i, field := range fields {
+		header[i] = field.JSON()
+		if options.labels {
+			header[i] = rc.model.getRelatedFieldInfo(field).description
+		}
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	return rc.ReadChunked(exportBatchSize, fields, func(batch *RecordCollection) error {
+		names := batch.exportDisplayNames(fields)
+		for _, rec := range batch.Records() {
+			row := make([]string, len(fields))
+			for j, field := range fields {
+				row[j] = rec.exportValue(field, names[j])
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// exportDisplayNames returns, for each of the given fields, the display names
+// of the records pointed at by this field from all the records of this
+// RecordCollection, or nil for non relation fields.
+//
+// Display names of each field are computed on a single RecordCollection so
+// that their name fields are read in one query.
+func (rc *RecordCollection) exportDisplayNames(fields []FieldName) []map[int64]string {
+	res := make([]map[int64]string, len(fields))
+	for i, field := range fields {
+		fi := rc.model.getRelatedFieldInfo(field)
+		if !fi.fieldType.IsRelationType() {
+			continue
+		}
+		var relIds []int64
+		for _, val := range rc.GetAll(field) {
+			if relRS, ok := val.(RecordSet); ok {
+				relIds = append(relIds, relRS.Ids()...)
+			}
+		}
+		res[i] = newRecordCollection(rc.Env(), fi.relatedModelName).withIds(relIds).DisplayNames()
+	}
+	return res
+}
+
+// exportValue returns the value of the given field of this singleton
+// as a string suitable for exporting. names are the display names of
+// the related records if field is a relation field.
+func (rc *RecordCollection) exportValue(field FieldName, names map[int64]string) string {
+	fi := rc.model.getRelatedFieldInfo(field)
+	val := rc.Get(field)
+	switch {
+	case val == nil:
+		return ""
+	case fi.fieldType.IsRelationType():
+		relIds := val.(RecordSet).Ids()
+		res := make([]string, len(relIds))
+		for i, id := range relIds {
+			res[i] = names[id]
+		}
+		return strings.Join(res, "|")
+	}
+	// Floats are written in plain decimal notation, with the digits of the
+	// field if any, instead of the exponent form of large values.
+	prec := -1
+	if fi.digits.Scale > 0 {
+		prec = int(fi.digits.Scale)
+	}
+	switch v := val.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', prec, 32)
+	}
+	return fmt.Sprintf("%v", val)
+}
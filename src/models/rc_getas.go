@@ -0,0 +1,99 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"reflect"
+)
+
+// GetAs returns the value of the given field of the given RecordSet as a T.
+//
+// The returned boolean is false, and the value is the zero value of T, if the
+// field value is nil or cannot be given as a T. Numeric values are converted
+// to any numeric T that holds them exactly: conversions that would truncate a
+// float, change the sign or overflow return false. Values of relation fields can be given as a RecordSet,
+// a *RecordCollection, a RecordCollection or as the typed RecordSet of the
+// related model.
+//
+//	name, ok := models.GetAs[string](user, "Name")
+func GetAs[T any](rs RecordSet, field string) (T, bool) {
+	var zero T
+	rc := rs.Collection()
+	val := rc.Get(rc.model.FieldName(field))
+	if val == nil {
+		return zero, false
+	}
+	if res, ok := val.(T); ok {
+		return res, true
+	}
+	if rel, ok := val.(*RecordCollection); ok {
+		if res, ok := interface{}(*rel).(T); ok {
+			return res, true
+		}
+		if _, exists := recordSetWrappers[rel.ModelName()]; exists {
+			if res, ok := rel.Wrap().(T); ok {
+				return res, true
+			}
+		}
+		return zero, false
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	value := reflect.ValueOf(val)
+	if isNumericKind(value.Kind()) && isNumericKind(typ.Kind()) {
+		if res, ok := convertNumeric(value, typ); ok {
+			return res.Interface().(T), true
+		}
+	}
+	return zero, false
+}
+
+// MustGetAs returns the value of the given field of the given RecordSet as a T.
+//
+// It panics if the field value is nil or cannot be given as a T.
+// See GetAs for the supported conversions.
+func MustGetAs[T any](rs RecordSet, field string) T {
+	res, ok := GetAs[T](rs, field)
+	if !ok {
+		rc := rs.Collection()
+		log.Panic("Field value is nil or not of the expected type", "model", rc.ModelName(), "field", field,
+			"expected", reflect.TypeOf((*T)(nil)).Elem(), "value", rc.Get(rc.model.FieldName(field)))
+	}
+	return res
+}
+
+// convertNumeric returns the given numeric value converted to the given
+// numeric type and true if the conversion is lossless, i.e. if the converted
+// value has the same sign and converts back to the given value.
+func convertNumeric(value reflect.Value, typ reflect.Type) (reflect.Value, bool) {
+	res := value.Convert(typ)
+	if isNegative(res) != isNegative(value) {
+		return res, false
+	}
+	if res.Convert(value.Type()).Interface() != value.Interface() {
+		return res, false
+	}
+	return res, true
+}
+
+// isNegative returns true if the given numeric value is strictly negative
+func isNegative(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() < 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() < 0
+	}
+	return false
+}
+
+// isNumericKind returns true if the given kind is an integer or float kind
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/tools/strutils"
+)
+
+// Hash returns the hex encoded SHA-256 hash of the values of the given fields
+// of this record. Two records with the same values for these fields have
+// the same hash, which makes it suitable for change detection or as an ETag.
+//
+// Relation fields are hashed as the sorted ids of the related records.
+// If no fields are given, all stored fields are hashed but the ID, the access
+// fields (CreateDate, WriteUID, etc.), the version field and the external ID,
+// which do not hold business data.
+//
+// It panics if this RecordCollection is not a singleton.
+func (rc *RecordCollection) Hash(fields ...string) string {
+	rc.EnsureOne()
+	var fNames []FieldName
+	if len(fields) == 0 {
+		excluded := map[string]bool{
+			ID.Name():           true,
+			externalIDFieldName: true,
+		}
+		for _, f := range accessFields {
+			excluded[f] = true
+		}
+		if rc.model.versionField != nil {
+			excluded[rc.model.versionField.Name()] = true
+		}
+		for _, f := range rc.model.fields.storedFieldNames() {
+			if excluded[f.Name()] {
+				continue
+			}
+			fNames = append(fNames, f)
+		}
+	} else {
+		fNames = make([]FieldName, len(fields))
+		for i, f := range fields {
+			fNames[i] = rc.model.FieldName(f)
+		}
+	}
+	rc.Load(fNames...)
+	values := make(map[string]interface{}, len(fNames))
+	for _, f := range fNames {
+		val := rc.Get(f)
+		if rs, ok := val.(RecordSet); ok {
+			ids := append([]int64{}, rs.Ids()...)
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			val = ids
+		}
+		values[f.JSON()] = val
+	}
+	data, err := strutils.MarshalCanonical(values)
+	if err != nil {
+		log.Panic("Unable to serialize record values for hashing", "model", rc.model, "id", rc.ids[0], "error", err)
+	}
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
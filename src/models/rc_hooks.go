@@ -0,0 +1,125 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import "sync"
+
+// A WriteHook is a function called after a record has been updated.
+//
+// changes holds the new values of the fields that have been modified,
+// with relation fields given as ids. uid is the ID of the user who made
+// the modification.
+type WriteHook func(model string, id int64, changes FieldMap, uid int64)
+
+// writeHook is the WriteHook called after each update, if any
+var writeHook WriteHook
+
+// SetWriteHook sets the function to call after each update of a record.
+// The hook is called once for each updated record that actually changed.
+// Updates of system models are not notified.
+//
+// Pass nil to remove a previously set hook.
+func SetWriteHook(hook WriteHook) {
+	writeHook = hook
+}
+
+// watchedFieldsValues returns the values of the fields of fMap for each record
+// of this RecordCollection, as they will be passed to the write hook.
+//
+// It returns nil if there is no write hook to call for this RecordCollection.
+func (rc *RecordCollection) watchedFieldsValues(fMap FieldMap) map[int64]FieldMap {
+	if writeHook == nil || rc.model.isSystem() || rc.hasNegIds {
+		return nil
+	}
+	watched := fMap.Copy()
+	watched.RemovePK()
+	rc.stripAccessFields(&watched)
+	fields := watched.FieldNames(rc.model)
+	res := make(map[int64]FieldMap)
+	for _, rec := range rc.Records() {
+		vals := make(FieldMap)
+		for _, field := range fields {
+			val := rec.Get(field)
+			if rs, ok := val.(RecordSet); ok {
+				val = rs.Ids()
+			}
+			vals[field.JSON()] = val
+		}
+		res[rec.ids[0]] = vals
+	}
+	return res
+}
+
+// callWriteHook calls the write hook for each record whose values
+// have changed since oldValues have been read.
+func (rc *RecordCollection) callWriteHook(oldValues map[int64]FieldMap, fMap FieldMap) {
+	if oldValues == nil {
+		return
+	}
+	newValues := rc.watchedFieldsValues(fMap)
+	for _, id := range rc.Ids() {
+		changes := oldValues[id].Diff(newValues[id])
+		if len(changes) == 0 {
+			continue
+		}
+		writeHook(rc.model.name, id, changes, rc.env.uid)
+	}
+}
+
+// A RecordEvent is an event in the lifecycle of records
+type RecordEvent string
+
+// Available record events
+const (
+	EventCreate RecordEvent = "create"
+	EventWrite  RecordEvent = "write"
+	EventUnlink RecordEvent = "unlink"
+)
+
+// An EventHandler is a function called when an event occurs on records.
+// rc holds the records on which the event occurred.
+type EventHandler func(rc *RecordCollection)
+
+// eventHandlers holds the registered EventHandlers by event and model name
+var eventHandlers = struct {
+	sync.RWMutex
+	registry map[RecordEvent]map[string][]EventHandler
+}{
+	registry: make(map[RecordEvent]map[string][]EventHandler),
+}
+
+// On registers the given handler to be called when the given event occurs
+// on records of the model with the given name.
+//
+// Handlers are called after the low level create, write or unlink method
+// has succeeded, inside the same transaction, in the order they have been
+// registered. For unlink events, the records do not exist in the database
+// anymore and only their ids can be used. Upsert fires write events.
+func On(event RecordEvent, modelName string, handler EventHandler) {
+	switch event {
+	case EventCreate, EventWrite, EventUnlink:
+	default:
+		log.Panic("Unknown record event", "event", event, "model", modelName)
+	}
+	eventHandlers.Lock()
+	defer eventHandlers.Unlock()
+	if eventHandlers.registry[event] == nil {
+		eventHandlers.registry[event] = make(map[string][]EventHandler)
+	}
+	eventHandlers.registry[event][modelName] = append(eventHandlers.registry[event][modelName], handler)
+}
+
+// fireEvent calls the handlers registered for the given event
+// on the model of this RecordCollection.
+func (rc *RecordCollection) fireEvent(event RecordEvent) {
+	if rc.IsEmpty() {
+		return
+	}
+	eventHandlers.RLock()
+	handlers := eventHandlers.registry[event][rc.model.name]
+	eventHandlers.RUnlock()
+	for _, handler := range handlers {
+		handler(rc)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An ImportError is the error of a single item of the data given to ImportRecords.
+type ImportError struct {
+	// Index is the index of the faulty item in the imported data
+	Index int
+	Err   error
+}
+
+// Error method for the ImportError type
+func (e ImportError) Error() string {
+	return fmt.Sprintf("item %d: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the faulty item.
+func (e ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportRecords creates or updates records of this RecordCollection's model
+// with the given data and returns the number of created and updated records.
+//
+// For each item of data, the record whose keyFields values match the item is
+// updated with the item values. If no such record exists, a new record is created.
+// New records are inserted together with BatchCreate.
+//
+// Items that cannot be imported, for instance because several records match their
+// key fields or because they do not pass validation, are skipped and returned as
+// ImportErrors with their index. Changes of the other items are kept: it is the
+// caller's responsibility to roll back the transaction if errs is not empty.
+func (rc *RecordCollection) ImportRecords(data []RecordData, keyFields []FieldName) (created, updated int, errs []ImportError) {
+	if len(keyFields) == 0 {
+		log.Panic("ImportRecords needs at least one key field", "model", rc.ModelName())
+	}
+	var (
+		toCreate []int
+		keys     = make(map[string]bool)
+	)
+	createPending := func() {
+		if len(toCreate) == 0 {
+			return
+		}
+		created += rc.importCreate(data, toCreate, &errs)
+		toCreate = nil
+		keys = make(map[string]bool)
+	}
+	for i, item := range data {
+		key, err := importKey(item, keyFields)
+		if err != nil {
+			errs = append(errs, ImportError{Index: i, Err: err})
+			continue
+		}
+		if keys[key] {
+			// This item matches a record that is still to be created
+			createPending()
+		}
+		var isNew bool
+		err = rc.try("Write", func() {
+			isNew = rc.importRecord(item, keyFields)
+		})
+		switch {
+		case err != nil:
+			errs = append(errs, ImportError{Index: i, Err: err})
+		case isNew:
+			toCreate = append(toCreate, i)
+			keys[key] = true
+		default:
+			updated++
+		}
+	}
+	createPending()
+	return
+}
+
+// importKey returns a string identifying the values of the
+// given keyFields in item.
+func importKey(item RecordData, keyFields []FieldName) (string, error) {
+	md := item.Underlying()
+	vals := make([]string, len(keyFields))
+	for i, kf := range keyFields {
+		if !md.Has(kf) {
+			return "", fmt.Errorf("missing key field %s in record data", kf.Name())
+		}
+		vals[i] = fmt.Sprintf("%v", md.Get(kf))
+	}
+	return strings.Join(vals, "\x00"), nil
+}
+
+// importRecord updates the record matching the given item and returns
+// true if there is no such record and it must be created.
+func (rc *RecordCollection) importRecord(item RecordData, keyFields []FieldName) bool {
+	md := item.Underlying()
+	cond := newCondition()
+	for _, kf := range keyFields {
+		cond = cond.And().Field(kf).Equals(md.Get(kf))
+	}
+	// We call Search directly without Call so as not to be polluted by
+	// Search overrides such as "Active test".
+	existing := rc.env.Pool(rc.ModelName()).Search(cond)
+	switch existing.Len() {
+	case 0:
+		return true
+	case 1:
+		existing.Call("Write", md)
+	default:
+		log.Panic("Several records match the key fields", "model", rc.ModelName(), "ids", existing.Ids())
+	}
+	return false
+}
+
+// importCreate creates the items of data at the given indexes with BatchCreate,
+// adds the errors of the items that cannot be created to errs and returns the
+// number of created records.
+//
+// If the batch fails, the items are created one at a time to find the faulty ones.
+func (rc *RecordCollection) importCreate(data []RecordData, indexes []int, errs *[]ImportError) int {
+	items := make([]RecordData, len(indexes))
+	for i, index := range indexes {
+		items[i] = data[index]
+	}
+	err := rc.try("Create", func() {
+		rc.BatchCreate(items)
+	})
+	if err == nil {
+		return len(items)
+	}
+	var created int
+	for i, item := range items {
+		err := rc.try("Create", func() {
+			rc.BatchCreate([]RecordData{item})
+		})
+		if err != nil {
+			*errs = append(*errs, ImportError{Index: indexes[i], Err: err})
+			continue
+		}
+		created++
+	}
+	return created
+}
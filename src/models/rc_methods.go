@@ -165,7 +165,7 @@ func (rc *RecordCollection) CheckExecutionPermission(method *Method, dontPanic .
 	if caller != nil {
 		methodCaller = fmt.Sprintf("%s.%s()", caller.model.name, caller.name)
 	}
-	log.Panic("You are not allowed to execute this method", "model", rc.ModelName(),
+	panicAccessError("You are not allowed to execute this method", "model", rc.ModelName(),
 		"method", fmt.Sprintf("%s.%s()", method.model.name, method.name), "uid", rc.env.uid,
 		"methodCaller", methodCaller)
 	// Unreachable
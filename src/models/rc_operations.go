@@ -39,6 +39,66 @@ func (rc *RecordCollection) Union(other RecordSet) *RecordCollection {
 	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids)
 }
 
+// UnionQuery returns a new read-only RecordCollection with the rows selected
+// by the query of this RecordCollection and the rows selected by the query of
+// the given `other` RecordCollection, combined with "UNION ALL".
+//
+// Contrary to Union, duplicate rows are kept: a record selected by both
+// queries appears twice in the result. None of the RecordCollections is
+// fetched and the combined query is only executed when the result is read.
+// The order, limit and offset of each RecordCollection apply to its own query,
+// so that the result can be ordered and limited again as a whole. Conditions
+// added to the result with Search are added to both queries.
+//
+// Both queries must select compatible columns, i.e. both RecordCollections
+// must be of the same model and have the same context, and none of them can
+// be grouped.
+func (rc *RecordCollection) UnionQuery(other RecordSet) *RecordCollection {
+	otherRC := other.Collection()
+	if rc.ModelName() != otherRC.ModelName() {
+		log.Panic("Unable to combine queries selecting different columns", "this", rc.ModelName(),
+			"other", otherRC.ModelName())
+	}
+	res := rc.Reset()
+	for _, rs := range []*RecordCollection{rc, otherRC} {
+		if len(rs.query.groups) > 0 {
+			log.Panic("Trying to combine a grouped query", "model", rs.model)
+		}
+		if rs.hasNegIds {
+			log.Panic("Trying to combine a memory RecordSet created by New", "model", rs.model, "ids", rs.ids)
+		}
+		rSet := rs.clone()
+		addNameSearchesToCondition(rSet.model, rSet.query.cond)
+		rSet.applyContexts()
+		rSet = rSet.substituteRelatedInQuery()
+		res.query.unions = append(res.query.unions, rSet.query)
+	}
+	if res.query.unions[0].ctxArgsSlug() != res.query.unions[1].ctxArgsSlug() {
+		log.Panic("Unable to combine queries with different contexts", "model", rc.model,
+			"this", res.query.unions[0].ctxArgsSlug(), "other", res.query.unions[1].ctxArgsSlug())
+	}
+	res.readOnly = true
+	return res
+}
+
+// withUnionIds sets the given ids, as returned by the query of a RecordCollection
+// built with UnionQuery, to this RecordCollection and returns it too.
+//
+// Contrary to withIds, duplicate ids are kept and the query is not overridden,
+// so that it is run again to load other fields.
+func (rc *RecordCollection) withUnionIds(ids []int64) *RecordCollection {
+	rc.ids = make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		rc.ids = append(rc.ids, id)
+		rc.env.cache.updateEntry(rc.model, id, "id", id, rc.query.ctxArgsSlug())
+	}
+	rc.fetched = true
+	return rc
+}
+
 // Subtract returns a RecordSet with the Records that are in this
 // RecordCollection but not in the given 'other' one.
 // The result is guaranteed to be a set of unique records.
@@ -0,0 +1,249 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// cursorSecret holds the key used to sign pagination cursors
+var cursorSecret struct {
+	sync.RWMutex
+	key []byte
+}
+
+func init() {
+	cursorSecret.key = make([]byte, 32)
+	if _, err := rand.Read(cursorSecret.key); err != nil {
+		log.Panic("Unable to generate pagination cursors secret", "error", err)
+	}
+}
+
+// SetCursorSecret sets the key used to sign the cursors returned by After.
+//
+// By default, a random key is generated when the application starts, so that
+// cursors are only valid until it is restarted. Set a fixed secret to keep
+// cursors valid across restarts or between several instances of the
+// application.
+func SetCursorSecret(secret []byte) {
+	cursorSecret.Lock()
+	defer cursorSecret.Unlock()
+	cursorSecret.key = secret
+}
+
+// cursorData is the content of a pagination cursor. Values are the JSON
+// encoded values of the ordering fields.
+type cursorData struct {
+	Model  string
+	Orders []string
+	Values []json.RawMessage
+}
+
+// After returns the page of at most limit records of this RecordCollection
+// that come after the given cursor with the current order, and the cursor of
+// the next page. An empty cursor returns the first page, and an empty next
+// cursor is returned when there are no more records.
+//
+// Pages are fetched with keyset pagination on the values of the ordering
+// fields of the last record of the previous page, so that deep pages are as
+// fast as the first ones and records are neither skipped nor repeated if
+// records are created or deleted between pages. The ID is appended to the
+// ordering if it is not part of it so that the order is deterministic.
+// Ordering fields must not be null.
+//
+// Cursors are opaque signed strings that are only valid for the same model
+// and ordering. It panics if the given cursor is invalid.
+func (rc *RecordCollection) After(cursor string, limit int) (*RecordCollection, string) {
+	if limit <= 0 {
+		log.Panic("Page limit must be strictly positive", "model", rc.model.name, "limit", limit)
+	}
+	orders := rc.paginationOrders()
+	base := rc
+	if rc.query.limit > 0 || rc.query.offset > 0 {
+		// Pages must only span the records of this limited set
+		base = rc.Fetch()
+	}
+	exprs := make([]string, len(orders))
+	keys := make([]string, len(orders))
+	for i, order := range orders {
+		exprs[i] = order.field.Name()
+		keys[i] = order.field.JSON()
+		if order.desc {
+			exprs[i] += " desc"
+			keys[i] += " desc"
+		}
+	}
+	page := base.OrderBy(exprs...)
+	if cursor != "" {
+		values := rc.decodeCursor(cursor, orders, keys)
+		page = page.Search(rc.paginationCondition(orders, values))
+	}
+	// We fetch one more record to know if there is a next page
+	page = page.Limit(limit + 1).Fetch()
+	if page.Len() <= limit {
+		return page, ""
+	}
+	page.ids = page.ids[:limit]
+	page.query.limit = limit
+	last := page.Records()[limit-1]
+	values := make([]interface{}, len(orders))
+	for i, order := range orders {
+		val := last.Get(order.field)
+		if rs, ok := val.(RecordSet); ok {
+			val = int64(0)
+			if !rs.IsEmpty() {
+				val = rs.Ids()[0]
+			}
+		}
+		values[i] = val
+	}
+	return page, rc.encodeCursor(orders, keys, values)
+}
+
+// paginationOrders returns the order predicates of this RecordCollection
+// for After, with the ID appended if it is not already part of them.
+func (rc *RecordCollection) paginationOrders() []orderPredicate {
+	orders := rc.query.orders
+	if len(orders) == 0 {
+		orders = rc.model.defaultOrder
+	}
+	res := make([]orderPredicate, len(orders), len(orders)+1)
+	copy(res, orders)
+	for _, order := range res {
+		if order.field.JSON() == ID.JSON() {
+			return res
+		}
+	}
+	return append(res, orderPredicate{field: ID})
+}
+
+// paginationCondition returns the condition selecting the records that come
+// after the given values of the given orders, that is:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR (k1 = v1 AND k2 = v2 AND k3 > v3)...
+// with '<' instead of '>' for descending orders.
+func (rc *RecordCollection) paginationCondition(orders []orderPredicate, values []interface{}) *Condition {
+	cond := newCondition()
+	for i, order := range orders {
+		term := newCondition()
+		for j := 0; j < i; j++ {
+			term = term.AndCond(rc.model.Field(orders[j].field).Equals(values[j]))
+		}
+		if order.desc {
+			term = term.AndCond(rc.model.Field(order.field).Lower(values[i]))
+		} else {
+			term = term.AndCond(rc.model.Field(order.field).Greater(values[i]))
+		}
+		cond = cond.OrCond(term)
+	}
+	return cond
+}
+
+// encodeCursor returns a signed cursor for the given orders, with the given
+// keys and values.
+func (rc *RecordCollection) encodeCursor(orders []orderPredicate, keys []string, values []interface{}) string {
+	data := cursorData{
+		Model:  rc.model.name,
+		Orders: keys,
+		Values: make([]json.RawMessage, len(values)),
+	}
+	for i, val := range values {
+		fi := rc.model.getRelatedFieldInfo(orders[i].field)
+		switch fi.fieldType {
+		case fieldtype.Date:
+			val = val.(dates.Date).Time
+		case fieldtype.DateTime:
+			val = val.(dates.DateTime).Time
+		}
+		raw, err := json.Marshal(val)
+		if err != nil {
+			log.Panic("Unable to encode pagination cursor", "model", rc.model.name, "values", values, "error", err)
+		}
+		data.Values[i] = raw
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Panic("Unable to encode pagination cursor", "model", rc.model.name, "values", values, "error", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(cursorSignature(payload))
+}
+
+// decodeCursor checks the signature of the given cursor and returns its
+// values converted to the type of the fields of the given orders. It panics
+// if the cursor is invalid or has not been created for this model and the
+// given order keys.
+func (rc *RecordCollection) decodeCursor(cursor string, orders []orderPredicate, keys []string) []interface{} {
+	toks := strings.Split(cursor, ".")
+	if len(toks) != 2 {
+		panicValidationError("Invalid pagination cursor", "model", rc.model.name, "cursor", cursor)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(toks[0])
+	if err != nil {
+		panicValidationError("Invalid pagination cursor", "model", rc.model.name, "cursor", cursor, "error", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(toks[1])
+	if err != nil || !hmac.Equal(signature, cursorSignature(payload)) {
+		panicValidationError("Invalid pagination cursor signature", "model", rc.model.name, "cursor", cursor)
+	}
+	var data cursorData
+	if err = json.Unmarshal(payload, &data); err != nil {
+		panicValidationError("Invalid pagination cursor", "model", rc.model.name, "cursor", cursor, "error", err)
+	}
+	if data.Model != rc.model.name || strings.Join(data.Orders, ",") != strings.Join(keys, ",") || len(data.Values) != len(keys) {
+		panicValidationError("Pagination cursor does not match the model or ordering", "model", rc.model.name,
+			"cursorModel", data.Model, "cursorOrders", data.Orders, "orders", keys)
+	}
+	values := make([]interface{}, len(data.Values))
+	for i, raw := range data.Values {
+		val, err := decodeCursorValue(rc.model.getRelatedFieldInfo(orders[i].field), raw)
+		if err != nil {
+			panicValidationError("Invalid pagination cursor", "model", rc.model.name, "cursor", cursor, "error", err)
+		}
+		values[i] = val
+	}
+	return values
+}
+
+// decodeCursorValue returns the given JSON encoded cursor value as a value
+// of the type of the given field. Relation fields values are ids.
+func decodeCursorValue(fi *Field, raw json.RawMessage) (interface{}, error) {
+	switch {
+	case fi.fieldType.IsRelationType():
+		var id int64
+		err := json.Unmarshal(raw, &id)
+		return id, err
+	case fi.fieldType == fieldtype.Date, fi.fieldType == fieldtype.DateTime:
+		var t time.Time
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		if fi.fieldType == fieldtype.Date {
+			return dates.Date{Time: t}, nil
+		}
+		return dates.DateTime{Time: t}, nil
+	}
+	val := reflect.New(fi.structField.Type)
+	err := json.Unmarshal(raw, val.Interface())
+	return val.Elem().Interface(), err
+}
+
+// cursorSignature returns the signature of the given cursor payload
+func cursorSignature(payload []byte) []byte {
+	cursorSecret.RLock()
+	defer cursorSecret.RUnlock()
+	mac := hmac.New(sha256.New, cursorSecret.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// ReadDepth returns the values of the given fields of the records of this
+// RecordCollection as FieldMaps keyed by the fields' JSON names.
+//
+// Relation fields are expanded up to depth levels: the related records are
+// embedded as a FieldMap for many2one, one2one and rev2one fields and as a
+// slice of FieldMaps for one2many and many2many fields. Related records are read with
+// all their fields but non stored computed fields. Relation fields that are
+// not expanded, either because depth is reached or because the related record
+// is already being expanded higher in the graph, hold the id or the slice of
+// ids of the related records instead.
+//
+// If no fields are given, all fields but non stored computed fields are read.
+// Fields the user is not allowed to read are omitted.
+func (rc *RecordCollection) ReadDepth(depth int, fields ...string) []FieldMap {
+	fNames := make(FieldNames, len(fields))
+	for i, f := range fields {
+		fNames[i] = rc.model.FieldName(f)
+	}
+	return rc.readDepth(depth, fNames, make(map[cacheRecordRef]bool))
+}
+
+// readDepth reads the given fields of this RecordCollection, expanding relation
+// fields up to depth levels. expanding holds the records that are being
+// expanded in the current branch of the graph and that must not be expanded
+// again to avoid infinite recursion.
+func (rc *RecordCollection) readDepth(depth int, fields FieldNames, expanding map[cacheRecordRef]bool) []FieldMap {
+	if len(fields) == 0 {
+		fields = rc.model.readDepthFieldNames()
+	}
+	fields = rc.readableFields(addIDIfNotPresent(fields))
+	res := make([]FieldMap, 0, rc.Len())
+	for _, rec := range rc.Records() {
+		ref := cacheRecordRef{model: rc.model.name, id: rec.ids[0]}
+		expanding[ref] = true
+		fMap := make(FieldMap)
+		for _, f := range fields {
+			key := f.JSON()
+			if af, ok := f.(aliasedFieldName); ok {
+				key = af.alias
+			}
+			fMap[key] = rec.readDepthValue(f, depth, expanding)
+		}
+		delete(expanding, ref)
+		res = append(res, fMap)
+	}
+	return res
+}
+
+// readDepthValue returns the value of the given field of this singleton
+// for ReadDepth.
+func (rc *RecordCollection) readDepthValue(field FieldName, depth int, expanding map[cacheRecordRef]bool) interface{} {
+	val := rc.Get(field)
+	fi := rc.model.getRelatedFieldInfo(field)
+	if !fi.fieldType.IsRelationType() {
+		return val
+	}
+	relRC := val.(RecordSet).Collection()
+	var expand bool
+	if depth > 0 {
+		expand = true
+		for _, id := range relRC.Ids() {
+			if expanding[cacheRecordRef{model: relRC.model.name, id: id}] {
+				expand = false
+				break
+			}
+		}
+	}
+	switch {
+	case fi.fieldType.Is2OneRelationType() && relRC.IsEmpty():
+		return nil
+	case fi.fieldType.Is2OneRelationType() && expand:
+		return relRC.readDepth(depth-1, nil, expanding)[0]
+	case fi.fieldType.Is2OneRelationType():
+		return relRC.ids[0]
+	case expand:
+		return relRC.readDepth(depth-1, nil, expanding)
+	}
+	return relRC.Ids()
+}
+
+// readDepthFieldNames returns the names of the fields of this model that
+// are read by ReadDepth when no fields are given, that is all fields but
+// non stored computed fields.
+func (m *Model) readDepthFieldNames() FieldNames {
+	var res FieldNames
+	for _, fName := range m.fields.allFieldNames() {
+		fi := m.fields.MustGet(fName.Name())
+		if fi.isComputedField() && !fi.stored {
+			continue
+		}
+		res = append(res, fName)
+	}
+	return res
+}
@@ -161,7 +161,7 @@ func (rc *RecordCollection) createRelatedRecord(path FieldName, vals RecordData)
 		if len(exprs) > 1 {
 			target = rc.Get(joinFieldNames(exprs[:len(exprs)-1], ExprSep)).(RecordSet).Collection()
 			if target.IsEmpty() {
-				log.Panic("Target record does not exist", "recordset", rc, "path", joinFieldNames(exprs[:len(exprs)-1], ExprSep))
+				panicMissingError("Target record does not exist", "recordset", rc, "path", joinFieldNames(exprs[:len(exprs)-1], ExprSep))
 			}
 			target = target.Records()[0]
 		}
@@ -14,12 +14,20 @@
 
 package models
 
-import "github.com/hexya-erp/hexya/src/models/security"
+import (
+	"sort"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+)
 
 // addRecordRuleConditions adds the RecordRule conditions on the query of this
 // RecordSet for the user with the given uid and for the given perm Permission.
+//
+// Global rules must all be matched. Rules of the same group are combined with
+// OR, and the conditions of the different groups of the user with AND.
+// Record rules do not apply to the superuser.
 func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Permission) *RecordCollection {
-	if rc.filtered {
+	if rc.filtered || uid == security.SuperUserID {
 		return rc
 	}
 	rSet := rc
@@ -29,20 +37,87 @@ func (rc *RecordCollection) addRecordRuleConditions(uid int64, perm security.Per
 			rSet = rSet.Search(rule.Condition)
 		}
 	}
-	// Add groups rules
+	// Restrict multi-company models to the allowed companies
+	if cond := rSet.companyCondition(); cond != nil {
+		rSet = rSet.Search(cond)
+	}
+	// Add groups rules: rules of the same group are ORed together and the
+	// conditions of the different groups are ANDed.
 	userGroups := security.Registry.UserGroups(uid)
-	groupCondition := newCondition()
+	groupNames := make([]string, 0, len(userGroups))
 	for group := range userGroups {
-		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[group.Name] {
+		groupNames = append(groupNames, group.Name)
+	}
+	sort.Strings(groupNames)
+	for _, groupName := range groupNames {
+		groupCondition := newCondition()
+		for _, rule := range rSet.model.rulesRegistry.rulesByGroup[groupName] {
 			if perm&rule.Perms > 0 {
 				groupCondition = groupCondition.OrCond(rule.Condition)
 			}
 		}
-	}
-	if !groupCondition.IsEmpty() {
-		rSet = rSet.Search(groupCondition)
+		if !groupCondition.IsEmpty() {
+			rSet = rSet.Search(groupCondition)
+		}
 	}
 	rSet.filtered = true
 	*rc = *rSet
 	return rc
 }
+
+// readableFields returns the given fields without those that the user of
+// this RecordCollection's environment is not allowed to read.
+func (rc *RecordCollection) readableFields(fields FieldNames) FieldNames {
+	var res FieldNames
+	for _, f := range fields {
+		if !rc.model.getRelatedFieldInfo(f).isReadableBy(rc.env.uid) {
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// checkReadOnlyAfterCreate panics if fMap changes the value of a field that
+// is read only after creation in one of the records of this RecordCollection.
+// fMap values must have been converted to the field types.
+//
+// This check is skipped for the superuser, so that these fields can be
+// written with Sudo, e.g. in migrations.
+func (rc *RecordCollection) checkReadOnlyAfterCreate(fMap FieldMap) {
+	if rc.env.uid == security.SuperUserID {
+		return
+	}
+	for key, val := range fMap {
+		field := rc.model.FieldName(key)
+		fi := rc.model.getRelatedFieldInfo(field)
+		if !fi.readOnlyAfterCreate {
+			continue
+		}
+		for _, rec := range rc.Records() {
+			if rec.ids[0] < 0 {
+				// Memory records have not been created yet
+				continue
+			}
+			if !valuesEqual(rec.Get(field), val) {
+				panicValidationError("This field cannot be modified once the record is created", "model", rc.ModelName(), "field", fi.name, "id", rec.ids[0])
+			}
+		}
+	}
+}
+
+// checkFieldsWritePermission panics if the user of this RecordCollection's
+// environment is not allowed to modify one of the fields of fMap.
+//
+// Computed values writes are not checked.
+func (rc *RecordCollection) checkFieldsWritePermission(fMap FieldMap) {
+	if rc.env.context.GetBool("hexya_force_compute_write") {
+		return
+	}
+	for key := range fMap {
+		fi := rc.model.getRelatedFieldInfo(rc.model.FieldName(key))
+		if !fi.isWritableBy(rc.env.uid) {
+			panicAccessError("You are not allowed to modify this field", "model", rc.ModelName(), "field", fi.name, "uid", rc.env.uid)
+		}
+	}
+}
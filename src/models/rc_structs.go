@@ -0,0 +1,166 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// A structFieldMapping maps a field of a struct to a field of a model
+type structFieldMapping struct {
+	index int
+	field FieldName
+}
+
+// ReadFirst reads the first record of this RecordCollection into the struct
+// pointed at by dest.
+//
+// Only the fields of the model that match the exported fields of the struct
+// are read from the database. A struct field matches the model field with
+// the same name, or with the name given in its `hexya` tag, such as
+// `hexya:"Name"`. Fields tagged `hexya:"-"` are ignored.
+//
+// Relation fields can be read into int64 (resp. []int64) struct fields to get
+// the id (resp. ids) of the related records, or into a RecordSet type.
+//
+// It returns an error if dest is not a pointer to a struct, if a struct field
+// does not match a model field or if a value cannot be assigned to its struct
+// field. dest is left untouched if this RecordCollection is empty.
+func (rc *RecordCollection) ReadFirst(dest interface{}) error {
+	val := reflect.ValueOf(dest)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ReadFirst expects a pointer to a struct, got %T", dest)
+	}
+	mappings, err := rc.structFieldMappings(val.Elem().Type())
+	if err != nil {
+		return err
+	}
+	// We read the first record alone, so that the fields are not loaded
+	// for the other records of this RecordCollection.
+	ids := rc.Limit(1).Ids()
+	if len(ids) == 0 {
+		return nil
+	}
+	return newRecordCollection(rc.Env(), rc.ModelName()).withIds(ids[:1]).scanToStruct(val.Elem(), mappings)
+}
+
+// ReadAll reads all the records of this RecordCollection into the slice of
+// structs pointed at by dest. Struct fields are mapped to model fields as
+// for ReadFirst, and only these fields are read from the database.
+func (rc *RecordCollection) ReadAll(dest interface{}) error {
+	val := reflect.ValueOf(dest)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice || val.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ReadAll expects a pointer to a slice of structs, got %T", dest)
+	}
+	structType := val.Elem().Type().Elem()
+	mappings, err := rc.structFieldMappings(structType)
+	if err != nil {
+		return err
+	}
+	fields := make([]FieldName, len(mappings))
+	for i, m := range mappings {
+		fields[i] = m.field
+	}
+	rc.Fetch()
+	rc.Load(fields...)
+	res := reflect.MakeSlice(val.Elem().Type(), rc.Len(), rc.Len())
+	for i, rec := range rc.Records() {
+		if err := rec.scanToStruct(res.Index(i), mappings); err != nil {
+			return err
+		}
+	}
+	val.Elem().Set(res)
+	return nil
+}
+
+// structFieldMappings returns the mappings between the exported fields of the
+// given struct type and the fields of the model of this RecordCollection.
+func (rc *RecordCollection) structFieldMappings(typ reflect.Type) ([]structFieldMapping, error) {
+	var res []structFieldMapping
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("hexya"); ok {
+			name = tag
+		}
+		if name == "-" {
+			continue
+		}
+		fi, ok := rc.model.fields.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("field %s of struct %s does not match any field of model %s", sf.Name, typ, rc.model.name)
+		}
+		res = append(res, structFieldMapping{index: i, field: rc.model.FieldName(fi.name)})
+	}
+	if len(res) == 0 {
+		return nil, errors.New("struct has no field matching the model " + rc.model.name)
+	}
+	return res, nil
+}
+
+// scanToStruct sets the fields of the given struct value with the values of
+// the mapped fields of the first record of this RecordCollection.
+func (rc *RecordCollection) scanToStruct(dest reflect.Value, mappings []structFieldMapping) error {
+	fields := make([]FieldName, len(mappings))
+	for i, m := range mappings {
+		fields[i] = m.field
+	}
+	data := rc.First(fields...)
+	for _, m := range mappings {
+		target := dest.Field(m.index)
+		value, err := structFieldValue(data.Get(m.field), target.Type())
+		if err != nil {
+			return fmt.Errorf("unable to read field %s of model %s into struct field %s: %s",
+				m.field.Name(), rc.model.name, dest.Type().Field(m.index).Name, err)
+		}
+		target.Set(value)
+	}
+	return nil
+}
+
+// structFieldValue returns the given field value as a reflect.Value of the
+// given type, converting it if necessary. Numeric values are only converted
+// if the conversion is lossless, as with GetAs.
+func structFieldValue(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	if rs, ok := value.(RecordSet); ok {
+		switch {
+		case typ == reflect.TypeOf(int64(0)):
+			var id int64
+			if !rs.IsEmpty() {
+				id = rs.Ids()[0]
+			}
+			return reflect.ValueOf(id), nil
+		case typ == reflect.TypeOf([]int64{}):
+			return reflect.ValueOf(rs.Ids()), nil
+		case typ.Kind() == reflect.Struct && typ.NumField() > 0 && typ.Field(0).Type == reflect.TypeOf(new(RecordCollection)):
+			// RecordSet wrapper type
+			res := reflect.New(typ).Elem()
+			res.Field(0).Set(reflect.ValueOf(rs.Collection()))
+			return res, nil
+		}
+		value = rs.Collection()
+	}
+	if value == nil {
+		return reflect.Zero(typ), nil
+	}
+	val := reflect.ValueOf(value)
+	switch {
+	case val.Type().AssignableTo(typ):
+		return val, nil
+	case isNumericKind(val.Kind()) && isNumericKind(typ.Kind()):
+		if res, ok := convertNumeric(val, typ); ok {
+			return res, nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot convert value %v to %s without loss", value, typ)
+	case val.Type().ConvertibleTo(typ) && val.Kind() != reflect.String && typ.Kind() != reflect.String:
+		return val.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot assign value of type %s to %s", val.Type(), typ)
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// ChangedSince returns the records of this RecordCollection that have been
+// created or modified after t, ordered by WriteDate then ID.
+//
+// This is meant for incremental synchronization with external systems:
+// clients can keep the WriteDate of the last record they processed as a
+// checkpoint, or page through the result with After to resume an interrupted
+// synchronization. It panics if the model has no WriteDate field.
+func (rc *RecordCollection) ChangedSince(t time.Time) *RecordCollection {
+	if _, ok := rc.model.fields.Get("WriteDate"); !ok {
+		log.Panic("ChangedSince requires a WriteDate field", "model", rc.model.name)
+	}
+	writeDate := rc.model.FieldName("WriteDate")
+	return rc.Search(rc.model.Field(writeDate).Greater(dates.DateTime{Time: t})).OrderBy("WriteDate", "ID")
+}
+
+// addTombstonesToDeleteQuery returns the given DELETE query modified so that
+// it also inserts the ids of the deleted rows in the tombstone table of this
+// model. The query is returned unchanged if tombstones are not enabled.
+func (rc *RecordCollection) addTombstonesToDeleteQuery(query string, args SQLParams) (string, SQLParams) {
+	tm := rc.model.tombstoneModel
+	if tm == nil {
+		return query, args
+	}
+	adapter := adapters[db.DriverName()]
+	query = fmt.Sprintf(`WITH deleted AS (%s RETURNING %s) INSERT INTO %s (%s, %s) SELECT %s, ? FROM deleted`,
+		query, adapter.QuoteIdentifier("id"), adapter.QuoteTableName(tm.tableName),
+		adapter.QuoteIdentifier("res_id"), adapter.QuoteIdentifier("delete_date"), adapter.QuoteIdentifier("id"))
+	return query, args.Extend(SQLParams{rc.env.cr.Now()})
+}
+
+// DeletedSince returns the ids of the records of the given model that have
+// been deleted after t, in the order of their deletion.
+//
+// Only deletions made through Unlink or DeleteAll are logged, and only
+// if tombstones have been enabled for this model with EnableTombstones.
+// Records deleted by a database cascade are not logged. It panics if
+// tombstones are not enabled for this model.
+func DeletedSince(modelName string, t time.Time) []int64 {
+	mi := Registry.MustGet(modelName)
+	if mi.tombstoneModel == nil {
+		log.Panic("Tombstones are not enabled for this model", "model", modelName)
+	}
+	adapter := adapters[db.DriverName()]
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s > ? ORDER BY %s, %s`,
+		adapter.QuoteIdentifier("res_id"), adapter.QuoteTableName(mi.tombstoneModel.tableName),
+		adapter.QuoteIdentifier("delete_date"), adapter.QuoteIdentifier("delete_date"), adapter.QuoteIdentifier("id"))
+	var ids []int64
+	dbSelectNoTx(&ids, query, dates.DateTime{Time: t})
+	return ids
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TextSearch returns a new RecordSet with the records of this RecordSet that
+// match the given text in at least one of the full text fields of the model.
+// The returned records are sorted by decreasing relevance.
+//
+// Full text fields are declared with the FullText parameter, which holds the
+// text search configuration to use, such as "english" or "simple".
+//
+// This method panics if the model has no full text field.
+func (rc *RecordCollection) TextSearch(text string) *RecordCollection {
+	fields := rc.model.fullTextFields()
+	if len(fields) == 0 {
+		log.Panic("Model has no full text field", "model", rc.model.name)
+	}
+	adapter := adapters[db.DriverName()]
+	matchSQL := make([]string, len(fields))
+	rankSQL := make([]string, len(fields))
+	args := make([]interface{}, len(fields))
+	for i, fi := range fields {
+		vector := adapter.TextSearchVectorSQL(fmt.Sprintf("%s.%s", rc.query.thisTable(), adapter.QuoteIdentifier(fi.json)), fi.fullText)
+		tsQuery := adapter.TextSearchQuerySQL(fi.fullText)
+		matchSQL[i] = fmt.Sprintf("%s @@ %s", vector, tsQuery)
+		rankSQL[i] = fmt.Sprintf("ts_rank(%s, %s)", vector, tsQuery)
+		args[i] = text
+	}
+	rSet := rc.SearchRaw(strings.Join(matchSQL, " OR "), args...).Fetch()
+	if rSet.IsEmpty() {
+		return rSet
+	}
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE id IN (?) ORDER BY %s DESC, id`,
+		rc.query.thisTable(), strings.Join(rankSQL, " + "))
+	var ids []int64
+	rc.env.cr.Select(&ids, query, append([]interface{}{rSet.ids}, args...)...)
+	return rSet.withIds(ids)
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// tryCallSavepoint is the name of the savepoint that is set before
+// executing a method with TryCall.
+const tryCallSavepoint = "hexya_try_call"
+
+// A CallError is the error returned by TryCall and the other Try methods
+// of RecordCollection when the called method panicked.
+type CallError struct {
+	Model   string
+	Method  string
+	Message string
+	// Cause is the value the method panicked with if it is an error
+	Cause error
+}
+
+// Error method for the CallError type
+func (e CallError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", e.Model, e.Method, e.Message)
+}
+
+// Unwrap returns the error the method panicked with, if any.
+func (e CallError) Unwrap() error {
+	return e.Cause
+}
+
+// TryCall calls the given method like Call but returns a CallError
+// instead of panicking if the method fails.
+//
+// The method is executed inside a savepoint of the transaction. If it fails,
+// its changes are rolled back and the cache of the Environment is cleared,
+// so that the Environment can still be used afterwards. The message of a
+// UserError is translated in the language of the Environment.
+func (rc *RecordCollection) TryCall(methName string, args ...interface{}) (interface{}, error) {
+	var res interface{}
+	err := rc.try(methName, func() {
+		res = rc.Call(methName, args...)
+	})
+	return res, err
+}
+
+// try executes fnct inside a savepoint and returns a CallError for the
+// given method name if it panics.
+//
+// Serialization and deadlock errors are not caught, since the whole
+// transaction must then be retried.
+func (rc *RecordCollection) try(methName string, fnct func()) (err error) {
+	rc.env.cr.Execute(fmt.Sprintf("SAVEPOINT %s", tryCallSavepoint))
+	recomputeSnapshot := rc.env.recompute.snapshot()
+	defer func() {
+		r := recover()
+		if r == nil {
+			rc.env.cr.Execute(fmt.Sprintf("RELEASE SAVEPOINT %s", tryCallSavepoint))
+			return
+		}
+		if e, ok := r.(error); ok && adapters[db.DriverName()].IsSerializationError(e) {
+			panic(r)
+		}
+		rc.env.cr.Execute(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", tryCallSavepoint))
+		rc.env.cache.clear()
+		// Recomputations postponed by fnct are for rolled back changes
+		rc.env.recompute.restore(recomputeSnapshot)
+		callErr := CallError{
+			Model:   rc.model.name,
+			Method:  methName,
+			Message: strings.TrimSpace(fmt.Sprint(r)),
+		}
+		if e, ok := r.(error); ok {
+			callErr.Cause = rc.env.TranslateError(e)
+			if userErr, isUserErr := exceptions.AsUserError(callErr.Cause); isUserErr {
+				callErr.Message = userErr.Message
+			}
+		}
+		err = callErr
+	}()
+	fnct()
+	return nil
+}
+
+// TryCreate creates a record like the Create method and returns it, or
+// returns a CallError if the creation failed.
+func (rc *RecordCollection) TryCreate(data RecordData) (*RecordCollection, error) {
+	res, err := rc.TryCall("Create", data)
+	if err != nil {
+		return nil, err
+	}
+	return res.(RecordSet).Collection(), nil
+}
+
+// TryWrite updates the records of this RecordCollection like the Write
+// method, or returns a CallError if the update failed.
+func (rc *RecordCollection) TryWrite(data RecordData) error {
+	_, err := rc.TryCall("Write", data)
+	return err
+}
+
+// TryRead reads the given fields of the records of this RecordCollection
+// like the Read method, or returns a CallError if the read failed.
+func (rc *RecordCollection) TryRead(fields FieldNames) ([]RecordData, error) {
+	res, err := rc.TryCall("Read", fields)
+	if err != nil {
+		return nil, err
+	}
+	return res.([]RecordData), nil
+}
+
+// TrySearch returns the records matching the given condition like the
+// Search method, or returns a CallError if the search failed.
+//
+// Contrary to Search, the returned RecordCollection is already fetched
+// from the database, so that an invalid condition returns an error.
+func (rc *RecordCollection) TrySearch(cond Conditioner) (*RecordCollection, error) {
+	var res *RecordCollection
+	err := rc.try("Search", func() {
+		res = rc.Call("Search", cond).(RecordSet).Collection().Fetch()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
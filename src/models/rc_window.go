@@ -0,0 +1,164 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// topNRankAlias is the alias of the window expression used by TopNPerGroup
+const topNRankAlias = "hexya_rank"
+
+// sqlAliasRegexp matches valid aliases of window expressions and CTEs
+var sqlAliasRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// A windowExpr is a window function expression added to a query with WithWindow
+type windowExpr struct {
+	expr  string
+	alias string
+}
+
+// WithWindow returns a new RecordSet with the given window function expression
+// added to its query under the given alias, e.g.
+//
+//	rc.WithWindow("ROW_NUMBER() OVER (PARTITION BY User ORDER BY CreateDate DESC)", "rn")
+//
+// The window is computed over the records of this RecordSet. Its value for each
+// record is read with WindowValues and its alias can be used to filter the
+// records with WhereWindow. As with Aggregate, the Go names
+// of the stored fields of the model are translated into their columns and it
+// is the caller's responsibility to give a valid SQL expression.
+//
+// alias must be a lower case SQL identifier.
+func (rc *RecordCollection) WithWindow(expr, alias string) *RecordCollection {
+	if !sqlAliasRegexp.MatchString(alias) {
+		log.Panic("Invalid window expression alias", "model", rc.model, "alias", alias)
+	}
+	for _, w := range rc.query.windows {
+		if w.alias == alias {
+			log.Panic("Window expression alias already used", "model", rc.model, "alias", alias)
+		}
+	}
+	rSet := rc.clone()
+	rSet.query.windows = append(append([]windowExpr{}, rc.query.windows...), windowExpr{
+		expr:  rc.translateSQLExpr(expr),
+		alias: alias,
+	})
+	return rSet
+}
+
+// WindowValues returns the value of the window expression with the given alias
+// for each record of this RecordSet, keyed by record ID. Numeric values are
+// returned as int64 or float64.
+//
+// It panics if no window expression has been added with this alias.
+func (rc *RecordCollection) WindowValues(alias string) map[int64]interface{} {
+	var win *windowExpr
+	for i, w := range rc.query.windows {
+		if w.alias == alias {
+			win = &rc.query.windows[i]
+			break
+		}
+	}
+	if win == nil {
+		log.Panic("Unknown window expression alias", "model", rc.model, "alias", alias)
+	}
+	if rc.hasNegIds {
+		log.Panic("Trying to compute a window expression on a memory RecordSet created by New", "model", rc.model, "ids", rc.ids)
+	}
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	adapter := adapters[db.DriverName()]
+	rSet := rc.clone()
+	rSet.query.windows = nil
+	idsQuery, args := rSet.idsSubQuery()
+	query := fmt.Sprintf(`SELECT %s.%s, %s FROM %s WHERE %s.%s IN (%s)`,
+		rc.query.thisTable(), adapter.QuoteIdentifier("id"), win.expr, rc.query.thisTable(),
+		rc.query.thisTable(), adapter.QuoteIdentifier("id"), idsQuery)
+	rows := dbQuery(rc.env.cr.tx, query, args...)
+	defer rows.Close()
+	res := make(map[int64]interface{})
+	for rows.Next() {
+		var (
+			id  int64
+			val interface{}
+		)
+		if err := rows.Scan(&id, &val); err != nil {
+			log.Panic(err.Error(), "model", rc.ModelName(), "alias", alias)
+		}
+		if b, ok := val.([]byte); ok {
+			// Numeric values are returned as text by the driver
+			if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+				val = f
+			} else {
+				val = string(b)
+			}
+		}
+		res[id] = val
+	}
+	if err := rows.Err(); err != nil {
+		log.Panic(err.Error(), "model", rc.ModelName(), "alias", alias)
+	}
+	return res
+}
+
+// WhereWindow returns a new RecordSet with the records of this RecordSet for
+// which the given SQL condition on the aliases of its window expressions is
+// true, e.g.
+//
+//	rc.WithWindow("ROW_NUMBER() OVER (PARTITION BY User ORDER BY CreateDate DESC)", "rn").
+//		WhereWindow("rn <= ?", 3)
+//
+// args are bound to the '?' placeholders of cond. The returned RecordSet does
+// not hold any window expression anymore. It panics if WithWindow has not been
+// called on this RecordSet.
+func (rc *RecordCollection) WhereWindow(cond string, args ...interface{}) *RecordCollection {
+	if len(rc.query.windows) == 0 {
+		log.Panic("WhereWindow called on a RecordSet without window expression", "model", rc.model)
+	}
+	adapter := adapters[db.DriverName()]
+	rSet := rc.clone()
+	rSet.query.windows = nil
+	cols := []string{fmt.Sprintf("%s.%s", rc.query.thisTable(), adapter.QuoteIdentifier("id"))}
+	for _, w := range rc.query.windows {
+		cols = append(cols, fmt.Sprintf("%s AS %s", w.expr, w.alias))
+	}
+	idsQuery, idsArgs := rSet.idsSubQuery()
+	query := fmt.Sprintf(`%s.%s IN (SELECT %s FROM (SELECT %s FROM %s WHERE %s.%s IN (%s)) win WHERE %s)`,
+		rc.query.thisTable(), adapter.QuoteIdentifier("id"), adapter.QuoteIdentifier("id"),
+		strings.Join(cols, ", "), rc.query.thisTable(), rc.query.thisTable(), adapter.QuoteIdentifier("id"),
+		idsQuery, cond)
+	return rSet.SearchRaw(query, idsArgs.Extend(args)...)
+}
+
+// TopNPerGroup returns a new RecordSet with, for each value of groupField, the
+// first n records of this RecordSet when ordered by orderField.
+//
+// orderField is the name of a stored field of the model, optionally followed by
+// "asc" or "desc" (e.g. "CreateDate desc"). Ties are broken by ID.
+func (rc *RecordCollection) TopNPerGroup(groupField, orderField string, n int) *RecordCollection {
+	if n <= 0 {
+		log.Panic("TopNPerGroup requires a positive number of records", "model", rc.model, "n", n)
+	}
+	orderTokens := strings.Fields(orderField)
+	if len(orderTokens) == 0 || len(orderTokens) > 2 {
+		log.Panic("Invalid order field", "model", rc.model, "orderField", orderField)
+	}
+	groupFI := rc.model.fields.MustGet(groupField)
+	orderFI := rc.model.fields.MustGet(orderTokens[0])
+	if !groupFI.isStored() || !orderFI.isStored() {
+		log.Panic("TopNPerGroup fields must be stored", "model", rc.model, "groupField", groupField, "orderField", orderField)
+	}
+	direction := "ASC"
+	if len(orderTokens) == 2 {
+		direction = strings.ToUpper(orderTokens[1])
+		if direction != "ASC" && direction != "DESC" {
+			log.Panic("Invalid order direction", "model", rc.model, "orderField", orderField)
+		}
+	}
+	expr := fmt.Sprintf("ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s %s, ID)", groupFI.name, orderFI.name, direction)
+	return rc.WithWindow(expr, topNRankAlias).WhereWindow(fmt.Sprintf("%s <= ?", topNRankAlias), n)
+}
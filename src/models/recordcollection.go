@@ -23,22 +23,25 @@ import (
 
 	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
-	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/jmoiron/sqlx"
 )
 
 // RecordCollection is a generic struct representing several
 // records of a model.
 type RecordCollection struct {
-	model      *Model
-	query      *Query
-	env        *Environment
-	prefetchRC *RecordCollection
-	ids        []int64
-	fetched    bool
-	filtered   bool
-	hasNegIds  bool
+	model           *Model
+	query           *Query
+	env             *Environment
+	prefetchRC      *RecordCollection
+	relatedPrefetch map[string]*RecordCollection
+	ids             []int64
+	fetched         bool
+	filtered        bool
+	hasNegIds       bool
+	readOnly        bool
 }
 
 // Scan implements sql.Scanner
@@ -118,6 +121,55 @@ func (rc *RecordCollection) Ids() []int64 {
 	return rc.ids
 }
 
+// WithKeys returns a new RecordCollection with the records of this model
+// whose composite key is one of the given keys. Each key must give the values
+// of the key fields in the order they were declared with SetCompositeKey.
+//
+// It panics if the model has no composite key.
+func (rc *RecordCollection) WithKeys(keys ...[]interface{}) *RecordCollection {
+	keyFields := rc.model.keyFields
+	if len(keyFields) == 0 {
+		log.Panic("Model has no composite key", "model", rc.model.name)
+	}
+	cond := rc.model.Field(ID).Equals(-1)
+	for _, key := range keys {
+		if len(key) != len(keyFields) {
+			log.Panic("Wrong number of values for composite key", "model", rc.model.name, "key", key, "keyFields", keyFields)
+		}
+		keyCond := newCondition()
+		for i, field := range keyFields {
+			keyCond = keyCond.And().Field(field).Equals(key[i])
+		}
+		cond = cond.OrCond(keyCond)
+	}
+	return rc.Search(cond)
+}
+
+// Keys returns the composite keys of the records of this RecordCollection,
+// that is the values of the key fields of each record.
+//
+// It panics if the model has no composite key.
+func (rc *RecordCollection) Keys() [][]interface{} {
+	keyFields := rc.model.keyFields
+	if len(keyFields) == 0 {
+		log.Panic("Model has no composite key", "model", rc.model.name)
+	}
+	rc.Load(keyFields...)
+	res := make([][]interface{}, rc.Len())
+	for i, rec := range rc.Records() {
+		key := make([]interface{}, len(keyFields))
+		for j, field := range keyFields {
+			val := rec.Get(field)
+			if rs, ok := val.(RecordSet); ok {
+				val = rs.Collection().Get(ID)
+			}
+			key[j] = val
+		}
+		res[i] = key
+	}
+	return res
+}
+
 // clone returns a pointer to a new RecordCollection identical to this one.
 func (rc *RecordCollection) clone() *RecordCollection {
 	rSet := *rc
@@ -138,11 +190,30 @@ func (rc *RecordCollection) new(data RecordData) *RecordCollection {
 	fMap := newData.Underlying().FieldMap
 	fMap["id"] = id
 	rc.model.convertValuesToFieldType(&fMap, false)
+	rc.model.roundValues(fMap)
 	rSet := rc.withIds([]int64{id})
 	rc.env.cache.addRecord(rc.model, id, fMap, rSet.query.ctxArgsSlug())
 	return rSet
 }
 
+// memoryData returns the values of the settable fields of this memory
+// record that have been set with New or Set as a new ModelData.
+func (rc *RecordCollection) memoryData() *ModelData {
+	rc.EnsureOne()
+	res := NewModelData(rc.model)
+	for _, fi := range rc.model.fields.registryByJSON {
+		if fi.json == "id" || !fi.isSettable() || fi.isRelatedField() {
+			continue
+		}
+		if !rc.env.cache.isInCache(rc.model, rc.ids[0], fi.json, rc.query.ctxArgsSlug(), false) {
+			continue
+		}
+		fName := rc.model.FieldName(fi.name)
+		res.Set(fName, rc.Get(fName))
+	}
+	return res
+}
+
 // create inserts a new record in the database with the given data.
 // data can be either a FieldMap or a struct pointer of the same model as rs.
 // This function is private and low level. It should not be called directly.
@@ -153,7 +224,140 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 			panic(rc.substituteSQLErrorMessage(r))
 		}
 	}()
+	rc.checkNotReadOnly("Create")
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	if rc.hasNegIds {
+		// We persist a memory record, given data overrides its values
+		memData := rc.memoryData()
+		memData.MergeWith(data.Underlying())
+		data = memData
+		rc = rc.env.Pool(rc.model.name)
+	}
+	rc.model.checkFieldMapKeys(data.Underlying().FieldMap)
+	rc.checkFieldsWritePermission(data.Underlying().FieldMap)
+	data, fMap, storedFieldMap := rc.prepareCreateData(data)
+	rc.checkCompanyAccess(fMap)
+	// insert in DB
+	query, args := rc.query.insertQuery(storedFieldMap)
+	createdIds := rc.execReturningQuery(query, args, storedFieldMap)
+	rSet := rc.withIds(createdIds)
+	rSet.finishCreate(data, fMap)
+	rSet.fireEvent(EventCreate)
+	return rSet
+}
+
+// BatchCreate inserts new records in the database with the given data and
+// returns them in the same order.
+//
+// Rows are inserted with a single query for each set of inserted columns.
+// Relations, related and computed fields and constraints of the new records
+// are then processed as with Create. This function does not call the Create
+// method of the model, so that its overrides are not executed.
+func (rc *RecordCollection) BatchCreate(data []RecordData) *RecordCollection {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(rc.substituteSQLErrorMessage(r))
+		}
+	}()
+	rc.checkNotReadOnly("Create")
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	type insertGroup struct {
+		cols    []string
+		indexes []int
+		rows    []SQLParams
+	}
+	var (
+		groups   []*insertGroup
+		groupMap = make(map[string]*insertGroup)
+		datas    = make([]RecordData, len(data))
+		fMaps    = make([]FieldMap, len(data))
+		stored   = make([]FieldMap, len(data))
+	)
+	for i, d := range data {
+		rc.model.checkFieldMapKeys(d.Underlying().FieldMap)
+		rc.checkFieldsWritePermission(d.Underlying().FieldMap)
+		datas[i], fMaps[i], stored[i] = rc.prepareCreateData(d)
+		rc.checkCompanyAccess(fMaps[i])
+		cols, vals := rc.query.insertColumns(stored[i])
+		key := strings.Join(cols, ",")
+		group, ok := groupMap[key]
+		if !ok {
+			group = &insertGroup{cols: cols}
+			groupMap[key] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, i)
+		group.rows = append(group.rows, vals)
+	}
+	ids := make([]int64, len(data))
+	for _, group := range groups {
+		query, args := rc.query.batchInsertQuery(group.cols, group.rows)
+		// Rows are returned in the order of the VALUES list
+		createdIds := rc.execReturningRowsQuery(query, args, func(i int) FieldMap {
+			return stored[group.indexes[i]]
+		})
+		for i, id := range createdIds {
+			ids[group.indexes[i]] = id
+		}
+	}
+	rSet := rc.env.Pool(rc.ModelName()).withIds(ids)
+	for i, rec := range rSet.Records() {
+		rec.finishCreate(datas[i], fMaps[i])
+	}
+	rSet.fireEvent(EventCreate)
+	return rSet
+}
+
+// Upsert inserts a new record in the database with the given data or, if a
+// record with the same values for the given conflictFields already exists,
+// updates this record with the data. It returns the created or updated record.
+//
+// The record is inserted with a query that does nothing if the record already
+// exists, so that there is no race condition between checking if the record
+// exists and creating it. conflictFields must be the fields of a unique
+// constraint of the model, such as its composite key.
+//
+// An existing record is updated by calling its Write method with the given
+// data without the conflict fields.
+func (rc *RecordCollection) Upsert(data RecordData, conflictFields []FieldName) *RecordCollection {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(rc.substituteSQLErrorMessage(r))
+		}
+	}()
+	rc.checkNotReadOnly("Upsert")
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Create"))
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Write"))
+	rc.model.checkFieldMapKeys(data.Underlying().FieldMap)
+	rc.checkFieldsWritePermission(data.Underlying().FieldMap)
+	createData, fMap, storedFieldMap := rc.prepareCreateData(data)
+	rc.checkCompanyAccess(fMap)
+	query, args := rc.query.upsertQuery(storedFieldMap, conflictFields)
+	if ids := rc.execReturningQuery(query, args, storedFieldMap); len(ids) > 0 {
+		rSet := rc.withIds(ids)
+		rSet.finishCreate(createData, fMap)
+		rSet.fireEvent(EventCreate)
+		return rSet
+	}
+	// A record with the same conflict fields values already exists
+	cond := newCondition()
+	updateData := data.Underlying().Copy()
+	for _, field := range conflictFields {
+		fi := rc.model.fields.MustGet(field.Name())
+		cond = cond.And().Field(fi).Equals(fMap[fi.json])
+		updateData.Unset(fi)
+	}
+	existing := rc.env.Pool(rc.ModelName()).Search(cond)
+	if len(updateData.FieldMap) > 0 || len(updateData.ToCreate) > 0 {
+		existing.Call("Write", updateData)
+	}
+	return existing.Fetch()
+}
+
+// prepareCreateData processes the given data before inserting it in the
+// database. It returns the processed data, the full FieldMap of the new
+// record and the FieldMap of the values to store in the model's table.
+func (rc *RecordCollection) prepareCreateData(data RecordData) (RecordData, FieldMap, FieldMap) {
 	// process create data for FK relations if any
 	data = rc.createFKRelationRecords(data)
 
@@ -164,28 +368,29 @@ func (rc *RecordCollection) create(data RecordData) *RecordCollection {
 	rc.addAccessFieldsCreateData(&fMap)
 	fMap = rc.addEmbeddedfields(fMap)
 	rc.model.convertValuesToFieldType(&fMap, true)
+	rc.model.roundValues(fMap)
+	rc.model.checkSelectionValues(fMap)
 	fMap = rc.addContextsFieldsValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
 	storedFieldMap := rc.filterMapOnStoredFields(fMap)
-	// insert in DB
-	var createdId int64
-	query, args := rc.query.insertQuery(storedFieldMap)
-	rc.env.cr.Get(&createdId, query, args...)
+	return data, fMap, storedFieldMap
+}
 
-	rc.env.cache.addRecord(rc.model, createdId, storedFieldMap, rc.query.ctxArgsSlug())
-	rSet := rc.withIds([]int64{createdId})
+// finishCreate updates the relations, related and computed fields of
+// this newly inserted RecordCollection and checks its constraints.
+func (rc *RecordCollection) finishCreate(data RecordData, fMap FieldMap) {
 	// update reverse relation fields
-	rSet.updateRelationFields(fMap)
+	rc.updateRelationFields(fMap)
 	// update related fields
-	rSet.updateRelatedFields(fMap)
+	rc.updateRelatedFields(fMap)
 	// process create data for reverse relations if any
-	rSet.createReverseRelationRecords(data)
+	rc.createReverseRelationRecords(data)
 	// compute stored fields
-	rSet.processInverseMethods(data)
-	rSet.processTriggers(fMap.FieldNames(rSet.model))
-	rSet.CheckConstraints()
-	return rSet
+	rc.processInverseMethods(data)
+	rc.processTriggers(fMap.FieldNames(rc.model))
+	rc.updateExternalIDs(data)
+	rc.CheckConstraints()
 }
 
 // createReverseRelationRecords creates the reverse records of relation fields when
@@ -336,7 +541,8 @@ func (rc *RecordCollection) addContextsFieldsValues(fMap FieldMap) FieldMap {
 // CheckConstraints executes the constraint method for each field defined
 // in the given fMap with the corresponding value.
 // Each method is only executed once, even if it is called by several fields.
-// It panics as soon as one constraint fails.
+// It panics as soon as one constraint fails. A constraint method that panics
+// with a UserError fails with a ValidationError.
 func (rc *RecordCollection) CheckConstraints() {
 	if rc.env.context.GetBool("hexya_skip_check_constraints") {
 		return
@@ -350,6 +556,11 @@ func (rc *RecordCollection) CheckConstraints() {
 	if len(methods) == 0 {
 		return
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			panic(asValidationError(r))
+		}
+	}()
 	for method := range methods {
 		for _, rec := range rc.Records() {
 			rec.Call(method)
@@ -357,13 +568,44 @@ func (rc *RecordCollection) CheckConstraints() {
 	}
 }
 
+// accessFields are the fields automatically set by the ORM to track the
+// creation and the last modification of records.
+var accessFields = []string{"CreateDate", "CreateUID", "WriteDate", "WriteUID"}
+
+// stripAccessFields removes the access fields from the given FieldMap
+// so that they cannot be forged by the user.
+func (rc *RecordCollection) stripAccessFields(fMap *FieldMap) {
+	for _, f := range accessFields {
+		fi, ok := rc.model.fields.Get(f)
+		if !ok {
+			continue
+		}
+		delete(*fMap, fi.name)
+		delete(*fMap, fi.json)
+	}
+}
+
 // addAccessFieldsCreateData adds appropriate CreateDate and CreateUID fields to
-// the given FieldMap.
+// the given FieldMap. WriteDate and WriteUID are set to the same values so that
+// new records are seen as modified at their creation.
+// Any user given value for these fields is discarded.
 func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
-	if !rc.model.isSystem() {
-		(*fMap)["CreateDate"] = dates.Now()
+	rc.stripAccessFields(fMap)
+	if rc.model.isSystem() {
+		return
+	}
+	if _, ok := rc.model.fields.Get("CreateDate"); ok {
+		(*fMap)["CreateDate"] = rc.env.cr.Now()
+	}
+	if _, ok := rc.model.fields.Get("CreateUID"); ok {
 		(*fMap)["CreateUID"] = rc.env.uid
 	}
+	if _, ok := rc.model.fields.Get("WriteDate"); ok {
+		(*fMap)["WriteDate"] = rc.env.cr.Now()
+	}
+	if _, ok := rc.model.fields.Get("WriteUID"); ok {
+		(*fMap)["WriteUID"] = rc.env.uid
+	}
 }
 
 // update updates the database with the given data and returns the number of updated rows.
@@ -372,25 +614,22 @@ func (rc *RecordCollection) addAccessFieldsCreateData(fMap *FieldMap) {
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Call("Write")
 func (rc *RecordCollection) update(data RecordData) bool {
+	rc.checkNotReadOnly("Write")
+	rc.checkNotAllRecords("Write")
+	rc.model.checkFieldMapKeys(data.Underlying().FieldMap)
+	rc.checkFieldsWritePermission(data.Underlying().FieldMap)
 	if !rc.hasNegIds && rc.ForceLoad(ID).IsEmpty() {
 		return true
 	}
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write)
-	// process create data for FK relations if any
-	data = rc.createFKRelationRecords(data)
-	fMap := data.Underlying().Copy().FieldMap
-	rSet.addAccessFieldsUpdateData(&fMap)
-	rSet.applyContexts()
-	fMap = rSet.addContextsFieldsValues(fMap)
-	// We process inverse method before we convert RecordSets to ids
-	rSet.processInverseMethods(data)
-	rSet.model.convertValuesToFieldType(&fMap, true)
-	// clean our fMap from ID and non stored fields
-	fMap.RemovePK()
+	data, fMap := rSet.prepareUpdateData(data)
+	oldValues := rSet.watchedFieldsValues(fMap)
+	oldCompData := rSet.retrieveRelatedComputeData(fMap)
 	storedFieldMap := rSet.filterMapOnStoredFields(fMap)
 	rSet.doUpdate(storedFieldMap)
 	// Let's fetch once for all
 	rSet.Fetch()
+	rSet.updateExternalIDs(data)
 	// write reverse relation fields
 	rSet.updateRelationFields(fMap)
 	// write related fields
@@ -399,15 +638,49 @@ func (rc *RecordCollection) update(data RecordData) bool {
 	rSet.createReverseRelationRecords(data)
 	// compute stored fields
 	rSet.processTriggers(fMap.FieldNames(rSet.model))
+	rSet.updateStoredFields(oldCompData)
 	rSet.CheckConstraints()
+	rSet.callWriteHook(oldValues, fMap)
+	rSet.fireEvent(EventWrite)
 	return true
 }
 
+// prepareUpdateData checks that the given data can be written in the records
+// of this RecordCollection and returns it with its FK relation records created,
+// as well as the FieldMap to write, converted to the field types and with the
+// access and contexts fields values. Inverse methods of the computed fields
+// of data are called.
+func (rc *RecordCollection) prepareUpdateData(data RecordData) (RecordData, FieldMap) {
+	// process create data for FK relations if any
+	data = rc.createFKRelationRecords(data)
+	fMap := data.Underlying().Copy().FieldMap
+	rc.addAccessFieldsUpdateData(&fMap)
+	rc.applyContexts()
+	fMap = rc.addContextsFieldsValues(fMap)
+	// We process inverse method before we convert RecordSets to ids
+	rc.processInverseMethods(data)
+	rc.model.convertValuesToFieldType(&fMap, true)
+	rc.model.roundValues(fMap)
+	rc.model.checkSelectionValues(fMap)
+	rc.checkCompanyAccess(fMap)
+	rc.checkReadOnlyAfterCreate(fMap)
+	rc.checkSingleExternalID(fMap)
+	// clean our fMap from ID
+	fMap.RemovePK()
+	return data, fMap
+}
+
 // addAccessFieldsUpdateData adds appropriate WriteDate and WriteUID fields to
-// the given FieldMap.
+// the given FieldMap. Any user given value for access fields is discarded.
 func (rc *RecordCollection) addAccessFieldsUpdateData(fMap *FieldMap) {
-	if !rc.model.isSystem() {
-		(*fMap)["WriteDate"] = dates.Now()
+	rc.stripAccessFields(fMap)
+	if rc.model.isSystem() {
+		return
+	}
+	if _, ok := rc.model.fields.Get("WriteDate"); ok {
+		(*fMap)["WriteDate"] = rc.env.cr.Now()
+	}
+	if _, ok := rc.model.fields.Get("WriteUID"); ok {
 		(*fMap)["WriteUID"] = rc.env.uid
 	}
 }
@@ -454,11 +727,26 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 		}
 	}
 	if !rc.hasNegIds {
-		query, args := rc.query.updateQuery(fMap)
-		res := rc.env.cr.Execute(query, args...)
-		if num, _ := res.RowsAffected(); num == 0 {
+		uRc := rc
+		var checkVersion bool
+		if vf := rc.model.versionField; vf != nil {
+			// Optimistic locking: only update records that still have the expected version
+			vJSON := rc.model.fields.MustGet(vf.Name()).json
+			if expected, ok := fMap[vJSON]; ok {
+				delete(fMap, vJSON)
+				uRc = rc.Search(rc.model.Field(vf).Equals(expected))
+				checkVersion = true
+			}
+		}
+		query, args := uRc.query.updateQuery(fMap)
+		num := len(rc.execReturningQuery(query, args, fMap))
+		switch {
+		case checkVersion && num != len(rc.Ids()):
+			log.Panic("Records have been modified by another transaction since they were read", "model", rc.ModelName(), "ids", rc.Ids())
+		case num == 0:
 			log.Panic("Unexpected noop on update (num = 0)", "model", rc.ModelName(), "values", fMap, "query", query, "args", args)
 		}
+		return
 	}
 	for _, rec := range rc.Records() {
 		for k, v := range fMap {
@@ -467,6 +755,42 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 	}
 }
 
+// execReturningQuery executes the given INSERT or UPDATE query with a RETURNING
+// clause and puts the returned values of each row in cache, so that values
+// set by the database are available without reloading.
+//
+// Values of fMap that are not returned by the query (e.g. contexted fields)
+// are also put in cache. It returns the ids of the returned rows.
+func (rc *RecordCollection) execReturningQuery(query string, args SQLParams, fMap FieldMap) []int64 {
+	return rc.execReturningRowsQuery(query, args, func(int) FieldMap { return fMap })
+}
+
+// execReturningRowsQuery is like execReturningQuery, except that the values
+// put in cache with the i-th returned row are given by rowData(i).
+func (rc *RecordCollection) execReturningRowsQuery(query string, args SQLParams, rowData func(int) FieldMap) []int64 {
+	rows := dbQuery(rc.env.cr.tx, query, args...)
+	defer rows.Close()
+	var ids []int64
+	for i := 0; rows.Next(); i++ {
+		line := make(FieldMap)
+		if err := rc.model.scanToFieldMap(rows, &line, nil); err != nil {
+			log.Panic(err.Error(), "model", rc.ModelName(), "query", query)
+		}
+		for k, v := range rowData(i) {
+			if _, ok := line[k]; !ok {
+				line[k] = v
+			}
+		}
+		id := line["id"].(int64)
+		rc.env.cache.addRecord(rc.model, id, line, rc.query.ctxArgsSlug())
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Panic(err.Error(), "model", rc.ModelName(), "query", query)
+	}
+	return ids
+}
+
 // updateRelationFields updates reverse relations fields of the
 // given fMap.
 func (rc *RecordCollection) updateRelationFields(fMap FieldMap) {
@@ -495,12 +819,14 @@ func (rc *RecordCollection) updateRelationFields(fMap FieldMap) {
 
 		case fieldtype.Rev2One:
 		case fieldtype.Many2Many:
-			delQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s IN (?)`, fi.m2mRelModel.tableName, fi.m2mOurField.json)
+			adapter := adapters[db.DriverName()]
+			delQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s IN (?)`, adapter.QuoteTableName(fi.m2mRelModel.tableName),
+				adapter.QuoteIdentifier(fi.m2mOurField.json))
 			rc.env.cr.Execute(delQuery, rc.ids)
 			for _, id := range rc.ids {
 				rc.env.cache.removeM2MLinks(fi, id)
-				query := fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES (?, ?)`, fi.m2mRelModel.tableName,
-					fi.m2mOurField.json, fi.m2mTheirField.json)
+				query := fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES (?, ?)`, adapter.QuoteTableName(fi.m2mRelModel.tableName),
+					adapter.QuoteIdentifier(fi.m2mOurField.json), adapter.QuoteIdentifier(fi.m2mTheirField.json))
 				for _, relId := range value.([]int64) {
 					rc.env.cr.Execute(query, id, relId)
 				}
@@ -656,8 +982,12 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 	}
 	for constraintName, constraint := range rc.model.sqlConstraints {
 		if strings.Contains(err.Error(), constraintName) {
-			res := adapters[db.DriverName()].substituteErrorMessage(err, constraint.errorString)
-			return res
+			res := adapters[db.DriverName()].SubstituteErrorMessage(err, constraint.errorString)
+			return exceptions.ValidationError{
+				UserError: exceptions.UserError{
+					Message: res.Error(),
+				},
+			}
 		}
 	}
 	return r
@@ -667,6 +997,8 @@ func (rc *RecordCollection) substituteSQLErrorMessage(r interface{}) interface{}
 // This function is private and low level. It should not be called directly.
 // Instead use rs.Unlink() or rs.Call("Unlink")
 func (rc *RecordCollection) unlink() int64 {
+	rc.checkNotReadOnly("Unlink")
+	rc.checkNotAllRecords("Unlink")
 	rc.CheckExecutionPermission(rc.model.methods.MustGet("Unlink"))
 	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Unlink)
 	ids := rSet.Ids()
@@ -675,17 +1007,48 @@ func (rc *RecordCollection) unlink() int64 {
 	}
 	// get recomputate data to update after unlinking
 	compData := rc.retrieveComputeData(rc.model.fields.allFieldNames())
+	// We do not use withIds so as not to put the deleted records back in cache
+	deleted := newRecordCollection(rc.Env(), rc.ModelName())
+	deleted.ids = ids
+	deleted.fetched = true
 	var num int64
 	if !rSet.hasNegIds {
-		query, args := rSet.query.deleteQuery()
+		query, args := rSet.addTombstonesToDeleteQuery(rSet.query.deleteQuery())
 		res := rSet.env.cr.Execute(query, args...)
 		num, _ = res.RowsAffected()
+		deleted.removeExternalIDs()
 	}
 	for _, id := range ids {
 		rc.env.cache.invalidateRecord(rc.model, id)
 	}
 	// Update stored fields that referenced this recordset
 	rc.updateStoredFields(compData)
+	deleted.fireEvent(EventUnlink)
+	return num
+}
+
+// DeleteAll deletes all the records matching the query of this RecordCollection
+// in a single SQL query, without loading them first. It returns the number of
+// deleted records.
+//
+// This is meant for purging large amounts of records efficiently. Unlike
+// Unlink, stored fields that depend on the deleted records are not recomputed
+// and unlink events are not fired. Database foreign keys constraints still apply.
+func (rc *RecordCollection) DeleteAll() int64 {
+	rc.checkNotReadOnly("DeleteAll")
+	rc.checkNotAllRecords("DeleteAll")
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Unlink"))
+	if rc.hasNegIds {
+		log.Panic("Cannot delete records that are not in the database", "model", rc.ModelName())
+	}
+	rSet := rc.clone().addRecordRuleConditions(rc.env.uid, security.Unlink)
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	rSet.applyContexts()
+	rSet = rSet.substituteRelatedInQuery()
+	query, args := rSet.addTombstonesToDeleteQuery(rSet.query.deleteAllQuery())
+	res := rSet.env.cr.Execute(query, args...)
+	num, _ := res.RowsAffected()
+	rc.env.cache.invalidateModel(rc.model)
 	return num
 }
 
@@ -695,19 +1058,69 @@ func (rc *RecordCollection) Search(cond *Condition) *RecordCollection {
 	rSetVal := *rc
 	rSetVal.query = rc.query.clone(&rSetVal)
 	rSetVal.query.cond = rSetVal.query.cond.AndCond(cond)
+	if rc.fetched && len(rc.ids) > 0 && !rc.hasNegIds && !cond.IsEmpty() {
+		// Our ids must be filtered by the new condition
+		rSetVal.fetched = false
+	}
+	if rc.query.original != nil {
+		// Keep the original query in sync so that it selects the same records
+		rSetVal.query.original = rc.query.original.clone(&rSetVal)
+		rSetVal.query.original.cond = rSetVal.query.original.cond.AndCond(cond)
+	}
 	return &rSetVal
 }
 
+// SearchRaw returns a new RecordSet filtering on the current one with the given
+// raw SQL fragment, which is added to the WHERE clause of the query. args are bound
+// to the '?' placeholders of sql.
+//
+// This is an escape hatch for conditions that cannot be expressed with Search.
+// It is the caller's responsibility to write a valid and injection-safe SQL fragment
+// that refers to the columns of the model's table, e.g.
+//
+//	rc.SearchRaw(`"user".data->>'color' = ?`, "blue")
+func (rc *RecordCollection) SearchRaw(sql string, args ...interface{}) *RecordCollection {
+	return rc.Search(newCondition().AndRaw(sql, args...))
+}
+
+// OriginalQuery returns a new RecordSet with the query of this RecordSet as it was
+// before its records were fetched, i.e. before its condition was replaced by the
+// ids of the records. This allows to run the original search again, for instance
+// to count all matching records or to get records created since.
+//
+// Conditions added with Search after the records were fetched are part of the
+// original query. If this RecordSet has not been obtained by a search, the
+// returned RecordSet has the same query as this one.
+func (rc *RecordCollection) OriginalQuery() *RecordCollection {
+	rSet := newRecordCollection(rc.Env(), rc.ModelName())
+	query := rc.query
+	if query.original != nil {
+		query = query.original
+	}
+	rSet.query = query.clone(rSet)
+	return rSet
+}
+
 // Limit returns a new RecordSet with only the first 'limit' records.
+//
+// A zero or negative limit means no limit.
 func (rc *RecordCollection) Limit(limit int) *RecordCollection {
+	if limit < 0 {
+		limit = 0
+	}
 	rSet := *rc
 	rSet.query = rSet.query.clone(&rSet)
 	rSet.query.limit = limit
 	return &rSet
 }
 
-// Offset returns a new RecordSet with only the records starting at offset
+// Offset returns a new RecordSet with only the records starting at offset.
+//
+// A negative offset is treated as 0.
 func (rc *RecordCollection) Offset(offset int) *RecordCollection {
+	if offset < 0 {
+		offset = 0
+	}
 	rSet := *rc
 	rSet.query = rSet.query.clone(&rSet)
 	rSet.query.offset = offset
@@ -722,15 +1135,20 @@ func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
 	return &rSet
 }
 
-// GroupBy returns a new RecordSet grouped with the given GROUP BY expressions
+// GroupBy returns a new RecordSet grouped with the given GROUP BY expressions.
+//
+// Date and datetime fields can be grouped by date with the "Field:grouping"
+// syntax, e.g. "CreateDate:month". See GroupByDate.
 func (rc *RecordCollection) GroupBy(fields ...FieldName) *RecordCollection {
 	rSet := *rc
 	rSet.query = rSet.query.clone(&rSet)
-	exprs := make([]FieldName, len(fields))
-	for i, f := range fields {
-		exprs[i] = f
+	for _, f := range fields {
+		if field, grouping, ok := splitDateGroupExpr(rc.model, f); ok {
+			rSet = *rSet.GroupByDate(field, grouping)
+			continue
+		}
+		rSet.query.groups = append(rSet.query.groups, f)
 	}
-	rSet.query.groups = append(rSet.query.groups, exprs...)
 	return &rSet
 }
 
@@ -751,6 +1169,24 @@ func (rc *RecordCollection) Fetch() *RecordCollection {
 	return rc.Load(ID)
 }
 
+// Reset returns a new empty RecordSet of the same model and Environment as this
+// one, with a new query without condition, limit, offset, order, grouping or
+// distinct clause.
+//
+// This RecordSet is never modified, so that its ids are kept if it has been
+// loaded. The returned RecordSet never has ids, whether this RecordSet has been
+// loaded or not.
+func (rc *RecordCollection) Reset() *RecordCollection {
+	env := *rc.env
+	rSet := RecordCollection{
+		model: rc.model,
+		env:   &env,
+		ids:   make([]int64, 0),
+	}
+	rSet.query = newQuery(&rSet)
+	return &rSet
+}
+
 // SearchAll returns a new RecordSet with all items of the table, regardless of the
 // current RecordSet query. It is mainly meant to be used on an empty RecordSet
 func (rc *RecordCollection) SearchAll() *RecordCollection {
@@ -759,18 +1195,178 @@ func (rc *RecordCollection) SearchAll() *RecordCollection {
 	return rSet
 }
 
+// WithAllRecords returns a new RecordSet on which Write and Unlink are allowed
+// even if its query targets all the records of the table.
+//
+// Without it, writing or deleting a RecordSet obtained with SearchAll
+// and no other condition panics to prevent accidental full-table changes.
+func (rc *RecordCollection) WithAllRecords() *RecordCollection {
+	rSet := rc.clone()
+	rSet.query.allowAll = true
+	return rSet
+}
+
+// checkNotAllRecords panics if the query of this RecordCollection targets all
+// the records of the table and this has not been explicitly allowed with
+// WithAllRecords. operation is the name of the attempted operation.
+func (rc *RecordCollection) checkNotAllRecords(operation string) {
+	if rc.query.targetsAllRecords() && !rc.query.allowAll {
+		log.Panic("Trying to modify all records without condition. Use WithAllRecords() if this is intended",
+			"model", rc.ModelName(), "operation", operation)
+	}
+}
+
+// DisplayNames returns a map with the ID of each record of this
+// RecordCollection as key and the result of its NameGet method as value.
+func (rc *RecordCollection) DisplayNames() map[int64]string {
+	res := make(map[int64]string)
+	for _, rec := range rc.Records() {
+		res[rec.ids[0]] = rec.Call("NameGet").(string)
+	}
+	return res
+}
+
+// NameSearch returns at most limit records of this RecordCollection's model
+// with a name field matching the given name (case insensitive). If limit is
+// 0 or less, all matching records are returned.
+//
+// Records are ordered by relevance: first records with an exact match, then
+// records with a name starting with the given name, and finally other matches.
+// '%' and '_' in name are matched literally.
+func (rc *RecordCollection) NameSearch(name string, limit int) *RecordCollection {
+	name = escapeLikePattern(name)
+	matches := []struct {
+		op   operator.Operator
+		name string
+	}{
+		{op: operator.ILike, name: name},
+		{op: operator.ILike, name: name + "%"},
+		{op: operator.IContains, name: name},
+	}
+	var ids []int64
+	for _, match := range matches {
+		if limit > 0 && len(ids) >= limit {
+			break
+		}
+		cond := rc.model.nameCondition(match.op, match.name)
+		if len(ids) > 0 {
+			cond = rc.model.Field(ID).NotIn(ids).AndCond(cond)
+		}
+		found := rc.env.Pool(rc.ModelName()).Call("Search", cond).(RecordSet).Collection()
+		if limit > 0 {
+			found = found.Limit(limit - len(ids))
+		}
+		ids = append(ids, found.Ids()...)
+	}
+	return rc.env.Pool(rc.ModelName()).withIds(ids)
+}
+
+// escapeLikePattern returns the given string with the LIKE metacharacters
+// escaped, so that it can be used as a literal in a LIKE pattern.
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 // SearchCount fetch from the database the number of records that match the RecordSet conditions
 // It panics in case of error
 func (rc *RecordCollection) SearchCount() int {
+	query, args := rc.countQuery()
+	var res int
+	rc.env.cr.Get(&res, query, args...)
+	return res
+}
+
+// Count returns the number of records of this RecordCollection.
+//
+// If the ids of this RecordCollection have already been fetched, Count returns
+// their number without querying the database. Otherwise, it falls back to
+// SearchCount, which always queries the database.
+func (rc *RecordCollection) Count() int {
+	if rc.fetched {
+		return len(rc.ids)
+	}
+	return rc.SearchCount()
+}
+
+// countQuery returns the SQL query and arguments to count the records of this RecordCollection
+func (rc *RecordCollection) countQuery() (string, SQLParams) {
 	rSet := rc.Limit(0)
 	rSet.applyDefaultOrder()
 	rSet.applyContexts()
 	addNameSearchesToCondition(rSet.model, rSet.query.cond)
 	rSet = rSet.substituteRelatedInQuery()
-	query, args := rSet.query.countQuery()
-	var res int
-	rSet.env.cr.Get(&res, query, args...)
-	return res
+	return rSet.query.countQuery()
+}
+
+// ToSQL returns the SQL query and its arguments that would be executed to fetch
+// the ids of the records of this RecordSet, without executing it.
+//
+// The returned query is given with the placeholders of the database driver.
+// It is meant for inspection and debugging only.
+func (rc *RecordCollection) ToSQL() (string, []interface{}) {
+	query, args, _ := rc.idsQuery()
+	return sanitizeQuery(query, args...)
+}
+
+// idsQuery returns the SQL query, its arguments and field substitutions to
+// fetch the ids of the records of this RecordCollection.
+func (rc *RecordCollection) idsQuery() (string, SQLParams, map[string]string) {
+	rSet := rc.clone().addRecordRuleConditions(rc.env.uid, security.Read)
+	rSet.applyDefaultOrder()
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	rSet.applyContexts()
+	rSet = rSet.substituteRelatedInQuery()
+	return rSet.query.selectQuery([]FieldName{ID})
+}
+
+// idsSubQuery returns an SQL query with a single "id" column and its arguments
+// selecting the ids of the records of this RecordCollection. It is meant to be
+// used as a subquery in an IN clause.
+func (rc *RecordCollection) idsSubQuery() (string, SQLParams) {
+	adapter := adapters[db.DriverName()]
+	query, args, _ := rc.idsQuery()
+	return fmt.Sprintf(`SELECT %s FROM (%s) ids`, adapter.QuoteIdentifier("id"), query), args
+}
+
+// SearchIds executes the query of this RecordCollection and returns the ids of
+// the matching records, in the order and within the limit and offset of the query.
+//
+// Contrary to Ids, the query is always executed and the returned ids are neither
+// stored in this RecordCollection nor in the cache. This makes it lighter than
+// fetching the RecordCollection when only the ids are needed.
+func (rc *RecordCollection) SearchIds() []int64 {
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Load"))
+	ids := make([]int64, 0)
+	if rc.query.isEmpty() {
+		return ids
+	}
+	if rc.hasNegIds {
+		log.Panic("Trying to search ids of a memory RecordSet created by New", "model", rc.model, "ids", rc.ids)
+	}
+	query, args, substs := rc.idsQuery()
+	rows := dbQuery(rc.env.cr.tx, query, args...)
+	defer rows.Close()
+	for rows.Next() {
+		line := make(FieldMap)
+		if err := rc.model.scanToFieldMap(rows, &line, substs); err != nil {
+			log.Panic(err.Error(), "model", rc.ModelName())
+		}
+		ids = append(ids, line["id"].(int64))
+	}
+	if err := rows.Err(); err != nil {
+		log.Panic(err.Error(), "model", rc.ModelName(), "query", query)
+	}
+	return ids
+}
+
+// CountSQL returns the SQL query and its arguments that would be executed by
+// SearchCount on this RecordSet, without executing it.
+//
+// The returned query is given with the placeholders of the database driver.
+// It is meant for inspection and debugging only.
+func (rc *RecordCollection) CountSQL() (string, []interface{}) {
+	query, args := rc.countQuery()
+	return sanitizeQuery(query, args...)
 }
 
 // Load look up fields of the RecordCollection in cache and query the database
@@ -808,6 +1404,9 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 	if len(rc.query.groups) > 0 {
 		log.Panic("Trying to load a grouped query", "model", rc.model, "groups", rc.query.groups)
 	}
+	rc.env.cache.evict()
+	rc.env.cache.startLoading()
+	defer rc.env.cache.endLoading()
 	rSet := rc
 	var prefetch bool
 	if !rc.prefetchRC.IsEmpty() && len(rc.ids) > 0 {
@@ -842,7 +1441,11 @@ func (rc *RecordCollection) ForceLoad(fieldNames ...FieldName) *RecordCollection
 		ids = append(ids, line["id"].(int64))
 	}
 
-	rSet = rSet.withIds(ids)
+	if len(rSet.query.unions) > 0 {
+		rSet = rSet.withUnionIds(ids)
+	} else {
+		rSet = rSet.withIds(ids)
+	}
 	rSet.loadRelationFields(subFields)
 	if prefetch {
 		*rc = *rSet.Intersect(rc).WithEnv(rc.Env())
@@ -890,8 +1493,9 @@ func (rc *RecordCollection) loadRelationFields(fields FieldNames) {
 				relRC = relRC.Search(relRC.Model().Field(relRC.Model().FieldName(fi.reverseFK)).Equals(thisRC)).Call("Fetch").(RecordSet).Collection()
 				rc.env.cache.updateEntry(rc.model, id, fName.JSON(), relRC.ids, rc.query.ctxArgsSlug())
 			case fieldtype.Many2Many:
-				query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, fi.m2mTheirField.json,
-					fi.m2mRelModel.tableName, fi.m2mOurField.json)
+				adapter := adapters[db.DriverName()]
+				query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, adapter.QuoteIdentifier(fi.m2mTheirField.json),
+					adapter.QuoteTableName(fi.m2mRelModel.tableName), adapter.QuoteIdentifier(fi.m2mOurField.json))
 				var ids []int64
 				if thisRC.IsEmpty() {
 					continue
@@ -914,6 +1518,9 @@ func (rc *RecordCollection) loadRelationFields(fields FieldNames) {
 
 // Get returns the value of the given fieldName for the first record of this RecordCollection.
 // It returns the type's zero value if the RecordCollection is empty.
+//
+// Other records of this RecordCollection are silently ignored. Use GetOne
+// instead if this RecordCollection is expected to be a singleton.
 func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 	fi := rc.model.getRelatedFieldInfo(fieldName)
 	if !rc.IsValid() {
@@ -937,12 +1544,18 @@ func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 		if prefix.Name() != "" {
 			relRC = rc.Get(prefix).(RecordSet).Collection()
 		}
-		fMap := make(FieldMap)
-		relRC.computeFieldValues(&fMap, fi.json)
-		res = fMap[fi.json]
+		res = relRC.computedValue(fi)
 	case fi.isRelatedField():
 		res = rc.Get(rc.substituteRelatedInPath(fieldName))
 	default:
+		if fi.recomputeMode == RecomputeLazy {
+			// Lazy fields whose recomputation has been postponed must be computed first
+			recRC := rc
+			if len(exprs) > 1 {
+				recRC = rc.Get(joinFieldNames(exprs[:len(exprs)-1], ExprSep)).(RecordSet).Collection()
+			}
+			recRC.recomputeIfDirty(fi)
+		}
 		if rc.hasNegIds && len(exprs) > 1 {
 			// We have a negative ID, but we fetch a related field
 			// So we delegate to the related model to prevent fetching ourselves in the DB
@@ -964,7 +1577,45 @@ func (rc *RecordCollection) Get(fieldName FieldName) interface{} {
 	}
 
 	if fi.isRelationField() {
-		res = rc.convertToRecordSet(res, fi.relatedModelName)
+		relRC := rc.convertToRecordSet(res, fi.relatedModelName)
+		if fi.fieldType.IsFKRelationType() && !fi.isRelatedField() && len(exprs) == 1 && relRC.Len() == 1 {
+			// Records pointed at by our prefetch group will be loaded together
+			relRC.prefetchRC = rc.relatedPrefetchRC(fi)
+		}
+		res = relRC
+	}
+	return res
+}
+
+// relatedPrefetchRC returns the records pointed at by the given many2one or
+// one2one field from all the records of the prefetch group of this singleton.
+// Records whose value of the field is not in cache are ignored.
+//
+// The result is computed once per prefetch group and field, and shared by
+// all the records of the group.
+func (rc *RecordCollection) relatedPrefetchRC(fi *Field) *RecordCollection {
+	if rc.prefetchRC.IsEmpty() || rc.hasNegIds {
+		return newRecordCollection(rc.Env(), fi.relatedModelName)
+	}
+	key := fmt.Sprintf("%s|%s", fi.json, rc.query.ctxArgsSlug())
+	if res, ok := rc.prefetchRC.relatedPrefetch[key]; ok {
+		return res
+	}
+	res := newRecordCollection(rc.Env(), fi.relatedModelName)
+	var ids []int64
+	for _, id := range rc.prefetchRC.ids {
+		if !rc.env.cache.isInCache(rc.model, id, fi.json, rc.query.ctxArgsSlug(), true) {
+			continue
+		}
+		relID, ok := rc.env.cache.get(rc.model, id, fi.json, rc.query.ctxArgsSlug()).(int64)
+		if !ok || relID <= 0 {
+			continue
+		}
+		ids = append(ids, relID)
+	}
+	res.withIds(ids)
+	if rc.prefetchRC.relatedPrefetch != nil {
+		rc.prefetchRC.relatedPrefetch[key] = res
 	}
 	return res
 }
@@ -1006,6 +1657,31 @@ func (rc *RecordCollection) get(field FieldName, all bool) (interface{}, bool) {
 	return rc.env.cache.get(rc.model, rc.ids[0], field.JSON(), rc.query.ctxArgsSlug()), dbCalled
 }
 
+// GetOne returns the value of the given fieldName for this RecordCollection
+// which must be a singleton.
+//
+// Contrary to Get, it panics if this RecordCollection is empty or has more than one record.
+func (rc *RecordCollection) GetOne(fieldName FieldName) interface{} {
+	rc.EnsureOne()
+	return rc.Get(fieldName)
+}
+
+// GetAll returns the values of the given field for all the records of this
+// RecordCollection, mapped by id. The field is read for all records at once.
+// Values of relation fields are returned as *RecordCollection.
+func (rc *RecordCollection) GetAll(fieldName FieldName) map[int64]interface{} {
+	res := make(map[int64]interface{})
+	rc.Load(fieldName)
+	for _, rec := range rc.Records() {
+		val := rec.Get(fieldName)
+		if rs, ok := val.(RecordSet); ok {
+			val = rs.Collection()
+		}
+		res[rec.ids[0]] = val
+	}
+	return res
+}
+
 // Set sets field given by fieldName to the given value. If the RecordSet has several
 // Records, all of them will be updated. Each call to Set makes an update query in the
 // database. It panics if it is called on an empty RecordSet.
@@ -1014,6 +1690,139 @@ func (rc *RecordCollection) Set(fieldName FieldName, value interface{}) {
 	rc.Call("Write", md)
 }
 
+// SetMulti sets all the fields of the given FieldMap to their values. If the RecordSet
+// has several Records, all of them will be updated. Contrary to Set, a single update
+// query is made in the database for all the fields. It panics if it is called on an
+// empty RecordSet.
+func (rc *RecordCollection) SetMulti(values FieldMap) {
+	md := NewModelData(rc.model)
+	for field, value := range values {
+		md.Set(rc.model.FieldName(field), value)
+	}
+	rc.Call("Write", md)
+}
+
+// SetComputed sets the field given by fieldName of each record of this RecordSet
+// to the value returned by fn for this record.
+//
+// Values are checked and prepared for each record as with Write, but all the
+// records are then updated with a single query joining their values, instead
+// of one query per record, and the cache is invalidated once. This makes it
+// suitable to recompute flags or categories over large RecordSets. The field
+// must be stored in the table of this model. Overrides of the Write method are
+// not called, but access rights, triggers, constraints, write hooks and write
+// events apply as with Write.
+//
+// If the model has a version field, records are only updated if their version
+// is still the one read when calling fn, and SetComputed panics otherwise.
+// Unlike Write, SetComputed can be called on all the records of a model without
+// WithAllRecords, since each record gets its own value.
+func (rc *RecordCollection) SetComputed(fieldName FieldName, fn func(rec *RecordCollection) interface{}) {
+	rc.checkNotReadOnly("Write")
+	fi := rc.model.fields.MustGet(fieldName.Name())
+	if !fi.isStored() || fi.isRelatedField() || fi.fieldType.IsNonStoredRelationType() {
+		log.Panic("SetComputed requires a field stored in the table of the model", "model", rc.model, "field", fieldName)
+	}
+	if rc.hasNegIds {
+		// Memory records are not in the database
+		for _, rec := range rc.Records() {
+			rec.Set(fieldName, fn(rec))
+		}
+		return
+	}
+	rc.model.checkFieldMapKeys(FieldMap{fi.json: nil})
+	rc.checkFieldsWritePermission(FieldMap{fi.json: nil})
+	rc.CheckExecutionPermission(rc.model.methods.MustGet("Write"))
+	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Write).Fetch()
+	if rSet.IsEmpty() {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			panic(rSet.substituteSQLErrorMessage(r))
+		}
+	}()
+	adapter := adapters[db.DriverName()]
+	idType := adapter.ColumnTypeSQL(rc.model.fields.MustGet("ID"))
+	valType := adapter.ColumnTypeSQL(fi)
+	var vfi *Field
+	if vf := rc.model.versionField; vf != nil {
+		vfi = rc.model.fields.MustGet(vf.Name())
+	}
+	var (
+		rows      []string
+		args      SQLParams
+		accessMap FieldMap
+	)
+	recData := make([]RecordData, rSet.Len())
+	recFMaps := make([]FieldMap, rSet.Len())
+	for i, rec := range rSet.Records() {
+		var version int64
+		if vfi != nil {
+			if v := reflect.ValueOf(rec.Get(rc.model.versionField)); v.IsValid() {
+				version = v.Int()
+			}
+		}
+		recData[i], recFMaps[i] = rec.prepareUpdateData(NewModelData(rc.model).Set(fieldName, fn(rec)))
+		storedFieldMap := rec.filterMapOnStoredFields(recFMaps[i])
+		val, ok := storedFieldMap[fi.json]
+		if !ok {
+			// The value has been written by the inverse method of the field
+			continue
+		}
+		accessMap = storedFieldMap
+		row := fmt.Sprintf("(CAST(? AS %s), CAST(? AS %s)", idType, valType)
+		args = append(args, rec.ids[0], sqlValue(fi, val))
+		if vfi != nil {
+			row += fmt.Sprintf(", CAST(? AS %s)", adapter.ColumnTypeSQL(vfi))
+			args = append(args, version)
+		}
+		rows = append(rows, row+")")
+	}
+	oldCompData := rSet.retrieveRelatedComputeData(FieldMap{fi.json: nil})
+	oldValues := rSet.watchedFieldsValues(FieldMap{fi.json: nil})
+	if len(rows) > 0 {
+		tableName := adapter.QuoteTableName(rc.model.tableName)
+		sets := []string{fmt.Sprintf("%s = v.val", adapter.QuoteIdentifier(fi.json))}
+		var setArgs SQLParams
+		for f, v := range accessMap {
+			if f == fi.json {
+				continue
+			}
+			sets = append(sets, fmt.Sprintf("%s = ?", adapter.QuoteIdentifier(f)))
+			setArgs = append(setArgs, v)
+		}
+		columns := "id, val"
+		where := fmt.Sprintf("%s.%s = v.id", tableName, adapter.QuoteIdentifier("id"))
+		if vfi != nil {
+			// Optimistic locking: only update records that still have the version read
+			vCol := adapter.QuoteIdentifier(vfi.json)
+			sets = append(sets, fmt.Sprintf("%s = COALESCE(%s, 0) + 1", vCol, vCol))
+			columns += ", version"
+			where += fmt.Sprintf(" AND COALESCE(%s.%s, 0) = v.version", tableName, vCol)
+		}
+		query := fmt.Sprintf(`UPDATE %s SET %s FROM (VALUES %s) AS v(%s) WHERE %s`,
+			tableName, strings.Join(sets, ", "), strings.Join(rows, ", "), columns, where)
+		res := rc.env.cr.Execute(query, setArgs.Extend(args)...)
+		if num, err := res.RowsAffected(); vfi != nil && err == nil && num != int64(len(rows)) {
+			log.Panic("Records have been modified by another transaction since they were read", "model", rc.ModelName(), "ids", rSet.ids)
+		}
+	}
+	rc.env.cache.invalidateRecords(rc.model, rSet.ids)
+	for i, rec := range rSet.Records() {
+		rec.updateExternalIDs(recData[i])
+		if fi.contexts != nil {
+			// write the values of the contexted field
+			rec.updateRelatedFields(recFMaps[i])
+		}
+	}
+	rSet.processTriggers(FieldNames{fieldName})
+	rSet.updateStoredFields(oldCompData)
+	rSet.CheckConstraints()
+	rSet.callWriteHook(oldValues, FieldMap{fi.json: nil})
+	rSet.fireEvent(EventWrite)
+}
+
 // InvalidateCache clears the cache for this RecordSet data, and immediately reloads the data from the DB.
 func (rc *RecordCollection) InvalidateCache() {
 	for _, rec := range rc.Records() {
@@ -1024,13 +1833,17 @@ func (rc *RecordCollection) InvalidateCache() {
 
 // First returns the values of the first Record of the RecordCollection as a ModelData.
 //
+// If fields are given, only these fields and the ID are set in the returned ModelData
+// and only these fields are read from the database if they are not in cache.
+// Otherwise, all fields are returned.
+//
 // If this RecordCollection is empty, it returns an empty ModelData.
-func (rc *RecordCollection) First() *ModelData {
+func (rc *RecordCollection) First(fields ...FieldName) *ModelData {
 	rc.Fetch()
 	if rc.IsEmpty() {
 		NewModelData(rc.model)
 	}
-	fields := rc.model.fields.allFieldNames()
+	fields = rc.dataFieldNames(fields)
 	rc.Load(fields...)
 	res := NewModelDataFromRS(rc)
 	for _, f := range fields {
@@ -1040,16 +1853,31 @@ func (rc *RecordCollection) First() *ModelData {
 }
 
 // All returns the values of all records of the RecordCollection as a slice of ModelData.
-func (rc *RecordCollection) All() []*ModelData {
+//
+// If fields are given, only these fields and the ID are set in the returned ModelData
+// and only these fields are read from the database if they are not in cache.
+// Otherwise, all fields are returned.
+func (rc *RecordCollection) All(fields ...FieldName) []*ModelData {
 	rc.Fetch()
+	fields = rc.dataFieldNames(fields)
+	rc.Load(fields...)
 	res := make([]*ModelData, rc.Len())
 	recs := rc.Records()
 	for i := 0; i < rc.Len(); i++ {
-		res[i] = recs[i].First()
+		res[i] = recs[i].First(fields...)
 	}
 	return res
 }
 
+// dataFieldNames returns the fields to set in the ModelData returned by
+// First and All: the given fields with the ID or all fields if none are given.
+func (rc *RecordCollection) dataFieldNames(fields []FieldName) []FieldName {
+	if len(fields) == 0 {
+		return rc.model.fields.allFieldNames()
+	}
+	return addIDIfNotPresent(append([]FieldName{}, fields...))
+}
+
 // Aggregates returns the result of this RecordCollection query, which must by a grouped query.
 func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregateRow {
 	if len(rc.query.groups) == 0 {
@@ -1084,13 +1912,62 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldName) []GroupAggregate
 		line := GroupAggregateRow{
 			Values:    NewModelDataFromRS(rc, vals),
 			Count:     int(cnt),
-			Condition: getGroupCondition(groups, vals, rc.query.cond),
+			Condition: rc.query.groupCondition(groups, vals, rc.query.cond),
 		}
 		res = append(res, line)
 	}
 	return res
 }
 
+// NestedAggregates returns the result of this RecordCollection query, which must
+// be a grouped query, as a tree of groups.
+//
+// The returned rows are grouped by the first group by expression only, and the
+// SubGroups of each row are grouped by the second expression within this row,
+// and so on. Aggregated values and counts are computed by the database for
+// each level. Grouped fields are always returned in the Values of each row,
+// even if they are not given in fieldNames.
+func (rc *RecordCollection) NestedAggregates(fieldNames ...FieldName) []GroupAggregateRow {
+	if len(rc.query.groups) == 0 {
+		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
+	}
+	groups := make([]FieldName, len(rc.query.groups))
+	copy(groups, rc.query.groups)
+	return rc.nestedAggregates(groups, fieldNames)
+}
+
+// nestedAggregates returns the aggregates of this RecordCollection grouped by
+// the first of the given groups, with the subgroups of the remaining groups.
+func (rc *RecordCollection) nestedAggregates(groups []FieldName, fieldNames []FieldName) []GroupAggregateRow {
+	rSet := rc.clone()
+	rSet.query.groups = []FieldName{groups[0]}
+	fields := fieldNames
+	if !containsFieldName(fields, groups[0]) {
+		fields = append([]FieldName{groups[0]}, fieldNames...)
+	}
+	res := rSet.Aggregates(fields...)
+	if len(groups) == 1 {
+		return res
+	}
+	for i, row := range res {
+		subRS := rc.clone()
+		subRS.query.cond = row.Condition
+		res[i].SubGroups = subRS.nestedAggregates(groups[1:], fieldNames)
+	}
+	return res
+}
+
+// containsFieldName returns true if a field of the given slice
+// has the same JSON name as the given field.
+func containsFieldName(fields []FieldName, field FieldName) bool {
+	for _, f := range fields {
+		if f.JSON() == field.JSON() {
+			return true
+		}
+	}
+	return false
+}
+
 // fixGroupByOrders adds order by expressions to group by clause to have a correct query.
 // It also adds a default order to the grouped fields if it does not exist.
 func (rc *RecordCollection) fixGroupByOrders(fieldNames ...FieldName) *RecordCollection {
@@ -1146,7 +2023,7 @@ func (rc *RecordCollection) fieldsGroupOperators(fields []FieldName) ([]FieldNam
 			continue
 		}
 		fi := rc.model.getRelatedFieldInfo(dbf)
-		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Integer {
+		if fi.fieldType != fieldtype.Float && fi.fieldType != fieldtype.Decimal && fi.fieldType != fieldtype.Integer {
 			continue
 		}
 		res[dbf.JSON()] = fi.groupOperator
@@ -1170,10 +2047,14 @@ func (rc *RecordCollection) SQLFromCondition(c *Condition) (string, SQLParams) {
 // RecordCollection.
 func (rc *RecordCollection) Records() []*RecordCollection {
 	res := make([]*RecordCollection, rc.Len())
+	// The prefetch group of the records also holds the prefetch sets of their relations
+	group := rc.clone()
+	group.relatedPrefetch = make(map[string]*RecordCollection)
 	for i, id := range rc.Ids() {
 		newRC := newRecordCollection(rc.Env(), rc.ModelName())
 		res[i] = newRC.withIds([]int64{id})
-		res[i].prefetchRC = rc
+		res[i].prefetchRC = group
+		res[i].readOnly = rc.readOnly
 	}
 	return res
 }
@@ -1212,7 +2093,12 @@ func (rc *RecordCollection) IsValid() bool {
 	return true
 }
 
-// Len returns the number of records in this RecordCollection
+// Len returns the number of records in this RecordCollection.
+//
+// If the ids of this RecordCollection have not been fetched yet, Len fetches
+// them from the database first, so that the returned value is always the
+// actual number of matching records. Use Count to get this number without
+// fetching the ids.
 func (rc *RecordCollection) Len() int {
 	rc.Fetch()
 	return len(rc.ids)
@@ -1227,7 +2113,7 @@ func (rc *RecordCollection) Model() *Model {
 func (rc *RecordCollection) GetRecord(externalID string) *RecordCollection {
 	res := rc.Search(rc.model.Field(rc.model.FieldName("HexyaExternalID")).Equals(externalID))
 	if res.IsEmpty() {
-		log.Panic("Unknown external ID", "model", rc.model.name, "externalID", externalID)
+		panicMissingError("Unknown external ID", "model", rc.model.name, "externalID", externalID)
 	}
 	return res
 }
@@ -1264,6 +2150,10 @@ func (rc *RecordCollection) withIds(ids []int64) *RecordCollection {
 		for _, id := range rc.ids {
 			rc.env.cache.updateEntry(rc.model, id, "id", id, rc.query.ctxArgsSlug())
 		}
+		if rc.query.original == nil && !rc.query.isEmpty() {
+			// Keep the query before substituting ids so that it can be run again
+			rc.query.original = rc.query.clone(rc)
+		}
 		rc.query.cond = rc.Model().Field(ID).In(newIds)
 		rc.query.fetchAll = false
 		rc.query.limit = 0
@@ -15,13 +15,16 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
@@ -101,6 +104,18 @@ func (mc *modelCollection) add(mi *Model) {
 	mi.fields.model = mi
 }
 
+// setTableName changes the table name of the given Model of the modelCollection
+func (mc *modelCollection) setTableName(mi *Model, tableName string) {
+	mc.Lock()
+	defer mc.Unlock()
+	if other, exists := mc.registryByTableName[tableName]; exists && other != mi {
+		log.Panic("Trying to use a table name of another model", "model", mi.name, "table", tableName, "other", other.name)
+	}
+	delete(mc.registryByTableName, mi.tableName)
+	mi.tableName = tableName
+	mc.registryByTableName[tableName] = mi
+}
+
 // add the given Model to the modelCollection
 func (mc *modelCollection) addSequence(s *Sequence) {
 	if _, exists := mc.GetSequence(s.JSON); exists {
@@ -131,9 +146,16 @@ type Model struct {
 	methods         *MethodsCollection
 	mixins          []*Model
 	sqlConstraints  map[string]sqlConstraint
+	sqlIndexes      map[string]sqlIndex
 	sqlErrors       map[string]string
 	defaultOrderStr []string
 	defaultOrder    []orderPredicate
+	nameFields      FieldNames
+	versionField    FieldName
+	keyFields       FieldNames
+	tombstones      bool
+	tombstoneModel  *Model
+	viewQuery       string
 	created         bool
 }
 
@@ -144,6 +166,14 @@ type sqlConstraint struct {
 	errorString string
 }
 
+// An sqlIndex holds the data needed to create an index on several
+// Columns of a table in the database
+type sqlIndex struct {
+	name   string
+	fields FieldNames
+	unique bool
+}
+
 // Name returns the name of this model
 func (m *Model) Name() string {
 	return m.name
@@ -248,7 +278,13 @@ func (m *Model) convertValuesToFieldType(fMap *FieldMap, writeDB bool) {
 		fi := m.getRelatedFieldInfo(m.FieldName(colName))
 		fType := fi.structField.Type
 		typedValue := reflect.New(fType).Interface()
-		err := typesutils.Convert(fMapValue, typedValue, fi.isRelationField())
+		var err error
+		switch fi.fieldType {
+		case fieldtype.JSON:
+			err = convertJSONValue(fMapValue, typedValue)
+		default:
+			err = typesutils.Convert(fMapValue, typedValue, fi.isRelationField())
+		}
 		if err != nil {
 			log.Panic(err.Error(), "model", m.name, "field", colName, "type", fType, "value", fMapValue)
 		}
@@ -268,6 +304,84 @@ func (m *Model) convertValuesToFieldType(fMap *FieldMap, writeDB bool) {
 	}
 }
 
+// convertJSONValue decodes the given value of a JSON field into target,
+// which must be a pointer to the Go type of the field. value can be the
+// raw JSON returned by the database or any value that can be marshalled
+// into the target type.
+func convertJSONValue(value interface{}, target interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, target)
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(v), target)
+	}
+	if reflect.TypeOf(value) == reflect.TypeOf(target).Elem() {
+		reflect.ValueOf(target).Elem().Set(reflect.ValueOf(value))
+		return nil
+	}
+	return json.Unmarshal([]byte(strutils.MarshalToJSONString(value)), target)
+}
+
+// checkSelectionValues panics if the value of a selection field in the
+// given FieldMap is not one of the keys of the field's selection.
+func (m *Model) checkSelectionValues(fMap FieldMap) {
+	for colName, fMapValue := range fMap {
+		fi := m.getRelatedFieldInfo(m.FieldName(colName))
+		if fi.fieldType != fieldtype.Selection || len(fi.selection) == 0 {
+			continue
+		}
+		val := reflect.ValueOf(fMapValue)
+		if val.Kind() != reflect.String || val.String() == "" {
+			continue
+		}
+		if _, ok := fi.selection[val.String()]; !ok {
+			panicValidationError("Invalid value for selection field", "model", m.name, "field", fi.name, "value", val.String())
+		}
+	}
+}
+
+// checkFieldMapKeys panics if a key of the given FieldMap does not resolve to
+// a field of this model. Keys can be field names or JSON names, or paths of
+// them for related fields.
+func (m *Model) checkFieldMapKeys(fMap FieldMap) {
+	for key := range fMap {
+		rmi := m
+		for _, expr := range strings.Split(key, ExprSep) {
+			if rmi == nil {
+				log.Panic("Unknown field in FieldMap", "model", m.name, "field", key)
+			}
+			fi, ok := rmi.fields.Get(expr)
+			if !ok {
+				log.Panic("Unknown field in FieldMap", "model", m.name, "field", key)
+			}
+			rmi = fi.relatedModel
+		}
+	}
+}
+
+// checkConditionFields panics if a field path used in the given condition
+// does not resolve to a field of this model.
+func (m *Model) checkConditionFields(cond *Condition) {
+	for _, exprs := range cond.getAllExpressions(m) {
+		rmi := m
+		for _, expr := range exprs {
+			if rmi == nil {
+				log.Panic("Unknown field in condition", "model", m.name, "field", joinFieldNames(exprs, ExprSep))
+			}
+			fi, ok := rmi.fields.Get(expr.JSON())
+			if !ok {
+				log.Panic("Unknown field in condition", "model", m.name, "field", joinFieldNames(exprs, ExprSep))
+			}
+			rmi = fi.relatedModel
+		}
+	}
+}
+
 // AddFields adds the given fields to the model.
 func (m *Model) AddFields(fields map[string]FieldDefinition) {
 	for name, field := range fields {
@@ -295,6 +409,11 @@ func (m *Model) IsManual() bool {
 	return false
 }
 
+// isView returns true if this is a SQL view model created with NewViewModel.
+func (m *Model) isView() bool {
+	return m.IsManual() && m.viewQuery != ""
+}
+
 // isSystem returns true if this is a system model.
 func (m *Model) isSystem() bool {
 	if m.options&SystemModel > 0 {
@@ -311,6 +430,14 @@ func (m *Model) isContext() bool {
 	return false
 }
 
+// isAttachment returns true if this is an attachment model.
+func (m *Model) isAttachment() bool {
+	if m.options&AttachmentModel > 0 {
+		return true
+	}
+	return false
+}
+
 // IsM2MLink returns true if this is an M2M Link model.
 func (m *Model) IsM2MLink() bool {
 	if m.options&Many2ManyLinkModel > 0 {
@@ -330,6 +457,18 @@ func (m *Model) hasParentField() bool {
 	return parentExists
 }
 
+// fullTextFields returns the fields of this model that are indexed
+// for full text search.
+func (m *Model) fullTextFields() []*Field {
+	var res []*Field
+	for _, fi := range m.fields.registryByJSON {
+		if fi.hasFullTextIndex() {
+			res = append(res, fi)
+		}
+	}
+	return res
+}
+
 // Fields returns the fields collection of this model
 func (m *Model) Fields() *FieldsCollection {
 	return m.fields
@@ -345,19 +484,143 @@ func (m *Model) Methods() *MethodsCollection {
 // default order is 'id asc'.
 //
 // Give the order fields in separate strings, such as
-// model.SetDefaultOrder("Name desc", "date asc", "id")
+// model.SetDefaultOrder("Name desc", "date asc", "id"). The fields are
+// checked at bootstrap and must be stored in database.
 func (m *Model) SetDefaultOrder(orders ...string) {
 	m.defaultOrderStr = orders
 }
 
-// ordersFromStrings returns the given order by exprs as a slice of order structs
+// SetNameFields sets the fields of this model that are used to search
+// records by name. By default, only the "Name" field is used if it exists.
+func (m *Model) SetNameFields(fields ...FieldName) {
+	m.nameFields = fields
+}
+
+// SetCompositeKey declares that the given fields together identify
+// a record of this model. A unique constraint is created in the
+// database on the corresponding columns.
+//
+// Records of such a model still have an ID, but they can also be
+// addressed by their key with RecordCollection.WithKeys.
+func (m *Model) SetCompositeKey(fields ...FieldName) {
+	if len(fields) < 2 {
+		log.Panic("A composite key must have at least two fields", "model", m.name, "fields", fields)
+	}
+	for _, field := range fields {
+		fi := m.fields.MustGet(field.Name())
+		if !fi.isStored() {
+			log.Panic("Composite key fields must be stored", "model", m.name, "field", field)
+		}
+	}
+	m.keyFields = fields
+}
+
+// addCompositeKeyConstraint adds to this model the unique constraint
+// on the columns of its composite key, if any.
+//
+// It is called when synchronising the database, once the table and
+// column names are final.
+func (m *Model) addCompositeKeyConstraint() {
+	if len(m.keyFields) == 0 {
+		return
+	}
+	adapter := adapters[db.DriverName()]
+	cols := make([]string, len(m.keyFields))
+	for i, field := range m.keyFields {
+		cols[i] = adapter.QuoteIdentifier(m.fields.MustGet(field.Name()).json)
+	}
+	constraintName := m.compositeKeyConstraintName()
+	m.sqlConstraints[constraintName] = sqlConstraint{
+		name:        constraintName,
+		sql:         fmt.Sprintf("UNIQUE (%s)", strings.Join(cols, ", ")),
+		errorString: "Another record already exists with the same key",
+	}
+}
+
+// CompositeKey returns the fields of the composite key of this
+// model or nil if this model has no composite key.
+func (m *Model) CompositeKey() FieldNames {
+	return m.keyFields
+}
+
+// compositeKeyConstraintName returns the name of the unique constraint
+// that enforces the composite key of this model in the database
+func (m *Model) compositeKeyConstraintName() string {
+	return fmt.Sprintf("%s_keycon", m.tableName)
+}
+
+// SetVersionField enables optimistic locking on this model with the given
+// integer field as version number.
+//
+// The version field is incremented at each update of a record. If the data
+// given to Write contains a value for the version field, the update only
+// succeeds if the records still have this version in the database, and
+// panics otherwise. Callers should therefore pass the version they read
+// to detect concurrent modifications.
+func (m *Model) SetVersionField(field FieldName) {
+	fi := m.fields.MustGet(field.Name())
+	if fi.fieldType != fieldtype.Integer {
+		log.Panic("Version field must be an integer field", "model", m.name, "field", field)
+	}
+	m.versionField = field
+}
+
+// EnableTombstones makes this model keep a log of the ids of its deleted
+// records with their deletion time, which can be retrieved with DeletedSince.
+//
+// This is meant for synchronizing external systems that need to know which
+// records have been removed. The log is kept in a dedicated table that is
+// only created for the models that call this method.
+func (m *Model) EnableTombstones() {
+	m.tombstones = true
+}
+
+// NameFields returns the fields of this model that are used to search
+// records by name.
+func (m *Model) NameFields() FieldNames {
+	if len(m.nameFields) > 0 {
+		return m.nameFields
+	}
+	if _, exists := m.fields.Get("Name"); exists {
+		return FieldNames{m.FieldName("Name")}
+	}
+	return nil
+}
+
+// nameCondition returns a condition that matches records of this
+// model with at least one name field matching name with the given operator.
+func (m *Model) nameCondition(op operator.Operator, name string) *Condition {
+	nameFields := m.NameFields()
+	if len(nameFields) == 0 {
+		log.Panic("Model has no name field to search on", "model", m.name)
+	}
+	cond := newCondition()
+	for _, nf := range nameFields {
+		cond = cond.Or().Field(nf).AddOperator(op, name)
+	}
+	return cond
+}
+
+// ordersFromStrings returns the given order by exprs as a slice of order structs.
+//
+// Each expr is a field name or a dot separated path to a field of a related model,
+// optionally followed by a direction, such as "Profile.Age desc".
 func (m *Model) ordersFromStrings(exprs []string) []orderPredicate {
 	res := make([]orderPredicate, len(exprs))
 	for i, o := range exprs {
-		toks := strings.Split(o, " ")
+		toks := strings.Fields(o)
+		if len(toks) == 0 || len(toks) > 2 {
+			log.Panic("Invalid order expression", "model", m.name, "expression", o)
+		}
 		var desc bool
-		if len(toks) > 1 && strings.ToLower(toks[1]) == "desc" {
-			desc = true
+		if len(toks) > 1 {
+			switch strings.ToLower(toks[1]) {
+			case "asc":
+			case "desc":
+				desc = true
+			default:
+				log.Panic("Invalid order direction", "model", m.name, "expression", o)
+			}
 		}
 		res[i] = orderPredicate{field: m.FieldName(toks[0]), desc: desc}
 	}
@@ -375,10 +638,19 @@ func (m *Model) JSONizeFieldName(fieldName string) string {
 // FieldName returns a FieldName for the field with the given name.
 // name may be a dot separated path from this model.
 // It returns nil if the name is empty and panics if the path is invalid.
+//
+// name can also be a field path followed by an alias, such as
+// "Profile.Age as age". The alias is then used as key by Read.
 func (m *Model) FieldName(name string) FieldName {
 	if name == "" {
 		return nil
 	}
+	if toks := strings.Fields(name); len(toks) == 3 && strings.EqualFold(toks[1], "as") {
+		return aliasedFieldName{
+			fieldName: fieldName{name: toks[0], json: jsonizePath(m, toks[0])},
+			alias:     toks[2],
+		}
+	}
 	jsonName := jsonizePath(m, name)
 	return fieldName{name: name, json: jsonName}
 }
@@ -445,7 +717,11 @@ func (m *Model) FieldsGet(fields ...FieldName) map[string]*FieldInfo {
 
 // FilteredOn adds a condition with a table join on the given field and
 // filters the result with the given condition
+//
+// It panics if condition has raw SQL fragments, since they cannot be applied
+// to the related model.
 func (m *Model) FilteredOn(field FieldName, condition *Condition) *Condition {
+	condition.checkNoRawSQL()
 	res := Condition{predicates: make([]predicate, len(condition.predicates))}
 	i := 0
 	for _, p := range condition.predicates {
@@ -498,6 +774,51 @@ func (m *Model) RemoveSQLConstraint(name string) {
 	delete(m.sqlConstraints, fmt.Sprintf("%s_mancon", name))
 }
 
+// AddIndex adds an index in the database on the columns of the given fields,
+// in this order. It speeds up the queries that filter or sort on these fields
+// together. Use the Index parameter of a field to index a single field.
+func (m *Model) AddIndex(fields ...FieldName) {
+	m.addIndex(fields, false)
+}
+
+// AddUniqueIndex adds a unique index in the database on the columns of the
+// given fields, so that no two records can have the same values for them.
+func (m *Model) AddUniqueIndex(fields ...FieldName) {
+	m.addIndex(fields, true)
+}
+
+// RemoveIndex removes the index on the given fields from the database.
+func (m *Model) RemoveIndex(fields ...FieldName) {
+	delete(m.sqlIndexes, m.indexName(fields))
+}
+
+// addIndex adds an index on the given fields to this model
+func (m *Model) addIndex(fields FieldNames, unique bool) {
+	if len(fields) == 0 {
+		log.Panic("No fields given for index", "model", m.name)
+	}
+	if m.sqlIndexes == nil {
+		m.sqlIndexes = make(map[string]sqlIndex)
+	}
+	name := m.indexName(fields)
+	m.sqlIndexes[name] = sqlIndex{
+		name:   name,
+		fields: fields,
+		unique: unique,
+	}
+}
+
+// indexName returns the name in the database of the index on the given fields
+func (m *Model) indexName(fields FieldNames) string {
+	cols := fields.JSON()
+	name := fmt.Sprintf("%s_%s_mindex", m.tableName, strings.Join(cols, "_"))
+	if len(name) > maxSQLidentifierLength {
+		// Keep the name unique within the length accepted by the database
+		name = fmt.Sprintf("%s_%08x_mindex", m.tableName, crc32.ChecksumIEEE([]byte(strings.Join(cols, "_"))))
+	}
+	return name
+}
+
 // TableName return the db table name
 func (m *Model) TableName() string {
 	return m.tableName
@@ -552,6 +873,23 @@ func NewManualModel(name string) *Model {
 	return model
 }
 
+// NewViewModel creates a read-only model backed by a SQL view defined by
+// the given SELECT query. The view is (re)created in the database when the
+// database is synchronized.
+//
+// The query must return an "id" column with unique values and a column for
+// each stored field of the model. Records of a view model can be searched,
+// ordered and grouped as those of other models, but creating, updating or
+// deleting them panics.
+func NewViewModel(name, query string) *Model {
+	if strings.TrimSpace(query) == "" {
+		log.Panic("SQL view models must have a query", "model", name)
+	}
+	model := NewManualModel(name)
+	model.viewQuery = query
+	return model
+}
+
 // InheritModel extends this Model by importing all fields and methods of mixInModel.
 // MixIn methods and fields have a lower priority than those of the model and are
 // overridden by the them when applicable.
@@ -601,6 +939,9 @@ type Sequence struct {
 	JSON      string
 	Increment int64
 	Start     int64
+	Prefix    string
+	Suffix    string
+	Padding   int
 	boot      bool
 }
 
@@ -622,7 +963,7 @@ func CreateSequence(name string, increment, start int64) *Sequence {
 	if !boot {
 		// Create the sequence on the fly if we already bootstrapped.
 		// Otherwise, this will be done in Bootstrap
-		adapters[db.DriverName()].createSequence(seq.JSON, seq.Increment, seq.Start)
+		adapters[db.DriverName()].CreateSequence(seq.JSON, seq.Increment, seq.Start)
 	}
 	Registry.addSequence(seq)
 	return seq
@@ -639,7 +980,7 @@ func (s *Sequence) Drop() {
 		if s.boot {
 			log.Panic("Boot Sequences cannot be dropped after bootstrap")
 		}
-		adapters[db.DriverName()].dropSequence(s.JSON)
+		adapters[db.DriverName()].DropSequence(s.JSON)
 	}
 }
 
@@ -660,14 +1001,47 @@ func (s *Sequence) Alter(increment, restart int64) {
 		s.Increment = increment
 	}
 	if !boot {
-		adapters[db.DriverName()].alterSequence(s.JSON, increment, restart)
+		adapters[db.DriverName()].AlterSequence(s.JSON, increment, restart)
 	}
 }
 
 // NextValue returns the next value of this Sequence
 func (s *Sequence) NextValue() int64 {
 	adapter := adapters[db.DriverName()]
-	return adapter.nextSequenceValue(s.JSON)
+	return adapter.NextSequenceValue(s.JSON)
+}
+
+// SetFormat sets the format of the values returned by NextFormattedValue.
+//
+// The number is padded with zeros to padding digits and surrounded by the
+// given prefix and suffix. Prefix and suffix may contain the following date
+// tokens: %(year)s, %(y)s, %(month)s and %(day)s.
+func (s *Sequence) SetFormat(prefix, suffix string, padding int) *Sequence {
+	s.Prefix = prefix
+	s.Suffix = suffix
+	s.Padding = padding
+	return s
+}
+
+// NextFormattedValue returns the next value of this Sequence formatted
+// according to its prefix, suffix and padding, such as "INV/2019/0001".
+//
+// Date tokens are replaced by the date of the transaction of the given
+// Environment, so that all references of a transaction share the same date.
+func (s *Sequence) NextFormattedValue(env Environment) string {
+	return s.formatValue(s.NextValue(), env.cr.Now().Time)
+}
+
+// formatValue returns the given value formatted according to the prefix,
+// suffix and padding of this Sequence. Date tokens are replaced by date t.
+func (s *Sequence) formatValue(value int64, t time.Time) string {
+	tokens := map[string]string{
+		"%(year)s":  t.Format("2006"),
+		"%(y)s":     t.Format("06"),
+		"%(month)s": t.Format("01"),
+		"%(day)s":   t.Format("02"),
+	}
+	return fmt.Sprintf("%s%0*d%s", strutils.Substitute(s.Prefix, tokens), s.Padding, value, strutils.Substitute(s.Suffix, tokens))
 }
 
 // FreeTransientModels remove transient models records from database which are
@@ -0,0 +1,49 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"reflect"
+
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+// Round returns the given value rounded to the nearest multiple of
+// precision, such as 0.01 to round to the cent or 0.05 for cash rounding.
+//
+// Halfway values are rounded half up by default. Use
+// nbutils.SetRoundingMode to use banker's rounding instead. This is the
+// same rounding as the one applied when writing float and decimal fields
+// with digits and when formatting numbers with i18n.Locale, so that
+// stored and displayed values always match.
+func Round(value float64, precision float64) float64 {
+	return nbutils.Round(value, precision)
+}
+
+// roundValues rounds the float and decimal values of the given FieldMap to
+// the digits of their field, if any. fMap values must have been converted
+// to the field types.
+func (m *Model) roundValues(fMap FieldMap) {
+	for colName, fMapValue := range fMap {
+		fi := m.getRelatedFieldInfo(m.FieldName(colName))
+		if fi.digits == (nbutils.Digits{}) {
+			continue
+		}
+		switch fi.fieldType {
+		case fieldtype.Float:
+			val := reflect.ValueOf(fMapValue)
+			if val.Kind() != reflect.Float32 && val.Kind() != reflect.Float64 {
+				continue
+			}
+			rounded := Round(val.Float(), fi.digits.ToPrecision())
+			fMap[colName] = reflect.ValueOf(rounded).Convert(val.Type()).Interface()
+		case fieldtype.Decimal:
+			if dec, ok := fMapValue.(decimals.Decimal); ok {
+				fMap[colName] = dec.Round(int32(fi.digits.Scale))
+			}
+		}
+	}
+}
@@ -33,7 +33,7 @@ var dbArgs = struct {
 	Debug    string
 }{}
 
-var TestAdapter dbAdapter
+var TestAdapter DBAdapter
 
 func TestMain(m *testing.M) {
 	initializeTests()
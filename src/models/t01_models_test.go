@@ -17,15 +17,24 @@ package models
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// writerTitleComputeCount counts the computations of the WriterTitle
+// field of Post.
+var writerTitleComputeCount int
+
 func testPrefixdUser(rc *RecordCollection, prefix string) []string {
 	var res []string
 	for _, u := range rc.Records() {
@@ -42,13 +51,24 @@ func TestModelDeclaration(t *testing.T) {
 		tag := NewModel("Tag")
 		cv := NewModel("Resume")
 		comment := NewModel("Comment")
+		company := NewModel("Company")
 		addressMI := NewMixinModel("AddressMixIn")
 		activeMI := NewMixinModel("ActiveMixIn")
 		viewModel := NewManualModel("UserView")
+		cityViewModel := NewViewModel("UserCityView", `
+			SELECT u.id, u.name, p.city
+			FROM "user" u
+				LEFT JOIN "profile" p ON p.id = u.profile_id`)
+		So(func() { NewViewModel("EmptyView", " ") }, ShouldPanic)
 		wizard := NewTransientModel("Wizard")
 
 		userModel.NewMethod("PrefixedUser", testPrefixdUser)
 
+		AddMethod("User", "NamesCount", func(rc *RecordCollection) int {
+			return len(rc.Records())
+		})
+		So(func() { AddMethod("User", "NamesCount", func(rc *RecordCollection) int { return 0 }) }, ShouldPanic)
+
 		userModel.Methods().MustGet("PrefixedUser").Extend(
 			func(rc *RecordCollection, prefix string) []string {
 				res := rc.Super().Call("PrefixedUser", prefix).([]string)
@@ -143,6 +163,12 @@ func TestModelDeclaration(t *testing.T) {
 				return res
 			})
 
+		userModel.NewMethod("SearchDecoratedName",
+			func(rc *RecordCollection, op operator.Operator, arg interface{}) *Condition {
+				name := strings.TrimPrefix(strings.Split(arg.(string), " [")[0], "User: ")
+				return rc.Model().Field(rc.Model().FieldName("Name")).AddOperator(op, name)
+			})
+
 		userModel.NewMethod("ComputeAge",
 			func(rc *RecordCollection) *ModelData {
 				res := NewModelData(rc.Model())
@@ -312,6 +338,15 @@ func TestModelDeclaration(t *testing.T) {
 				return NewModelData(rc.Model()).Set(rc.Model().FieldName("TagsNames"), res)
 			})
 
+		post.NewMethod("ComputeWriterTitle",
+			func(rc *RecordCollection) *ModelData {
+				writerTitleComputeCount++
+				writer := rc.Get(rc.Model().FieldName("User")).(RecordSet).Collection()
+				return NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("WriterTitle"), fmt.Sprintf("%s by %s",
+						rc.Get(rc.Model().FieldName("Title")), writer.Get(Registry.MustGet("User").FieldName("Name"))))
+			})
+
 		post.NewMethod("ComputeWriterAge",
 			func(rc *RecordCollection) *ModelData {
 				return NewModelData(rc.Model()).
@@ -319,6 +354,24 @@ func TestModelDeclaration(t *testing.T) {
 						rc.Get(rc.Model().FieldName("User")).(RecordSet).Collection().Get(Registry.MustGet("User").FieldName("Age")).(int16))
 			})
 
+		post.NewMethod("ComputeCommentsCount",
+			func(rc *RecordCollection) *ModelData {
+				return NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("CommentsCount"), int64(rc.Get(rc.Model().FieldName("Comments")).(RecordSet).Len()))
+			})
+
+		post.NewMethod("ComputeLazyCommentsCount",
+			func(rc *RecordCollection) *ModelData {
+				return NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("LazyCommentsCount"), int64(rc.Get(rc.Model().FieldName("Comments")).(RecordSet).Len()))
+			})
+
+		post.NewMethod("ComputeManualCommentsCount",
+			func(rc *RecordCollection) *ModelData {
+				return NewModelData(rc.Model()).
+					Set(rc.Model().FieldName("ManualCommentsCount"), int64(rc.Get(rc.Model().FieldName("Comments")).(RecordSet).Len()))
+			})
+
 		post.NewMethod("Init",
 			func(rc *RecordCollection) {})
 
@@ -332,7 +385,7 @@ func TestModelDeclaration(t *testing.T) {
 		tag.NewMethod("CheckNameDescription",
 			func(rc *RecordCollection) {
 				if rc.Get(rc.Model().FieldName("Name")).(string) == rc.Get(rc.Model().FieldName("Description")).(string) {
-					log.Panic("Tag name and description must be different")
+					panic(exceptions.UserError{Message: "Tag name and description must be different"})
 				}
 			})
 
@@ -366,6 +419,7 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 			compute:     "ComputeDecoratedName",
+			search:      "SearchDecoratedName",
 		})
 		userModel.fields.add(&Field{
 			model:       userModel,
@@ -442,6 +496,29 @@ func TestModelDeclaration(t *testing.T) {
 			reverseFK:        "User",
 			noCopy:           false,
 		})
+		friendsRelModel, friendsOurField, friendsTheirField := CreateM2MRelModelInfo("UserFriendRel", "User", "User", "User", "Friend", false)
+		userModel.fields.add(&Field{
+			model:            userModel,
+			name:             "Friends",
+			json:             "friends_ids",
+			fieldType:        fieldtype.Many2Many,
+			structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+			relatedModelName: "User",
+			m2mRelModel:      friendsRelModel,
+			m2mOurField:      friendsOurField,
+			m2mTheirField:    friendsTheirField,
+		})
+		userModel.fields.add(&Field{
+			model:            userModel,
+			name:             "FriendOf",
+			json:             "friend_of_ids",
+			fieldType:        fieldtype.Many2Many,
+			structField:      reflect.StructField{Type: reflect.TypeOf([]int64{})},
+			relatedModelName: "User",
+			m2mRelModel:      friendsRelModel,
+			m2mOurField:      friendsTheirField,
+			m2mTheirField:    friendsOurField,
+		})
 		userModel.fields.add(&Field{
 			model:          userModel,
 			name:           "PMoney",
@@ -553,6 +630,9 @@ func TestModelDeclaration(t *testing.T) {
 		})
 		userModel.AddSQLConstraint("nums_premium", "CHECK((is_premium = TRUE AND nums IS NOT NULL AND nums > 0) OR (IS_PREMIUM = false))",
 			"Premium users must have positive nums")
+		userModel.AddIndex(userModel.Fields().MustGet("Email"), userModel.Fields().MustGet("Nums"))
+		userModel.AddUniqueIndex(userModel.Fields().MustGet("Name"), userModel.Fields().MustGet("Email"))
+		userModel.SetCompositeKey(userModel.Fields().MustGet("Name"), userModel.Fields().MustGet("Email"))
 
 		profileModel.fields.add(&Field{
 			model:       profileModel,
@@ -578,6 +658,13 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf(float64(0))},
 			defaultFunc: DefaultValue(0),
 		})
+		profileModel.fields.add(&Field{
+			model:       profileModel,
+			name:        "Data",
+			json:        "data",
+			fieldType:   fieldtype.JSON,
+			structField: reflect.StructField{Type: reflect.TypeOf(map[string]interface{}{})},
+		})
 		profileModel.fields.add(&Field{
 			model:            profileModel,
 			name:             "User",
@@ -611,6 +698,14 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.Char,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
+		profileModel.fields.add(&Field{
+			model:       profileModel,
+			name:        "Picture",
+			json:        "picture",
+			fieldType:   fieldtype.Binary,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			attachment:  true,
+		})
 		profileModel.fields.add(&Field{
 			model:          profileModel,
 			name:           "UserName",
@@ -643,8 +738,22 @@ func TestModelDeclaration(t *testing.T) {
 			fieldType:   fieldtype.HTML,
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 			required:    true,
+			fullText:    "english",
 		})
 		m2mRelModel, m2mOurField, m2mTheirField := CreateM2MRelModelInfo("PostTagRel", "Post", "Tag", "Post", "Tag", false)
+		So(m2mRelModel.tableName, ShouldEqual, "post_tag_rel")
+		So(m2mOurField.json, ShouldEqual, "post_id")
+		So(m2mTheirField.json, ShouldEqual, "tag_id")
+		linkRelModel, linkOurField, linkTheirField := CreateM2MRelModelInfo("PostTagLegacyRel", "Post", "Tag", "Post", "Tag", false)
+		SetM2MRelModelNames(linkRelModel, linkOurField, linkTheirField, "post_tag_link", "post_ref", "")
+		So(linkRelModel.tableName, ShouldEqual, "post_tag_link")
+		So(Registry.MustGet("post_tag_link"), ShouldEqual, linkRelModel)
+		So(linkOurField.json, ShouldEqual, "post_ref")
+		So(linkRelModel.fields.MustGet("post_ref"), ShouldEqual, linkOurField)
+		So(linkTheirField.json, ShouldEqual, "tag_id")
+		So(func() { SetM2MRelModelNames(linkRelModel, linkOurField, linkTheirField, "", "", "post_ref") }, ShouldPanic)
+		So(func() { SetM2MRelModelNames(linkRelModel, linkOurField, linkTheirField, "post_tag_rel", "", "") }, ShouldPanic)
+		So(func() { SetM2MRelModelNames(post, nil, nil, "post_link", "", "") }, ShouldPanic)
 		post.fields.add(&Field{
 			model:            post,
 			name:             "Tags",
@@ -715,6 +824,38 @@ func TestModelDeclaration(t *testing.T) {
 			structField:    reflect.StructField{Type: reflect.TypeOf("")},
 			relatedPathStr: "Comments.Text",
 		})
+		post.fields.add(&Field{
+			model:       post,
+			name:        "CommentsCount",
+			json:        "comments_count",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			compute:     "ComputeCommentsCount",
+			depends:     []string{"Comments"},
+			stored:      true,
+		})
+		post.fields.add(&Field{
+			model:         post,
+			name:          "LazyCommentsCount",
+			json:          "lazy_comments_count",
+			fieldType:     fieldtype.Integer,
+			structField:   reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			compute:       "ComputeLazyCommentsCount",
+			depends:       []string{"Comments"},
+			stored:        true,
+			recomputeMode: RecomputeLazy,
+		})
+		post.fields.add(&Field{
+			model:         post,
+			name:          "ManualCommentsCount",
+			json:          "manual_comments_count",
+			fieldType:     fieldtype.Integer,
+			structField:   reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			compute:       "ComputeManualCommentsCount",
+			depends:       []string{"Comments"},
+			stored:        true,
+			recomputeMode: RecomputeManual,
+		})
 		post.fields.add(&Field{
 			model:          post,
 			name:           "LastTagName",
@@ -731,6 +872,15 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 			compute:     "ComputeTagsNames",
 		})
+		post.fields.add(&Field{
+			model:       post,
+			name:        "WriterTitle",
+			json:        "writer_title",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+			compute:     "ComputeWriterTitle",
+			depends:     []string{"Title", "User", "User.Name"},
+		})
 		post.fields.add(&Field{
 			model:       post,
 			name:        "WriterAge",
@@ -751,8 +901,51 @@ func TestModelDeclaration(t *testing.T) {
 			relatedPathStr: "User.PMoney",
 			defaultFunc:    DefaultValue(0),
 		})
+		post.fields.add(&Field{
+			model:            post,
+			name:             "Company",
+			json:             "company_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         SetNull,
+			relatedModelName: "Company",
+		})
 		post.SetDefaultOrder("Title")
 
+		company.fields.add(&Field{
+			model:       company,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		company.fields.add(&Field{
+			model:         company,
+			name:          "Capital",
+			json:          "capital",
+			fieldType:     fieldtype.Decimal,
+			structField:   reflect.StructField{Type: reflect.TypeOf(decimals.Decimal{})},
+			digits:        nbutils.Digits{Precision: 20, Scale: 4},
+			groupOperator: "sum",
+			defaultFunc:   DefaultValue(decimals.Decimal{}),
+		})
+		company.fields.add(&Field{
+			model:       company,
+			name:        "Code",
+			json:        "code",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		company.fields.add(&Field{
+			model:       company,
+			name:        "Revenue",
+			json:        "revenue",
+			fieldType:   fieldtype.Float,
+			structField: reflect.StructField{Type: reflect.TypeOf(float64(0))},
+			digits:      nbutils.Digits{Precision: 12, Scale: 2},
+			defaultFunc: DefaultValue(0),
+		})
+
 		comment.fields.add(&Field{
 			model:            comment,
 			name:             "Post",
@@ -826,6 +1019,16 @@ func TestModelDeclaration(t *testing.T) {
 			onDelete:         SetNull,
 			relatedModelName: "Tag",
 		})
+		tag.fields.add(&Field{
+			model:            tag,
+			name:             "SoftPost",
+			json:             "soft_post_id",
+			fieldType:        fieldtype.Many2One,
+			structField:      reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			onDelete:         SetNull,
+			noForeignKey:     true,
+			relatedModelName: "Post",
+		})
 		tag.fields.add(&Field{
 			model:       tag,
 			name:        "Description",
@@ -860,7 +1063,15 @@ func TestModelDeclaration(t *testing.T) {
 			constraint:  "CheckRate",
 			defaultFunc: DefaultValue(0),
 		})
+		tag.fields.add(&Field{
+			model:       tag,
+			name:        "Order",
+			json:        "order",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+		})
 		tag.SetDefaultOrder("Name DESC", "ID ASC")
+		tag.EnableTombstones()
 
 		cv.fields.add(&Field{
 			model:       cv,
@@ -895,6 +1106,15 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 			compute:     "ComputeOther",
 		})
+		cv.fields.add(&Field{
+			model:       cv,
+			name:        "Version",
+			json:        "version",
+			fieldType:   fieldtype.Integer,
+			structField: reflect.StructField{Type: reflect.TypeOf(int64(0))},
+			defaultFunc: DefaultValue(0),
+		})
+		cv.SetVersionField(cv.FieldName("Version"))
 
 		addressMI.fields.add(&Field{
 			model:       addressMI,
@@ -944,6 +1164,21 @@ func TestModelDeclaration(t *testing.T) {
 			structField: reflect.StructField{Type: reflect.TypeOf("")},
 		})
 
+		cityViewModel.fields.add(&Field{
+			model:       cityViewModel,
+			name:        "Name",
+			json:        "name",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+		cityViewModel.fields.add(&Field{
+			model:       cityViewModel,
+			name:        "City",
+			json:        "city",
+			fieldType:   fieldtype.Char,
+			structField: reflect.StructField{Type: reflect.TypeOf("")},
+		})
+
 		wizard.fields.add(&Field{
 			model:       wizard,
 			name:        "Name",
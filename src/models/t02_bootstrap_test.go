@@ -128,6 +128,9 @@ func TestFieldModification(t *testing.T) {
 		checkUpdates(numsField, "unique", true)
 		numsField.SetUnique(false)
 		checkUpdates(numsField, "unique", false)
+		codeField := Registry.MustGet("Company").Fields().MustGet("Code")
+		codeField.SetReadOnlyAfterCreate(true)
+		checkUpdates(codeField, "readOnlyAfterCreate", true)
 		nameField := Registry.MustGet("User").Fields().MustGet("Name")
 		nameField.SetSize(127)
 		checkUpdates(nameField, "size", 127)
@@ -369,7 +372,7 @@ func TestBootStrap(t *testing.T) {
 
 	Convey("Database creation should run fine", t, func() {
 		Convey("Dummy table should exist", func() {
-			So(TestAdapter.tables(), ShouldContainKey, "shouldbedeleted")
+			So(TestAdapter.Tables(), ShouldContainKey, "shouldbedeleted")
 		})
 		Convey("Bootstrap should not panic", func() {
 			BootStrap()
@@ -379,6 +382,18 @@ func TestBootStrap(t *testing.T) {
 			So(BootStrapped(), ShouldBeTrue)
 			So(BootStrap, ShouldPanic)
 		})
+		Convey("Default order with non stored fields should panic", func() {
+			userModel := Registry.MustGet("User")
+			orderStr := userModel.defaultOrderStr
+			userModel.SetDefaultOrder("DecoratedName")
+			So(updateDefaultOrder, ShouldPanic)
+			userModel.SetDefaultOrder("Profile.Age desc", "Name")
+			So(updateDefaultOrder, ShouldNotPanic)
+			So(userModel.defaultOrder, ShouldHaveLength, 2)
+			So(userModel.defaultOrder[0].desc, ShouldBeTrue)
+			userModel.SetDefaultOrder(orderStr...)
+			updateDefaultOrder()
+		})
 		Convey("Creating methods after bootstrap should panic", func() {
 			So(func() {
 				Registry.MustGet("User").NewMethod("NewMethod", func(rc *RecordCollection) {})
@@ -394,8 +409,19 @@ func TestBootStrap(t *testing.T) {
 					)`)
 			}, ShouldNotPanic)
 		})
+		Convey("SQL view models should have a DB view", func() {
+			var count int
+			So(db.Get(&count, "SELECT COUNT(*) FROM information_schema.views WHERE table_name = 'user_city_view'"), ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+		Convey("Many2many link tables should use custom names", func() {
+			var columns []string
+			So(db.Select(&columns, "SELECT column_name FROM information_schema.columns WHERE table_name = 'post_tag_link'"), ShouldBeNil)
+			So(columns, ShouldContain, "post_ref")
+			So(columns, ShouldContain, "tag_id")
+		})
 		Convey("All models should have a DB table", func() {
-			dbTables := TestAdapter.tables()
+			dbTables := TestAdapter.Tables()
 			for tableName, mi := range Registry.registryByTableName {
 				if mi.IsMixin() || mi.IsManual() {
 					continue
@@ -404,21 +430,37 @@ func TestBootStrap(t *testing.T) {
 			}
 		})
 		Convey("All DB tables should have a model", func() {
-			for dbTable := range TestAdapter.tables() {
+			for dbTable := range TestAdapter.Tables() {
 				So(Registry.registryByTableName, ShouldContainKey, dbTable)
 			}
 		})
 		Convey("Table constraints should have been created", func() {
-			So(TestAdapter.constraints("%_mancon"), ShouldHaveLength, 1)
-			So(TestAdapter.constraints("%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
+			So(TestAdapter.Constraints("%_mancon"), ShouldHaveLength, 1)
+			So(TestAdapter.Constraints("%_mancon")[0], ShouldEqual, "nums_premium_user_mancon")
+		})
+		Convey("Composite key constraints should have been created", func() {
+			So(TestAdapter.ConstraintExists("user_keycon"), ShouldBeTrue)
+			So(TestAdapter.ConstraintExists("post_tag_link_keycon"), ShouldBeTrue)
+		})
+		Convey("Foreign key constraints should have been created", func() {
+			So(TestAdapter.ConstraintExists("post_user_id_fkey"), ShouldBeTrue)
+			So(TestAdapter.ConstraintExists("tag_parent_id_fkey"), ShouldBeTrue)
+			So(TestAdapter.ConstraintExists("tag_soft_post_id_fkey"), ShouldBeFalse)
+		})
+		Convey("Table indexes should have been created", func() {
+			So(TestAdapter.IndexExists("user", "user_email_nums_mindex"), ShouldBeTrue)
+			So(TestAdapter.IndexExists("user", "user_name_email_mindex"), ShouldBeTrue)
+			So(TestAdapter.Indexes("user", "%_mindex"), ShouldHaveLength, 2)
+			So(TestAdapter.IndexExists("post", "post_user_id_index"), ShouldBeTrue)
+			So(TestAdapter.IndexExists("user", "user_nums_index"), ShouldBeTrue)
 		})
 		Convey("Boot Sequence should be created", func() {
-			So(TestAdapter.sequences("%_bootseq"), ShouldHaveLength, 1)
-			So(TestAdapter.sequences("%_bootseq")[0].Name, ShouldEqual, "test_sequence_bootseq")
+			So(TestAdapter.Sequences("%_bootseq"), ShouldHaveLength, 1)
+			So(TestAdapter.Sequences("%_bootseq")[0].Name, ShouldEqual, "test_sequence_bootseq")
 		})
 		Convey("Manual sequences should be loaded in registry", func() {
-			So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 1)
-			So(TestAdapter.sequences("%_manseq")[0].Name, ShouldEqual, "test_manseq")
+			So(TestAdapter.Sequences("%_manseq"), ShouldHaveLength, 1)
+			So(TestAdapter.Sequences("%_manseq")[0].Name, ShouldEqual, "test_manseq")
 			seq, ok := Registry.GetSequence("Test")
 			So(ok, ShouldBeTrue)
 			So(seq.JSON, ShouldEqual, "test_manseq")
@@ -445,11 +487,16 @@ func TestBootStrap(t *testing.T) {
 			})
 			textField := Registry.MustGet("Comment").Fields().MustGet("Text")
 			textField.SetFieldType(fieldtype.Text)
+			userModel := Registry.MustGet("User")
+			userModel.RemoveIndex(userModel.Fields().MustGet("Email"), userModel.Fields().MustGet("Nums"))
 			So(BootStrap, ShouldNotPanic)
 			So(contentField.required, ShouldBeFalse)
 			So(profileField.required, ShouldBeFalse)
 			So(numsField.index, ShouldBeFalse)
 			So(SyncDatabase, ShouldNotPanic)
+			So(TestAdapter.IndexExists("user", "user_nums_index"), ShouldBeFalse)
+			So(TestAdapter.IndexExists("user", "user_email_nums_mindex"), ShouldBeFalse)
+			So(TestAdapter.IndexExists("user", "user_name_email_mindex"), ShouldBeTrue)
 		})
 	})
 
@@ -5,9 +5,13 @@ package models
 
 import (
 	"encoding/json"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -83,6 +87,69 @@ func TestTypes(t *testing.T) {
 				So(keys, ShouldContain, false)
 			})
 		})
+		Convey("Converting FieldMaps from and to url.Values", func() {
+			userModel := Registry.MustGet("User")
+			values := url.Values{
+				"Name":       {"John Smith"},
+				"nums":       {"13"},
+				"Size":       {"1.78"},
+				"IsStaff":    {"false", "on"},
+				"profile_id": {"4"},
+				"Posts":      {"1", "2", ""},
+			}
+			fMap, err := FieldMapFromValues(userModel, values)
+			So(err, ShouldBeNil)
+			So(fMap, ShouldHaveLength, 6)
+			So(fMap["name"], ShouldEqual, "John Smith")
+			So(fMap["nums"], ShouldEqual, 13)
+			So(fMap["size"], ShouldEqual, 1.78)
+			So(fMap["is_staff"], ShouldEqual, true)
+			So(fMap["profile_id"], ShouldEqual, 4)
+			So(fMap["posts_ids"], ShouldResemble, []int64{1, 2})
+			back := FieldMapToValues(userModel, fMap)
+			So(back["nums"], ShouldResemble, []string{"13"})
+			So(back["is_staff"], ShouldResemble, []string{"true"})
+			So(back["profile_id"], ShouldResemble, []string{"4"})
+			So(back["posts_ids"], ShouldResemble, []string{"1", "2"})
+			fMap2, err := FieldMapFromValues(userModel, back)
+			So(err, ShouldBeNil)
+			So(fMap2, ShouldResemble, fMap)
+			lastRead, err := FieldMapFromValues(Registry.MustGet("Post"), url.Values{"LastRead": {"2019-04-12"}})
+			So(err, ShouldBeNil)
+			So(lastRead["last_read"], ShouldResemble, dates.ParseDate("2019-04-12"))
+			So(FieldMapToValues(Registry.MustGet("Post"), FieldMap{"last_read": dates.Date{}})["last_read"], ShouldResemble, []string{""})
+			_, err = FieldMapFromValues(userModel, url.Values{"Name": {"John"}, "Unknown": {"x"}, "foo": {"y"}})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Unknown, foo")
+			_, err = FieldMapFromValues(userModel, url.Values{"Nums": {"thirteen"}})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Rounding values", func() {
+			So(Round(12.345, 0.01), ShouldEqual, 12.35)
+			So(Round(1.02, 0.05), ShouldEqual, 1)
+			So(Round(1.03, 0.05), ShouldEqual, 1.05)
+			So(Round(1.125, 0.05), ShouldEqual, 1.15)
+			So(Round(-1.125, 0.05), ShouldEqual, -1.15)
+			companyModel := Registry.MustGet("Company")
+			fMap := FieldMap{
+				"revenue": 12.345,
+				"capital": decimals.MustParse("1.23455"),
+				"name":    "Rounded",
+			}
+			companyModel.roundValues(fMap)
+			So(fMap["revenue"], ShouldEqual, 12.35)
+			So(fMap["capital"].(decimals.Decimal).String(), ShouldEqual, "1.2346")
+			So(fMap["name"], ShouldEqual, "Rounded")
+		})
+		Convey("Comparing field values", func() {
+			So(valuesEqual(decimals.MustParse("2.5"), decimals.MustParse("2.50")), ShouldBeTrue)
+			So(valuesEqual(decimals.MustParse("2.5"), decimals.MustParse("2.51")), ShouldBeFalse)
+			So(valuesEqual(decimals.MustParse("2.5"), 2.5), ShouldBeFalse)
+			now := dates.Now()
+			So(valuesEqual(now, now.In(time.FixedZone("UTC+2", 7200))), ShouldBeTrue)
+			So(valuesEqual("a", "a"), ShouldBeTrue)
+			So(valuesEqual([]int64{1, 2}, []int64{1, 2}), ShouldBeTrue)
+		})
 		Convey("Checking ModelData methods", func() {
 			numsField := Registry.MustGet("User").FieldName("Nums")
 			johnValues := NewModelData(Registry.MustGet("User")).
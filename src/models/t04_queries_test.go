@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -97,20 +98,20 @@ func TestConditions(t *testing.T) {
 				Convey("Simple query with database field names", func() {
 					rs = env.Pool("User").Search(rs.Model().FilteredOn(profile, env.Pool("Profile").Model().Field(bestPostTitle).Equals("foo"))).OrderBy("ID")
 					sql, args, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "T2".title AS profile_id__best_post_id__title, "user".id AS id FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id LEFT JOIN "post" "T2" ON "T1".best_post_id="T2".id  WHERE "T2".title = ? ORDER BY "user".id ) foo ORDER BY id `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "T2"."title" AS "profile_id__best_post_id__title", "user"."id" AS "id" FROM "user" "user" LEFT JOIN "profile" "T1" ON "user"."profile_id"="T1"."id" LEFT JOIN "post" "T2" ON "T1"."best_post_id"="T2"."id"  WHERE "T2"."title" = ? ORDER BY "user"."id" ) foo ORDER BY "id" `)
 					So(args, ShouldContain, "foo")
 				})
 				Convey("Simple query with struct field names", func() {
 					fields = []FieldName{Name, fieldName{name: "Profile.BestPost.Title", json: "profile_id.best_post_id.title"}}
 					sql, args, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "T2".title AS profile_id__best_post_id__title FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id LEFT JOIN "post" "T2" ON "T1".best_post_id="T2".id  WHERE "T2".title = ? ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "T2"."title" AS "profile_id__best_post_id__title" FROM "user" "user" LEFT JOIN "profile" "T1" ON "user"."profile_id"="T1"."id" LEFT JOIN "post" "T2" ON "T1"."best_post_id"="T2"."id"  WHERE "T2"."title" = ? ORDER BY "user"."id" ) foo  `)
 					So(args, ShouldContain, "foo")
 				})
 				Convey("Query with one2many relations", func() {
 					rso2m := env.Pool("User").Search(rs.Model().Field(postsTitle).Equals("1st post"))
 					fields = []FieldName{Name}
 					sql, args, _ := rso2m.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user" LEFT JOIN "post" "T1" ON "user".id="T1".user_id  WHERE "T1".title = ? ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user" LEFT JOIN "post" "T1" ON "user"."id"="T1"."user_id"  WHERE "T1"."title" = ? ORDER BY "user"."id" ) foo  `)
 					So(args, ShouldContain, "1st post")
 				})
 				Convey("Simple query with args inflation", func() {
@@ -120,7 +121,7 @@ func TestConditions(t *testing.T) {
 					rs2 := env.Pool("User").Search(rs.Model().Field(nums).Equals(getUserID))
 					fields = []FieldName{Name}
 					sql, args, _ := rs2.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user"  WHERE "user".nums = ? ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user"  WHERE "user"."nums" = ? ORDER BY "user"."id" ) foo  `)
 					So(len(args), ShouldEqual, 1)
 					So(args, ShouldContain, security.SuperUserID)
 				})
@@ -128,14 +129,14 @@ func TestConditions(t *testing.T) {
 					rs3 := env.Pool("User").Search(rs.Model().Field(isStaff).Equals(true))
 					fields = []FieldName{Name}
 					sql, args, _ := rs3.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user"  WHERE "user".is_staff = ? ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user"  WHERE "user"."is_staff" = ? ORDER BY "user"."id" ) foo  `)
 					So(len(args), ShouldEqual, 1)
 					So(args, ShouldContain, true)
 				})
 				Convey("Check WHERE clause with additionnal filter", func() {
 					rs = rs.Search(rs.Model().Field(profileAge).GreaterOrEqual(12))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user__profile__post".title = ?) AND ("user__profile".age >= ?)`)
+					So(sql, ShouldEqual, `WHERE ("user__profile__post"."title" = ?) AND ("user__profile"."age" >= ?)`)
 					So(args, ShouldContain, 12)
 					So(args, ShouldContain, "foo")
 				})
@@ -144,53 +145,89 @@ func TestConditions(t *testing.T) {
 					c2 := rs.Model().Field(Name).Contains("jane").Or().Field(profileMoney).Lower(1234.56)
 					rs = rs.Search(c2)
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE (("user__profile__post".title = ?) AND ("user__profile".age >= ?)) AND ("user".name LIKE ? OR "user__profile".money < ?)`)
+					So(sql, ShouldEqual, `WHERE (("user__profile__post"."title" = ?) AND ("user__profile"."age" >= ?)) AND ("user"."name" LIKE ? OR "user__profile"."money" < ?)`)
 					So(args, ShouldContain, "%jane%")
 					So(args, ShouldContain, 1234.56)
 					sql, _, _ = rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "T2".title AS profile_id__best_post_id__title FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id LEFT JOIN "post" "T2" ON "T1".best_post_id="T2".id  WHERE (("T2".title = ?) AND ("T1".age >= ?)) AND ("user".name LIKE ? OR "T1".money < ?) ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "T2"."title" AS "profile_id__best_post_id__title" FROM "user" "user" LEFT JOIN "profile" "T1" ON "user"."profile_id"="T1"."id" LEFT JOIN "post" "T2" ON "T1"."best_post_id"="T2"."id"  WHERE (("T2"."title" = ?) AND ("T1"."age" >= ?)) AND ("user"."name" LIKE ? OR "T1"."money" < ?) ORDER BY "user"."id" ) foo  `)
 				})
 				Convey("Check multi-join queries", func() {
 					rs = rs.Search(rs.Model().Field(profileAge).GreaterOrEqual(12))
 					c2 := rs.Model().Field(Name).Contains("jane").Or().Field(resumeEducation).Contains("MIT")
 					rs = rs.Search(c2)
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE (("user__profile__post".title = ?) AND ("user__profile".age >= ?)) AND ("user".name LIKE ? OR "user__resume".education LIKE ?)`)
+					So(sql, ShouldEqual, `WHERE (("user__profile__post"."title" = ?) AND ("user__profile"."age" >= ?)) AND ("user"."name" LIKE ? OR "user__resume"."education" LIKE ?)`)
 					So(args, ShouldContain, "%jane%")
 					So(args, ShouldContain, "%MIT%")
 					sql, _, _ = rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "T2".title AS profile_id__best_post_id__title FROM "user" "user" LEFT JOIN "profile" "T1" ON "user".profile_id="T1".id LEFT JOIN "post" "T2" ON "T1".best_post_id="T2".id LEFT JOIN "resume" "T3" ON "user".resume_id="T3".id  WHERE (("T2".title = ?) AND ("T1".age >= ?)) AND ("user".name LIKE ? OR "T3".education LIKE ?) ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "T2"."title" AS "profile_id__best_post_id__title" FROM "user" "user" LEFT JOIN "profile" "T1" ON "user"."profile_id"="T1"."id" LEFT JOIN "post" "T2" ON "T1"."best_post_id"="T2"."id" LEFT JOIN "resume" "T3" ON "user"."resume_id"="T3"."id"  WHERE (("T2"."title" = ?) AND ("T1"."age" >= ?)) AND ("user"."name" LIKE ? OR "T3"."education" LIKE ?) ORDER BY "user"."id" ) foo  `)
 				})
 				Convey("Testing query without WHERE clause", func() {
 					rs = env.Pool("User").Load()
 					fields = []FieldName{Name}
 					sql, _, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user"   ORDER BY "user".id ) foo  `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user"   ORDER BY "user"."id" ) foo  `)
 				})
 				Convey("Testing query with LIMIT clause", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).Call("Limit", 1).(RecordSet).Collection().Load()
 					fields = []FieldName{Name}
 					sql, _, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY id LIMIT 1 `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "user"."id" AS "id" FROM "user" "user"  WHERE "user"."email" ILIKE ? ORDER BY "user"."id" ) foo ORDER BY "id" LIMIT 1 `)
 				})
 				Convey("Testing query with LIMIT and OFFSET clauses", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).Call("Limit", 1).(RecordSet).Collection().Call("Offset", 2).(RecordSet).Collection().Load()
 					fields = []FieldName{Name}
 					sql, _, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY id LIMIT 1 OFFSET 2`)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "user"."id" AS "id" FROM "user" "user"  WHERE "user"."email" ILIKE ? ORDER BY "user"."id" ) foo ORDER BY "id" LIMIT 1 OFFSET 2`)
+				})
+				Convey("Testing query with zero or negative LIMIT and negative OFFSET", func() {
+					base := env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com"))
+					fields = []FieldName{Name}
+					baseSQL, _, _ := base.query.selectQuery(fields)
+					for _, rSet := range []*RecordCollection{base.Limit(0), base.Limit(-1), base.Offset(-5), base.Limit(-1).Offset(-5)} {
+						sql, _, _ := rSet.query.selectQuery(fields)
+						So(sql, ShouldEqual, baseSQL)
+						So(sql, ShouldNotContainSubstring, "LIMIT")
+						So(sql, ShouldNotContainSubstring, "OFFSET")
+					}
+					So(base.Limit(-1).Len(), ShouldEqual, 1)
+					So(base.Offset(-5).Len(), ShouldEqual, 1)
 				})
 				Convey("Testing query with ORDER BY clauses", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).Call("OrderBy", []string{"Email", "ID"}).(RecordSet).Collection().Load()
 					fields = []FieldName{Name}
 					sql, _, _ := rs.query.selectQuery(fields)
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name, "user".email AS email, "user".id AS id FROM "user" "user"  WHERE "user".email ILIKE ? ORDER BY "user".id ) foo ORDER BY email, id `)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name", "user"."email" AS "email", "user"."id" AS "id" FROM "user" "user"  WHERE "user"."email" ILIKE ? ORDER BY "user"."id" ) foo ORDER BY "email", "id" `)
+				})
+				Convey("Testing SQL inspection of a RecordSet", func() {
+					rs = env.Pool("User").Search(rs.Model().Field(email).IContains("jane.smith@example.com")).OrderBy("Email")
+					sql, args := rs.ToSQL()
+					So(sql, ShouldStartWith, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") `)
+					So(sql, ShouldContainSubstring, `WHERE "user"."email" ILIKE $1`)
+					So(sql, ShouldContainSubstring, `ORDER BY "email"`)
+					So(args, ShouldResemble, []interface{}{"%jane.smith@example.com%"})
+					sql, args = rs.CountSQL()
+					So(sql, ShouldStartWith, `SELECT COUNT(*) FROM (SELECT * FROM (SELECT DISTINCT ON ("user"."id") `)
+					So(sql, ShouldContainSubstring, `WHERE "user"."email" ILIKE $1`)
+					So(args, ShouldResemble, []interface{}{"%jane.smith@example.com%"})
+					So(rs.fetched, ShouldBeFalse)
+				})
+				Convey("Testing conditions on self-referencing many2many", func() {
+					friendsName := fieldName{name: "Friends.Name", json: "friends_ids.name"}
+					friendOfName := fieldName{name: "FriendOf.Name", json: "friend_of_ids.name"}
+					rs = env.Pool("User").Search(rs.Model().Field(friendsName).Equals("John").
+						Or().Field(friendOfName).Equals("Will"))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user__user_friend_rel__user_id__user"."name" = ? OR "user__user_friend_rel__friend_id__user"."name" = ?`)
+					So(args, ShouldContain, "John")
+					So(args, ShouldContain, "Will")
 				})
 				Convey("Testing complex conditions", func() {
 					rs = env.Pool("User").Search(rs.Model().Field(profileAge).GreaterOrEqual(12).
 						AndNot().Field(Name).IContains("Jane").
 						OrNot().FilteredOn(profile, env.Pool("Profile").Model().Field(age).Equals(20)))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user__profile".age >= ? AND NOT "user".name ILIKE ? OR NOT "user__profile".age = ?`)
+					So(sql, ShouldEqual, `WHERE "user__profile"."age" >= ? AND NOT "user"."name" ILIKE ? OR NOT "user__profile"."age" = ?`)
 					So(args, ShouldContain, 12)
 					So(args, ShouldContain, "%Jane%")
 					So(args, ShouldContain, 20)
@@ -201,7 +238,7 @@ func TestConditions(t *testing.T) {
 							AndNotCond(cond1).
 							OrNotCond(cond2))
 					sql, args = rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE (("user".age >= ?) AND NOT ("user".name ILIKE ?)) OR NOT ("user".name ILIKE ?)`)
+					So(sql, ShouldEqual, `WHERE (("user"."age" >= ?) AND NOT ("user"."name" ILIKE ?)) OR NOT ("user"."name" ILIKE ?)`)
 					So(args, ShouldContain, 30)
 					So(args, ShouldContain, "%Jane%")
 					So(args, ShouldContain, "%John%")
@@ -219,116 +256,184 @@ func TestConditions(t *testing.T) {
 					res := rs.CallMulti("SQLFromCondition", cond)
 					sql := res[0]
 					args := res[1]
-					So(sql, ShouldEqual, `"user".name = ?`)
+					So(sql, ShouldEqual, `"user"."name" = ?`)
 					So(args, ShouldContain, "John")
 				})
+				Convey("IEquals", func() {
+					rs = rs.Search(rs.Model().Field(email).IEquals("John@Example.com"))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE LOWER("user"."email") = LOWER(?)`)
+					So(args, ShouldContain, "John@Example.com")
+				})
 				Convey("NotEquals", func() {
 					rs = rs.Search(rs.Model().Field(Name).NotEquals("John"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NULL OR "user".name != ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NULL OR "user"."name" != ?)`)
 					So(args, ShouldContain, "John")
 				})
 				Convey("Greater", func() {
 					rs = rs.Search(rs.Model().Field(nums).Greater(12))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".nums > ?`)
+					So(sql, ShouldEqual, `WHERE "user"."nums" > ?`)
 					So(args, ShouldContain, 12)
 				})
 				Convey("GreaterOrEqual", func() {
 					rs = rs.Search(rs.Model().Field(nums).GreaterOrEqual(12))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".nums >= ?`)
+					So(sql, ShouldEqual, `WHERE "user"."nums" >= ?`)
 					So(args, ShouldContain, 12)
 				})
+				Convey("Reserved words as field names", func() {
+					rs = env.Pool("Tag").Search(env.Pool("Tag").Model().Field(fieldName{name: "Order", json: "order"}).Greater(2))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "tag"."order" > ?`)
+					So(args, ShouldContain, 2)
+					rs = rs.OrderBy("Order DESC")
+					So(rs.query.sqlOrderByClause(), ShouldEqual, `ORDER BY "order" DESC`)
+				})
+				Convey("JSON path", func() {
+					profileModel := Registry.MustGet("Profile")
+					rs = env.Pool("Profile").Search(profileModel.Field(profileModel.FieldName("Data")).JSONPath("size", "it's").Equals("3"))
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE jsonb_extract_path_text("profile"."data", 'size', 'it''s') = ?`)
+					So(args, ShouldContain, "3")
+					So(func() {
+						env.Pool("User").Search(env.Pool("User").Model().Field(Name).JSONPath("key").Equals("3")).query.sqlWhereClause(true)
+					}, ShouldPanic)
+				})
+				Convey("Delete all query", func() {
+					rs = env.Pool("User").Search(env.Pool("User").Model().Field(email).IEquals("John@Example.com"))
+					sql, args := rs.query.deleteAllQuery()
+					So(sql, ShouldStartWith, `DELETE FROM "user" WHERE "id" IN (SELECT "id" FROM (SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."id" AS "id" FROM "user" "user"  WHERE LOWER("user"."email") = LOWER(?)`)
+					So(args, ShouldContain, "John@Example.com")
+				})
+				Convey("Upsert query", func() {
+					sql, args := env.Pool("User").query.upsertQuery(FieldMap{"name": "John", "create_date": "2019-01-01"}, []FieldName{Name})
+					So(sql, ShouldStartWith, `INSERT INTO "user" (`)
+					So(sql, ShouldEndWith, `ON CONFLICT ("name") DO NOTHING RETURNING "id", "create_date", "name"`)
+					So(args, ShouldHaveLength, 2)
+				})
+				Convey("Insert query should only return the written columns", func() {
+					sql, args := env.Pool("User").query.insertQuery(FieldMap{"name": "John", "nums": 3})
+					So(sql, ShouldEqual, `INSERT INTO "user" ("name", "nums") VALUES (?, ?) RETURNING "id", "name", "nums"`)
+					So(args, ShouldResemble, SQLParams{"John", 3})
+				})
+				Convey("Raw SQL", func() {
+					rs = rs.Search(rs.Model().Field(nums).GreaterOrEqual(12)).SearchRaw(`lower("user".name) = ?`, "john")
+					sql, args := rs.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("user"."nums" >= ?) AND ((lower("user".name) = ?))`)
+					So(args, ShouldHaveLength, 2)
+					So(args, ShouldContain, "john")
+				})
 				Convey("Lower", func() {
 					rs = rs.Search(rs.Model().Field(nums).Lower(12))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".nums < ?`)
+					So(sql, ShouldEqual, `WHERE "user"."nums" < ?`)
 					So(args, ShouldContain, 12)
 				})
 				Convey("LowerOrEqual", func() {
 					rs = rs.Search(rs.Model().Field(nums).LowerOrEqual(12))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".nums <= ?`)
+					So(sql, ShouldEqual, `WHERE "user"."nums" <= ?`)
 					So(args, ShouldContain, 12)
 				})
 				Convey("Contains", func() {
 					rs = rs.Search(rs.Model().Field(Name).Contains("John"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".name LIKE ?`)
+					So(sql, ShouldEqual, `WHERE "user"."name" LIKE ?`)
 					So(args, ShouldContain, "%John%")
 				})
 				Convey("Not Contains", func() {
 					rs = rs.Search(rs.Model().Field(Name).NotContains("John"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NULL OR "user".name NOT LIKE ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NULL OR "user"."name" NOT LIKE ?)`)
 					So(args, ShouldContain, "%John%")
 				})
 				Convey("IContains", func() {
 					rs = rs.Search(rs.Model().Field(Name).IContains("John"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".name ILIKE ?`)
+					So(sql, ShouldEqual, `WHERE "user"."name" ILIKE ?`)
 					So(args, ShouldContain, "%John%")
 				})
 				Convey("Not IContains", func() {
 					rs = rs.Search(rs.Model().Field(Name).NotIContains("John"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NULL OR "user".name NOT ILIKE ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NULL OR "user"."name" NOT ILIKE ?)`)
 					So(args, ShouldContain, "%John%")
 				})
 				Convey("Contains pattern", func() {
 					rs = rs.Search(rs.Model().Field(Name).Like("John%"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".name LIKE ?`)
+					So(sql, ShouldEqual, `WHERE "user"."name" LIKE ?`)
 					So(args, ShouldContain, "John%")
 				})
 				Convey("IContains pattern", func() {
 					rs = rs.Search(rs.Model().Field(Name).ILike("John%"))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".name ILIKE ?`)
+					So(sql, ShouldEqual, `WHERE "user"."name" ILIKE ?`)
 					So(args, ShouldContain, "John%")
 				})
 				Convey("In", func() {
 					rs = rs.Search(rs.Model().Field(ID).In([]int64{23, 31}))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE "user".id IN (?)`)
+					So(sql, ShouldEqual, `WHERE "user"."id" IN (?)`)
 					So(args, ShouldContain, []int64{23, 31})
 				})
 				Convey("Not In", func() {
 					rs = rs.Search(rs.Model().Field(ID).NotIn([]int64{23, 31}))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".id IS NULL OR "user".id NOT IN (?))`)
+					So(sql, ShouldEqual, `WHERE ("user"."id" IS NULL OR "user"."id" NOT IN (?))`)
 					So(args, ShouldContain, []int64{23, 31})
 				})
+				Convey("In and Not In with long lists", func() {
+					ids := make([]int64, pgMaxInListSize+1)
+					for i := range ids {
+						ids[i] = int64(i + 1)
+					}
+					rsIn := rs.Search(rs.Model().Field(ID).In(ids))
+					sql, args := rsIn.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE "user"."id" = ANY(?)`)
+					So(args, ShouldHaveLength, 1)
+					So(args[0], ShouldResemble, pq.Array(ids))
+					rsNotIn := rs.Search(rs.Model().Field(ID).NotIn(ids))
+					sql, _ = rsNotIn.query.sqlWhereClause(true)
+					So(sql, ShouldEqual, `WHERE ("user"."id" IS NULL OR "user"."id" != ALL(?))`)
+				})
 				Convey("Is Null", func() {
 					rs = rs.Search(rs.Model().Field(Name).IsNull())
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NULL OR "user".name = ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NULL OR "user"."name" = ?)`)
 					So(args, ShouldContain, "")
 				})
 				Convey("Is Not Null", func() {
 					rs = rs.Search(rs.Model().Field(Name).IsNotNull())
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NOT NULL AND "user".name != ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NOT NULL AND "user"."name" != ?)`)
 					So(args, ShouldContain, "")
 				})
 				Convey("Empty string", func() {
 					rs = rs.Search(rs.Model().Field(Name).Equals(""))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".name IS NULL OR "user".name = ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."name" IS NULL OR "user"."name" = ?)`)
 					So(args, ShouldContain, "")
 				})
 				Convey("False bool", func() {
 					rs = rs.Search(rs.Model().Field(isStaff).Equals(false))
 					sql, args := rs.query.sqlWhereClause(true)
-					So(sql, ShouldEqual, `WHERE ("user".is_staff IS NULL OR "user".is_staff = ?)`)
+					So(sql, ShouldEqual, `WHERE ("user"."is_staff" IS NULL OR "user"."is_staff" = ?)`)
 					So(args, ShouldContain, false)
 				})
 				Convey("Child Of without parent field", func() {
 					rs = rs.Search(rs.Model().Field(ID).ChildOf(101))
 					sql, args, _ := rs.query.selectQuery([]FieldName{Name})
-					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user".id) "user".name AS name FROM "user" "user"  WHERE "user".id = ? ORDER BY "user".id ) foo  `)
-					So(args, ShouldContain, 101)
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user"  WHERE "user"."id" IN (?) ORDER BY "user"."id" ) foo  `)
+					So(args, ShouldContain, []interface{}{101})
+				})
+				Convey("Parent Of without parent field on several records", func() {
+					rs = rs.Search(rs.Model().Field(ID).ParentOf([]int64{101, 102}))
+					sql, args, _ := rs.query.selectQuery([]FieldName{Name})
+					So(sql, ShouldEqual, `SELECT * FROM (SELECT DISTINCT ON ("user"."id") "user"."name" AS "name" FROM "user" "user"  WHERE "user"."id" IN (?) ORDER BY "user"."id" ) foo  `)
+					So(args, ShouldContain, []int64{101, 102})
 				})
 			}), ShouldBeNil)
 		}
@@ -394,5 +499,38 @@ func TestConditionSerialization(t *testing.T) {
 			dom := cond.Serialize()
 			So(fmt.Sprint(dom), ShouldEqual, "[& | [C = C Value] | [B = B Value] [A = A Value] [D = D Value]]")
 		})
+		Convey("Testing A AND raw SQL condition should not be serialized", func() {
+			cond := newCondition().And().Field(Name).IContains("John").AndRaw(`"user".nums > ?`, 3)
+			So(func() { cond.Serialize() }, ShouldPanic)
+			So(cond.getAllExpressions(Registry.MustGet("User")), ShouldHaveLength, 1)
+		})
+		Convey("Testing FilteredOn with a raw SQL condition", func() {
+			raw := newCondition().And().Field(Name).IContains("John").AndRaw(`"user".nums > ?`, 3)
+			So(func() { newCondition().And().FilteredOn(a, raw) }, ShouldPanic)
+			So(func() { Registry.MustGet("User").FilteredOn(a, newCondition().AndCond(raw)) }, ShouldPanic)
+		})
+	})
+}
+
+func TestDBAdapter(t *testing.T) {
+	Convey("Testing the database adapter", t, func() {
+		if dbArgs.Driver == "postgres" {
+			adapter := adapters[dbArgs.Driver]
+			Convey("Placeholders should be rebound to the driver's style", func() {
+				sql, _ := sanitizeQuery(`SELECT id FROM "user" WHERE name = ? AND nums > ?`, "John", 1)
+				So(sql, ShouldEqual, `SELECT id FROM "user" WHERE name = $1 AND nums > $2`)
+			})
+			Convey("Identifiers should be quoted", func() {
+				So(adapter.QuoteIdentifier("user"), ShouldEqual, `"user"`)
+				So(adapter.QuoteIdentifier(`my"table`), ShouldEqual, `"my""table"`)
+				So(adapter.QuoteTableName("order"), ShouldEqual, `"order"`)
+			})
+			Convey("Limit and offset clauses", func() {
+				So(adapter.LimitOffsetSQL(0, 0), ShouldBeEmpty)
+				So(adapter.LimitOffsetSQL(10, 0), ShouldEqual, "LIMIT 10 ")
+				So(adapter.LimitOffsetSQL(10, 20), ShouldEqual, "LIMIT 10 OFFSET 20")
+				So(adapter.LimitOffsetSQL(-1, -5), ShouldEqual, "")
+			})
+		}
 	})
 }
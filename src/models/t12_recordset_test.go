@@ -15,9 +15,17 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -149,6 +157,48 @@ func TestCreateRecordSet(t *testing.T) {
 				})
 				So(func() { env.Pool("Tag").Call("Create", tag3Data) }, ShouldPanic)
 			})
+			Convey("Checking that fields named after SQL reserved words work", func() {
+				order := tagModel.FieldName("Order")
+				tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{
+					"Name":        "Ordered Tag",
+					"Description": "A tag with an order",
+					"Order":       5,
+				})).(RecordSet).Collection()
+				tag.Set(order, int64(7))
+				env.cache.invalidateRecord(tagModel, tag.ids[0])
+				So(tag.Get(order), ShouldEqual, 7)
+				found := env.Pool("Tag").Search(tagModel.Field(order).Equals(7)).OrderBy("Order")
+				So(found.Ids(), ShouldContain, tag.ids[0])
+			})
+			Convey("Upserting a user should create it or update the existing one", func() {
+				name := userModel.FieldName("Name")
+				email := userModel.FieldName("Email")
+				user := env.Pool("User").Upsert(NewModelData(userModel, FieldMap{
+					"Name":  "Upserted User",
+					"Email": "upsert1@example.com",
+				}), []FieldName{name})
+				So(user.Len(), ShouldEqual, 1)
+				So(user.Get(email), ShouldEqual, "upsert1@example.com")
+				user2 := env.Pool("User").Upsert(NewModelData(userModel, FieldMap{
+					"Name":  "Upserted User",
+					"Email": "upsert2@example.com",
+				}), []FieldName{name})
+				So(user2.Ids(), ShouldResemble, user.Ids())
+				So(user2.Get(email), ShouldEqual, "upsert2@example.com")
+				So(env.Pool("User").Search(userModel.Field(name).Equals("Upserted User")).SearchCount(), ShouldEqual, 1)
+				var changes []FieldMap
+				SetWriteHook(func(model string, id int64, fMap FieldMap, uid int64) {
+					changes = append(changes, fMap)
+				})
+				defer SetWriteHook(nil)
+				env.Pool("User").Upsert(NewModelData(userModel, FieldMap{
+					"Name":  "Upserted User",
+					"Email": "upsert3@example.com",
+				}), []FieldName{name})
+				So(user.Get(email), ShouldEqual, "upsert3@example.com")
+				So(changes, ShouldHaveLength, 1)
+				So(changes[0], ShouldResemble, FieldMap{"email": "upsert3@example.com"})
+			})
 			Convey("Checking that we can't create two users with the same name", func() {
 				user1Data := NewModelData(userModel, FieldMap{
 					"Name": "User1",
@@ -291,6 +341,145 @@ func TestSearchRecordSet(t *testing.T) {
 					So(ujData.Get(profile).(RecordSet).Collection().Get(ID), ShouldEqual, userJane.Get(profile).(RecordSet).Collection().Get(ID))
 					So(ujData.Has(profile), ShouldBeTrue)
 				})
+				Convey("Reading Jane with First and given fields", func() {
+					ujData := userJane.First(Name, email)
+					So(ujData.Get(Name), ShouldEqual, "Jane Smith")
+					So(ujData.Get(email), ShouldEqual, "jane.smith@example.com")
+					So(ujData.Get(ID), ShouldEqual, userJane.Get(ID).(int64))
+					So(ujData.Has(profile), ShouldBeFalse)
+					So(ujData.Has(posts), ShouldBeFalse)
+					So(ujData.Underlying().FieldMap, ShouldHaveLength, 3)
+					allData := env.Pool("User").SearchAll().All(Name)
+					So(allData, ShouldHaveLength, 3)
+					for _, data := range allData {
+						So(data.Underlying().FieldMap, ShouldHaveLength, 2)
+						So(data.Has(Name), ShouldBeTrue)
+						So(data.Has(email), ShouldBeFalse)
+					}
+				})
+				Convey("Reading Jane into structs", func() {
+					type userSummary struct {
+						Name     string
+						Mail     string `hexya:"Email"`
+						Age      int
+						Profile  int64
+						Posts    []int64
+						Ignored  string `hexya:"-"`
+						internal string
+					}
+					var summary userSummary
+					So(userJane.ReadFirst(&summary), ShouldBeNil)
+					So(summary.Name, ShouldEqual, "Jane Smith")
+					So(summary.Mail, ShouldEqual, "jane.smith@example.com")
+					So(summary.Age, ShouldEqual, userJane.Get(age))
+					So(summary.Profile, ShouldEqual, userJane.Get(profile).(RecordSet).Ids()[0])
+					So(summary.Posts, ShouldHaveLength, 2)
+					So(summary.Ignored, ShouldBeEmpty)
+					var summaries []userSummary
+					So(env.Pool("User").SearchAll().ReadAll(&summaries), ShouldBeNil)
+					So(summaries, ShouldHaveLength, 3)
+					So(summaries[0].Name, ShouldNotBeBlank)
+					var unknown struct {
+						Name     string
+						Nickname string
+					}
+					err := userJane.ReadFirst(&unknown)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "Nickname")
+					var wrongType struct {
+						Name int
+					}
+					So(userJane.ReadFirst(&wrongType), ShouldNotBeNil)
+					So(userJane.ReadFirst(summary), ShouldNotBeNil)
+					So(env.Pool("User").SearchAll().ReadAll(&summary), ShouldNotBeNil)
+				})
+				Convey("ReadFirst should only read the first record", func() {
+					userModel := Registry.MustGet("User")
+					env.cache.invalidateModel(userModel)
+					users := env.Pool("User").SearchAll().OrderBy("ID")
+					var mail struct {
+						Email string
+					}
+					So(users.ReadFirst(&mail), ShouldBeNil)
+					ids := users.Ids()
+					ctxSlug := users.query.ctxArgsSlug()
+					So(env.cache.isInCache(userModel, ids[0], "email", ctxSlug, false), ShouldBeTrue)
+					So(env.cache.isInCache(userModel, ids[1], "email", ctxSlug, false), ShouldBeFalse)
+					So(mail.Email, ShouldEqual, users.Records()[0].Get(email))
+				})
+				Convey("Reading into structs should refuse lossy conversions", func() {
+					_, err := structFieldValue(1.5, reflect.TypeOf(0))
+					So(err, ShouldNotBeNil)
+					_, err = structFieldValue(int64(300), reflect.TypeOf(int8(0)))
+					So(err, ShouldNotBeNil)
+					_, err = structFieldValue(int64(-1), reflect.TypeOf(uint(0)))
+					So(err, ShouldNotBeNil)
+					res, err := structFieldValue(int64(42), reflect.TypeOf(int8(0)))
+					So(err, ShouldBeNil)
+					So(res.Interface(), ShouldEqual, int8(42))
+				})
+				Convey("Reading Jane with GetOne", func() {
+					So(userJane.GetOne(Name), ShouldEqual, "Jane Smith")
+					So(func() { env.Pool("User").SearchAll().GetOne(Name) }, ShouldPanic)
+					So(func() { env.Pool("User").GetOne(Name) }, ShouldPanic)
+				})
+				Convey("Reading all users names with GetAll", func() {
+					users := env.Pool("User").SearchAll()
+					names := users.GetAll(Name)
+					So(names, ShouldHaveLength, users.Len())
+					So(names[userJane.Ids()[0]], ShouldEqual, "Jane Smith")
+					profiles := users.GetAll(profile)
+					So(profiles[userJane.Ids()[0]].(*RecordCollection).Equals(userJane.Get(profile).(RecordSet).Collection()), ShouldBeTrue)
+				})
+				Convey("Searching Jane by email case insensitively", func() {
+					users := env.Pool("User").Search(env.Pool("User").Model().Field(email).IEquals("Jane.Smith@Example.COM"))
+					So(users.Len(), ShouldEqual, 1)
+					So(users.Get(Name), ShouldEqual, "Jane Smith")
+					So(env.Pool("User").Search(env.Pool("User").Model().Field(email).Equals("Jane.Smith@Example.COM")).IsEmpty(), ShouldBeTrue)
+				})
+				Convey("Running again the original query of fetched users", func() {
+					users := env.Pool("User").Search(env.Pool("User").Model().Field(Name).IContains("Smith")).OrderBy("ID")
+					count := users.Len()
+					So(count, ShouldBeGreaterThan, 0)
+					env.Pool("User").Call("Create", NewModelData(Registry.MustGet("User")).
+						Set(Name, "Tom Smith").
+						Set(email, "tsmith@example.com"))
+					So(users.Len(), ShouldEqual, count)
+					So(users.OriginalQuery().Len(), ShouldEqual, count+1)
+					limited := env.Pool("User").Search(env.Pool("User").Model().Field(Name).IContains("Smith")).Limit(1)
+					So(limited.Fetch().OriginalQuery().Len(), ShouldEqual, 1)
+					johns := users.Search(env.Pool("User").Model().Field(Name).IContains("John"))
+					So(johns.OriginalQuery().Len(), ShouldEqual, env.Pool("User").Search(
+						env.Pool("User").Model().Field(Name).IContains("Smith").And().Field(Name).IContains("John")).Len())
+					So(users.OriginalQuery().Len(), ShouldEqual, count+1)
+				})
+				Convey("Searching Jane with a raw SQL condition", func() {
+					users := env.Pool("User").SearchRaw(`lower("user".name) = ?`, "jane smith")
+					So(users.Len(), ShouldEqual, 1)
+					So(users.Get(email), ShouldEqual, "jane.smith@example.com")
+					users = env.Pool("User").SearchAll().SearchRaw(`"user".nums > ?`, 1).SearchRaw(`"user".email = ?`, "jane.smith@example.com")
+					So(users.Len(), ShouldEqual, 1)
+				})
+				Convey("Full text search on posts", func() {
+					posts := env.Pool("Post").SearchAll().TextSearch("first")
+					So(posts.Len(), ShouldEqual, 1)
+					So(posts.Get(title), ShouldEqual, "1st Post")
+					So(env.Pool("Post").SearchAll().TextSearch("posts").Len(), ShouldBeGreaterThan, 1)
+					So(env.Pool("Post").SearchAll().TextSearch("nonexistentword").IsEmpty(), ShouldBeTrue)
+					So(func() { env.Pool("User").SearchAll().TextSearch("jane") }, ShouldPanic)
+				})
+				Convey("Searching Jane with a composite key", func() {
+					userModel := Registry.MustGet("User")
+					So(userModel.CompositeKey(), ShouldHaveLength, 2)
+					So(userModel.CompositeKey()[0].Name(), ShouldEqual, "Name")
+					So(userModel.CompositeKey()[1].Name(), ShouldEqual, "Email")
+					users := env.Pool("User").WithKeys(
+						[]interface{}{"Jane Smith", "jane.smith@example.com"},
+						[]interface{}{"Jane Smith", "will.smith@example.com"})
+					So(users.Ids(), ShouldResemble, userJane.Ids())
+					So(users.Keys(), ShouldResemble, [][]interface{}{{"Jane Smith", "jane.smith@example.com"}})
+					So(func() { env.Pool("User").WithKeys([]interface{}{"Jane Smith"}) }, ShouldPanic)
+				})
 				Convey("Reading an empty RecordSet should return zero value", func() {
 					empty := env.Pool("User")
 					So(empty.Get(Name), ShouldEqual, "")
@@ -306,6 +495,43 @@ func TestSearchRecordSet(t *testing.T) {
 				So(usersAll.Len(), ShouldEqual, 3)
 				usersAll = env.Pool("User").OrderBy("Name")
 				So(usersAll.Len(), ShouldEqual, 3)
+				Convey("Len should fetch the ids of an unloaded RecordSet", func() {
+					smiths := env.Pool("User").Search(env.Pool("User").Model().Field(Name).Contains("Smith"))
+					So(smiths.fetched, ShouldBeFalse)
+					So(smiths.Len(), ShouldEqual, 3)
+					So(smiths.fetched, ShouldBeTrue)
+					So(smiths.Ids(), ShouldHaveLength, 3)
+				})
+				Convey("Resetting the query of a RecordSet", func() {
+					usersModel := env.Pool("User").Model()
+					smiths := env.Pool("User").WithContext("lang", "fr_FR").
+						Search(usersModel.Field(Name).Contains("Smith")).
+						OrderBy("Name DESC").Limit(2).Offset(1)
+					smiths.Fetch()
+					reset := smiths.Reset()
+					So(reset.query.isEmpty(), ShouldBeTrue)
+					So(reset.query.sideDataIsEmpty(), ShouldBeTrue)
+					So(reset.Ids(), ShouldBeEmpty)
+					So(reset.ModelName(), ShouldEqual, "User")
+					So(reset.Env().Context().GetString("lang"), ShouldEqual, "fr_FR")
+					So(smiths.Ids(), ShouldHaveLength, 2)
+					So(reset.Search(usersModel.Field(Name).Contains("Smith")).Len(), ShouldEqual, 3)
+				})
+				Convey("Searching only the ids of users", func() {
+					usersModel := env.Pool("User").Model()
+					smiths := env.Pool("User").Search(usersModel.Field(Name).Contains("Smith")).OrderBy("Name DESC")
+					ids := smiths.SearchIds()
+					So(ids, ShouldHaveLength, 3)
+					So(smiths.fetched, ShouldBeFalse)
+					So(ids, ShouldResemble, smiths.Ids())
+					So(smiths.Limit(2).Offset(1).SearchIds(), ShouldResemble, ids[1:])
+					So(env.Pool("User").SearchIds(), ShouldBeEmpty)
+				})
+				Convey("Counting users with and without fetched ids", func() {
+					So(env.Pool("User").SearchAll().Count(), ShouldEqual, 3)
+					So(usersAll.Count(), ShouldEqual, 3)
+					So(usersAll.Records()[0].Count(), ShouldEqual, 1)
+				})
 				Convey("Reading first user with Get", func() {
 					So(usersAll.Get(Name), ShouldEqual, "Jane Smith")
 					So(usersAll.Get(email), ShouldEqual, "jane.smith@example.com")
@@ -327,6 +553,18 @@ func TestSearchRecordSet(t *testing.T) {
 					So(usersData[2].Has(email), ShouldBeTrue)
 				})
 			})
+			Convey("Testing search ordered by a related field", func() {
+				users := env.Pool("User").Search(env.Pool("User").Model().Field(profile).IsNotNull())
+				So(users.Len(), ShouldBeGreaterThan, 0)
+				for _, order := range []string{"Profile.Age desc", " Profile.Age  DESC "} {
+					recs := users.OrderBy(order).Records()
+					for i := 1; i < len(recs); i++ {
+						prevAge := recs[i-1].Get(profile).(RecordSet).Collection().Get(age).(int16)
+						So(recs[i].Get(profile).(RecordSet).Collection().Get(age), ShouldBeLessThanOrEqualTo, prevAge)
+					}
+				}
+				So(func() { users.OrderBy("Profile.Age down").Fetch() }, ShouldPanic)
+			})
 			Convey("Testing search on manual model", func() {
 				userViews := env.Pool("UserView").SearchAll()
 				So(userViews.Len(), ShouldEqual, 3)
@@ -341,6 +579,28 @@ func TestSearchRecordSet(t *testing.T) {
 				So(recs[1].Get(city), ShouldEqual, "")
 				So(recs[2].Get(city), ShouldEqual, "")
 			})
+			Convey("Testing SQL view model", func() {
+				cityViewModel := Registry.MustGet("UserCityView")
+				cityViews := env.Pool("UserCityView").SearchAll()
+				So(cityViews.Len(), ShouldEqual, 3)
+				nyViews := env.Pool("UserCityView").Search(cityViewModel.Field(city).Equals("New York")).OrderBy("Name")
+				So(nyViews.Len(), ShouldEqual, 1)
+				So(nyViews.Get(Name), ShouldEqual, "Jane Smith")
+				groups := env.Pool("UserCityView").SearchAll().GroupBy(city).Aggregates(city)
+				So(len(groups), ShouldBeGreaterThanOrEqualTo, 2)
+				var nyCount int
+				for _, group := range groups {
+					if group.Values.Get(city) == "New York" {
+						nyCount = group.Count
+					}
+				}
+				So(nyCount, ShouldEqual, 1)
+				So(func() {
+					env.Pool("UserCityView").Call("Create", NewModelData(cityViewModel).Set(Name, "View User"))
+				}, ShouldPanic)
+				So(func() { nyViews.Set(Name, "Jane View") }, ShouldPanic)
+				So(func() { nyViews.Call("Unlink") }, ShouldPanic)
+			})
 			Convey("Testing browse with empty ids", func() {
 				var ids []int64
 				users := env.Pool("User").Model().Browse(env, ids)
@@ -350,6 +610,8 @@ func TestSearchRecordSet(t *testing.T) {
 	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	security.Registry.AddMembership(2, group1)
+	group2 := security.Registry.NewGroup("group2", "Group 2")
+	security.Registry.AddMembership(2, group2)
 	Convey("Testing access control list while searching", t, func() {
 		So(SimulateInNewEnvironment(2, func(env Environment) {
 			userModel := Registry.MustGet("User")
@@ -404,9 +666,37 @@ func TestSearchRecordSet(t *testing.T) {
 				userModel.RemoveRecordRule("jOnly")
 				userModel.RemoveRecordRule("writeRule")
 			})
+			Convey("Checking record rules of several groups", func() {
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "janeOnly",
+					Group:     group1,
+					Condition: userModel.Field(Name).IContains("jane"),
+					Perms:     security.Read,
+				})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "willOnly",
+					Group:     group1,
+					Condition: userModel.Field(Name).IContains("will"),
+					Perms:     security.Read,
+				})
+				userModel.AddRecordRule(&RecordRule{
+					Name:      "jOnly",
+					Group:     group2,
+					Condition: userModel.Field(Name).IContains("j"),
+					Perms:     security.Read,
+				})
+				defer userModel.RemoveRecordRule("janeOnly")
+				defer userModel.RemoveRecordRule("willOnly")
+				defer userModel.RemoveRecordRule("jOnly")
+
+				users := env.Pool("User").SearchAll()
+				So(users.Len(), ShouldEqual, 1)
+				So(users.Get(Name), ShouldEqual, "Jane Smith")
+			})
 		}), ShouldBeNil)
 	})
 	security.Registry.UnregisterGroup(group1)
+	security.Registry.UnregisterGroup(group2)
 }
 
 func TestAdvancedQueries(t *testing.T) {
@@ -572,6 +862,36 @@ func TestAdvancedQueries(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing queries on JSON fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profileModel := Registry.MustGet("Profile")
+			data := profileModel.FieldName("Data")
+			prof := env.Pool("Profile").Call("Create", NewModelData(profileModel).
+				Set(data, map[string]interface{}{"color": "red", "size": map[string]interface{}{"w": 3}})).(RecordSet).Collection()
+			Convey("JSON values should be read back as maps", func() {
+				env.cache.invalidateRecord(profileModel, prof.ids[0])
+				So(prof.Get(data), ShouldResemble, map[string]interface{}{"color": "red", "size": map[string]interface{}{"w": float64(3)}})
+			})
+			Convey("Empty JSON values should be stored as NULL", func() {
+				prof.Set(data, map[string]interface{}(nil))
+				So(env.Pool("Profile").Search(profileModel.Field(data).IsNull()).Ids(), ShouldContain, prof.ids[0])
+			})
+			Convey("Searching inside JSON documents", func() {
+				So(env.Pool("Profile").Search(profileModel.Field(data).JSONPath("color").Equals("red")).Ids(), ShouldResemble, prof.ids)
+				So(env.Pool("Profile").Search(profileModel.Field(data).JSONPath("size", "w").Equals(3)).Ids(), ShouldResemble, prof.ids)
+				So(env.Pool("Profile").Search(profileModel.Field(data).JSONPath("color").Equals("blue")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("JSON values can be decoded into structs", func() {
+				var target struct {
+					Color string `json:"color"`
+				}
+				So(convertJSONValue([]byte(`{"color": "red"}`), &target), ShouldBeNil)
+				So(target.Color, ShouldEqual, "red")
+				So(convertJSONValue(map[string]interface{}{"color": "blue"}, &target), ShouldBeNil)
+				So(target.Color, ShouldEqual, "blue")
+			})
+		}), ShouldBeNil)
+	})
 }
 
 func TestGroupedQueries(t *testing.T) {
@@ -591,6 +911,54 @@ func TestGroupedQueries(t *testing.T) {
 				So(groupedUsers[1].Values.Get(nums), ShouldEqual, 4)
 				So(groupedUsers[1].Count, ShouldEqual, 2)
 			})
+			Convey("Nested grouped query", func() {
+				groupedUsers := env.Pool("User").SearchAll().GroupBy(isStaff, Name).NestedAggregates(nums)
+				So(groupedUsers, ShouldHaveLength, 2)
+				So(groupedUsers[0].Values.Get(isStaff), ShouldBeFalse)
+				So(groupedUsers[0].Values.Get(nums), ShouldEqual, 2)
+				So(groupedUsers[0].Count, ShouldEqual, 1)
+				So(groupedUsers[0].SubGroups, ShouldHaveLength, 1)
+				So(groupedUsers[1].Values.Get(isStaff), ShouldBeTrue)
+				So(groupedUsers[1].Values.Get(nums), ShouldEqual, 4)
+				So(groupedUsers[1].Count, ShouldEqual, 2)
+				So(groupedUsers[1].SubGroups, ShouldHaveLength, 2)
+				for _, group := range groupedUsers {
+					var total int
+					for _, subGroup := range group.SubGroups {
+						So(subGroup.Values.Has(Name), ShouldBeTrue)
+						So(subGroup.Count, ShouldEqual, 1)
+						So(subGroup.SubGroups, ShouldBeEmpty)
+						So(env.Pool("User").Search(subGroup.Condition).Get(isStaff), ShouldEqual, group.Values.Get(isStaff))
+						total += subGroup.Count
+					}
+					So(total, ShouldEqual, group.Count)
+				}
+			})
+			Convey("Grouped query by month of a datetime field", func() {
+				users := env.Pool("User").SearchAll()
+				groupedUsers := users.GroupByDate(createDate, DateGroupMonth).Aggregates(createDate)
+				So(len(groupedUsers), ShouldBeGreaterThan, 0)
+				var total int
+				for _, group := range groupedUsers {
+					total += group.Count
+					So(env.Pool("User").Search(group.Condition).Len(), ShouldEqual, group.Count)
+				}
+				So(total, ShouldEqual, users.SearchCount())
+			})
+			Convey("Grouped query with date grouping syntax", func() {
+				users := env.Pool("User").SearchAll()
+				groupedUsers := users.GroupBy(fieldName{name: "CreateDate:year", json: "create_date:year"}).Aggregates(createDate)
+				So(len(groupedUsers), ShouldBeGreaterThan, 0)
+				var total int
+				for _, group := range groupedUsers {
+					total += group.Count
+				}
+				So(total, ShouldEqual, users.SearchCount())
+			})
+			Convey("Grouping by date a non date field should panic", func() {
+				So(func() { env.Pool("User").SearchAll().GroupByDate(Name, DateGroupMonth) }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().GroupByDate(createDate, DateGrouping("decade")) }, ShouldPanic)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -624,6 +992,16 @@ func TestUpdateRecordSet(t *testing.T) {
 				john.Set(isStaff, true)
 				So(john.Get(isStaff), ShouldBeTrue)
 			})
+			Convey("Update on users Jane and John with SetMulti", func() {
+				users := env.Pool("User").Search(env.Pool("User").Model().Field(Name).Equals("Jane A. Smith").
+					Or().Field(Name).Equals("John Smith"))
+				So(users.Len(), ShouldEqual, 2)
+				users.SetMulti(FieldMap{"Email": "multi@example.com", "nums": 4})
+				for _, user := range users.Records() {
+					So(user.Get(email), ShouldEqual, "multi@example.com")
+					So(user.Get(nums), ShouldEqual, 4)
+				}
+			})
 			Convey("Updating an empty RecordSet should do nothing", func() {
 				empty := env.Pool("User")
 				So(func() { empty.Set(Name, "Foo") }, ShouldNotPanic)
@@ -754,7 +1132,905 @@ func TestUpdateRecordSet(t *testing.T) {
 			userWill.Call("Write", NewModelData(userModel).Set(nums, 0).Set(isPremium, true))
 		}).Error(), ShouldStartWith, "pq: Premium users must have positive nums")
 	})
+	Convey("Checking selection values enforcement", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profileModel := Registry.MustGet("Profile")
+			gender := profileModel.FieldName("Gender")
+			profile := env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "m")).(RecordSet).Collection()
+			So(profile.Get(gender), ShouldEqual, "m")
+			So(func() {
+				profile.Call("Write", NewModelData(profileModel).Set(gender, "unknown"))
+			}, ShouldPanic)
+			So(func() {
+				env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(gender, "male"))
+			}, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking FieldMap keys validation", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			post := env.Pool("Post").Call("Create", &ModelData{
+				FieldMap: FieldMap{"Title": "Valid Keys", "content": "Name and JSON keys are accepted"},
+				Model:    postModel,
+			}).(RecordSet).Collection()
+			So(post.Get(postModel.FieldName("Title")), ShouldEqual, "Valid Keys")
+			So(func() {
+				post.Call("Write", &ModelData{FieldMap: FieldMap{"title": "New Title"}, Model: postModel})
+			}, ShouldNotPanic)
+			So(func() {
+				post.Call("Write", &ModelData{FieldMap: FieldMap{"Titel": "Misspelled"}, Model: postModel})
+			}, ShouldPanic)
+			So(func() {
+				env.Pool("Post").Call("Create", &ModelData{FieldMap: FieldMap{"title": "Post", "User.Nmae": "Jane"}, Model: postModel})
+			}, ShouldPanic)
+			So(func() {
+				env.Pool("Post").Call("Create", &ModelData{FieldMap: FieldMap{"title": "Post", "Title.Name": "Post"}, Model: postModel})
+			}, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking stored fields depending on one2many relations", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			commentModel := Registry.MustGet("Comment")
+			commentsCount := postModel.FieldName("CommentsCount")
+			post := commentModel.FieldName("Post")
+			post1 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Commented Post").
+				Set(content, "Post with comments")).(RecordSet).Collection()
+			post2 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Other Commented Post").
+				Set(content, "Post with other comments")).(RecordSet).Collection()
+			So(post1.Get(commentsCount), ShouldEqual, 0)
+			comment := env.Pool("Comment").Call("Create", NewModelData(commentModel).
+				Set(text, "Moving Comment").
+				Set(post, post1)).(RecordSet).Collection()
+			So(post1.Get(commentsCount), ShouldEqual, 1)
+			So(post2.Get(commentsCount), ShouldEqual, 0)
+			comment.Set(post, post2)
+			So(post1.Get(commentsCount), ShouldEqual, 0)
+			So(post2.Get(commentsCount), ShouldEqual, 1)
+			comment.Call("Unlink")
+			So(post2.Get(commentsCount), ShouldEqual, 0)
+		}), ShouldBeNil)
+	})
+	Convey("Checking recompute modes of stored computed fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			commentModel := Registry.MustGet("Comment")
+			lazyCount := postModel.FieldName("LazyCommentsCount")
+			manualCount := postModel.FieldName("ManualCommentsCount")
+			post := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Recomputed Post").
+				Set(content, "Post with lazy and manual counts")).(RecordSet).Collection()
+			env.Pool("Comment").Call("Create", NewModelData(commentModel).
+				Set(text, "Counted Comment").
+				Set(commentModel.FieldName("Post"), post))
+			var dbCount int64
+			env.Cr().Get(&dbCount, "SELECT COALESCE(lazy_comments_count, 0) FROM post WHERE id = ?", post.Ids()[0])
+			So(dbCount, ShouldEqual, 0)
+			So(env.recompute.isEmpty(), ShouldBeFalse)
+			So(post.Get(lazyCount), ShouldEqual, 1)
+			env.Cr().Get(&dbCount, "SELECT COALESCE(lazy_comments_count, 0) FROM post WHERE id = ?", post.Ids()[0])
+			So(dbCount, ShouldEqual, 1)
+			So(env.recompute.isEmpty(), ShouldBeTrue)
+
+			So(post.Get(manualCount), ShouldEqual, 0)
+			env.Flush()
+			So(post.Get(manualCount), ShouldEqual, 0)
+			post.Recompute(manualCount)
+			So(post.Get(manualCount), ShouldEqual, 1)
+			So(func() { post.Recompute(title) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking deferred recomputation of stored fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			commentModel := Registry.MustGet("Comment")
+			commentsCount := postModel.FieldName("CommentsCount")
+			post := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Bulk Commented Post").
+				Set(content, "Post with many comments")).(RecordSet).Collection()
+			var dbCount int64
+			env.DeferRecompute(func() {
+				for i := 0; i < 3; i++ {
+					env.Pool("Comment").Call("Create", NewModelData(commentModel).
+						Set(text, "Bulk Comment").
+						Set(commentModel.FieldName("Post"), post))
+				}
+				env.Cr().Get(&dbCount, "SELECT COALESCE(comments_count, 0) FROM post WHERE id = ?", post.Ids()[0])
+				So(dbCount, ShouldEqual, 0)
+				So(env.recompute.isEmpty(), ShouldBeFalse)
+			})
+			So(env.recompute.isEmpty(), ShouldBeTrue)
+			env.Cr().Get(&dbCount, "SELECT COALESCE(comments_count, 0) FROM post WHERE id = ?", post.Ids()[0])
+			So(dbCount, ShouldEqual, 3)
+			So(post.Get(commentsCount), ShouldEqual, 3)
+			So(func() {
+				env.DeferRecompute(func() {
+					env.Pool("Comment").Call("Create", NewModelData(commentModel).
+						Set(text, "Failed Bulk Comment").
+						Set(commentModel.FieldName("Post"), post))
+					panic("bulk load error")
+				})
+			}, ShouldPanic)
+			So(env.recompute.deferred, ShouldEqual, 0)
+			So(env.recompute.isEmpty(), ShouldBeTrue)
+		}), ShouldBeNil)
+	})
+
+	Convey("Checking decimal fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			companyModel := Registry.MustGet("Company")
+			capital := companyModel.FieldName("Capital")
+			comp := env.Pool("Company").Call("Create", NewModelData(companyModel).
+				Set(Name, "Decimal Company").
+				Set(capital, decimals.MustParse("1234567890123.1234"))).(RecordSet).Collection()
+			env.cache.invalidateRecord(companyModel, comp.ids[0])
+			So(comp.Get(capital).(decimals.Decimal).String(), ShouldEqual, "1234567890123.1234")
+			var sum decimals.Decimal
+			for i := 0; i < 10; i++ {
+				sum = sum.Add(decimals.MustParse("0.1"))
+			}
+			comp.Set(capital, sum)
+			env.cache.invalidateRecord(companyModel, comp.ids[0])
+			So(comp.Get(capital).(decimals.Decimal).Equal(decimals.NewFromInt(1)), ShouldBeTrue)
+			comp.Set(capital, 2.5)
+			So(comp.Get(capital).(decimals.Decimal).String(), ShouldEqual, "2.5000")
+			var precision, scale int
+			env.Cr().Get(&precision, "SELECT numeric_precision FROM information_schema.columns WHERE table_name = 'company' AND column_name = 'capital'")
+			env.Cr().Get(&scale, "SELECT numeric_scale FROM information_schema.columns WHERE table_name = 'company' AND column_name = 'capital'")
+			So(precision, ShouldEqual, 20)
+			So(scale, ShouldEqual, 4)
+			groups := env.Pool("Company").Search(companyModel.Field(Name).Equals("Decimal Company")).
+				GroupBy(Name).Aggregates(Name, capital)
+			So(groups, ShouldHaveLength, 1)
+			So(groups[0].Values.Get(capital).(decimals.Decimal).String(), ShouldEqual, "2.5000")
+		}), ShouldBeNil)
+	})
+	Convey("Checking child_of and parent_of operators", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			parent := tagModel.FieldName("Parent")
+			root := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Root")).(RecordSet).Collection()
+			mid := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Mid").Set(parent, root)).(RecordSet).Collection()
+			leaf := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Leaf").Set(parent, mid)).(RecordSet).Collection()
+			other := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Other").Set(parent, root)).(RecordSet).Collection()
+			hierarchy := tagModel.Field(ID).In(root.Union(mid).Union(leaf).Union(other))
+			children := env.Pool("Tag").Search(hierarchy.And().Field(ID).ChildOf(mid))
+			So(children.Len(), ShouldEqual, 2)
+			So(children.Ids(), ShouldContain, mid.ids[0])
+			So(children.Ids(), ShouldContain, leaf.ids[0])
+			parents := env.Pool("Tag").Search(hierarchy.And().Field(ID).ParentOf(leaf))
+			So(parents.Len(), ShouldEqual, 3)
+			So(parents.Ids(), ShouldNotContain, other.ids[0])
+			parents = env.Pool("Tag").Search(hierarchy.And().Field(ID).AddOperator(operator.ParentOf, leaf.Union(other)))
+			So(parents.Len(), ShouldEqual, 4)
+			byParent := env.Pool("Tag").Search(tagModel.Field(parent).ChildOf(root))
+			So(byParent.Len(), ShouldEqual, 3)
+			So(env.Pool("Tag").Search(tagModel.Field(ID).ChildOf(env.Pool("Tag"))).Len(), ShouldEqual, 0)
+			users := env.Pool("User").SearchAll().Limit(2).Fetch()
+			So(users.Len(), ShouldEqual, 2)
+			usersChildren := env.Pool("User").Search(users.Model().Field(ID).ChildOf(users))
+			So(usersChildren.Len(), ShouldEqual, 2)
+			usersParents := env.Pool("User").Search(users.Model().Field(ID).ParentOf(func(RecordSet) RecordSet { return users }))
+			So(usersParents.Len(), ShouldEqual, 2)
+		}), ShouldBeNil)
+	})
+	Convey("Checking searches with very long lists of values", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			users := env.Pool("User").SearchAll()
+			ids := make([]int64, 100000)
+			for i := range ids {
+				ids[i] = int64(i + 1)
+			}
+			So(env.Pool("User").Search(userModel.Field(ID).In(ids)).Len(), ShouldEqual, users.Len())
+			So(env.Pool("User").Search(userModel.Field(ID).NotIn(ids)).Len(), ShouldEqual, 0)
+			names := make([]string, 100000)
+			for i := range names {
+				names[i] = fmt.Sprintf("User %d", i)
+			}
+			names[0] = "Jane Smith"
+			So(env.Pool("User").Search(userModel.Field(Name).In(names)).Len(), ShouldEqual, 1)
+		}), ShouldBeNil)
+	})
+	Convey("Checking Try methods returning errors", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			Convey("Successful calls return no error", func() {
+				users, err := env.Pool("User").TrySearch(userModel.Field(Name).Equals("Jane Smith"))
+				So(err, ShouldBeNil)
+				So(users.Equals(jane), ShouldBeTrue)
+				data, err := jane.TryRead(FieldNames{Name})
+				So(err, ShouldBeNil)
+				So(data, ShouldHaveLength, 1)
+				So(jane.TryWrite(NewModelData(userModel).Set(nums, 12)), ShouldBeNil)
+				So(jane.Get(nums), ShouldEqual, 12)
+				user, err := env.Pool("User").TryCreate(NewModelData(userModel).Set(Name, "Try User"))
+				So(err, ShouldBeNil)
+				So(user.Len(), ShouldEqual, 1)
+			})
+			Convey("Failing calls return a CallError", func() {
+				_, err := env.Pool("User").TrySearch(userModel.Field(Name).Lower(nil))
+				So(err, ShouldHaveSameTypeAs, CallError{})
+				So(err.(CallError).Method, ShouldEqual, "Search")
+				So(err.Error(), ShouldContainSubstring, "Null argument can only be used with = and != operators")
+				_, err = env.Pool("User").TryCall("UnknownMethod")
+				So(err, ShouldHaveSameTypeAs, CallError{})
+			})
+			Convey("Environment can be used after a database error", func() {
+				_, err := env.Pool("User").TryCreate(NewModelData(userModel).
+					Set(Name, "Jane Smith").
+					Set(email, "jane.smith@example.com"))
+				So(err, ShouldHaveSameTypeAs, CallError{})
+				So(err.(CallError).Model, ShouldEqual, "User")
+				So(err.(CallError).Method, ShouldEqual, "Create")
+				So(env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith")).Len(), ShouldEqual, 1)
+				So(jane.TryWrite(NewModelData(userModel).Set(nums, 3)), ShouldBeNil)
+				So(jane.Get(nums), ShouldEqual, 3)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Checking typed errors", t, func() {
+		panicValue := func(fnct func()) (res interface{}) {
+			defer func() {
+				res = recover()
+			}()
+			fnct()
+			return nil
+		}
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			userModel := Registry.MustGet("User")
+			Convey("Failed constraints raise ValidationErrors", func() {
+				_, err := env.Pool("Tag").TryCreate(NewModelData(tagModel).
+					Set(Name, "Same").
+					Set(description, "Same"))
+				var valErr exceptions.ValidationError
+				So(errors.As(err, &valErr), ShouldBeTrue)
+				So(valErr.Message, ShouldEqual, "Tag name and description must be different")
+				r := panicValue(func() {
+					env.Pool("Tag").Call("Create", NewModelData(tagModel).
+						Set(Name, "Rated").
+						Set(tagModel.FieldName("Rate"), float32(12)))
+				})
+				So(r, ShouldHaveSameTypeAs, "")
+				So(r, ShouldStartWith, "Tag rate must be between 0 and 10")
+				r = panicValue(func() {
+					env.Pool("User").Call("Create", NewModelData(userModel).
+						Set(Name, "Rob Smith").
+						Set(userModel.FieldName("IsPremium"), true))
+				})
+				So(r, ShouldHaveSameTypeAs, exceptions.ValidationError{})
+				So(r.(exceptions.ValidationError).Message, ShouldStartWith, "pq: Premium users must have positive nums")
+			})
+			Convey("Error messages are translated in the language of the Environment", func() {
+				i18n.Registry.LoadPOFile("testdata/fr_FR.po")
+				_, err := env.Pool("Tag").WithContext("lang", "fr_FR").TryCreate(NewModelData(tagModel).
+					Set(Name, "Same").
+					Set(description, "Same"))
+				So(err.(CallError).Message, ShouldEqual, "Le nom et la description de l'étiquette doivent être différents")
+				var valErr exceptions.ValidationError
+				So(errors.As(err, &valErr), ShouldBeTrue)
+				So(valErr.Message, ShouldEqual, "Le nom et la description de l'étiquette doivent être différents")
+				userErr := exceptions.UserError{Message: "Tag name and description must be different"}
+				So(env.TranslateError(userErr), ShouldResemble, userErr)
+				frEnv := env.Pool("Tag").WithContext("lang", "fr_FR").Env()
+				So(frEnv.TranslateError(userErr).(exceptions.UserError).Message, ShouldEqual, "Le nom et la description de l'étiquette doivent être différents")
+			})
+			Convey("Unknown records raise MissingErrors", func() {
+				So(panicValue(func() { env.Ref("unknown_external_id") }), ShouldHaveSameTypeAs, exceptions.MissingError{})
+				So(panicValue(func() { env.Pool("Tag").GetRecord("unknown_external_id") }), ShouldHaveSameTypeAs, exceptions.MissingError{})
+			})
+		}), ShouldBeNil)
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			Convey("Denied permissions raise AccessErrors", func() {
+				r := panicValue(func() { env.Pool("Tag").CheckExecutionPermission(tagModel.methods.MustGet("Unlink")) })
+				So(r, ShouldHaveSameTypeAs, exceptions.AccessError{})
+				So(r.(exceptions.AccessError).Message, ShouldEqual, "You are not allowed to execute this method")
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Checking memoization of non stored computed fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			userModel := Registry.MustGet("User")
+			writerTitle := postModel.FieldName("WriterTitle")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			john := env.Pool("User").Search(userModel.Field(Name).Equals("John Smith"))
+			post := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Memo Post").
+				Set(content, "Content").
+				Set(user, jane)).(RecordSet).Collection()
+			writerTitleComputeCount = 0
+			So(post.Get(writerTitle), ShouldEqual, "Memo Post by Jane Smith")
+			So(post.Get(writerTitle), ShouldEqual, "Memo Post by Jane Smith")
+			So(writerTitleComputeCount, ShouldEqual, 1)
+			Convey("Writing a field of the record invalidates the value", func() {
+				post.Set(title, "New Title")
+				So(post.Get(writerTitle), ShouldEqual, "New Title by Jane Smith")
+				So(post.Get(writerTitle), ShouldEqual, "New Title by Jane Smith")
+				So(writerTitleComputeCount, ShouldEqual, 2)
+				post.Set(user, john)
+				So(post.Get(writerTitle), ShouldEqual, "New Title by John Smith")
+				So(writerTitleComputeCount, ShouldEqual, 3)
+			})
+			Convey("Writing a field of a related record invalidates the value", func() {
+				jane.Set(Name, "Jane Doe")
+				So(post.Get(writerTitle), ShouldEqual, "Memo Post by Jane Doe")
+				So(writerTitleComputeCount, ShouldEqual, 2)
+			})
+			Convey("Values are memoized by context", func() {
+				So(post.WithContext("lang", "fr_FR").Get(writerTitle), ShouldEqual, "Memo Post by Jane Smith")
+				So(writerTitleComputeCount, ShouldEqual, 2)
+			})
+			Convey("Values are memoized by user", func() {
+				So(post.Sudo(2).computedMemoKey(), ShouldNotEqual, post.computedMemoKey())
+				_, ok := env.cache.getComputed("Post", post.ids[0], writerTitle.JSON(), post.Sudo(2).computedMemoKey())
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Invalidating a related record invalidates the value", func() {
+				env.Cr().Execute(`UPDATE "user" SET name = ? WHERE id = ?`, "Jane Raw", jane.ids[0])
+				env.cache.invalidateRecord(userModel, jane.ids[0])
+				So(post.Get(writerTitle), ShouldEqual, "Memo Post by Jane Raw")
+				So(writerTitleComputeCount, ShouldEqual, 2)
+			})
+			Convey("Writing a field not in dependencies does not invalidate the value", func() {
+				post.Set(content, "New content")
+				So(post.Get(writerTitle), ShouldEqual, "Memo Post by Jane Smith")
+				So(writerTitleComputeCount, ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Checking EvalCompute", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			writerTitle := Registry.MustGet("Post").FieldName("WriterTitle")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			Convey("Computing fields of memory records", func() {
+				memPost := env.New("Post", FieldMap{"Title": "Virtual Post", "User": jane})
+				writerTitleComputeCount = 0
+				So(memPost.EvalCompute("WriterTitle"), ShouldEqual, "Virtual Post by Jane Smith")
+				So(memPost.EvalCompute("writer_title"), ShouldEqual, "Virtual Post by Jane Smith")
+				So(writerTitleComputeCount, ShouldEqual, 2)
+				memUser := env.New("User", FieldMap{"Name": "Memory User", "Profile": jane.Get(profile)})
+				So(memUser.EvalCompute("Age"), ShouldEqual, jane.Get(profile).(RecordSet).Collection().Get(age))
+			})
+			Convey("Computed values should not be memoized", func() {
+				post := env.Pool("Post").Search(Registry.MustGet("Post").Field(user).Equals(jane)).Limit(1)
+				expected := post.Get(writerTitle)
+				writerTitleComputeCount = 0
+				So(post.EvalCompute("WriterTitle"), ShouldEqual, expected)
+				So(post.EvalCompute("WriterTitle"), ShouldEqual, expected)
+				So(writerTitleComputeCount, ShouldEqual, 2)
+			})
+			Convey("Non computed fields and multiple records should panic", func() {
+				So(func() { jane.EvalCompute("Name") }, ShouldPanic)
+				So(func() { env.Pool("User").SearchAll().EvalCompute("Age") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Checking SetComputed", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").SearchAll()
+			users.SetComputed(nums, func(rec *RecordCollection) interface{} {
+				return len(rec.Get(Name).(string))
+			})
+			for _, rec := range users.Records() {
+				So(rec.Get(nums), ShouldEqual, len(rec.Get(Name).(string)))
+			}
+			users.SetComputed(nums, func(rec *RecordCollection) interface{} {
+				if rec.Get(email) == "jane.smith@example.com" {
+					return 1
+				}
+				return 2
+			})
+			for _, rec := range users.Records() {
+				expected := 2
+				if rec.Get(email) == "jane.smith@example.com" {
+					expected = 1
+				}
+				So(rec.Get(nums), ShouldEqual, expected)
+				rec.InvalidateCache()
+				So(rec.Get(nums), ShouldEqual, expected)
+			}
+			So(func() {
+				users.SetComputed(users.Model().FieldName("DecoratedName"), func(rec *RecordCollection) interface{} {
+					return "Decorated"
+				})
+			}, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking aggregate expressions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			users := env.Pool("User").SearchAll()
+			users.SetComputed(nums, func(rec *RecordCollection) interface{} {
+				return len(rec.Get(Name).(string))
+			})
+			users.SetComputed(size, func(rec *RecordCollection) interface{} {
+				return 1.5
+			})
+			var expected float64
+			for _, rec := range users.Records() {
+				expected += float64(rec.Get(nums).(int)) * 1.5
+			}
+			So(users.Aggregate("SUM(Nums * Size)"), ShouldEqual, expected)
+			res := users.AggregateExprs("COUNT(*)", "MAX(Nums)", "MIN(Name)")
+			So(res["COUNT(*)"], ShouldEqual, users.Len())
+			So(res["MAX(Nums)"], ShouldEqual, len("Jane Smith"))
+			So(res["MIN(Name)"], ShouldEqual, "Jane Smith")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			So(jane.Aggregate("SUM(Nums)"), ShouldEqual, len("Jane Smith"))
+			So(jane.Aggregate("SUM(CASE WHEN Name = 'Nums' THEN 1 ELSE 0 END)"), ShouldEqual, 0)
+			So(env.Pool("User").Search(userModel.Field(Name).Equals("Nobody")).Aggregate("SUM(Nums)"), ShouldEqual, 0)
+			So(func() { users.Aggregate("MIN(Name)") }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking window expressions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			john := env.Pool("User").Search(userModel.Field(Name).Equals("John Smith"))
+			for _, data := range []struct {
+				title string
+				user  *RecordCollection
+			}{{"Ranked A", jane}, {"Ranked B", jane}, {"Ranked C", jane}, {"Ranked D", john}, {"Ranked E", john}} {
+				env.Pool("Post").Call("Create", NewModelData(postModel).
+					Set(title, data.title).
+					Set(content, "Content").
+					Set(user, data.user))
+			}
+			posts := env.Pool("Post").Search(postModel.Field(title).Contains("Ranked"))
+			titles := func(rs *RecordCollection) []string {
+				var res []string
+				for _, rec := range rs.OrderBy("Title").Records() {
+					res = append(res, rec.Get(title).(string))
+				}
+				return res
+			}
+			So(titles(posts.TopNPerGroup("User", "Title desc", 2)), ShouldResemble, []string{"Ranked B", "Ranked C", "Ranked D", "Ranked E"})
+			So(titles(posts.TopNPerGroup("User", "Title", 1)), ShouldResemble, []string{"Ranked A", "Ranked D"})
+			So(titles(posts.Search(postModel.Field(user).Equals(jane)).TopNPerGroup("User", "Title", 1)), ShouldResemble, []string{"Ranked A"})
+			crowded := posts.WithWindow("COUNT(*) OVER (PARTITION BY User)", "cnt").WhereWindow("cnt >= ?", 3)
+			So(titles(crowded), ShouldResemble, []string{"Ranked A", "Ranked B", "Ranked C"})
+			counts := posts.WithWindow("COUNT(*) OVER (PARTITION BY User)", "cnt").WindowValues("cnt")
+			So(counts, ShouldHaveLength, 5)
+			for _, rec := range posts.Records() {
+				expected := int64(2)
+				if rec.Get(user).(RecordSet).Collection().Equals(jane) {
+					expected = 3
+				}
+				So(counts[rec.Ids()[0]], ShouldEqual, expected)
+			}
+			So(func() { posts.WithWindow("COUNT(*) OVER ()", "cnt").WindowValues("rn") }, ShouldPanic)
+			So(func() { posts.WhereWindow("cnt >= ?", 3) }, ShouldPanic)
+			So(func() { posts.WithWindow("COUNT(*) OVER ()", "Cnt") }, ShouldPanic)
+			So(func() { posts.TopNPerGroup("User", "Title", 0) }, ShouldPanic)
+			So(func() { posts.TopNPerGroup("Tags", "Title", 1) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking common table expressions", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "CTE Post").
+				Set(content, "Content").
+				Set(user, jane))
+			ctePosts := env.Pool("Post").Search(postModel.Field(title).Equals("CTE Post"))
+			users := env.Pool("User").Search(userModel.Field(Name).Contains("Smith")).
+				With("cte_posts", ctePosts).
+				SearchRaw(`"user"."id" IN (SELECT "user_id" FROM cte_posts WHERE "content" = ?)`, "Content")
+			sql, args := users.ToSQL()
+			So(sql, ShouldContainSubstring, "WITH cte_posts AS (")
+			So(args[0], ShouldEqual, "CTE Post")
+			So(args[len(args)-1], ShouldEqual, "Content")
+			So(users.Ids(), ShouldResemble, jane.Ids())
+			So(users.SearchCount(), ShouldEqual, 1)
+			So(users.Get(Name), ShouldEqual, "Jane Smith")
+			noPosts := ctePosts.Search(postModel.Field(content).Equals("Other"))
+			So(env.Pool("User").With("cte_posts", noPosts).
+				SearchRaw(`"user"."id" IN (SELECT "user_id" FROM cte_posts)`).IsEmpty(), ShouldBeTrue)
+			So(func() { users.With("cte_posts", ctePosts) }, ShouldPanic)
+			So(func() { users.With("Posts", ctePosts) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking UNION ALL queries", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tags := env.Pool("Tag").SearchAll()
+			first := tags.OrderBy("Name").Limit(1)
+			last := tags.OrderBy("Name desc").Limit(1)
+			combined := first.UnionQuery(last)
+			So(first.fetched, ShouldBeFalse)
+			So(last.fetched, ShouldBeFalse)
+			So(combined.Len(), ShouldEqual, 2)
+			So(combined.OrderBy("Name").Ids(), ShouldResemble, first.Union(last).Ids())
+			So(combined.SearchCount(), ShouldEqual, 2)
+			twice := first.UnionQuery(first)
+			So(twice.SearchCount(), ShouldEqual, 2)
+			So(twice.Ids(), ShouldResemble, []int64{first.Ids()[0], first.Ids()[0]})
+			records := twice.Records()
+			So(records, ShouldHaveLength, 2)
+			So(records[1].Get(Name), ShouldEqual, first.Get(Name))
+			So(twice.UnionQuery(last).Len(), ShouldEqual, 3)
+			named := env.Pool("Tag").Search(tagModel.Field(Name).Equals(last.Get(Name)))
+			So(combined.Search(tagModel.Field(Name).Equals(last.Get(Name))).Ids(), ShouldResemble, named.Ids())
+			So(func() { combined.Set(description, "Combined") }, ShouldPanic)
+			So(func() { records[0].Set(description, "Combined") }, ShouldPanic)
+			So(func() { combined.Call("Unlink") }, ShouldPanic)
+			So(func() { first.UnionQuery(env.Pool("User").SearchAll()) }, ShouldPanic)
+			So(func() { first.UnionQuery(tags.GroupBy(Name)) }, ShouldPanic)
+			So(func() { first.UnionQuery(last.WithContext("lang", "fr_FR")) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking typed field getters", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			name, ok := GetAs[string](jane, "Name")
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "Jane Smith")
+			_, ok = GetAs[int64](jane, "Name")
+			So(ok, ShouldBeFalse)
+			jane.Set(nums, 12)
+			num, ok := GetAs[int64](jane, "Nums")
+			So(ok, ShouldBeTrue)
+			So(num, ShouldEqual, 12)
+			small, ok := GetAs[int8](jane, "Nums")
+			So(ok, ShouldBeTrue)
+			So(small, ShouldEqual, 12)
+			jane.Set(nums, 300)
+			_, ok = GetAs[int8](jane, "Nums")
+			So(ok, ShouldBeFalse)
+			jane.Set(nums, -3)
+			_, ok = GetAs[uint](jane, "Nums")
+			So(ok, ShouldBeFalse)
+			jane.Set(size, 1.5)
+			_, ok = GetAs[int](jane, "Size")
+			So(ok, ShouldBeFalse)
+			jane.Set(size, 2.0)
+			whole, ok := GetAs[int](jane, "Size")
+			So(ok, ShouldBeTrue)
+			So(whole, ShouldEqual, 2)
+			posts, ok := GetAs[*RecordCollection](jane, "Posts")
+			So(ok, ShouldBeTrue)
+			So(posts.Equals(jane.Get(userModel.FieldName("Posts")).(RecordSet)), ShouldBeTrue)
+			postsVal, ok := GetAs[RecordCollection](jane, "Posts")
+			So(ok, ShouldBeTrue)
+			So(postsVal.Ids(), ShouldResemble, posts.Ids())
+			postsSet, ok := GetAs[RecordSet](jane, "Posts")
+			So(ok, ShouldBeTrue)
+			So(postsSet.Ids(), ShouldResemble, posts.Ids())
+			So(MustGetAs[string](jane, "Email"), ShouldEqual, "jane.smith@example.com")
+			So(func() { MustGetAs[bool](jane, "Email") }, ShouldPanic)
+			So(func() { MustGetAs[string](jane, "Unknown") }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking counts of related records", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			postModel := Registry.MustGet("Post")
+			users := env.Pool("User").SearchAll()
+			counts := users.RelatedCount("Posts")
+			So(counts, ShouldHaveLength, users.Len())
+			for _, rec := range users.Records() {
+				So(counts[rec.Ids()[0]], ShouldEqual, rec.Get(posts).(RecordSet).Len())
+			}
+			will := env.Pool("User").Search(userModel.Field(Name).Equals("Will Smith"))
+			So(will.RelatedCount("Posts"), ShouldResemble, map[int64]int{will.Ids()[0]: will.Get(posts).(RecordSet).Len()})
+			allPosts := env.Pool("Post").SearchAll()
+			tagCounts := allPosts.RelatedCount("Tags")
+			for _, rec := range allPosts.Records() {
+				So(tagCounts[rec.Ids()[0]], ShouldEqual, rec.Get(tags).(RecordSet).Len())
+			}
+			So(env.Pool("Post").Search(postModel.Field(title).Equals("Nothing")).RelatedCount("Tags"), ShouldBeEmpty)
+			So(func() { users.RelatedCount("Name") }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking records hash", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			postModel := Registry.MustGet("Post")
+			userModel := Registry.MustGet("User")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			someTags := env.Pool("Tag").SearchAll().Limit(2)
+			post1 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Hashed Post").
+				Set(content, "Content").
+				Set(user, jane).
+				Set(tags, someTags)).(RecordSet).Collection()
+			post2 := env.Pool("Post").Call("Create", NewModelData(postModel).
+				Set(title, "Hashed Post").
+				Set(content, "Content").
+				Set(user, jane).
+				Set(tags, someTags.Records()[1].Union(someTags.Records()[0]))).(RecordSet).Collection()
+			hash1 := post1.Hash("Title", "Content", "User", "Tags")
+			So(hash1, ShouldHaveLength, 64)
+			So(post1.Hash("Title", "Content", "User", "Tags"), ShouldEqual, hash1)
+			So(post2.Hash("Title", "Content", "User", "Tags"), ShouldEqual, hash1)
+			So(post2.Hash("Tags", "User", "Content", "Title"), ShouldEqual, hash1)
+			So(post1.Hash(), ShouldEqual, post2.Hash())
+			post2.Set(content, "Other content")
+			So(post2.Hash("Title", "Content", "User", "Tags"), ShouldNotEqual, hash1)
+			So(post1.Hash("Title", "User"), ShouldEqual, post2.Hash("Title", "User"))
+			So(func() { post1.Union(post2).Hash() }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking keyset pagination", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			for i := 0; i < 5; i++ {
+				env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, fmt.Sprintf("Paged Tag %d", i%3)).
+					Set(description, fmt.Sprintf("Paged tag number %d", i)))
+			}
+			tags := env.Pool("Tag").Search(tagModel.Field(Name).Contains("Paged"))
+			readAll := func(rs *RecordCollection, limit int) []int64 {
+				var (
+					ids    []int64
+					cursor string
+				)
+				for {
+					var page *RecordCollection
+					page, cursor = rs.After(cursor, limit)
+					So(page.Len(), ShouldBeLessThanOrEqualTo, limit)
+					ids = append(ids, page.Ids()...)
+					if cursor == "" {
+						return ids
+					}
+				}
+			}
+			Convey("Pages should cover all records in order", func() {
+				So(readAll(tags.OrderBy("Name"), 2), ShouldResemble, tags.OrderBy("Name", "ID").Fetch().Ids())
+				So(readAll(tags.OrderBy("Name desc"), 2), ShouldResemble, tags.OrderBy("Name desc", "ID").Fetch().Ids())
+				So(readAll(tags.OrderBy("Name", "ID desc"), 3), ShouldResemble, tags.OrderBy("Name", "ID desc").Fetch().Ids())
+				So(readAll(tags, 5), ShouldResemble, tags.OrderBy("Name desc", "ID").Fetch().Ids())
+			})
+			Convey("A page holding the last records should have no next cursor", func() {
+				page, cursor := tags.After("", 5)
+				So(page.Len(), ShouldEqual, 5)
+				So(cursor, ShouldBeBlank)
+				page, cursor = tags.After("", 4)
+				So(page.Len(), ShouldEqual, 4)
+				So(cursor, ShouldNotBeBlank)
+			})
+			Convey("Pages should be ordered on decimal fields", func() {
+				companyModel := Registry.MustGet("Company")
+				for i, c := range []string{"2.50", "1.25", "2.50", "0.10"} {
+					env.Pool("Company").Call("Create", NewModelData(companyModel).
+						Set(Name, fmt.Sprintf("Paged Company %d", i)).
+						Set(companyModel.FieldName("Capital"), decimals.MustParse(c)))
+				}
+				companies := env.Pool("Company").Search(companyModel.Field(Name).Contains("Paged Company")).OrderBy("Capital")
+				So(readAll(companies, 1), ShouldResemble, companies.OrderBy("Capital", "ID").Fetch().Ids())
+			})
+			Convey("Records created before the cursor should not shift pages", func() {
+				page1, cursor := tags.OrderBy("Name").After("", 2)
+				env.Pool("Tag").Call("Create", NewModelData(tagModel).
+					Set(Name, "Paged Tag").
+					Set(description, "Late paged tag"))
+				page2, _ := tags.OrderBy("Name").After(cursor, 2)
+				So(page2.Intersect(page1).IsEmpty(), ShouldBeTrue)
+				So(page2.Records()[0].Get(Name), ShouldEqual, "Paged Tag 1")
+			})
+			Convey("Invalid cursors should panic", func() {
+				_, cursor := tags.OrderBy("Name").After("", 2)
+				So(cursor, ShouldNotBeBlank)
+				So(func() { tags.OrderBy("Name").After(cursor+"x", 2) }, ShouldPanic)
+				So(func() { tags.OrderBy("Name").After("x"+cursor, 2) }, ShouldPanic)
+				So(func() { tags.OrderBy("Name").After("garbage", 2) }, ShouldPanic)
+				So(func() { tags.OrderBy("Name desc").After(cursor, 2) }, ShouldPanic)
+				So(func() { env.Pool("User").OrderBy("Name").After(cursor, 2) }, ShouldPanic)
+				So(func() { tags.After("", 0) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Checking records changed since a given time", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			since := env.Cr().Now().Time.Add(-time.Millisecond)
+			newTag := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Changed Tag").
+				Set(description, "A new tag")).(RecordSet).Collection()
+			So(newTag.Get(writeDate), ShouldResemble, newTag.Get(createDate))
+			oldTag := env.Pool("Tag").Search(tagModel.Field(Name).NotEquals("Changed Tag")).Limit(1).Fetch()
+			So(env.Pool("Tag").ChangedSince(since).Ids(), ShouldResemble, newTag.Ids())
+			oldTag.Set(description, "Modified description")
+			changed := env.Pool("Tag").ChangedSince(since)
+			So(changed.Len(), ShouldEqual, 2)
+			So(changed.Intersect(oldTag).Len(), ShouldEqual, 1)
+			So(changed.Ids(), ShouldResemble, env.Pool("Tag").Search(tagModel.Field(ID).In(changed.Ids())).OrderBy("ID").Fetch().Ids())
+			So(env.Pool("Tag").ChangedSince(env.Cr().Now().Time).IsEmpty(), ShouldBeTrue)
+			page, cursor := env.Pool("Tag").ChangedSince(since).After("", 1)
+			So(page.Ids(), ShouldResemble, changed.Ids()[:1])
+			page, _ = env.Pool("Tag").ChangedSince(since).After(cursor, 1)
+			So(page.Ids(), ShouldResemble, changed.Ids()[1:])
+			So(func() { env.Pool("UserView").ChangedSince(since) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Checking self-referencing many2many fields", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			friends := userModel.FieldName("Friends")
+			friendOf := userModel.FieldName("FriendOf")
+			jane := env.Pool("User").Search(userModel.Field(Name).Equals("Jane Smith"))
+			john := env.Pool("User").Search(userModel.Field(Name).Equals("John Smith"))
+			will := env.Pool("User").Search(userModel.Field(Name).Equals("Will Smith"))
+			jane.Set(friends, john.Union(will))
+			So(jane.Get(friends).(RecordSet).Collection().Ids(), ShouldHaveLength, 2)
+			So(john.Get(friendOf).(RecordSet).Collection().Ids(), ShouldResemble, jane.Ids())
+			So(john.Get(friends).(RecordSet).IsEmpty(), ShouldBeTrue)
+			env.cache.invalidateRecord(userModel, jane.ids[0])
+			env.cache.invalidateRecord(userModel, will.ids[0])
+			So(jane.Get(friends).(RecordSet).Collection().Ids(), ShouldHaveLength, 2)
+			So(will.Get(friendOf).(RecordSet).Collection().Ids(), ShouldResemble, jane.Ids())
+			jane.Set(friends, jane.Get(friends).(RecordSet).Collection().Subtract(john))
+			So(jane.Get(friends).(RecordSet).Collection().Ids(), ShouldResemble, will.Ids())
+			So(john.Get(friendOf).(RecordSet).IsEmpty(), ShouldBeTrue)
+			will.Set(friends, will.Get(friends).(RecordSet).Collection().Union(jane))
+			So(jane.Get(friendOf).(RecordSet).Collection().Ids(), ShouldResemble, will.Ids())
+			janeFriends := env.Pool("User").Search(userModel.Field(friendOf).In(jane))
+			So(janeFriends.Ids(), ShouldResemble, will.Ids())
+			mutual := env.Pool("User").Search(userModel.Field(userModel.FieldName("Friends.Name")).Equals("Jane Smith").
+				And().Field(userModel.FieldName("FriendOf.Name")).Equals("Jane Smith"))
+			So(mutual.Ids(), ShouldResemble, will.Ids())
+		}), ShouldBeNil)
+	})
+	Convey("Checking rounding of values with digits", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			companyModel := Registry.MustGet("Company")
+			capital := companyModel.FieldName("Capital")
+			revenue := companyModel.FieldName("Revenue")
+			comp := env.Pool("Company").Call("Create", NewModelData(companyModel).
+				Set(Name, "Rounding Company").
+				Set(capital, decimals.MustParse("10.12345")).
+				Set(revenue, 1234.565)).(RecordSet).Collection()
+			So(comp.Get(capital).(decimals.Decimal).String(), ShouldEqual, "10.1235")
+			So(comp.Get(revenue), ShouldEqual, 1234.57)
+			comp.Set(revenue, 0.1+0.2)
+			So(comp.Get(revenue), ShouldEqual, 0.3)
+			env.cache.invalidateRecord(companyModel, comp.ids[0])
+			So(comp.Get(revenue), ShouldEqual, 0.3)
+			So(comp.Get(capital).(decimals.Decimal).String(), ShouldEqual, "10.1235")
+		}), ShouldBeNil)
+	})
+	Convey("Checking binary fields stored as attachments", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			profileModel := Registry.MustGet("Profile")
+			picture := profileModel.FieldName("Picture")
+			profile := env.Pool("Profile").Call("Create", NewModelData(profileModel).Set(picture, "aGV4eWE=")).(RecordSet).Collection()
+			So(profile.Get(picture), ShouldEqual, "aGV4eWE=")
+			var count int
+			env.Cr().Get(&count, "SELECT COUNT(*) FROM profile_hexya_picture_attachment WHERE record_id = ?", profile.ids[0])
+			So(count, ShouldEqual, 1)
+			profile.Set(picture, "aGV4eWEy")
+			env.cache.invalidateRecord(profileModel, profile.ids[0])
+			So(profile.Get(picture), ShouldEqual, "aGV4eWEy")
+			profile.Call("Unlink")
+			env.Cr().Get(&count, "SELECT COUNT(*) FROM profile_hexya_picture_attachment WHERE record_id = ?", profile.ids[0])
+			So(count, ShouldEqual, 0)
+		}), ShouldBeNil)
+	})
+	Convey("Checking optimistic locking with version field", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			resumeModel := Registry.MustGet("Resume")
+			education := resumeModel.FieldName("Education")
+			version := resumeModel.FieldName("Version")
+			resume := env.Pool("Resume").Call("Create", NewModelData(resumeModel).Set(education, "Hexya School")).(RecordSet).Collection()
+			readVersion := resume.Get(version).(int64)
+			resume.Call("Write", NewModelData(resumeModel).Set(education, "Hexya University").Set(version, readVersion))
+			So(resume.Get(version), ShouldEqual, readVersion+1)
+			So(resume.Get(education), ShouldEqual, "Hexya University")
+			Convey("Writing with a stale version should panic", func() {
+				So(func() {
+					resume.Call("Write", NewModelData(resumeModel).Set(education, "Hexya College").Set(version, readVersion))
+				}, ShouldPanic)
+			})
+			Convey("Writing without version should not check", func() {
+				resume.Call("Write", NewModelData(resumeModel).Set(education, "Hexya College"))
+				So(resume.Get(version), ShouldEqual, readVersion+2)
+			})
+			Convey("Values set by the database should be cached after writing", func() {
+				resume.Call("Write", NewModelData(resumeModel).Set(education, "Hexya College"))
+				So(env.cache.checkIfInCache(resumeModel, resume.Ids(), []string{"version", "education"}, resume.query.ctxArgsSlug(), true), ShouldBeTrue)
+				So(resume.Get(version), ShouldEqual, readVersion+2)
+			})
+		}), ShouldBeNil)
+	})
 
+	Convey("Checking write hook", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			type hookCall struct {
+				id      int64
+				changes FieldMap
+			}
+			var calls []hookCall
+			SetWriteHook(func(model string, id int64, changes FieldMap, uid int64) {
+				So(model, ShouldEqual, "User")
+				So(uid, ShouldEqual, security.SuperUserID)
+				calls = append(calls, hookCall{id: id, changes: changes})
+			})
+			defer SetWriteHook(nil)
+			users := env.Pool("User").Search(env.Pool("User").Model().Field(email).In([]string{"jane.smith@example.com", "will.smith@example.com"}))
+			userWill := users.Search(users.Model().Field(email).Equals("will.smith@example.com"))
+			users.Call("Write", NewModelData(users.Model()).Set(Name, "Jane A. Smith"))
+			So(calls, ShouldHaveLength, 1)
+			So(calls[0].id, ShouldEqual, userWill.Ids()[0])
+			So(calls[0].changes, ShouldResemble, FieldMap{"name": "Jane A. Smith"})
+		}), ShouldBeNil)
+	})
+
+	Convey("Checking record events", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			var events []string
+			for _, event := range []RecordEvent{EventCreate, EventWrite, EventUnlink} {
+				evt := event
+				On(evt, "Tag", func(rc *RecordCollection) {
+					So(rc.ModelName(), ShouldEqual, "Tag")
+					So(rc.Len(), ShouldEqual, 1)
+					events = append(events, string(evt))
+				})
+			}
+			On(EventWrite, "Tag", func(rc *RecordCollection) {
+				events = append(events, "second write handler")
+			})
+			defer func() {
+				eventHandlers.Lock()
+				for _, event := range []RecordEvent{EventCreate, EventWrite, EventUnlink} {
+					delete(eventHandlers.registry[event], "Tag")
+				}
+				eventHandlers.Unlock()
+			}()
+			tagModel := Registry.MustGet("Tag")
+			tag := env.Pool("Tag").Call("Create", NewModelData(tagModel, FieldMap{
+				"Name":        "Event Tag",
+				"Description": "A tag to check events",
+			})).(RecordSet).Collection()
+			tag.Set(tagModel.FieldName("Rate"), float32(5))
+			tagID := tag.Ids()[0]
+			tag.Call("Unlink")
+			So(events, ShouldResemble, []string{"create", "write", "second write handler", "unlink"})
+			So(env.cache.isInCache(tagModel, tagID, "id", "", false), ShouldBeFalse)
+			events = nil
+			userModel := Registry.MustGet("User")
+			for _, evt := range []RecordEvent{EventCreate, EventWrite} {
+				evt := evt
+				On(evt, "User", func(rc *RecordCollection) {
+					events = append(events, string(evt))
+				})
+			}
+			defer func() {
+				eventHandlers.Lock()
+				for _, event := range []RecordEvent{EventCreate, EventWrite} {
+					delete(eventHandlers.registry[event], "User")
+				}
+				eventHandlers.Unlock()
+			}()
+			env.Pool("User").Upsert(NewModelData(userModel, FieldMap{
+				"Name":  "Event User",
+				"Email": "event1@example.com",
+			}), []FieldName{userModel.FieldName("Name")})
+			So(events, ShouldNotBeEmpty)
+			So(events[0], ShouldEqual, "create")
+			events = nil
+			env.Pool("User").Upsert(NewModelData(userModel, FieldMap{
+				"Name":  "Event User",
+				"Email": "event2@example.com",
+			}), []FieldName{userModel.FieldName("Name")})
+			So(events, ShouldContain, "write")
+			So(events, ShouldNotContain, "create")
+			So(func() { On("read", "Tag", func(rc *RecordCollection) {}) }, ShouldPanic)
+		}), ShouldBeNil)
+	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	security.Registry.AddMembership(2, group1)
 	Convey("Testing access control list on update (write only)", t, func() {
@@ -831,6 +2107,131 @@ func TestUpdateRecordSet(t *testing.T) {
 				userModel.RemoveRecordRule("jOnly")
 				userModel.RemoveRecordRule("unlinkRule")
 			})
+			Convey("Checking that record rules do not apply to the superuser", func() {
+				userModel.methods.MustGet("Load").AllowGroup(group1)
+				rule := RecordRule{
+					Name:      "jOnlyRead",
+					Global:    true,
+					Condition: env.Pool("User").Model().Field(Name).IContains("j"),
+					Perms:     security.Read,
+				}
+				userModel.AddRecordRule(&rule)
+				defer userModel.RemoveRecordRule("jOnlyRead")
+				So(env.Pool("User").SearchAll().Len(), ShouldEqual, 2)
+				So(env.Pool("User").Sudo().SearchAll().Len(), ShouldEqual, 3)
+			})
+			Convey("Checking multi-company isolation", func() {
+				postModel := Registry.MustGet("Post")
+				companyModel := Registry.MustGet("Company")
+				company := postModel.FieldName("Company")
+				postModel.methods.MustGet("Load").AllowGroup(group1)
+				postModel.methods.MustGet("Create").AllowGroup(group1)
+				postModel.methods.MustGet("Write").AllowGroup(group1)
+				companyModel.methods.MustGet("Load").AllowGroup(group1)
+				comp1 := env.Pool("Company").Sudo().Call("Create", NewModelData(companyModel).
+					Set(Name, "Company 1")).(RecordSet).Collection()
+				comp2 := env.Pool("Company").Sudo().Call("Create", NewModelData(companyModel).
+					Set(Name, "Company 2")).(RecordSet).Collection()
+				for _, comp := range []*RecordCollection{comp1, comp2} {
+					env.Pool("Post").Sudo().Call("Create", NewModelData(postModel).
+						Set(title, "Company Post").
+						Set(content, "Post of "+comp.Get(Name).(string)).
+						Set(company, comp))
+				}
+				cond := postModel.Field(title).Equals("Company Post")
+				So(env.Pool("Post").Search(cond).Len(), ShouldEqual, 2)
+
+				posts := env.Pool("Post").WithContext("allowed_company_ids", []int64{comp1.Ids()[0]}).Search(cond)
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get(company).(RecordSet).Collection().Equals(comp1), ShouldBeTrue)
+				So(func() { posts.Set(company, comp2) }, ShouldPanic)
+				So(func() { posts.Set(company, comp1) }, ShouldNotPanic)
+				So(func() {
+					posts.Call("Create", NewModelData(postModel).
+						Set(title, "Other Company Post").
+						Set(content, "Post of company 2").
+						Set(company, comp2))
+				}, ShouldPanic)
+				So(env.Pool("Post").Sudo().WithContext("allowed_company_ids", []int64{comp1.Ids()[0]}).Search(cond).Len(), ShouldEqual, 2)
+
+				SetAllowedCompaniesFunc(func(env Environment) []int64 {
+					return []int64{comp2.Ids()[0]}
+				})
+				defer SetAllowedCompaniesFunc(nil)
+				posts = env.Pool("Post").Search(cond)
+				So(posts.Len(), ShouldEqual, 1)
+				So(posts.Get(company).(RecordSet).Collection().Equals(comp2), ShouldBeTrue)
+			})
+			Convey("Checking fields read only after creation", func() {
+				companyModel := Registry.MustGet("Company")
+				code := companyModel.FieldName("Code")
+				companyModel.methods.MustGet("Load").AllowGroup(group1)
+				companyModel.methods.MustGet("Write").AllowGroup(group1)
+				sudoComp := env.Pool("Company").Sudo().Call("Create", NewModelData(companyModel).
+					Set(Name, "Coded Company").
+					Set(code, "CC001")).(RecordSet).Collection()
+				comp := env.Pool("Company").withIds(sudoComp.Ids())
+				So(comp.Get(code), ShouldEqual, "CC001")
+				So(func() { comp.Set(code, "CC001") }, ShouldNotPanic)
+				So(func() { comp.Set(Name, "Renamed Company") }, ShouldNotPanic)
+				func() {
+					defer func() {
+						_, ok := recover().(exceptions.ValidationError)
+						So(ok, ShouldBeTrue)
+					}()
+					comp.Set(code, "CC002")
+				}()
+				So(func() {
+					comp.Call("Write", NewModelData(companyModel).Set(code, "CC002"))
+				}, ShouldPanic)
+				So(func() {
+					comp.SetComputed(code, func(rec *RecordCollection) interface{} { return "CC001" })
+				}, ShouldNotPanic)
+				func() {
+					defer func() {
+						_, ok := recover().(exceptions.ValidationError)
+						So(ok, ShouldBeTrue)
+					}()
+					comp.SetComputed(code, func(rec *RecordCollection) interface{} { return "CC002" })
+				}()
+				comp.Sudo().Set(code, "CC002")
+				So(comp.Get(code), ShouldEqual, "CC002")
+				So(valuesEqual(comp, comp.Ids()[0]), ShouldBeTrue)
+				So(valuesEqual(comp, []int64{comp.Ids()[0]}), ShouldBeTrue)
+				So(valuesEqual(comp, int64(0)), ShouldBeFalse)
+			})
+			Convey("Checking field access rights", func() {
+				userModel.methods.MustGet("Load").AllowGroup(group1)
+				userModel.methods.MustGet("Read").AllowGroup(group1)
+				userModel.methods.MustGet("Write").AllowGroup(group1)
+				fieldGroup := security.Registry.NewGroup("fieldGroup", "Field Group")
+				numsField := userModel.fields.MustGet("Nums")
+				emailField := userModel.fields.MustGet("Email")
+				numsField.groups = map[*security.Group]bool{fieldGroup: true}
+				emailField.writeGroups = map[*security.Group]bool{fieldGroup: true}
+				defer func() {
+					numsField.groups = nil
+					emailField.writeGroups = nil
+					security.Registry.UnregisterGroup(fieldGroup)
+				}()
+
+				john := env.Pool("User").Search(env.Pool("User").Model().Field(Name).Equals("John Smith"))
+				johnData := john.Call("Read", FieldNames{Name, email, nums}).([]RecordData)
+				So(johnData, ShouldHaveLength, 1)
+				So(johnData[0].Underlying().Has(Name), ShouldBeTrue)
+				So(johnData[0].Underlying().Has(email), ShouldBeTrue)
+				So(johnData[0].Underlying().Has(nums), ShouldBeFalse)
+
+				So(func() { john.Set(nums, 14) }, ShouldPanic)
+				So(func() { john.Set(email, "jsmith4@example.com") }, ShouldPanic)
+				So(func() { john.Set(Name, "John B. Smith") }, ShouldNotPanic)
+				So(func() { john.Sudo().Set(nums, 14) }, ShouldNotPanic)
+
+				security.Registry.AddMembership(2, fieldGroup)
+				johnData = john.Call("Read", FieldNames{nums}).([]RecordData)
+				So(johnData[0].Underlying().Has(nums), ShouldBeTrue)
+				So(func() { john.Set(email, "jsmith4@example.com") }, ShouldNotPanic)
+			})
 		}), ShouldBeNil)
 	})
 	security.Registry.UnregisterGroup(group1)
@@ -875,10 +2276,67 @@ func TestDeleteRecordSet(t *testing.T) {
 				userJohn.ForceLoad()
 				So(userJohn.Len(), ShouldEqual, 0)
 			})
+			Convey("Deleting records by condition without loading them", func() {
+				users := env.Pool("User").Search(env.Pool("User").Model().Field(Name).Equals("John Smith").Or().Field(Name).Equals("Will Smith"))
+				So(users.Len(), ShouldEqual, 2)
+				So(env.Pool("User").SearchAll().DeleteAll, ShouldPanic)
+				So(env.Pool("User").Search(env.Pool("User").Model().Field(Name).Equals("Nobody")).OrderBy("Email", "Name desc").DeleteAll(), ShouldEqual, 0)
+				num := env.Pool("User").Search(env.Pool("User").Model().Field(Name).Equals("John Smith").Or().Field(Name).Equals("Will Smith")).DeleteAll()
+				So(num, ShouldEqual, 2)
+				users.ForceLoad()
+				So(users.IsEmpty(), ShouldBeTrue)
+				So(env.Pool("User").SearchAll().Len(), ShouldEqual, 1)
+			})
+			Convey("Modifying all records without condition should be explicitly allowed", func() {
+				tags := env.Pool("Tag").SearchAll()
+				So(tags.Len(), ShouldBeGreaterThan, 0)
+				So(func() { tags.Call("Unlink") }, ShouldPanic)
+				So(func() { env.Pool("Tag").SearchAll().Set(Name, "All tags") }, ShouldPanic)
+				So(env.Pool("Tag").SearchAll().Len(), ShouldEqual, tags.Len())
+				first := tags.Records()[0]
+				filtered := env.Pool("Tag").SearchAll().Fetch().Search(env.Pool("Tag").Model().Field(ID).Equals(first.Ids()[0]))
+				So(filtered.Ids(), ShouldResemble, first.Ids())
+				So(func() { filtered.Set(Name, "Filtered tag") }, ShouldNotPanic)
+				So(first.Get(Name), ShouldEqual, "Filtered tag")
+				So(func() { filtered.Call("Unlink") }, ShouldNotPanic)
+				So(env.Pool("Tag").SearchAll().Len(), ShouldEqual, tags.Len()-1)
+				env.Pool("Tag").SearchAll().WithAllRecords().Call("Unlink")
+				So(env.Pool("Tag").SearchAll().IsEmpty(), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 	group1 := security.Registry.NewGroup("group1", "Group 1")
 	security.Registry.AddMembership(2, group1)
+	Convey("Checking tombstones of deleted records", t, func() {
+		So(Registry.MustGet("TagHexyaTombstone").isSystem(), ShouldBeTrue)
+		So(func() { Registry.MustGet("UserHexyaTombstone") }, ShouldPanic)
+		var (
+			since        time.Time
+			unlinkedID   int64
+			deletedAllID int64
+		)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			since = env.Cr().Now().Time
+		}), ShouldBeNil)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			tag1 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Tombstone Tag 1").
+				Set(description, "To be unlinked")).(RecordSet).Collection()
+			tag2 := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Tombstone Tag 2").
+				Set(description, "To be deleted")).(RecordSet).Collection()
+			unlinkedID, deletedAllID = tag1.Ids()[0], tag2.Ids()[0]
+			So(tag1.Call("Unlink"), ShouldEqual, 1)
+			So(env.Pool("Tag").Search(tagModel.Field(Name).Equals("Tombstone Tag 2")).DeleteAll(), ShouldEqual, 1)
+		}), ShouldBeNil)
+		deleted := DeletedSince("Tag", since)
+		So(deleted, ShouldHaveLength, 2)
+		So(deleted, ShouldContain, unlinkedID)
+		So(deleted, ShouldContain, deletedAllID)
+		So(DeletedSince("Tag", time.Now().Add(time.Hour)), ShouldBeEmpty)
+		So(func() { DeletedSince("User", since) }, ShouldPanic)
+	})
 	Convey("Checking unlink access permissions", t, func() {
 		So(SimulateInNewEnvironment(2, func(env Environment) {
 			userModel := Registry.MustGet("User")
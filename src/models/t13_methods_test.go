@@ -55,8 +55,33 @@ func TestMethods(t *testing.T) {
 				So(res1.FieldMap, ShouldContainKey, "decorated_name")
 				So(res1.FieldMap["decorated_name"], ShouldEqual, "User: Jane A. Smith [<jane.smith@example.com>]")
 			})
+			Convey("Calling a method registered with AddMethod", func() {
+				users := env.Pool("User").SearchAll()
+				So(users.Call("NamesCount"), ShouldEqual, 3)
+			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing method layers dump", t, func() {
+		Convey("Layers of a method are listed from top to bottom", func() {
+			layers := MethodLayers("User", "PrefixedUser")
+			So(layers, ShouldHaveLength, 2)
+			So(layers[1], ShouldContainSubstring, "testPrefixdUser")
+			So(layers[1], ShouldContainSubstring, "t01_models_test.go")
+			So(layers[0], ShouldNotContainSubstring, "[mixed in]")
+		})
+		Convey("Mixed in layers are below the model's own layers", func() {
+			layers := MethodLayers("Profile", "PrintAddress")
+			So(layers, ShouldHaveLength, 4)
+			So(layers[0], ShouldNotContainSubstring, "[mixed in]")
+			So(layers[1], ShouldNotContainSubstring, "[mixed in]")
+			So(layers[2], ShouldEndWith, "[mixed in]")
+			So(layers[3], ShouldEndWith, "[mixed in]")
+		})
+		Convey("Unknown models or methods return no layers", func() {
+			So(MethodLayers("NonExistentModel", "PrefixedUser"), ShouldBeEmpty)
+			So(MethodLayers("User", "NonExistentMethod"), ShouldBeEmpty)
+		})
+	})
 }
 
 func TestComputedNonStoredFields(t *testing.T) {
@@ -80,6 +105,21 @@ func TestComputedNonStoredFields(t *testing.T) {
 				users = users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
 				So(users.Get(displayName).(string), ShouldEqual, "Jane A. Smith")
 			})
+			Convey("Searching on built-in DisplayName", func() {
+				users := env.Pool("User")
+				jane := users.Search(users.Model().Field(displayName).Equals("Jane A. Smith"))
+				So(jane.Len(), ShouldEqual, 1)
+				So(jane.Get(email), ShouldEqual, "jane.smith@example.com")
+				So(users.Model().fields.MustGet("DisplayName").depends, ShouldResemble, []string{"Name"})
+			})
+			Convey("Searching on a computed field with a search method", func() {
+				users := env.Pool("User")
+				jane := users.Search(users.Model().Field(decoratedName).Equals("User: Jane A. Smith [<jane.smith@example.com>]"))
+				So(jane.Len(), ShouldEqual, 1)
+				So(jane.Get(email), ShouldEqual, "jane.smith@example.com")
+				smiths := users.Search(users.Model().Field(decoratedName).IContains("smith"))
+				So(smiths.Len(), ShouldEqual, 3)
+			})
 			Convey("Testing computed field through a related field", func() {
 				users := env.Pool("User")
 				jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
@@ -391,7 +431,7 @@ func TestContextedFields(t *testing.T) {
 	Convey("Testing contexted group by queries", t, func() {
 		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
 			mTags := env.Pool("Tag")
-			mTags.SearchAll().Call("Unlink")
+			mTags.SearchAll().WithAllRecords().Call("Unlink")
 			Convey("Simple group by query", func() {
 				tag1 := mTags.Call("Create", NewModelData(mTags.model).
 					Set(Name, "Contexted tag").
@@ -15,10 +15,15 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -221,6 +226,31 @@ X2M Links
 				So(userMatt.Get(nums), ShouldEqual, 4)
 				So(userMatt.Get(age), ShouldEqual, 23)
 			})
+			Convey("Cache limit should evict least recently used records", func() {
+				memTag := env.New("Tag", FieldMap{"Name": "Memory Tag"})
+				env.SetCacheLimit(2)
+				allUsers := users.SearchAll().Load(Name)
+				So(len(env.cache.data[users.model.name]), ShouldEqual, allUsers.Len())
+				So(allUsers.Len(), ShouldBeGreaterThan, 2)
+				env.Pool("Tag").SearchAll().Load(Name)
+				So(len(env.cache.data[users.model.name]), ShouldBeLessThan, 2)
+				So(memTag.Get(Name), ShouldEqual, "Memory Tag")
+				So(allUsers.Records()[0].Get(Name), ShouldNotBeBlank)
+			})
+			Convey("Evicting records should only remove the relations referencing them", func() {
+				jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+				janePosts := jane.Get(posts).(*RecordCollection)
+				So(janePosts.Len(), ShouldEqual, 2)
+				john := users.Search(users.Model().Field(Name).Equals("John Smith"))
+				john.Get(posts)
+				So(env.cache.checkIfInCache(users.model, john.Ids(), []string{posts.JSON()}, "", true), ShouldBeTrue)
+				post := janePosts.Records()[0]
+				post.Load()
+				env.cache.removeX2MEntries(post.model, post.Ids()[0])
+				So(env.cache.checkIfInCache(users.model, jane.Ids(), []string{posts.JSON()}, "", true), ShouldBeFalse)
+				So(env.cache.checkIfInCache(users.model, john.Ids(), []string{posts.JSON()}, "", true), ShouldBeTrue)
+				So(jane.Get(posts).(*RecordCollection).Len(), ShouldEqual, 2)
+			})
 		}), ShouldBeNil)
 	})
 	Convey("Testing prefetch", t, func() {
@@ -256,6 +286,128 @@ X2M Links
 				So(postsJohn, ShouldHaveLength, 0)
 				So(postsJane, ShouldHaveLength, 2)
 			})
+			Convey("Records pointed at by many2one fields should be loaded together", func() {
+				postSet := env.Pool("Post").Search(env.Pool("Post").Model().Field(user).IsNotNull())
+				records := postSet.Records()
+				So(len(records), ShouldBeGreaterThan, 1)
+				writer := records[0].Get(user).(*RecordCollection)
+				So(writer.prefetchRC.Ids(), ShouldContain, writer.Ids()[0])
+				So(writer.prefetchRC.Ids(), ShouldContain, records[1].Get(user).(*RecordCollection).Ids()[0])
+				_, fetched := writer.get(Name, false)
+				So(fetched, ShouldBeTrue)
+				So(env.cache.checkIfInCache(users.Model(), writer.prefetchRC.Ids(), []string{Name.JSON()}, "", false), ShouldBeTrue)
+				So(records[1].Get(user).(*RecordCollection).prefetchRC, ShouldEqual, writer.prefetchRC)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing Environment Flush", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userJane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			janeProfile := userJane.Get(profile).(RecordSet).Collection()
+			var dbAge int16
+			env.DeferRecompute(func() {
+				janeProfile.Set(age, int16(42))
+				So(env.recompute.isEmpty(), ShouldBeFalse)
+				env.Cr().Get(&dbAge, `SELECT age FROM "user" WHERE id = ?`, userJane.Ids()[0])
+				So(dbAge, ShouldNotEqual, 42)
+				env.Flush()
+				So(env.recompute.isEmpty(), ShouldBeTrue)
+				env.Cr().Get(&dbAge, `SELECT age FROM "user" WHERE id = ?`, userJane.Ids()[0])
+				So(dbAge, ShouldEqual, 42)
+			})
+			env.DeferRecompute(func() {
+				err := janeProfile.try("Write", func() {
+					janeProfile.Set(age, int16(24))
+					So(env.recompute.isEmpty(), ShouldBeFalse)
+					panic("rollback")
+				})
+				So(err, ShouldNotBeNil)
+				So(env.recompute.isEmpty(), ShouldBeTrue)
+			})
+			env.Cr().Get(&dbAge, `SELECT age FROM "user" WHERE id = ?`, userJane.Ids()[0])
+			So(dbAge, ShouldEqual, 42)
+		}), ShouldBeNil)
+	})
+	Convey("Testing external IDs resolution", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userJane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			So(userJane.ExternalID(), ShouldNotBeEmpty)
+			So(func() { env.Ref(userJane.ExternalID()) }, ShouldPanic)
+			userJane.Set(users.Model().FieldName("HexyaExternalID"), "test_module.user_jane")
+			jane := env.Ref("test_module.user_jane")
+			So(jane.ModelName(), ShouldEqual, "User")
+			So(jane.Equals(userJane), ShouldBeTrue)
+			So(func() { env.Ref("other_module.user_jane") }, ShouldPanic)
+			tagModel := Registry.MustGet("Tag")
+			tag := env.Pool("Tag").Call("Create", NewModelData(tagModel).
+				Set(Name, "Referenced Tag").
+				Set(tagModel.FieldName("HexyaExternalID"), "test_module.referenced_tag")).(RecordSet).Collection()
+			So(tag.ExternalID(), ShouldEqual, "test_module.referenced_tag")
+			ref := env.Ref("test_module.referenced_tag")
+			So(ref.ModelName(), ShouldEqual, "Tag")
+			So(ref.Ids(), ShouldResemble, tag.Ids())
+			So(func() { env.Ref("test_module.unknown") }, ShouldPanic)
+			tag.Call("Unlink")
+			So(func() { env.Ref("test_module.referenced_tag") }, ShouldPanic)
+			So(func() { env.Pool("User").SearchAll().ExternalID() }, ShouldPanic)
+			func() {
+				defer func() {
+					_, ok := recover().(exceptions.ValidationError)
+					So(ok, ShouldBeTrue)
+				}()
+				users.SearchAll().Set(users.Model().FieldName("HexyaExternalID"), "test_module.all_users")
+			}()
+		}), ShouldBeNil)
+	})
+	Convey("Testing filtered pools", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			cond := userModel.Field(email).Equals("jane.smith@example.com")
+			users := env.PoolFiltered("User", cond)
+			So(users.fetched, ShouldBeFalse)
+			So(users.Ids(), ShouldResemble, env.Pool("User").Search(cond).Ids())
+			So(users.Get(Name), ShouldEqual, "Jane Smith")
+			So(env.PoolFiltered("User", userModel.FilteredOn(profile,
+				Registry.MustGet("Profile").Field(age).Equals(-1))).SearchCount(), ShouldEqual, 0)
+			So(func() { env.PoolFiltered("Unknown", cond) }, ShouldPanic)
+			So(func() { env.PoolFiltered("Tag", cond) }, ShouldPanic)
+			So(func() {
+				env.PoolFiltered("User", userModel.Field(fieldName{name: "Profile.Unknown", json: "profile_id.unknown"}).Equals("Bar"))
+			}, ShouldPanic)
+		}), ShouldBeNil)
+	})
+	Convey("Testing read-only clones of an Environment", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			tagModel := Registry.MustGet("Tag")
+			env.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Uncommitted Tag"))
+			cond := tagModel.Field(Name).Equals("Uncommitted Tag")
+			So(env.Pool("Tag").Search(cond).Len(), ShouldEqual, 1)
+			userCount := env.Pool("User").SearchAll().SearchCount()
+			counts := make([]int, 2)
+			var wg sync.WaitGroup
+			for i := range counts {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					clone := env.CloneReadOnly()
+					defer clone.Close()
+					counts[i] = clone.Pool("User").SearchAll().SearchCount()
+				}(i)
+			}
+			wg.Wait()
+			So(counts, ShouldResemble, []int{userCount, userCount})
+
+			clone := env.CloneReadOnly()
+			defer clone.Close()
+			So(clone.ReadOnly(), ShouldBeTrue)
+			So(clone.cache, ShouldNotEqual, env.cache)
+			So(clone.Pool("Tag").Search(cond).IsEmpty(), ShouldBeTrue)
+			So(func() {
+				clone.Pool("Tag").Call("Create", NewModelData(tagModel).Set(Name, "Clone Tag"))
+			}, ShouldPanic)
+			So(func() { env.Close() }, ShouldPanic)
 		}), ShouldBeNil)
 	})
 	Convey("Checking error types", t, func() {
@@ -267,7 +419,7 @@ X2M Links
 	Convey("Testing db error retries", t, func() {
 		Convey("ExecuteInNewEnvironment should retry db errors up to max retries", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
 				retries++
 				panic(&pq.Error{Code: "40001"})
 			}), ShouldNotBeNil)
@@ -275,7 +427,7 @@ X2M Links
 		})
 		Convey("ExecuteInNewEnvironment should retry db errors and stop when ok", func() {
 			var retries uint8
-			So(doExecuteInNewEnvironment(security.SuperUserID, 0, func(env Environment) {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
 				retries++
 				if retries < 3 {
 					panic(&pq.Error{Code: "40001"})
@@ -301,5 +453,71 @@ X2M Links
 			}), ShouldBeNil)
 			So(retries, ShouldEqual, 3)
 		})
+		Convey("RunInTransaction should retry db errors up to max retries", func() {
+			var retries uint8
+			err := RunInTransaction(security.SuperUserID, func(env Environment) error {
+				retries++
+				return &pq.Error{Code: "40001"}
+			}, WithMaxRetries(3), WithBackoff(time.Millisecond))
+			So(err, ShouldNotBeNil)
+			So(retries, ShouldEqual, 3)
+		})
+		Convey("RunInTransaction should retry panicking db errors and stop when ok", func() {
+			var retries uint8
+			So(RunInTransaction(security.SuperUserID, func(env Environment) error {
+				retries++
+				if retries < 3 {
+					panic(&pq.Error{Code: "40P01"})
+				}
+				return nil
+			}, WithBackoff(time.Millisecond)), ShouldBeNil)
+			So(retries, ShouldEqual, 3)
+		})
+		Convey("ExecuteInNewEnvironment should set the given isolation level", func() {
+			var level string
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Cr().Get(&level, "SHOW transaction_isolation")
+			}, WithIsolationLevel(sql.LevelReadCommitted)), ShouldBeNil)
+			So(level, ShouldEqual, "read committed")
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(env.ReadOnly(), ShouldBeTrue)
+			}, WithReadOnly()), ShouldBeNil)
+		})
+		Convey("RunInTransaction should set the given isolation level", func() {
+			var level string
+			So(RunInTransaction(security.SuperUserID, func(env Environment) error {
+				env.Cr().Get(&level, "SHOW transaction_isolation")
+				return nil
+			}, WithIsolationLevel(sql.LevelReadCommitted)), ShouldBeNil)
+			So(level, ShouldEqual, "read committed")
+			So(RunInTransaction(security.SuperUserID, func(env Environment) error {
+				env.Cr().Get(&level, "SHOW transaction_isolation")
+				return nil
+			}), ShouldBeNil)
+			So(level, ShouldEqual, "serializable")
+		})
+		Convey("RunInTransaction with WithReadOnly should forbid modifications", func() {
+			So(RunInTransaction(security.SuperUserID, func(env Environment) error {
+				So(env.ReadOnly(), ShouldBeTrue)
+				users := env.Pool("User")
+				So(users.SearchAll().Len(), ShouldBeGreaterThan, 0)
+				So(func() {
+					users.SearchAll().Call("Write", NewModelData(users.Model()).Set(Name, "Read-only"))
+				}, ShouldPanic)
+				So(func() {
+					users.Call("Create", NewModelData(users.Model()).Set(Name, "Read-only"))
+				}, ShouldPanic)
+				So(func() { users.SearchAll().Call("Unlink") }, ShouldPanic)
+				return nil
+			}, WithReadOnly()), ShouldBeNil)
+		})
+		Convey("RunInTransaction should not retry other errors", func() {
+			var retries uint8
+			So(RunInTransaction(security.SuperUserID, func(env Environment) error {
+				retries++
+				return errors.New("some error")
+			}), ShouldNotBeNil)
+			So(retries, ShouldEqual, 1)
+		})
 	})
 }
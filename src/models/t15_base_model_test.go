@@ -31,6 +31,23 @@ func TestBaseModelMethods(t *testing.T) {
 				time.Sleep(1*time.Second + 100*time.Millisecond)
 				So(newComment.Get(lastupdate).(dates.DateTime).Sub(newComment.Get(createDate).(dates.DateTime)), ShouldBeLessThanOrEqualTo, 1*time.Second)
 			})
+			Convey("Access fields are set by the ORM", func() {
+				createUID := commentModel.FieldName("CreateUID")
+				writeUID := commentModel.FieldName("WriteUID")
+				forged := dates.DateTime{Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+				newComment := commentModel.Create(env, NewModelData(commentModel).
+					Set(text, "MyComment").
+					Set(createDate, forged).
+					Set(createUID, 42))
+				So(newComment.Get(createDate), ShouldResemble, env.Cr().Now())
+				So(newComment.Get(createUID), ShouldEqual, security.SuperUserID)
+				newComment.Call("Write", NewModelData(commentModel).
+					Set(text, "MyNewComment").
+					Set(writeDate, forged).
+					Set(writeUID, 42))
+				So(newComment.Get(writeDate), ShouldResemble, env.Cr().Now())
+				So(newComment.Get(writeUID), ShouldEqual, security.SuperUserID)
+			})
 			Convey("Load and Read", func() {
 				userJane = userJane.Call("Load", []FieldName{ID, Name, age, posts, profile}).(RecordSet).Collection()
 				res := userJane.Call("Read", []FieldName{Name, age, posts, profile})
@@ -48,6 +65,48 @@ func TestBaseModelMethods(t *testing.T) {
 				So(fMap, ShouldContainKey, "id")
 				So(fMap["id"], ShouldEqual, userJane.Ids()[0])
 			})
+			Convey("Read with aliases", func() {
+				res := userJane.Call("Read", []FieldName{
+					userModel.FieldName("Profile.Age as profile_age"),
+					userModel.FieldName("Name AS user_name"),
+					profileAge,
+					email,
+				}).([]RecordData)
+				So(res, ShouldHaveLength, 1)
+				fMap := res[0].Underlying().FieldMap
+				So(fMap, ShouldHaveLength, 5)
+				So(fMap, ShouldContainKey, "profile_age")
+				So(fMap["profile_age"], ShouldEqual, userJane.Get(profileAge))
+				So(fMap, ShouldContainKey, "user_name")
+				So(fMap["user_name"], ShouldEqual, "Jane A. Smith")
+				So(fMap, ShouldContainKey, "profile_id.age")
+				So(fMap, ShouldContainKey, "email")
+				So(fMap, ShouldContainKey, "id")
+				So(func() { userModel.FieldName("Profile.Agee as age") }, ShouldPanic)
+			})
+			Convey("ReadDepth", func() {
+				res := userJane.ReadDepth(1, "Name", "Profile", "Posts")
+				So(res, ShouldHaveLength, 1)
+				So(res[0], ShouldHaveLength, 4)
+				So(res[0]["name"], ShouldEqual, "Jane A. Smith")
+				So(res[0]["id"], ShouldEqual, userJane.Ids()[0])
+				profileData, ok := res[0]["profile_id"].(FieldMap)
+				So(ok, ShouldBeTrue)
+				So(profileData["id"], ShouldEqual, userJane.Get(profile).(RecordSet).Collection().Ids()[0])
+				So(profileData, ShouldContainKey, "age")
+				postsData, ok := res[0]["posts_ids"].([]FieldMap)
+				So(ok, ShouldBeTrue)
+				So(postsData, ShouldHaveLength, 2)
+				So(postsData[0]["user_id"], ShouldEqual, userJane.Ids()[0])
+				So(postsData[0]["tags_ids"], ShouldHaveSameTypeAs, []int64{})
+				res = userJane.ReadDepth(2, "Posts")
+				postsData = res[0]["posts_ids"].([]FieldMap)
+				So(postsData[0]["user_id"], ShouldEqual, userJane.Ids()[0])
+				So(postsData[0]["tags_ids"], ShouldHaveSameTypeAs, []FieldMap{})
+				res = userJane.ReadDepth(0, "Profile", "Posts")
+				So(res[0]["profile_id"], ShouldEqual, userJane.Get(profile).(RecordSet).Collection().Ids()[0])
+				So(res[0]["posts_ids"], ShouldHaveLength, 2)
+			})
 			Convey("Browse and BrowseOne", func() {
 				jid := userJane.Ids()[0]
 				j2 := userModel.Browse(env, []int64{jid})
@@ -95,7 +154,7 @@ func TestBaseModelMethods(t *testing.T) {
 				So(fInfo.Help, ShouldEqual, "The user's username")
 				So(fInfo.Type, ShouldEqual, fieldtype.Char)
 				fInfos := userJane.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
-				So(fInfos, ShouldHaveLength, 35)
+				So(fInfos, ShouldHaveLength, 37)
 			})
 			Convey("NameGet", func() {
 				So(userJane.Get(displayName), ShouldEqual, "Jane A. Smith")
@@ -131,6 +190,20 @@ func TestBaseModelMethods(t *testing.T) {
 				So(dummyUser.Get(decoratedName), ShouldEqual, "User: DummyUser [<du@example.com>]")
 				So(func() { dummyUser.unlink() }, ShouldNotPanic)
 			})
+			Convey("New from environment and Create", func() {
+				memTag := env.New("Tag", FieldMap{"Name": "Memory Tag"})
+				So(memTag.Ids()[0], ShouldBeLessThan, 0)
+				memTag.Set(description, "Tag created in memory")
+				So(memTag.Get(description), ShouldEqual, "Tag created in memory")
+				cond := tagModel.Field(Name).Equals("Memory Tag")
+				So(env.Pool("Tag").Search(cond).IsEmpty(), ShouldBeTrue)
+				tag := memTag.Call("Create", NewModelData(tagModel).Set(rate, 5)).(RecordSet).Collection()
+				So(tag.Ids()[0], ShouldBeGreaterThan, 0)
+				So(tag.Get(Name), ShouldEqual, "Memory Tag")
+				So(tag.Get(description), ShouldEqual, "Tag created in memory")
+				So(tag.Get(rate), ShouldEqual, 5)
+				So(env.Pool("Tag").Search(cond).Len(), ShouldEqual, 1)
+			})
 			Convey("Onchange", func() {
 				Convey("Testing with existing RecordSet", func() {
 					res := userJane.Call("Onchange", OnchangeParams{
@@ -391,7 +464,7 @@ func TestBaseModelMethods(t *testing.T) {
 					}
 				})
 				Convey("With tags", func() {
-					env.Pool("Tag").SearchAll().Call("Unlink")
+					env.Pool("Tag").SearchAll().WithAllRecords().Call("Unlink")
 					for i := 0; i < 20; i++ {
 						env.Pool("Tag").Call("Create", NewModelData(tagModel).
 							Set(Name, fmt.Sprintf("Tag %02d", i/2)))
@@ -507,6 +580,40 @@ func TestBaseModelMethods(t *testing.T) {
 				j := env.Pool("User").Call("SearchByName", "Jane A. Smith", operator.Operator(""), userModel.Field(isStaff).Equals(false), 10).(RecordSet).Collection()
 				So(j.Equals(userJane), ShouldBeTrue)
 			})
+			Convey("DisplayNames", func() {
+				names := userJane.DisplayNames()
+				So(names, ShouldHaveLength, 1)
+				So(names, ShouldContainKey, userJane.ids[0])
+				So(names[userJane.ids[0]], ShouldEqual, "Jane A. Smith")
+				So(env.Pool("User").DisplayNames(), ShouldBeEmpty)
+			})
+			Convey("NameSearch", func() {
+				users := env.Pool("User")
+				So(userModel.NameFields(), ShouldHaveLength, 1)
+				So(userModel.NameFields()[0].JSON(), ShouldEqual, "name")
+				So(users.NameSearch("jane a. smith", 10).Equals(userJane), ShouldBeTrue)
+				smiths := users.NameSearch("smith", 0)
+				So(smiths.Len(), ShouldEqual, users.Search(userModel.Field(Name).IContains("smith")).Len())
+				john := users.Search(userModel.Field(Name).Equals("John Smith"))
+				js := users.NameSearch("j", 0)
+				So(js.Len(), ShouldBeGreaterThanOrEqualTo, 2)
+				So(users.NameSearch("John Smith", 1).Equals(john), ShouldBeTrue)
+				So(users.NameSearch("j", 1).Len(), ShouldEqual, 1)
+				So(users.NameSearch("%", 0).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("NameSearch should return matches by relevance and match metacharacters literally", func() {
+				tagModel := Registry.MustGet("Tag")
+				var tags []*RecordCollection
+				for _, name := range []string{"AZyx", "Zyx_a", "Zyx"} {
+					tags = append(tags, env.Pool("Tag").Call("Create", NewModelData(tagModel).
+						Set(Name, name)).(RecordSet).Collection())
+				}
+				res := env.Pool("Tag").NameSearch("zyx", 0)
+				So(res.Len(), ShouldEqual, 3)
+				So(res.Ids(), ShouldResemble, []int64{tags[2].Ids()[0], tags[1].Ids()[0], tags[0].Ids()[0]})
+				So(env.Pool("Tag").NameSearch("zy_", 0).IsEmpty(), ShouldBeTrue)
+				So(env.Pool("Tag").NameSearch("x_", 0).Equals(tags[1]), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -517,16 +624,30 @@ func TestPostBootSequences(t *testing.T) {
 		testSeq.Drop()
 		seq := CreateSequence("ManualSequence", 1, 1)
 		So(seq.JSON, ShouldEqual, "manual_sequence_manseq")
-		So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 1)
-		So(TestAdapter.sequences("%_manseq")[0].Name, ShouldEqual, "manual_sequence_manseq")
+		So(TestAdapter.Sequences("%_manseq"), ShouldHaveLength, 1)
+		So(TestAdapter.Sequences("%_manseq")[0].Name, ShouldEqual, "manual_sequence_manseq")
 		So(seq.NextValue(), ShouldEqual, 1)
 		So(seq.NextValue(), ShouldEqual, 2)
 		seq.Alter(2, 5)
 		So(seq.NextValue(), ShouldEqual, 5)
 		So(seq.NextValue(), ShouldEqual, 7)
+		seq.SetFormat("INV/%(year)s/", "", 4)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			year := env.cr.Now().Year()
+			So(seq.NextFormattedValue(env), ShouldEqual, fmt.Sprintf("INV/%d/0009", year))
+			So(env.NextSequence("ManualSequence"), ShouldEqual, fmt.Sprintf("INV/%d/0011", year))
+			seq.SetFormat("", "", 0)
+			So(seq.NextFormattedValue(env), ShouldEqual, "13")
+		}), ShouldBeNil)
 		So(func() { CreateSequence("ManualSequence", 1, 1) }, ShouldPanic)
 		seq.Drop()
-		So(TestAdapter.sequences("%_manseq"), ShouldHaveLength, 0)
+		So(TestAdapter.Sequences("%_manseq"), ShouldHaveLength, 0)
+	})
+	Convey("Formatting sequence values", t, func() {
+		seq := &Sequence{Prefix: "%(y)s%(month)s-", Suffix: "/%(day)s", Padding: 3}
+		date := time.Date(2019, 3, 7, 12, 0, 0, 0, time.UTC)
+		So(seq.formatValue(42, date), ShouldEqual, "1903-042/07")
+		So(seq.formatValue(12345, date), ShouldEqual, "1903-12345/07")
 	})
 	Convey("Boot sequences cannot be altered or dropped after bootstrap", t, func() {
 		bootSeq := Registry.MustGetSequence("TestSequence")
@@ -0,0 +1,192 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportCSV(t *testing.T) {
+	Convey("Testing CSV export", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+			Convey("Exporting a single record", func() {
+				var buf bytes.Buffer
+				So(jane.ExportCSV(&buf, []FieldName{Name, email}), ShouldBeNil)
+				So(buf.String(), ShouldEqual, "name,email\nJane A. Smith,jane.smith@example.com\n")
+			})
+			Convey("Exporting with labels and delimiter", func() {
+				var buf bytes.Buffer
+				So(jane.ExportCSV(&buf, []FieldName{Name, email}, CSVDelimiter(';'), CSVWithLabels()), ShouldBeNil)
+				So(buf.String(), ShouldEqual, "Name;Email\nJane A. Smith;jane.smith@example.com\n")
+			})
+			Convey("Exporting large floats should not use the exponent form", func() {
+				var buf bytes.Buffer
+				jane.Set(size, 1000000.5)
+				So(jane.ExportCSV(&buf, []FieldName{size}), ShouldBeNil)
+				So(buf.String(), ShouldEqual, "size\n1000000.5\n")
+			})
+			Convey("Exporting relation fields", func() {
+				var buf bytes.Buffer
+				So(jane.ExportCSV(&buf, []FieldName{email, profile}), ShouldBeNil)
+				So(buf.String(), ShouldStartWith, "email,profile_id\njane.smith@example.com,")
+			})
+			Convey("Exporting all records", func() {
+				var buf bytes.Buffer
+				allUsers := users.SearchAll()
+				So(allUsers.ExportCSV(&buf, []FieldName{Name}), ShouldBeNil)
+				So(bytes.Count(buf.Bytes(), []byte("\n")), ShouldEqual, allUsers.Len()+1)
+			})
+			Convey("Exporting should not modify the exported RecordSet", func() {
+				var buf bytes.Buffer
+				allUsers := users.SearchAll().Fetch()
+				ids := allUsers.Ids()
+				So(allUsers.ExportCSV(&buf, []FieldName{Name, profile, posts}), ShouldBeNil)
+				So(allUsers.Ids(), ShouldResemble, ids)
+				So(jane.ExportCSV(&buf, []FieldName{posts}), ShouldBeNil)
+				var names []string
+				for _, post := range jane.Get(posts).(RecordSet).Collection().Records() {
+					names = append(names, post.Call("NameGet").(string))
+				}
+				So(buf.String(), ShouldEndWith, strings.Join(names, "|")+"\n")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestMappedChunked(t *testing.T) {
+	Convey("Testing reading a field by chunks", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User").SearchAll()
+			Convey("All values should be read in chunks ordered by ID", func() {
+				var (
+					chunks int
+					emails []interface{}
+				)
+				err := users.MappedChunked(email, 2, func(values []interface{}) error {
+					chunks++
+					So(len(values), ShouldBeLessThanOrEqualTo, 2)
+					emails = append(emails, values...)
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(emails, ShouldHaveLength, users.Len())
+				So(chunks, ShouldEqual, (users.Len()+1)/2)
+				So(emails[0], ShouldEqual, users.OrderBy("ID").Records()[0].Get(email))
+			})
+			Convey("An error should stop reading chunks", func() {
+				var chunks int
+				stop := errors.New("stop")
+				err := users.MappedChunked(email, 1, func(values []interface{}) error {
+					chunks++
+					return stop
+				})
+				So(err, ShouldEqual, stop)
+				So(chunks, ShouldEqual, 1)
+			})
+			Convey("Limited sets should only read their records", func() {
+				var count int
+				err := users.OrderBy("ID").Limit(2).MappedChunked(Name, 1, func(values []interface{}) error {
+					count += len(values)
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 2)
+			})
+			Convey("Reading chunks should not modify the RecordSet nor the cache", func() {
+				limited := users.OrderBy("ID").Limit(2)
+				fetched := env.Pool("User").SearchAll().Fetch()
+				fetched.Load(email)
+				err := fetched.ReadChunked(1, []FieldName{Name, email}, func(chunk *RecordCollection) error {
+					So(chunk.Len(), ShouldEqual, 1)
+					return nil
+				})
+				So(err, ShouldBeNil)
+				So(env.cache.checkIfInCache(fetched.model, fetched.Ids(), []string{"email"}, fetched.query.ctxArgsSlug(), true), ShouldBeTrue)
+				So(limited.MappedChunked(Name, 1, func([]interface{}) error { return nil }), ShouldBeNil)
+				So(limited.fetched, ShouldBeFalse)
+				So(limited.query.limit, ShouldEqual, 2)
+			})
+			Convey("Empty chunk size should panic", func() {
+				So(func() { users.MappedChunked(Name, 0, func([]interface{}) error { return nil }) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestImportRecords(t *testing.T) {
+	Convey("Testing records import", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			Convey("Importing should update existing records and create others", func() {
+				data := []RecordData{
+					NewModelData(users.Model()).
+						Set(email, "jane.smith@example.com").
+						Set(Name, "Jane Imported"),
+					NewModelData(users.Model()).
+						Set(email, "imported@example.com").
+						Set(Name, "New Imported"),
+				}
+				created, updated, errs := users.ImportRecords(data, []FieldName{email})
+				So(errs, ShouldBeEmpty)
+				So(created, ShouldEqual, 1)
+				So(updated, ShouldEqual, 1)
+				jane := users.Search(users.Model().Field(email).Equals("jane.smith@example.com"))
+				So(jane.Get(Name), ShouldEqual, "Jane Imported")
+				imported := users.Search(users.Model().Field(email).Equals("imported@example.com"))
+				So(imported.Len(), ShouldEqual, 1)
+				So(imported.Get(Name), ShouldEqual, "New Imported")
+				created, updated, errs = users.ImportRecords(data, []FieldName{email})
+				So(errs, ShouldBeEmpty)
+				So(created, ShouldEqual, 0)
+				So(updated, ShouldEqual, 2)
+			})
+			Convey("Importing the same new key twice should create a single record", func() {
+				data := []RecordData{
+					NewModelData(users.Model()).
+						Set(email, "twice@example.com").
+						Set(Name, "Twice First"),
+					NewModelData(users.Model()).
+						Set(email, "twice@example.com").
+						Set(Name, "Twice Second"),
+				}
+				created, updated, errs := users.ImportRecords(data, []FieldName{email})
+				So(errs, ShouldBeEmpty)
+				So(created, ShouldEqual, 1)
+				So(updated, ShouldEqual, 1)
+				twice := users.Search(users.Model().Field(email).Equals("twice@example.com"))
+				So(twice.Len(), ShouldEqual, 1)
+				So(twice.Get(Name), ShouldEqual, "Twice Second")
+			})
+			Convey("Faulty items should be reported with their index", func() {
+				data := []RecordData{
+					NewModelData(users.Model()).Set(Name, "No Email"),
+					NewModelData(users.Model()).
+						Set(email, "valid@example.com").
+						Set(Name, "Valid Import"),
+					NewModelData(users.Model()).
+						Set(email, "invalid@example.com").
+						Set(Name, "Invalid Import").
+						Set(isPremium, true).
+						Set(nums, 0),
+				}
+				created, updated, errs := users.ImportRecords(data, []FieldName{email})
+				So(created, ShouldEqual, 1)
+				So(updated, ShouldEqual, 0)
+				So(errs, ShouldHaveLength, 2)
+				So(errs[0].Index, ShouldEqual, 0)
+				So(errs[1].Index, ShouldEqual, 2)
+				So(users.Search(users.Model().Field(email).Equals("valid@example.com")).Len(), ShouldEqual, 1)
+				So(users.Search(users.Model().Field(email).Equals("invalid@example.com")).Len(), ShouldEqual, 0)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -128,4 +128,43 @@ func TestDataLoading(t *testing.T) {
 			})
 		}), ShouldBeNil)
 	})
+	Convey("Testing YAML and JSON data files loading", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			Convey("Loading records with forward references", func() {
+				So(LoadDataFile(env, "testdata/data_tags_posts.yml"), ShouldBeNil)
+				child := env.Ref("data_tag_child")
+				parentTag := env.Ref("data_tag_parent")
+				So(child.Get(Name), ShouldEqual, "Child Tag")
+				So(child.Get(parent).(RecordSet).Collection().Equals(parentTag), ShouldBeTrue)
+				So(parentTag.Get(description), ShouldEqual, "Parent tag loaded from YAML")
+				post := env.Ref("data_post")
+				So(post.Get(title), ShouldEqual, "YAML Post")
+				So(post.Get(user).(RecordSet).Collection().ExternalID(), ShouldEqual, "external_id_1")
+				So(post.Get(tags).(RecordSet).Collection().Len(), ShouldEqual, 2)
+				Convey("Loading the file again updates the records", func() {
+					postsCount := env.Pool("Post").SearchAll().Len()
+					child.Set(Name, "Modified Tag")
+					So(LoadDataFile(env, "testdata/data_tags_posts.yml"), ShouldBeNil)
+					So(env.Pool("Post").SearchAll().Len(), ShouldEqual, postsCount)
+					So(env.Ref("data_tag_child").Get(Name), ShouldEqual, "Child Tag")
+				})
+			})
+			Convey("Loading records referencing each other", func() {
+				So(LoadDataFile(env, "testdata/data_cycle.json"), ShouldBeNil)
+				first := env.Ref("data_tag_first")
+				second := env.Ref("data_tag_second")
+				So(first.Get(parent).(RecordSet).Collection().Equals(second), ShouldBeTrue)
+				So(second.Get(parent).(RecordSet).Collection().Equals(first), ShouldBeTrue)
+			})
+			Convey("Loading invalid files returns errors", func() {
+				err := LoadDataFile(env, "testdata/data_unknown_field.yml")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, `"data_tag_wrong": unknown field "Colour"`)
+				err = LoadDataFile(env, "testdata/data_unknown_ref.yml")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, `record "data_post_wrong": field User: unknown external ID "data_unknown_user"`)
+				So(LoadDataFile(env, "testdata/User.csv"), ShouldNotBeNil)
+			})
+		}), ShouldBeNil)
+	})
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -327,7 +328,7 @@ func TestExtModelDeclaration(t *testing.T) {
 		tag.NewMethod("CheckNameDescription",
 			func(rc *models.RecordCollection) {
 				if rc.Get(rc.Model().FieldName("Name")).(string) == rc.Get(rc.Model().FieldName("Description")).(string) {
-					log.Panic("Tag name and description must be different")
+					panic(exceptions.UserError{Message: "Tag name and description must be different"})
 				}
 			})
 
@@ -33,6 +33,7 @@ func (t tableJoin) sqlString() string {
 	if !t.joined {
 		return fmt.Sprintf("%s %s ", t.tableName, t.alias)
 	}
+	adapter := adapters[db.DriverName()]
 	return fmt.Sprintf("LEFT JOIN %s %s ON %s.%s=%s.%s ", t.tableName, t.alias, t.otherTable.alias,
-		t.otherField.JSON(), t.alias, t.field.JSON())
+		adapter.QuoteIdentifier(t.otherField.JSON()), t.alias, adapter.QuoteIdentifier(t.field.JSON()))
 }
@@ -96,6 +96,18 @@ func (f fieldName) JSON() string {
 	return f.json
 }
 
+// aliasedFieldName is a FieldName with an alias, used to give another
+// key to a field in the result of Read.
+type aliasedFieldName struct {
+	fieldName
+	alias string
+}
+
+// Alias returns the alias of this field
+func (a aliasedFieldName) Alias() string {
+	return a.alias
+}
+
 // NewFieldName returns a fieldName instance with the given name and json
 func NewFieldName(name, json string) FieldName {
 	return fieldName{name: name, json: json}
@@ -153,10 +165,12 @@ func (f FieldNames) JSON() []string {
 // - Values holds the values of the actual query
 // - Count is the number of lines aggregated into this one
 // - Condition can be used to query the aggregated rows separately if needed
+// - SubGroups holds the rows of the next grouping level (see NestedAggregates)
 type GroupAggregateRow struct {
 	Values    *ModelData
 	Count     int
 	Condition *Condition
+	SubGroups []GroupAggregateRow
 }
 
 // FieldContexts define the different contexts for a field, that will define different
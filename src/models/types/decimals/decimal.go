@@ -0,0 +1,268 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package decimals
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cockroachdb/apd/v2"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+)
+
+// ctx is the context of all arithmetic operations on Decimals.
+//
+// Its precision only limits the number of significant digits of
+// divisions, since additions, subtractions and multiplications are exact.
+var ctx = apd.Context{
+	MaxExponent: apd.MaxExponent,
+	MinExponent: apd.MinExponent,
+	Traps:       apd.DefaultTraps,
+	Rounding:    apd.RoundHalfUp,
+	Precision:   128,
+}
+
+// A Decimal is an exact decimal number. It is the Go type of decimal fields
+// which are stored as NUMERIC in the database.
+//
+// Unlike float64, arithmetic operations on Decimals do not lose precision,
+// which makes them suitable for monetary values. The zero value of a
+// Decimal is 0. Decimals are immutable: operations return a new Decimal.
+type Decimal struct {
+	dec apd.Decimal
+}
+
+// New returns a new Decimal with the value coeff * 10^exponent.
+//
+// New(1234, -2) => 12.34
+func New(coeff int64, exponent int32) Decimal {
+	var res Decimal
+	res.dec.SetFinite(coeff, exponent)
+	return res
+}
+
+// NewFromInt returns a new Decimal with the given integer value.
+func NewFromInt(value int64) Decimal {
+	return New(value, 0)
+}
+
+// NewFromFloat returns a new Decimal from the given float. The Decimal
+// has the shortest decimal representation of the float, so that
+// NewFromFloat(0.1) is exactly 0.1.
+func NewFromFloat(value float64) Decimal {
+	var res Decimal
+	if _, err := res.dec.SetFloat64(value); err != nil {
+		panic(fmt.Errorf("unable to convert %v to decimal: %s", value, err))
+	}
+	return res
+}
+
+// Parse returns a new Decimal from the given string, such as "12.34".
+func Parse(value string) (Decimal, error) {
+	var res Decimal
+	if _, _, err := res.dec.SetString(value); err != nil {
+		return Decimal{}, fmt.Errorf("unable to parse %q as decimal: %s", value, err)
+	}
+	return res, nil
+}
+
+// MustParse returns a new Decimal from the given string.
+//
+// It panics if the string is not a valid decimal number.
+func MustParse(value string) Decimal {
+	res, err := Parse(value)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// apply returns a new Decimal with the result of fnct
+func apply(fnct func(d *apd.Decimal) (apd.Condition, error)) Decimal {
+	var res Decimal
+	if _, err := fnct(&res.dec); err != nil {
+		panic(fmt.Errorf("error in decimal operation: %s", err))
+	}
+	return res
+}
+
+// Add returns d + other
+func (d Decimal) Add(other Decimal) Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Add(res, &d.dec, &other.dec)
+	})
+}
+
+// Sub returns d - other
+func (d Decimal) Sub(other Decimal) Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Sub(res, &d.dec, &other.dec)
+	})
+}
+
+// Mul returns d * other
+func (d Decimal) Mul(other Decimal) Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Mul(res, &d.dec, &other.dec)
+	})
+}
+
+// Quo returns d / other with up to 128 significant digits.
+//
+// It panics if other is zero.
+func (d Decimal) Quo(other Decimal) Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Quo(res, &d.dec, &other.dec)
+	})
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Neg(res, &d.dec)
+	})
+}
+
+// Abs returns the absolute value of d
+func (d Decimal) Abs() Decimal {
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return ctx.Abs(res, &d.dec)
+	})
+}
+
+// Round returns d rounded to the given number of decimal places.
+// Halfway values are rounded according to nbutils' RoundingMode.
+//
+// MustParse("1.235").Round(2) => 1.24
+func (d Decimal) Round(scale int32) Decimal {
+	rCtx := ctx
+	rCtx.Rounding = string(nbutils.GetRoundingMode())
+	return apply(func(res *apd.Decimal) (apd.Condition, error) {
+		return rCtx.Quantize(res, &d.dec, -scale)
+	})
+}
+
+// Cmp compares d and other and returns:
+//
+//	-1 if d <  other
+//	 0 if d == other
+//	+1 if d >  other
+func (d Decimal) Cmp(other Decimal) int {
+	return d.dec.Cmp(&other.dec)
+}
+
+// Equal returns true if d and other have the same value,
+// whatever their number of decimal places.
+func (d Decimal) Equal(other Decimal) bool {
+	return d.Cmp(other) == 0
+}
+
+// Sign returns -1 if d is negative, 0 if it is zero and +1 if it is positive.
+func (d Decimal) Sign() int {
+	return d.dec.Sign()
+}
+
+// IsZero returns true if d is zero
+func (d Decimal) IsZero() bool {
+	return d.dec.IsZero()
+}
+
+// Float64 returns the nearest float64 value of d
+func (d Decimal) Float64() float64 {
+	res, err := d.dec.Float64()
+	if err != nil {
+		panic(fmt.Errorf("unable to convert %s to float: %s", d, err))
+	}
+	return res
+}
+
+// String returns d in decimal notation without exponent, such as "12.30"
+func (d Decimal) String() string {
+	return d.dec.Text('f')
+}
+
+// StringFixed returns d rounded to the given number of
+// decimal places, with trailing zeros if needed.
+//
+// MustParse("12.3").StringFixed(2) => "12.30"
+func (d Decimal) StringFixed(scale int32) string {
+	return d.Round(scale).String()
+}
+
+// MarshalJSON returns d as a JSON number with all its digits
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON sets d from a JSON number or string
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		str = string(data)
+	}
+	if str == "null" || str == "" || str == "false" {
+		*d = Decimal{}
+		return nil
+	}
+	val, err := Parse(str)
+	if err != nil {
+		return err
+	}
+	*d = val
+	return nil
+}
+
+// Value formats our Decimal for storing in database
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan casts the database output to a Decimal. It also accepts the
+// numbers and strings that may be given by clients.
+func (d *Decimal) Scan(src interface{}) error {
+	var (
+		val Decimal
+		err error
+	)
+	switch t := src.(type) {
+	case nil:
+	case Decimal:
+		val = t
+	case []byte:
+		val, err = Parse(string(t))
+	case string:
+		if t != "" {
+			val, err = Parse(t)
+		}
+	case float64:
+		val = NewFromFloat(t)
+	case float32:
+		val = NewFromFloat(float64(t))
+	case int:
+		val = NewFromInt(int64(t))
+	case int8:
+		val = NewFromInt(int64(t))
+	case int16:
+		val = NewFromInt(int64(t))
+	case int32:
+		val = NewFromInt(int64(t))
+	case int64:
+		val = NewFromInt(t)
+	default:
+		return fmt.Errorf("decimal data is not a number but %T", src)
+	}
+	if err != nil {
+		return err
+	}
+	*d = val
+	return nil
+}
+
+var _ driver.Valuer = Decimal{}
+var _ sql.Scanner = new(Decimal)
+var _ json.Marshaler = Decimal{}
+var _ json.Unmarshaler = new(Decimal)
+var _ fmt.Stringer = Decimal{}
@@ -0,0 +1,87 @@
+package decimals
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecimal(t *testing.T) {
+	Convey("Testing Decimal objects", t, func() {
+		Convey("Creating decimals", func() {
+			So(New(1234, -2).String(), ShouldEqual, "12.34")
+			So(NewFromInt(42).String(), ShouldEqual, "42")
+			So(NewFromFloat(0.1).String(), ShouldEqual, "0.1")
+			So(MustParse("-3.140").String(), ShouldEqual, "-3.140")
+			So(Decimal{}.String(), ShouldEqual, "0")
+			_, err := Parse("12,34")
+			So(err, ShouldNotBeNil)
+			So(func() { MustParse("abc") }, ShouldPanic)
+		})
+		Convey("Arithmetic should be exact", func() {
+			var sum Decimal
+			for i := 0; i < 10; i++ {
+				sum = sum.Add(MustParse("0.1"))
+			}
+			So(sum.Equal(NewFromInt(1)), ShouldBeTrue)
+			So(MustParse("0.3").Sub(MustParse("0.1")).String(), ShouldEqual, "0.2")
+			So(MustParse("1.5").Mul(MustParse("1.5")).String(), ShouldEqual, "2.25")
+			So(NewFromInt(1).Quo(NewFromInt(4)).String(), ShouldEqual, "0.25")
+			So(func() { NewFromInt(1).Quo(Decimal{}) }, ShouldPanic)
+			So(MustParse("2.5").Neg().String(), ShouldEqual, "-2.5")
+			So(MustParse("-2.5").Abs().String(), ShouldEqual, "2.5")
+		})
+		Convey("Operations should not modify their operands", func() {
+			a := MustParse("1.5")
+			b := a.Add(MustParse("1"))
+			So(a.String(), ShouldEqual, "1.5")
+			So(b.String(), ShouldEqual, "2.5")
+		})
+		Convey("Rounding and comparing", func() {
+			So(MustParse("1.235").Round(2).String(), ShouldEqual, "1.24")
+			So(MustParse("-1.235").Round(2).String(), ShouldEqual, "-1.24")
+			So(MustParse("12.3").StringFixed(2), ShouldEqual, "12.30")
+			So(MustParse("12.30").Equal(MustParse("12.3")), ShouldBeTrue)
+			So(MustParse("1").Cmp(MustParse("2")), ShouldEqual, -1)
+			So(MustParse("-1").Sign(), ShouldEqual, -1)
+			So(Decimal{}.IsZero(), ShouldBeTrue)
+			So(MustParse("12.5").Float64(), ShouldEqual, 12.5)
+		})
+		Convey("Rounding with banker's rounding", func() {
+			nbutils.SetRoundingMode(nbutils.RoundHalfEven)
+			defer nbutils.SetRoundingMode(nbutils.RoundHalfUp)
+			So(MustParse("1.235").Round(2).String(), ShouldEqual, "1.24")
+			So(MustParse("1.225").Round(2).String(), ShouldEqual, "1.22")
+			So(MustParse("1.225").StringFixed(2), ShouldEqual, "1.22")
+		})
+		Convey("Scanning values", func() {
+			var d Decimal
+			So(d.Scan([]byte("12.34")), ShouldBeNil)
+			So(d.String(), ShouldEqual, "12.34")
+			So(d.Scan(int64(3)), ShouldBeNil)
+			So(d.String(), ShouldEqual, "3")
+			So(d.Scan(0.5), ShouldBeNil)
+			So(d.String(), ShouldEqual, "0.5")
+			So(d.Scan(nil), ShouldBeNil)
+			So(d.IsZero(), ShouldBeTrue)
+			So(d.Scan(true), ShouldNotBeNil)
+			val, err := MustParse("12.34").Value()
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, "12.34")
+		})
+		Convey("JSON marshalling", func() {
+			data, err := json.Marshal(MustParse("12.30"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "12.30")
+			var d Decimal
+			So(json.Unmarshal([]byte("0.1"), &d), ShouldBeNil)
+			So(d.String(), ShouldEqual, "0.1")
+			So(json.Unmarshal([]byte(`"7.25"`), &d), ShouldBeNil)
+			So(d.String(), ShouldEqual, "7.25")
+			So(json.Unmarshal([]byte("false"), &d), ShouldBeNil)
+			So(d.IsZero(), ShouldBeTrue)
+		})
+	})
+}
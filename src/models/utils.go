@@ -15,7 +15,11 @@
 package models
 
 import (
+	"reflect"
 	"strings"
+
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/models/types/decimals"
 )
 
 var (
@@ -153,16 +157,6 @@ func addIDIfNotPresent(fields []FieldName) []FieldName {
 	return fields
 }
 
-// getGroupCondition returns the condition to retrieve the individual aggregated rows in vals
-// knowing that they were grouped by groups and that we had the given initial condition
-func getGroupCondition(groups []FieldName, vals map[string]interface{}, initialCondition *Condition) *Condition {
-	res := initialCondition
-	for _, group := range groups {
-		res = res.And().Field(group).Equals(vals[group.JSON()])
-	}
-	return res
-}
-
 // substituteKeys returns a new map with its keys substituted following substMap after changing sqlSep into ExprSep.
 // vals keys that are not found in substMap are not returned
 func substituteKeys(vals map[string]interface{}, substMap map[string]string) map[string]interface{} {
@@ -219,10 +213,16 @@ func consumeAndPredicates(i int, predicates []predicate, res []interface{}) ([]i
 
 // appendPredicateToSerial appends the given predicate to the given serialized
 // predicate list and returns the result.
+//
+// It panics if the predicate is a raw SQL fragment, so that server side SQL
+// is never sent to clients.
 func appendPredicateToSerial(res []interface{}, predicate predicate) []interface{} {
-	if predicate.isCond {
+	switch {
+	case predicate.isCond:
 		res = append(res, serializePredicates(predicate.cond.predicates)...)
-	} else {
+	case predicate.rawSQL != "":
+		log.Panic("Raw SQL conditions cannot be serialized")
+	default:
 		res = append(res, []interface{}{joinFieldNames(predicate.exprs, ExprSep).JSON(), predicate.operator, predicate.arg})
 	}
 	return res
@@ -287,3 +287,67 @@ func splitFieldNames(f FieldName, sep string) []FieldName {
 	}
 	return res
 }
+
+// valuesEqual returns true if a and b are the same field value.
+//
+// Decimals, dates and datetimes are compared by value, so that for instance
+// 2.5 and 2.50 or the same instant in two locations are equal. RecordSets are
+// equal if they have the same ids, whatever their order, and can be compared
+// to ids as converted to relation field types (int64 or []int64). Other
+// values are compared with reflect.DeepEqual.
+func valuesEqual(a, b interface{}) bool {
+	_, aIsRS := a.(RecordSet)
+	_, bIsRS := b.(RecordSet)
+	if aIsRS || bIsRS {
+		aIds, aOk := relationValueIds(a)
+		bIds, bOk := relationValueIds(b)
+		return aOk && bOk && idsSetsEqual(aIds, bIds)
+	}
+	switch va := a.(type) {
+	case decimals.Decimal:
+		vb, ok := b.(decimals.Decimal)
+		return ok && va.Equal(vb)
+	case dates.DateTime:
+		vb, ok := b.(dates.DateTime)
+		return ok && va.Equal(vb)
+	case dates.Date:
+		vb, ok := b.(dates.Date)
+		return ok && va.Equal(vb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// relationValueIds returns the ids of the given relation field value, which
+// may be a RecordSet or ids. The second returned value is false if val is
+// not a relation field value.
+func relationValueIds(val interface{}) ([]int64, bool) {
+	switch v := val.(type) {
+	case RecordSet:
+		return v.Ids(), true
+	case int64:
+		if v == 0 {
+			return nil, true
+		}
+		return []int64{v}, true
+	case []int64:
+		return v, true
+	}
+	return nil, false
+}
+
+// idsSetsEqual returns true if a and b hold the same ids, whatever their order.
+func idsSetsEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	idsSet := make(map[int64]bool)
+	for _, id := range a {
+		idsSet[id] = true
+	}
+	for _, id := range b {
+		if !idsSet[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/hexya/src/tools/hweb"
 )
@@ -23,6 +24,10 @@ type Context struct {
 }
 
 // RPC serializes the given struct as JSON-RPC into the response body.
+//
+// If an error is given, its message is translated in the language of the
+// "lang" key of this Context. This key is set by BindRPCParams from the
+// context of the request and can be set by controllers from their Environment.
 func (c *Context) RPC(code int, obj interface{}, err ...error) {
 	id, ok := c.Get("id")
 	if !ok {
@@ -34,11 +39,12 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 		id = req.ID
 	}
 	if len(err) > 0 && err[0] != nil {
-		userError, ok2 := err[0].(exceptions.UserError)
+		exception, ok2 := i18n.TranslateError(c.GetString("lang"), err[0]).(exceptions.Exception)
 		if !ok2 {
 			c.AbortWithError(http.StatusInternalServerError, errors.New("error is of unknown type"))
 			return
 		}
+		userError := exception.Base()
 		respErr := ResponseError{
 			JsonRPC: "2.0",
 			ID:      id.(int64),
@@ -47,7 +53,7 @@ func (c *Context) RPC(code int, obj interface{}, err ...error) {
 				Message: "Hexya Server Error",
 				Data: JSONRPCErrorData{
 					Arguments:     []string{userError.Message},
-					ExceptionType: "user_error",
+					ExceptionType: exception.Type(),
 					Debug:         userError.Debug,
 				},
 			},
@@ -71,6 +77,14 @@ func (c *Context) BindRPCParams(data interface{}) {
 		return
 	}
 	c.Set("id", req.ID)
+	var ctxParams struct {
+		Context struct {
+			Lang string `json:"lang"`
+		} `json:"context"`
+	}
+	if json.Unmarshal(req.Params, &ctxParams) == nil && ctxParams.Context.Lang != "" {
+		c.Set("lang", ctxParams.Context.Lang)
+	}
 	if err := json.Unmarshal(req.Params, data); err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
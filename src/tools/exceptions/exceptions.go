@@ -9,12 +9,130 @@ import "fmt"
 // UserError is an error that must rollback the current transaction and
 // be displayed as a warning to the user.
 type UserError struct {
+	// Message is the untranslated message of the error. It is used as
+	// translation key and as format string for Args if any.
 	Message string
-	Debug   string
+	// Args are the interpolation arguments of Message
+	Args  []interface{}
+	Debug string
 }
 
 // Error method for the UserError type.
 // Returns the message.
 func (u UserError) Error() string {
-	return fmt.Sprintf("%s\n----------------------------------\n%s", u.Message, u.Debug)
+	return fmt.Sprintf("%s\n----------------------------------\n%s", u.Text(), u.Debug)
+}
+
+// Text returns the message of this UserError interpolated with its arguments.
+func (u UserError) Text() string {
+	return u.Render(func(src string) string { return src })
+}
+
+// Render returns the message of this UserError translated with the given
+// translate function and interpolated with its arguments.
+func (u UserError) Render(translate func(string) string) string {
+	msg := translate(u.Message)
+	if len(u.Args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, u.Args...)
+}
+
+// Base returns this UserError
+func (u UserError) Base() UserError {
+	return u
+}
+
+// WithBase returns the given UserError
+func (u UserError) WithBase(base UserError) Exception {
+	return base
+}
+
+// Type returns "user_error"
+func (u UserError) Type() string {
+	return "user_error"
+}
+
+// An Exception is a UserError or one of its typed variants.
+type Exception interface {
+	error
+	// Base returns the UserError of this exception
+	Base() UserError
+	// WithBase returns a copy of this exception with the given UserError
+	WithBase(base UserError) Exception
+	// Type returns the name of the type of this exception as sent to clients
+	Type() string
+}
+
+// AsUserError returns the UserError of the given error and true if err is a
+// UserError or one of its typed variants. It returns false otherwise.
+func AsUserError(err error) (UserError, bool) {
+	if e, ok := err.(Exception); ok {
+		return e.Base(), true
+	}
+	return UserError{}, false
+}
+
+// Translate returns a copy of the given error whose message is translated with
+// the given translate function and interpolated with its arguments, if err is
+// a UserError or one of its typed variants. Other errors are returned as is.
+func Translate(err error, translate func(string) string) error {
+	e, ok := err.(Exception)
+	if !ok {
+		return err
+	}
+	u := e.Base()
+	u.Message = u.Render(translate)
+	u.Args = nil
+	return e.WithBase(u)
+}
+
+// ValidationError is a UserError raised when data do not satisfy the
+// constraints of a model.
+type ValidationError struct {
+	UserError
+}
+
+// WithBase returns a copy of this ValidationError with the given UserError
+func (v ValidationError) WithBase(base UserError) Exception {
+	v.UserError = base
+	return v
+}
+
+// Type returns "validation_error"
+func (v ValidationError) Type() string {
+	return "validation_error"
+}
+
+// AccessError is a UserError raised when the current user is not allowed
+// to perform an operation.
+type AccessError struct {
+	UserError
+}
+
+// WithBase returns a copy of this AccessError with the given UserError
+func (a AccessError) WithBase(base UserError) Exception {
+	a.UserError = base
+	return a
+}
+
+// Type returns "access_error"
+func (a AccessError) Type() string {
+	return "access_error"
+}
+
+// MissingError is a UserError raised when a requested record does not exist.
+type MissingError struct {
+	UserError
+}
+
+// WithBase returns a copy of this MissingError with the given UserError
+func (m MissingError) WithBase(base UserError) Exception {
+	m.UserError = base
+	return m
+}
+
+// Type returns "missing_error"
+func (m MissingError) Type() string {
+	return "missing_error"
 }
@@ -296,10 +296,10 @@ func addFieldTypesToModelData(mData *modelData) {
 			SanType: f.SanType,
 			IsRS:    f.IsRS,
 			Operators: []operatorDef{
-				{Name: "Equals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
+				{Name: "Equals"}, {Name: "IEquals"}, {Name: "NotEquals"}, {Name: "Greater"}, {Name: "GreaterOrEqual"}, {Name: "Lower"},
 				{Name: "LowerOrEqual"}, {Name: "Like"}, {Name: "Contains"}, {Name: "NotContains"}, {Name: "IContains"},
 				{Name: "NotIContains"}, {Name: "ILike"}, {Name: "In", Multi: true}, {Name: "NotIn", Multi: true},
-				{Name: "ChildOf"},
+				{Name: "ChildOf", Multi: true}, {Name: "ParentOf", Multi: true},
 			},
 		})
 	}
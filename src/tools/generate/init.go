@@ -14,6 +14,8 @@ const (
 	ModelsPath = HexyaPath + "/src/models"
 	// DatesPath is the go import path of the hexya/models/types/dates package
 	DatesPath = HexyaPath + "/src/models/types/dates"
+	// DecimalsPath is the go import path of the hexya/models/types/decimals package
+	DecimalsPath = HexyaPath + "/src/models/types/decimals"
 	// PoolPath is the go import path of the autogenerated pool package
 	PoolPath = "github.com/hexya-erp/pool"
 	// PoolModelPackage is the name of the pool package with model data
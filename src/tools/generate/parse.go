@@ -418,8 +418,11 @@ func parseAddFields(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[str
 			typeStr = strings.TrimSuffix(ft.Sel.Name, "Field")
 		}
 		var importPath string
-		if typeStr == "Date" || typeStr == "DateTime" {
+		switch typeStr {
+		case "Date", "DateTime":
 			importPath = DatesPath
+		case "Decimal":
+			importPath = DecimalsPath
 		}
 
 		var fieldParams []ast.Expr
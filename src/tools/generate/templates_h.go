@@ -379,16 +379,20 @@ func (s {{ .Name }}Set) CartesianProduct(others ...{{ .InterfacesPackageName }}.
 
 // First returns the values of the first Record of the RecordSet as a pointer to a {{ .Name }}Data.
 //
+// If fields are given, only these fields and the ID are read. Otherwise all fields are read.
+//
 // If this RecordSet is empty, it returns an empty {{ .Name }}Data.
-func (s {{ .Name }}Set) First() {{ .InterfacesPackageName }}.{{ .Name }}Data {
+func (s {{ .Name }}Set) First(fields ...models.FieldName) {{ .InterfacesPackageName }}.{{ .Name }}Data {
 	return &{{ .Name }}Data {
-		s.RecordCollection.First(),
+		s.RecordCollection.First(fields...),
 	}
 }
 
 // All returns the values of all Records of the RecordCollection as a slice of {{ .Name }}Data pointers.
-func (s {{ .Name }}Set) All() []{{ .InterfacesPackageName }}.{{ .Name }}Data {
-	allSlice := s.RecordCollection.All()
+//
+// If fields are given, only these fields and the ID are read. Otherwise all fields are read.
+func (s {{ .Name }}Set) All(fields ...models.FieldName) []{{ .InterfacesPackageName }}.{{ .Name }}Data {
+	allSlice := s.RecordCollection.All(fields...)
 	res := make([]{{ .InterfacesPackageName }}.{{ .Name }}Data, len(allSlice))
 	for i, v := range allSlice {
 		res[i] = &{{ .Name }}Data{v}
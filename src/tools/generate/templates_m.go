@@ -66,10 +66,14 @@ type {{ .Name }}Set interface {
 	Records() []{{ .Name }}Set
 	// First returns the values of the first Record of the RecordSet as a pointer to a {{ .Name }}Data.
 	//
+	// If fields are given, only these fields and the ID are read. Otherwise all fields are read.
+	//
 	// If this RecordSet is empty, it returns an empty {{ .Name }}Data.
-	First() {{ .Name }}Data
+	First(fields ...models.FieldName) {{ .Name }}Data
 	// All returns the values of all Records of the RecordCollection as a slice of {{ .Name }}Data pointers.
-	All() []{{ .Name }}Data
+	//
+	// If fields are given, only these fields and the ID are read. Otherwise all fields are read.
+	All(fields ...models.FieldName) []{{ .Name }}Data
 }
 
 // {{ .Name }}Data is used to hold values of an {{ .Name }} object instance
@@ -186,6 +186,12 @@ func LogPanicData(panicData interface{}) error {
 
 	stackTrace := stack(1)
 	fullMsg := fmt.Sprintf("%s\n\n%s", msg, stackTrace)
+	// Typed errors are kept as is so that callers can tell them apart
+	if err, ok := panicData.(exceptions.Exception); ok {
+		base := err.Base()
+		base.Debug = fmt.Sprintf("%s\n\n%s", base.Debug, stackTrace)
+		return err.WithBase(base)
+	}
 	return exceptions.UserError{
 		Message: msg,
 		Debug:   fullMsg,
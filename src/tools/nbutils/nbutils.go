@@ -65,6 +65,41 @@ func (d Digits) ToPrecision() float64 {
 	return math.Pow10(int(-d.Scale))
 }
 
+// A RoundingMode defines how Round rounds values that are exactly halfway
+// between two multiples of the precision.
+type RoundingMode string
+
+const (
+	// RoundHalfUp rounds halfway values away from zero: 2.5 => 3, -2.5 => -3
+	RoundHalfUp RoundingMode = apd.RoundHalfUp
+	// RoundHalfEven rounds halfway values to the nearest even multiple of
+	// the precision (banker's rounding): 2.5 => 2, 3.5 => 4
+	RoundHalfEven RoundingMode = apd.RoundHalfEven
+)
+
+// roundingMode is the RoundingMode used by Round
+var roundingMode = RoundHalfUp
+
+// SetRoundingMode sets the RoundingMode used by Round and by all the
+// functions that round values, such as Compare or IsZero. It also applies
+// to the rounding of decimals.Decimal values.
+//
+// The default rounding mode is RoundHalfUp. It is meant to be set once at
+// startup, before any value is rounded.
+func SetRoundingMode(mode RoundingMode) {
+	switch mode {
+	case RoundHalfUp, RoundHalfEven:
+	default:
+		panic(fmt.Errorf("unknown rounding mode %q", mode))
+	}
+	roundingMode = mode
+}
+
+// GetRoundingMode returns the RoundingMode used by Round
+func GetRoundingMode() RoundingMode {
+	return roundingMode
+}
+
 var ctx = apd.Context{
 	MaxExponent: apd.MaxExponent,
 	MinExponent: apd.MinExponent,
@@ -104,9 +139,14 @@ func applyDecimalOperation(value, precision float64, fnct func(d, x *apd.Decimal
 // Round rounds the given val to the given precision, which is a float such as :
 //
 // - 0.01 to round at the nearest 100th
+// - 0.05 to round at the nearest 5 cents (cash rounding)
 // - 10 to round at the nearest ten
+//
+// Halfway values are rounded according to the current RoundingMode.
 func Round(value float64, precision float64) float64 {
-	return applyDecimalOperation(value, precision, ctx.RoundToIntegralExact)
+	rCtx := ctx
+	rCtx.Rounding = string(roundingMode)
+	return applyDecimalOperation(value, precision, rCtx.RoundToIntegralExact)
 }
 
 // Ceil rounds up the given val to the given precision, which is a float such as :
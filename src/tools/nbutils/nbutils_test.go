@@ -74,6 +74,29 @@ func TestRound(t *testing.T) {
 		So(Round(12.2499, 0.1), ShouldEqual, 12.2)
 		So(Round(-61.160000000000004, 0.01), ShouldEqual, -61.16)
 	})
+	Convey("Testing cash rounding", t, func() {
+		So(Round(1.02, 0.05), ShouldEqual, 1)
+		So(Round(1.03, 0.05), ShouldEqual, 1.05)
+		So(Round(1.074, 0.05), ShouldEqual, 1.05)
+		So(Round(1.075, 0.05), ShouldEqual, 1.1)
+		So(Round(-1.03, 0.05), ShouldEqual, -1.05)
+	})
+	Convey("Testing rounding modes", t, func() {
+		So(GetRoundingMode(), ShouldEqual, RoundHalfUp)
+		So(Round(2.5, 1), ShouldEqual, 3)
+		So(Round(-2.5, 1), ShouldEqual, -3)
+		SetRoundingMode(RoundHalfEven)
+		So(GetRoundingMode(), ShouldEqual, RoundHalfEven)
+		So(Round(2.5, 1), ShouldEqual, 2)
+		So(Round(3.5, 1), ShouldEqual, 4)
+		So(Round(12.25, 0.1), ShouldEqual, 12.2)
+		So(Round(1.025, 0.05), ShouldEqual, 1)
+		So(Round(1.075, 0.05), ShouldEqual, 1.1)
+		So(Round(1.03, 0.05), ShouldEqual, 1.05)
+		SetRoundingMode(RoundHalfUp)
+		So(func() { SetRoundingMode("half_down") }, ShouldPanic)
+		So(GetRoundingMode(), ShouldEqual, RoundHalfUp)
+	})
 }
 
 func TestIsZero(t *testing.T) {
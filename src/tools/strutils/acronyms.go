@@ -0,0 +1,50 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package strutils
+
+import (
+	"strings"
+	"sync"
+)
+
+// Acronyms is the set of acronyms used by AcronymSnakeCase, Title, CamelCase
+// and PascalCase. Acronyms are written in upper case by CamelCase and
+// PascalCase and a known acronym followed by a plural 's' (such as "IDs") is
+// handled as a single word by AcronymSnakeCase and Title.
+//
+// Projects can register their own acronyms with Acronyms.Add, preferably
+// before any model is declared so that names are consistent.
+var Acronyms = NewAcronymSet("API", "CSS", "CSV", "HTML", "HTTP", "ID", "JSON", "PDF", "SQL", "URL", "UUID", "XML")
+
+// An AcronymSet is a set of acronyms. It is safe for concurrent use.
+type AcronymSet struct {
+	sync.RWMutex
+	words map[string]bool
+}
+
+// NewAcronymSet returns a new AcronymSet with the given acronyms.
+func NewAcronymSet(acronyms ...string) *AcronymSet {
+	res := AcronymSet{
+		words: make(map[string]bool),
+	}
+	res.Add(acronyms...)
+	return &res
+}
+
+// Add adds the given acronyms to this AcronymSet.
+func (as *AcronymSet) Add(acronyms ...string) {
+	as.Lock()
+	defer as.Unlock()
+	for _, acr := range acronyms {
+		as.words[strings.ToUpper(acr)] = true
+	}
+}
+
+// Has returns true if the given word is an acronym of this AcronymSet,
+// whatever its case.
+func (as *AcronymSet) Has(word string) bool {
+	as.RLock()
+	defer as.RUnlock()
+	return as.words[strings.ToUpper(word)]
+}
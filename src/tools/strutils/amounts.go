@@ -0,0 +1,121 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package strutils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// An AmountSpeller spells out amounts in a given language.
+type AmountSpeller interface {
+	// SpellAmount returns the given amount in words. units is the absolute
+	// value of the integer part of the amount and cents the number of
+	// hundredths of its fractional part, i.e. its minor currency unit.
+	SpellAmount(negative bool, units, cents uint64, currencyName string) string
+}
+
+var (
+	amountSpellersMutex sync.RWMutex
+	amountSpellers      = map[string]AmountSpeller{
+		"en": englishSpeller{},
+	}
+)
+
+// RegisterAmountSpeller registers the given AmountSpeller for the given
+// language code, so that it can be used by AmountToWordsIn. An existing
+// AmountSpeller for this language is replaced.
+func RegisterAmountSpeller(lang string, speller AmountSpeller) {
+	amountSpellersMutex.Lock()
+	defer amountSpellersMutex.Unlock()
+	amountSpellers[lang] = speller
+}
+
+// AmountToWords returns the given amount spelled out in English, such as
+// on checks, with the fractional part given as hundredths of the currency.
+// eg. AmountToWords(1234.56, "dollars") =>
+// "one thousand two hundred thirty-four and 56/100 dollars"
+//
+// The currency name is omitted if it is empty.
+func AmountToWords(value float64, currencyName string) string {
+	return AmountToWordsIn("en", value, currencyName)
+}
+
+// AmountToWordsIn returns the given amount spelled out in the given language
+// with the AmountSpeller registered for this language. It panics if there is
+// no AmountSpeller for this language.
+func AmountToWordsIn(lang string, value float64, currencyName string) string {
+	amountSpellersMutex.RLock()
+	speller, ok := amountSpellers[lang]
+	amountSpellersMutex.RUnlock()
+	if !ok {
+		log.Panic("No amount speller registered for language", "lang", lang)
+	}
+	totalCents := uint64(math.Round(math.Abs(value) * 100))
+	return speller.SpellAmount(value < 0 && totalCents > 0, totalCents/100, totalCents%100, currencyName)
+}
+
+// englishSpeller is the AmountSpeller for English
+type englishSpeller struct{}
+
+var (
+	englishSmallNumbers = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+		"eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+	englishTens   = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	englishScales = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion"}
+)
+
+// SpellAmount method of the englishSpeller
+func (e englishSpeller) SpellAmount(negative bool, units, cents uint64, currencyName string) string {
+	res := fmt.Sprintf("%s and %02d/100", e.spellNumber(units), cents)
+	if negative {
+		res = "minus " + res
+	}
+	if currencyName != "" {
+		res += " " + currencyName
+	}
+	return res
+}
+
+// spellNumber returns the given number in English words
+func (e englishSpeller) spellNumber(n uint64) string {
+	if n == 0 {
+		return englishSmallNumbers[0]
+	}
+	var groups []string
+	for scale := 0; n > 0; scale++ {
+		group := n % 1000
+		n /= 1000
+		if group == 0 {
+			continue
+		}
+		words := e.spellHundreds(group)
+		if englishScales[scale] != "" {
+			words += " " + englishScales[scale]
+		}
+		groups = append([]string{words}, groups...)
+	}
+	return strings.Join(groups, " ")
+}
+
+// spellHundreds returns the given number between 1 and 999 in English words
+func (e englishSpeller) spellHundreds(n uint64) string {
+	var words []string
+	if n >= 100 {
+		words = append(words, englishSmallNumbers[n/100], "hundred")
+		n %= 100
+	}
+	switch {
+	case n == 0:
+	case n < 20:
+		words = append(words, englishSmallNumbers[n])
+	case n%10 == 0:
+		words = append(words, englishTens[n/10])
+	default:
+		words = append(words, fmt.Sprintf("%s-%s", englishTens[n/10], englishSmallNumbers[n%10]))
+	}
+	return strings.Join(words, " ")
+}
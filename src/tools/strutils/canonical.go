@@ -0,0 +1,104 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package strutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// MarshalCanonical returns the canonical JSON representation of the given
+// data, suitable for use as a cache key or as the input of a signature.
+//
+// The data is first marshaled with json.Marshal, so that custom MarshalJSON
+// methods and struct tags are honoured, and the result is then rewritten so
+// that two calls with equal values always return the same string.
+// Compared to json.Marshal, MarshalCanonical guarantees that:
+//
+// - The keys of all JSON objects are sorted, at any nesting level. This
+// includes maps such as models.FieldMap, objects produced by custom
+// MarshalJSON methods and structs, whose fields are output by key instead
+// of declaration order.
+//
+// - There is no insignificant whitespace.
+//
+// - Characters such as '<', '>' or '&' are not HTML-escaped.
+//
+// - Numbers keep the text generated by json.Marshal, so that int(1) and
+// float64(1) are both output as 1.
+//
+// Contrary to MarshalToJSONString, a string argument is marshaled as a JSON
+// string and errors are returned instead of panicking.
+func MarshalCanonical(data interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err = dec.Decode(&value); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = writeCanonical(&buf, value); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// writeCanonical writes the canonical JSON representation of the given value
+// decoded from JSON into buf.
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalScalar(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elt := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elt); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return writeCanonicalScalar(buf, v)
+	}
+	return nil
+}
+
+// writeCanonicalScalar writes the given scalar value (string, number, bool
+// or nil) into buf without HTML escaping.
+func writeCanonicalScalar(buf *bytes.Buffer, value interface{}) error {
+	var scalar bytes.Buffer
+	enc := json.NewEncoder(&scalar)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+	return nil
+}
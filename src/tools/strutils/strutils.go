@@ -30,18 +30,74 @@ func init() {
 	log = logging.GetLogger("strutils")
 }
 
+// camelWordStarts returns for each rune of the given camel case runes
+// whether it starts a new word.
+func camelWordStarts(runes []rune) []bool {
+	length := len(runes)
+	res := make([]bool, length)
+	for i := 1; i < length; i++ {
+		if unicode.IsUpper(runes[i]) && ((i+1 < length && unicode.IsLower(runes[i+1])) || unicode.IsLower(runes[i-1])) {
+			res[i] = true
+		}
+	}
+	return res
+}
+
+// wordStarts returns for each rune of the given camel case runes whether
+// it starts a new word. Contrary to camelWordStarts, known acronyms followed
+// by a plural 's' such as "IDs" are single words.
+func wordStarts(runes []rune) []bool {
+	length := len(runes)
+	res := camelWordStarts(runes)
+	for i := 1; i+1 < length; i++ {
+		if !res[i] || !unicode.IsUpper(runes[i-1]) || runes[i+1] != 's' || (i+2 < length && unicode.IsLower(runes[i+2])) {
+			continue
+		}
+		// We have an upper case run followed by 's', check if it ends with an acronym
+		start := i - 1
+		for start > 0 && unicode.IsUpper(runes[start-1]) {
+			start--
+		}
+		for j := start; j < i; j++ {
+			if Acronyms.Has(string(runes[j : i+1])) {
+				res[i] = false
+				if j > start {
+					res[j] = true
+				}
+				break
+			}
+		}
+	}
+	return res
+}
+
 // SnakeCase convert the given string to snake case following the Golang format:
 // acronyms are converted to lower-case and preceded by an underscore.
+//
+// Table and column names are derived from SnakeCase, so its output must not
+// change. Use AcronymSnakeCase to keep plural acronyms together.
 func SnakeCase(in string) string {
-	runes := []rune(in)
-	length := len(runes)
+	return snakeCase([]rune(in), camelWordStarts)
+}
+
+// AcronymSnakeCase converts the given string to snake case like SnakeCase,
+// except that acronyms of the Acronyms set followed by a plural 's' are
+// kept together, e.g. PartnerIDs => partner_ids instead of partner_i_ds.
+func AcronymSnakeCase(in string) string {
+	return snakeCase([]rune(in), wordStarts)
+}
+
+// snakeCase returns the given runes in snake case, with words split by the
+// given starts function.
+func snakeCase(runes []rune, startsFunc func([]rune) []bool) string {
+	starts := startsFunc(runes)
 
 	var out []rune
-	for i := 0; i < length; i++ {
-		if i > 0 && unicode.IsUpper(runes[i]) && ((i+1 < length && unicode.IsLower(runes[i+1])) || unicode.IsLower(runes[i-1])) {
+	for i, r := range runes {
+		if starts[i] {
 			out = append(out, '_')
 		}
-		out = append(out, unicode.ToLower(runes[i]))
+		out = append(out, unicode.ToLower(r))
 	}
 
 	return string(out)
@@ -49,20 +105,92 @@ func SnakeCase(in string) string {
 
 // Title convert the given camelCase string to a title string.
 // eg. MyHTMLData => My HTML Data
+//
+// Words that are already separated by spaces are kept as is and white
+// spaces are collapsed to a single space, so that Title(Title(s)) == Title(s).
 func Title(in string) string {
-
 	runes := []rune(in)
-	length := len(runes)
+	starts := wordStarts(runes)
 
 	var out []rune
-	for i := 0; i < length; i++ {
-		if i > 0 && unicode.IsUpper(runes[i]) && ((i+1 < length && unicode.IsLower(runes[i+1])) || unicode.IsLower(runes[i-1])) {
+	for i, r := range runes {
+		spaced := len(out) == 0 || out[len(out)-1] == ' '
+		if unicode.IsSpace(r) {
+			if !spaced {
+				out = append(out, ' ')
+			}
+			continue
+		}
+		if starts[i] && !spaced {
 			out = append(out, ' ')
 		}
-		out = append(out, runes[i])
+		out = append(out, r)
 	}
 
-	return string(out)
+	return strings.TrimRight(string(out), " ")
+}
+
+// PascalCase converts the given snake case, camel case or space separated
+// string to Pascal case following the Golang format: acronyms of the
+// Acronyms set are written in upper case.
+// eg. partner_ids => PartnerIDs, html_data => HTMLData
+//
+// For any snake case string made of lower case words separated by single
+// underscores, AcronymSnakeCase(PascalCase(s)) == s, unless two acronyms
+// follow each other. Conversely, PascalCase(AcronymSnakeCase(s)) == s for any
+// Pascal case identifier whose acronyms all belong to the Acronyms set.
+func PascalCase(in string) string {
+	var out strings.Builder
+	for _, word := range splitWords(in) {
+		out.WriteString(capitalizeWord(word))
+	}
+	return out.String()
+}
+
+// CamelCase converts the given snake case, Pascal case or space separated
+// string to camel case following the Golang format: it is the PascalCase
+// of the given string with its first word in lower case.
+// eg. partner_ids => partnerIDs, html_data => htmlData
+func CamelCase(in string) string {
+	words := splitWords(in)
+	if len(words) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	out.WriteString(strings.ToLower(words[0]))
+	for _, word := range words[1:] {
+		out.WriteString(capitalizeWord(word))
+	}
+	return out.String()
+}
+
+// splitWords returns the words of the given snake case, camel case or
+// space separated string.
+func splitWords(in string) []string {
+	var res []string
+	for _, tok := range strings.FieldsFunc(in, func(r rune) bool {
+		return r == '_' || r == '-' || unicode.IsSpace(r)
+	}) {
+		res = append(res, strings.Split(AcronymSnakeCase(tok), "_")...)
+	}
+	return res
+}
+
+// capitalizeWord returns the given lower case word with its first letter in
+// upper case, or in upper case if it is a known acronym, possibly in plural.
+func capitalizeWord(word string) string {
+	switch {
+	case Acronyms.Has(word):
+		return strings.ToUpper(word)
+	case len(word) > 2 && strings.HasSuffix(word, "s") && Acronyms.Has(word[:len(word)-1]):
+		return strings.ToUpper(word[:len(word)-1]) + "s"
+	}
+	runes := []rune(word)
+	if len(runes) == 0 {
+		return ""
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
 }
 
 // GetDefaultString returns str if it is not an empty string or def otherwise
@@ -103,6 +231,32 @@ func HumanSize(size int64) string {
 	return fmt.Sprintf("%.2f %s", s, units[i])
 }
 
+// Ordinal returns the given number followed by its English ordinal suffix.
+// eg. Ordinal(21) => "21st", Ordinal(112) => "112th"
+func Ordinal(n int) string {
+	return strconv.Itoa(n) + OrdinalSuffix(n)
+}
+
+// OrdinalSuffix returns the English ordinal suffix of the given number,
+// i.e. one of "st", "nd", "rd" or "th".
+func OrdinalSuffix(n int) string {
+	if n < 0 {
+		n = -n
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
+
 // Substitute substitutes each occurrence of each key of mapping in str by the
 // corresponding mapping value and returns the substituted string.
 func Substitute(str string, mapping map[string]string) string {
@@ -158,3 +312,57 @@ func TrimArgs(args []interface{}) []string {
 	}
 	return argStr
 }
+
+// CSVEscape returns the given field escaped to be written as a CSV field as
+// per RFC 4180. The field is enclosed in double quotes if it contains the
+// delimiter, a double quote or a line break, and embedded double quotes are
+// doubled. The delimiter defaults to a comma if it is not given.
+func CSVEscape(field string, delimiter ...rune) string {
+	delim := ','
+	if len(delimiter) > 0 {
+		delim = delimiter[0]
+	}
+	if !strings.ContainsRune(field, delim) && !strings.ContainsAny(field, "\"\r\n") {
+		return field
+	}
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
+
+// MaskString returns s with all its runes replaced by maskRune except for the
+// keepStart first and keepEnd last runes. If s is too short to keep both the
+// requested start and end visible, the whole string is masked.
+// eg. MaskString("4111111111111111", 0, 4, '*') => "************1111"
+func MaskString(s string, keepStart, keepEnd int, maskRune rune) string {
+	runes := []rune(s)
+	if keepStart < 0 {
+		keepStart = 0
+	}
+	if keepEnd < 0 {
+		keepEnd = 0
+	}
+	if keepStart+keepEnd >= len(runes) {
+		keepStart, keepEnd = 0, 0
+	}
+	for i := keepStart; i < len(runes)-keepEnd; i++ {
+		runes[i] = maskRune
+	}
+	return string(runes)
+}
+
+// MaskEmail returns the given email address with its local part masked, only
+// keeping its first character and the domain visible. The masked part has a
+// fixed length so as not to leak the length of the local part.
+// eg. MaskEmail("jane.smith@example.com") => "j***@example.com"
+//
+// If email has no '@', it is entirely masked.
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return MaskString(email, 0, 0, '*')
+	}
+	local := []rune(email[:at])
+	if len(local) > 0 {
+		local = local[:1]
+	}
+	return string(local) + "***" + email[at:]
+}
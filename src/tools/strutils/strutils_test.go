@@ -0,0 +1,250 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package strutils
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCaseConversions(t *testing.T) {
+	Convey("Testing case conversions", t, func() {
+		Convey("SnakeCase", func() {
+			So(SnakeCase("Name"), ShouldEqual, "name")
+			So(SnakeCase("HexyaExternalID"), ShouldEqual, "hexya_external_id")
+			So(SnakeCase("MyHTMLData"), ShouldEqual, "my_html_data")
+			So(SnakeCase("PartnerIDs"), ShouldEqual, "partner_i_ds")
+			So(SnakeCase("Address2"), ShouldEqual, "address2")
+			So(SnakeCase("already_snake"), ShouldEqual, "already_snake")
+		})
+		Convey("AcronymSnakeCase", func() {
+			So(AcronymSnakeCase("HexyaExternalID"), ShouldEqual, "hexya_external_id")
+			So(AcronymSnakeCase("MyHTMLData"), ShouldEqual, "my_html_data")
+			So(AcronymSnakeCase("PartnerIDs"), ShouldEqual, "partner_ids")
+			So(AcronymSnakeCase("MyAPIIDs"), ShouldEqual, "my_api_ids")
+			So(AcronymSnakeCase("Address2"), ShouldEqual, "address2")
+		})
+		Convey("Title", func() {
+			So(Title("MyHTMLData"), ShouldEqual, "My HTML Data")
+			So(Title("PartnerIDs"), ShouldEqual, "Partner IDs")
+			So(Title("UserID"), ShouldEqual, "User ID")
+		})
+		Convey("Title with spaced and mixed input", func() {
+			So(Title("my HTML data"), ShouldEqual, "my HTML data")
+			So(Title("My Data"), ShouldEqual, "My Data")
+			So(Title("  My   Data\t"), ShouldEqual, "My Data")
+			So(Title("My HTMLData"), ShouldEqual, "My HTML Data")
+			So(Title("Address2Line"), ShouldEqual, "Address2 Line")
+			So(Title("Invoice 2Lines"), ShouldEqual, "Invoice 2 Lines")
+			So(Title("HTML5 Page Count"), ShouldEqual, "HTML5 Page Count")
+			for _, str := range []string{"MyHTMLData", "my HTML data", "PartnerIDs", "Address2Line"} {
+				So(Title(Title(str)), ShouldEqual, Title(str))
+			}
+		})
+		Convey("PascalCase and CamelCase", func() {
+			So(PascalCase("partner_ids"), ShouldEqual, "PartnerIDs")
+			So(PascalCase("html_data"), ShouldEqual, "HTMLData")
+			So(PascalCase("user id"), ShouldEqual, "UserID")
+			So(PascalCase("userName"), ShouldEqual, "UserName")
+			So(PascalCase(""), ShouldEqual, "")
+			So(CamelCase("partner_ids"), ShouldEqual, "partnerIDs")
+			So(CamelCase("html_data"), ShouldEqual, "htmlData")
+			So(CamelCase("UserID"), ShouldEqual, "userID")
+			So(CamelCase(""), ShouldEqual, "")
+		})
+		Convey("Round trips", func() {
+			for _, name := range []string{"partner_ids", "user_id", "html_data", "address2", "hexya_external_id", "name"} {
+				So(AcronymSnakeCase(PascalCase(name)), ShouldEqual, name)
+				So(AcronymSnakeCase(CamelCase(name)), ShouldEqual, name)
+			}
+			for _, name := range []string{"PartnerIDs", "UserID", "HTMLData", "Address2", "HexyaExternalID", "Name"} {
+				So(PascalCase(AcronymSnakeCase(name)), ShouldEqual, name)
+			}
+		})
+		Convey("Registering acronyms", func() {
+			So(PascalCase("vat_number"), ShouldEqual, "VatNumber")
+			acronyms := NewAcronymSet("VAT")
+			So(acronyms.Has("vat"), ShouldBeTrue)
+			So(acronyms.Has("ID"), ShouldBeFalse)
+			Acronyms.Add("vat")
+			defer func() {
+				delete(Acronyms.words, "VAT")
+			}()
+			So(PascalCase("vat_number"), ShouldEqual, "VATNumber")
+			So(AcronymSnakeCase("CustomerVATs"), ShouldEqual, "customer_vats")
+		})
+	})
+}
+
+type testSpeller struct{}
+
+func (t testSpeller) SpellAmount(negative bool, units, cents uint64, currencyName string) string {
+	return fmt.Sprintf("%t %d %d %s", negative, units, cents, currencyName)
+}
+
+func TestAmountToWords(t *testing.T) {
+	Convey("Testing amounts spelling", t, func() {
+		Convey("English amounts", func() {
+			So(AmountToWords(1234.56, ""), ShouldEqual, "one thousand two hundred thirty-four and 56/100")
+			So(AmountToWords(1234.56, "dollars"), ShouldEqual, "one thousand two hundred thirty-four and 56/100 dollars")
+			So(AmountToWords(0, "euros"), ShouldEqual, "zero and 00/100 euros")
+			So(AmountToWords(-15.5, ""), ShouldEqual, "minus fifteen and 50/100")
+			So(AmountToWords(-0.001, ""), ShouldEqual, "zero and 00/100")
+			So(AmountToWords(0.29, ""), ShouldEqual, "zero and 29/100")
+			So(AmountToWords(100, ""), ShouldEqual, "one hundred and 00/100")
+			So(AmountToWords(1000000, ""), ShouldEqual, "one million and 00/100")
+			So(AmountToWords(2019011.999, ""), ShouldEqual, "two million nineteen thousand twelve and 00/100")
+			So(AmountToWords(90017, ""), ShouldEqual, "ninety thousand seventeen and 00/100")
+		})
+		Convey("Other languages", func() {
+			So(func() { AmountToWordsIn("xx", 12, "") }, ShouldPanic)
+			RegisterAmountSpeller("xx", testSpeller{})
+			So(AmountToWordsIn("xx", -12.3, "EUR"), ShouldEqual, "true 12 30 EUR")
+		})
+	})
+}
+
+func TestOrdinal(t *testing.T) {
+	Convey("Testing ordinals", t, func() {
+		for _, tc := range []struct {
+			n        int
+			expected string
+		}{
+			{0, "0th"}, {1, "1st"}, {2, "2nd"}, {3, "3rd"}, {4, "4th"},
+			{11, "11th"}, {12, "12th"}, {13, "13th"}, {14, "14th"},
+			{21, "21st"}, {22, "22nd"}, {23, "23rd"}, {101, "101st"},
+			{111, "111th"}, {112, "112th"}, {113, "113th"}, {1002, "1002nd"},
+			{-1, "-1st"}, {-12, "-12th"},
+		} {
+			So(Ordinal(tc.n), ShouldEqual, tc.expected)
+		}
+		So(OrdinalSuffix(42), ShouldEqual, "nd")
+		So(OrdinalSuffix(213), ShouldEqual, "th")
+	})
+}
+
+func TestCSVEscape(t *testing.T) {
+	Convey("Testing CSV escaping", t, func() {
+		So(CSVEscape("hello"), ShouldEqual, "hello")
+		So(CSVEscape(""), ShouldEqual, "")
+		So(CSVEscape("12 Main Street, Springfield"), ShouldEqual, `"12 Main Street, Springfield"`)
+		So(CSVEscape(`The "best" one`), ShouldEqual, `"The ""best"" one"`)
+		So(CSVEscape("12 Main Street\nSpringfield"), ShouldEqual, "\"12 Main Street\nSpringfield\"")
+		So(CSVEscape("line\r"), ShouldEqual, "\"line\r\"")
+		So(CSVEscape("a;b"), ShouldEqual, "a;b")
+		So(CSVEscape("a;b", ';'), ShouldEqual, `"a;b"`)
+		So(CSVEscape("a,b", ';'), ShouldEqual, "a,b")
+		So(CSVEscape("a\tb", '\t'), ShouldEqual, "\"a\tb\"")
+	})
+}
+
+func TestMasking(t *testing.T) {
+	Convey("Testing masking helpers", t, func() {
+		Convey("Masking strings", func() {
+			So(MaskString("4111111111111111", 0, 4, '*'), ShouldEqual, "************1111")
+			So(MaskString("secret-token", 2, 2, '#'), ShouldEqual, "se########en")
+			So(MaskString("abc", 2, 2, '*'), ShouldEqual, "***")
+			So(MaskString("abcd", 2, 2, '*'), ShouldEqual, "****")
+			So(MaskString("abcde", 2, 2, '*'), ShouldEqual, "ab*de")
+			So(MaskString("", 1, 1, '*'), ShouldEqual, "")
+			So(MaskString("héllo wörld", 1, 1, '•'), ShouldEqual, "h•••••••••d")
+			So(MaskString("abc", -1, 1, '*'), ShouldEqual, "**c")
+		})
+		Convey("Masking emails", func() {
+			So(MaskEmail("jane.smith@example.com"), ShouldEqual, "j***@example.com")
+			So(MaskEmail("j@example.com"), ShouldEqual, "j***@example.com")
+			So(MaskEmail("éric@example.com"), ShouldEqual, "é***@example.com")
+			So(MaskEmail("@example.com"), ShouldEqual, "***@example.com")
+			So(MaskEmail("not-an-email"), ShouldEqual, "************")
+		})
+	})
+}
+
+func TestValidators(t *testing.T) {
+	Convey("Testing validators", t, func() {
+		Convey("Emails", func() {
+			for _, s := range []string{"jane.smith@example.com", "j@example.co.uk", "john+tag@mail.example.org", "éric@exemple.fr"} {
+				So(IsEmail(s), ShouldBeTrue)
+			}
+			for _, s := range []string{"", "jane.smith", "jane@", "@example.com", "jane@example", "jane@@example.com",
+				"jane smith@example.com", "jane@example..com", "jane@.example.com", "jane@example.com."} {
+				So(IsEmail(s), ShouldBeFalse)
+			}
+		})
+		Convey("URLs", func() {
+			for _, s := range []string{"http://example.com", "https://www.example.com/path?q=1#top", "ftp://files.example.com", "http://localhost:8080"} {
+				So(IsURL(s), ShouldBeTrue)
+			}
+			for _, s := range []string{"", "example.com", "/path/to/file", "mailto:jane@example.com", "http://", "http://exa mple.com"} {
+				So(IsURL(s), ShouldBeFalse)
+			}
+		})
+		Convey("Numeric strings", func() {
+			for _, s := range []string{"0", "42", "-42", "+42", "3.14", "-0.5", ".5", "007"} {
+				So(IsNumeric(s), ShouldBeTrue)
+			}
+			for _, s := range []string{"", "-", "+", ".", "1.", "1.2.3", "1e10", "1,000", " 1", "abc", "12a", "--1"} {
+				So(IsNumeric(s), ShouldBeFalse)
+			}
+		})
+	})
+}
+
+type unsortedJSON struct{}
+
+func (u unsortedJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`{"zeta": 1, "alpha": {"y": true, "x": null}}`), nil
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	Convey("Testing canonical JSON marshaling", t, func() {
+		Convey("Nested maps should have sorted keys", func() {
+			data := map[string]interface{}{
+				"Name":    "John",
+				"Address": map[string]interface{}{"Zip": "12345", "City": "Springfield"},
+				"Tags":    []interface{}{map[string]int{"b": 2, "a": 1}, "x"},
+				"Age":     42,
+			}
+			res, err := MarshalCanonical(data)
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `{"Address":{"City":"Springfield","Zip":"12345"},"Age":42,"Name":"John","Tags":[{"a":1,"b":2},"x"]}`)
+		})
+		Convey("Custom marshalers and structs should have sorted keys", func() {
+			res, err := MarshalCanonical(unsortedJSON{})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `{"alpha":{"x":null,"y":true},"zeta":1}`)
+			res, err = MarshalCanonical(struct {
+				Zeta  string `json:"zeta"`
+				Alpha string `json:"alpha"`
+			}{"z", "a"})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `{"alpha":"a","zeta":"z"}`)
+		})
+		Convey("Scalars should be output consistently", func() {
+			res, err := MarshalCanonical(map[string]interface{}{"int": 1, "float": float64(1), "html": "<a & b>", "pi": 3.14})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `{"float":1,"html":"<a & b>","int":1,"pi":3.14}`)
+			res, err = MarshalCanonical("hello")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `"hello"`)
+			res, err = MarshalCanonical(nil)
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `null`)
+		})
+		Convey("Output should be stable", func() {
+			data := map[string]interface{}{"c": 3, "b": map[string]interface{}{"e": 5, "d": 4}, "a": 1}
+			first, _ := MarshalCanonical(data)
+			for i := 0; i < 20; i++ {
+				res, _ := MarshalCanonical(data)
+				So(res, ShouldEqual, first)
+			}
+		})
+		Convey("Unmarshalable data should return an error", func() {
+			_, err := MarshalCanonical(map[string]interface{}{"ch": make(chan int)})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
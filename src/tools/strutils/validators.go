@@ -0,0 +1,43 @@
+// Copyright 2019 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package strutils
+
+import (
+	"net/url"
+	"regexp"
+)
+
+// The validators of this file are pragmatic checks meant for field
+// constraints and user input. They reject obviously malformed values
+// but are not full RFC validators.
+
+var (
+	emailRegexp   = regexp.MustCompile(`^[^\s@]+@[^\s@.]+(\.[^\s@.]+)+$`)
+	numericRegexp = regexp.MustCompile(`^[+-]?(\d+(\.\d+)?|\.\d+)$`)
+)
+
+// IsEmail returns true if s looks like an email address, i.e. a local part
+// and a domain with at least one dot separated by a single '@', without any
+// whitespace. Quoted local parts and IP address domains are not supported.
+func IsEmail(s string) bool {
+	return emailRegexp.MatchString(s)
+}
+
+// IsURL returns true if s is an absolute URL with a scheme and a host,
+// such as "https://example.com/path?q=1". URLs without host, such as
+// "mailto:" URLs, are not considered valid.
+func IsURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return u.Scheme != "" && u.Host != ""
+}
+
+// IsNumeric returns true if s is a decimal number made of digits with an
+// optional leading sign and an optional decimal part separated by a dot.
+// Exponents, thousands separators and surrounding spaces are not allowed.
+func IsNumeric(s string) bool {
+	return numericRegexp.MatchString(s)
+}